@@ -3,6 +3,7 @@ package cmd
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -40,6 +41,9 @@ func (sc *AofCmd) Stop() error {
 
 func (rc *AofCmd) Run() error {
 	action := config.GetFlag().AofCmd.Action
+	if isRedisAof(config.GetFlag().AofCmd.Path) {
+		return rc.runRedisAof(action)
+	}
 	switch action {
 	case "parse":
 		rc.Parse()
@@ -85,23 +89,28 @@ func (rc *AofCmd) Cmd() {
 		util.PanicIfErr(err)
 	}
 
+	cmdFilter := newAofCmdFilter()
 	decoder := client.NewDecoder(bufio.NewReader(file))
+	offset := start
 	for {
 		resp, incrOffset, err := client.MustDecodeOpt(decoder)
 		if err != nil {
-			log.Errorf("%v", err)
+			if !errors.Is(err, io.EOF) {
+				log.Errorf("%v", err)
+			}
 			return
 		}
 
 		sCmd, argv, err := client.ParseArgs(resp) // lower case
 		if err != nil {
-			log.Errorf("%v", err)
-			log.Info("offset(%d), cmd(%d), %s", incrOffset, sCmd, argv)
+			log.Errorf("offset(%d), error(%v)", offset, err)
 			return
 		}
-
+		if cmdFilter.match(sCmd, argv) {
+			fmt.Printf("offset(%d) : %s\n", offset, formatAofCmd(sCmd, argv))
+		}
+		offset = start + incrOffset
 	}
-
 }
 
 func (rc *AofCmd) Parse() {
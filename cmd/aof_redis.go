@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/filter"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+	"github.com/mgtv-tech/redis-GunYu/pkg/rdb"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
+	"github.com/mgtv-tech/redis-GunYu/pkg/store"
+	"github.com/mgtv-tech/redis-GunYu/pkg/util"
+)
+
+// aof files of redis :
+// a multi-part aof(redis 7.0), the path is the directory or the manifest,
+// or an aof file, aof files of the storer are named as <offset>.aof
+
+// isRedisAof returns true if the path is not an aof file of the storer
+func isRedisAof(path string) bool {
+	if store.IsAofManifest(path) {
+		return true
+	}
+	_, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(path), ".aof"), 10, 64)
+	return err != nil
+}
+
+type redisAofFiles struct {
+	manifest *store.AofManifest // nil if it's an aof file
+	rdb      string             // base rdb of the manifest
+	aofs     []string
+}
+
+func openRedisAofFiles(path string) (*redisAofFiles, error) {
+	if !store.IsAofManifest(path) {
+		return &redisAofFiles{aofs: []string{path}}, nil
+	}
+	manifest, err := store.ParseAofManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	files := &redisAofFiles{manifest: manifest, aofs: manifest.Files()}
+	if manifest.Base != nil && manifest.Base.IsRdb() {
+		files.rdb = files.aofs[0]
+		files.aofs = files.aofs[1:]
+	}
+	return files, nil
+}
+
+func (rc *AofCmd) runRedisAof(action string) error {
+	files, err := openRedisAofFiles(config.GetFlag().AofCmd.Path)
+	util.PanicIfErr(err)
+
+	switch action {
+	case "parse":
+		util.PanicIfErr(rc.parseRedisAof(files))
+	case "verify":
+		rc.verifyRedisAof(files)
+	case "cmd":
+		util.PanicIfErr(rc.redisAofCmd(files))
+	default:
+		panic(fmt.Errorf("unsupported mode : %s", action))
+	}
+	return nil
+}
+
+// parseRedisAof prints files in the order of loading
+func (rc *AofCmd) parseRedisAof(files *redisAofFiles) error {
+	printFile := func(typ string, seq int64, path string) error {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s : file(%s), seq(%d), size(%d)\n", typ, path, seq, fi.Size())
+		return nil
+	}
+	if files.manifest == nil {
+		return printFile("aof", 0, files.aofs[0])
+	}
+	m := files.manifest
+	if m.Base != nil {
+		if err := printFile("base", m.Base.Seq, m.Path(m.Base)); err != nil {
+			return err
+		}
+	}
+	for _, f := range m.Incrs {
+		if err := printFile("incr", f.Seq, m.Path(f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redisAofCmd prints commands of the base rdb and aof files
+func (rc *AofCmd) redisAofCmd(files *redisAofFiles) error {
+	cmdFilter := newAofCmdFilter()
+
+	if files.rdb != "" {
+		err := walkRdbCmds(rc, files.rdb, func(db int, cmd string, args [][]byte) {
+			if cmdFilter.match(cmd, args) {
+				fmt.Printf("file(%s), db(%d) : %s\n", files.rdb, db, formatAofCmd(cmd, args))
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	reader := store.NewRedisAofReader(files.aofs)
+	defer reader.Close()
+	for {
+		cmd, err := reader.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Errorf("%v", err)
+			}
+			return nil
+		}
+		name := strings.ToLower(string(cmd.Args[0]))
+		if cmdFilter.match(name, cmd.Args[1:]) {
+			fmt.Printf("file(%s), offset(%d) : %s\n", cmd.File, cmd.Offset, formatAofCmd(name, cmd.Args[1:]))
+		}
+	}
+}
+
+// verifyRedisAof parses all files, a truncated tail of the last aof file is loaded by redis if aof-load-truncated is yes
+func (rc *AofCmd) verifyRedisAof(files *redisAofFiles) {
+	err := func() error {
+		if files.rdb != "" {
+			if err := walkRdbCmds(rc, files.rdb, nil); err != nil {
+				return fmt.Errorf("base rdb : file(%s), error(%w)", files.rdb, err)
+			}
+		}
+		reader := store.NewRedisAofReader(files.aofs)
+		defer reader.Close()
+		cmds := 0
+		for {
+			_, err := reader.Next()
+			if err == nil {
+				cmds++
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				fmt.Printf("commands(%d)\n", cmds)
+				return nil
+			}
+			file, offset := reader.File()
+			if errors.Is(err, io.ErrUnexpectedEOF) && len(files.aofs) > 0 && file == files.aofs[len(files.aofs)-1] {
+				fmt.Printf("commands(%d), the last aof is truncated : file(%s), offset(%d)\n", cmds, file, offset)
+				return nil
+			}
+			return err
+		}
+	}()
+	if err != nil {
+		fmt.Printf("aof verify failed : %v", err)
+	} else {
+		fmt.Printf("aof verify success")
+	}
+}
+
+// walkRdbCmds parses the rdb, and calls fn for each command of the rdb if fn is not nil
+func walkRdbCmds(rc *AofCmd, path string, fn func(db int, cmd string, args [][]byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var stat atomic.Int64
+	pipe := redis.ParseRdb(file, &stat, config.RDBPipeSize, "7.2")
+	for {
+		select {
+		case e, ok := <-pipe:
+			if !ok || e == nil {
+				return nil
+			}
+			if e.Err != nil {
+				if errors.Is(e.Err, io.EOF) {
+					return nil
+				}
+				return e.Err
+			}
+			if fn == nil || e.ObjectParser == nil {
+				continue
+			}
+			err := execEntryCmds(e, func(cmd string, args ...interface{}) error {
+				bs := make([][]byte, 0, len(args))
+				for _, arg := range args {
+					switch tt := arg.(type) {
+					case []byte:
+						bs = append(bs, tt)
+					case string:
+						bs = append(bs, []byte(tt))
+					default:
+						bs = append(bs, []byte(fmt.Sprint(tt)))
+					}
+				}
+				fn(e.DB, strings.ToLower(cmd), bs)
+				return nil
+			})
+			if errors.Is(err, rdb.ErrUnsupportedModule) {
+				log.Warnf("skip the module key : %v", err)
+			} else if err != nil {
+				return err
+			}
+		case <-rc.ctx.Done():
+			return rc.ctx.Err()
+		}
+	}
+}
+
+// aofCmdFilter matches commands by -aof.filter.cmds and -aof.filter.keys
+type aofCmdFilter struct {
+	cmds map[string]struct{}
+	keys []string
+}
+
+func newAofCmdFilter() *aofCmdFilter {
+	f := &aofCmdFilter{}
+	for _, cmd := range strings.Split(config.GetFlag().AofCmd.FilterCmds, ",") {
+		if cmd = strings.TrimSpace(cmd); cmd != "" {
+			if f.cmds == nil {
+				f.cmds = make(map[string]struct{})
+			}
+			f.cmds[strings.ToLower(cmd)] = struct{}{}
+		}
+	}
+	for _, key := range strings.Split(config.GetFlag().AofCmd.FilterKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			f.keys = append(f.keys, key)
+		}
+	}
+	return f
+}
+
+// match returns true if the command matches names and any key matches prefixes, cmd is in lower case
+func (f *aofCmdFilter) match(cmd string, args [][]byte) bool {
+	if f.cmds != nil {
+		if _, ok := f.cmds[cmd]; !ok {
+			return false
+		}
+	}
+	if len(f.keys) == 0 {
+		return true
+	}
+	for _, idx := range filter.CommandKeyIndexes(cmd, len(args)) {
+		for _, prefix := range f.keys {
+			if strings.HasPrefix(string(args[idx]), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func formatAofCmd(cmd string, args [][]byte) string {
+	var sb strings.Builder
+	sb.WriteString(cmd)
+	for _, arg := range args {
+		sb.WriteByte(' ')
+		sb.WriteString(strconv.Quote(string(arg)))
+	}
+	return sb.String()
+}
@@ -5,3 +5,8 @@ type Cmd interface {
 	Run() error  // Run is a blocking function
 	Stop() error // non-block, just notify cmd to stop
 }
+
+// Reloader reloads the configuration, e.g. SIGHUP
+type Reloader interface {
+	Reload() error
+}
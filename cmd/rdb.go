@@ -9,6 +9,7 @@ import (
 	"sync/atomic"
 
 	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/rdb"
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
 	"github.com/mgtv-tech/redis-GunYu/pkg/util"
 )
@@ -68,7 +69,7 @@ func (rc *RdbCmd) Print() error {
 			}
 			fmt.Printf("db(%d), key(%s), value(%s)\n", e.DB, e.Key, e.Value())
 			if config.GetFlag().RdbCmd.ToCmd {
-				e.ObjectParser.ExecCmd(func(cmd string, args ...interface{}) error {
+				err := execEntryCmds(e, func(cmd string, args ...interface{}) error {
 					params := []interface{}{}
 					for _, arg := range args {
 						switch tt := arg.(type) {
@@ -81,9 +82,21 @@ func (rc *RdbCmd) Print() error {
 					fmt.Println("\t", cmd, params)
 					return nil
 				})
+				if errors.Is(err, rdb.ErrUnsupportedModule) {
+					fmt.Printf("\t%v\n", err)
+				} else if err != nil {
+					return err
+				}
 			}
 		case <-rc.ctx.Done():
 			return rc.ctx.Err()
 		}
 	}
 }
+
+// execEntryCmds calls fn for each command of the entry
+func execEntryCmds(e *rdb.BinEntry, fn rdb.RdbObjExecutor) (err error) {
+	defer util.Xrecover(&err)
+	e.ObjectParser.ExecCmd(fn)
+	return nil
+}
@@ -11,11 +11,14 @@ import (
 	"time"
 
 	"github.com/soheilhy/cmux"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/exp/slices"
 	"google.golang.org/grpc"
 
 	"github.com/mgtv-tech/redis-GunYu/config"
 	"github.com/mgtv-tech/redis-GunYu/pkg/cluster"
 	ufs "github.com/mgtv-tech/redis-GunYu/pkg/io/fs"
+	"github.com/mgtv-tech/redis-GunYu/pkg/journal"
 	"github.com/mgtv-tech/redis-GunYu/pkg/log"
 	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
@@ -23,6 +26,7 @@ import (
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client"
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
 	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+	"github.com/mgtv-tech/redis-GunYu/pkg/telemetry"
 	"github.com/mgtv-tech/redis-GunYu/pkg/util"
 	"github.com/mgtv-tech/redis-GunYu/syncer"
 )
@@ -52,6 +56,14 @@ type SyncerCmd struct {
 	clusterCli    *cluster.Cluster
 	registerKey   string
 	multiListener cmux.CMux
+	runMode       syncerRunMode // mode of current run
+	reloadMutex   sync.Mutex
+}
+
+type syncerRunMode struct {
+	watchIn  bool
+	watchOut bool
+	txnMode  bool
 }
 
 func NewSyncerCmd() *SyncerCmd {
@@ -79,6 +91,27 @@ func (sc *SyncerCmd) Stop() error {
 
 func (sc *SyncerCmd) stop() {
 	sc.stopServer()
+	sc.stopTelemetry()
+	if err := journal.Stop(); err != nil {
+		sc.logger.Errorf("stop journal : %v", err)
+	}
+}
+
+// startTelemetry exports metrics and traces if server.otlp is configured, a failure doesn't stop syncing
+func (sc *SyncerCmd) startTelemetry() {
+	otlp := config.Get().Server.Otlp
+	if otlp == nil {
+		return
+	}
+	if err := telemetry.Start(otlp, config.Get().Server.ListenPeer); err != nil {
+		sc.logger.Errorf("start telemetry : %v", err)
+	}
+}
+
+func (sc *SyncerCmd) stopTelemetry() {
+	if err := telemetry.Stop(config.Get().Server.GracefullStopTimeout); err != nil {
+		sc.logger.Errorf("stop telemetry : %v", err)
+	}
 }
 
 func (sc *SyncerCmd) Run() error {
@@ -91,8 +124,13 @@ func (sc *SyncerCmd) Run() error {
 
 	defer sc.stop()
 
+	// events are not recorded if the journal fails, but syncing goes on
+	if err := journal.Start(config.Get().Server.Journal.DirPath, config.Get().Server.Journal.MaxSize); err != nil {
+		sc.logger.Errorf("start journal : %v", err)
+	}
 	sc.startCron()
 	sc.startServer()
+	sc.startTelemetry()
 
 	for {
 		err = sc.run()
@@ -103,6 +141,7 @@ func (sc *SyncerCmd) Run() error {
 			break
 		} else if errors.Is(err, syncer.ErrRestart) {
 			sc.logger.Infof("syncer restart : err(%v)", err)
+			journal.Record(journal.Event{Actor: journal.ActorSyncer, Type: journal.TypeRestart, Reason: err.Error()})
 			fixErr := util.RetryLinearJitter(sc.waitCloser.Context(), func() error {
 				return sc.fixConfig()
 			}, 60, time.Second*3, 0.3) // a long consensus time for redis cluster
@@ -129,9 +168,37 @@ func (sc *SyncerCmd) Run() error {
 	return sc.waitCloser.Error()
 }
 
-func (sc *SyncerCmd) syncerConfigs() (cfgs []syncer.SyncerConfig, watchInput bool, watchOutput bool, txnMode bool, err error) {
-	inputRedis := config.Get().Input.Redis
-	outputRedis := config.Get().Output.Redis
+func hasOwnOutput(runCfg *config.Config, input config.RedisConfig) bool {
+	in := runCfg.InputOverride(input.ShardAddresses()...)
+	return in != nil && in.HasOwnOutput()
+}
+
+// overrideSyncerConfig applies the override of the input
+//   - own standalone output : multi/exec
+//   - own cluster output : update checkpoint periodically
+func overrideSyncerConfig(runCfg *config.Config, cfg *syncer.SyncerConfig) {
+	in := runCfg.InputOverride(cfg.Input.ShardAddresses()...)
+	if in == nil {
+		return
+	}
+	if !in.HasOwnOutput() {
+		if !*in.Output.ReplayTransaction {
+			cfg.CanTransaction = false
+		}
+		return
+	}
+	if in.Output.Redis.IsStanalone() && !in.Output.Redis.IsSharded() {
+		cfg.Output = in.Output.Redis.Index(0)
+		cfg.CanTransaction = *in.Output.ReplayTransaction && !in.Output.Redis.Proxy
+	} else {
+		cfg.Output = *in.Output.Redis
+		cfg.CanTransaction = false
+	}
+}
+
+func (sc *SyncerCmd) syncerConfigs(runCfg *config.Config) (cfgs []syncer.SyncerConfig, watchInput bool, watchOutput bool, txnMode bool, err error) {
+	inputRedis := runCfg.Input.Redis
+	outputRedis := runCfg.Output.Redis
 
 	// 1. standalone <-> standalone  ==> multi/exec
 	// 2. cluster    <-> standalone  ==> multi/exec, monitor typology
@@ -141,28 +208,63 @@ func (sc *SyncerCmd) syncerConfigs() (cfgs []syncer.SyncerConfig, watchInput boo
 	//		4.2 slots arenot matched, update checkpoint periodically
 	// 5. cluster : if cluster
 
-	syncFrom := config.Get().Input.SyncFrom
-	inputMode := config.Get().Input.Mode
-	enableTransaction := *config.Get().Output.ReplayTransaction
+	syncFrom := runCfg.Input.SyncFrom
+	inputMode := runCfg.Input.Mode
+	enableTransaction := *runCfg.Output.ReplayTransaction
 
-	if outputRedis.IsStanalone() {
+	if outputRedis.IsSharded() || outputRedis.Proxy {
+		// standalone/cluster <-> sharded standalone or proxies ==> update checkpoint periodically
+		var inputs []config.RedisConfig
+		if inputRedis.IsCluster() && inputMode != config.InputModeStatic {
+			inputs = inputRedis.SelNodes(true, syncFrom)
+		} else {
+			inputs = inputRedis.SelNodes(false, syncFrom)
+		}
+		for i, source := range inputs {
+			source.Type = config.RedisTypeStandalone
+			output := *outputRedis
+			if outputRedis.Proxy { // proxies are in front of the same redis, spread inputs
+				output = outputRedis.Index(i % len(outputRedis.Addresses))
+			}
+			cfgs = append(cfgs, syncer.SyncerConfig{
+				Id:             i,
+				CanTransaction: false,
+				Output:         output,
+				Input:          source,
+				Channel:        *runCfg.Channel.Clone(),
+			})
+		}
+		// monitor typology, if changed, restart syncer
+		watchInput = inputRedis.IsCluster()
+	} else if outputRedis.IsStanalone() {
 		// standalone <-> standalone  ==> multi/exec
 		if inputRedis.IsStanalone() {
 			// @TODO auto sharding
-			if len(inputRedis.Addresses) != len(outputRedis.Addresses) {
+			inputs := inputRedis.SelNodes(false, syncFrom)
+			// inputs which have their own outputs are not paired with output redis
+			paired := 0
+			for _, source := range inputs {
+				if !hasOwnOutput(runCfg, source) {
+					paired++
+				}
+			}
+			if paired != 0 && paired != len(outputRedis.Addresses) {
 				err = errors.Join(syncer.ErrQuit, fmt.Errorf("the amount of input redis does not equal output redis : %d != %d",
-					len(inputRedis.Addresses), len(outputRedis.Addresses)))
+					paired, len(outputRedis.Addresses)))
 				sc.logger.Errorf("%v", err)
 				return
 			}
-			inputs := inputRedis.SelNodes(false, syncFrom)
+			j := 0
 			for i, source := range inputs {
 				scg := syncer.SyncerConfig{
 					Id:             i,
 					CanTransaction: true,
-					Output:         outputRedis.Index(i),
 					Input:          source,
-					Channel:        *config.Get().Channel.Clone(),
+					Channel:        *runCfg.Channel.Clone(),
+				}
+				if !hasOwnOutput(runCfg, source) {
+					scg.Output = outputRedis.Index(j)
+					j++
 				}
 				if !enableTransaction {
 					scg.CanTransaction = false
@@ -188,7 +290,7 @@ func (sc *SyncerCmd) syncerConfigs() (cfgs []syncer.SyncerConfig, watchInput boo
 					CanTransaction: true,
 					Output:         *outputRedis,
 					Input:          source,
-					Channel:        *config.Get().Channel.Clone(),
+					Channel:        *runCfg.Channel.Clone(),
 				}
 				if !enableTransaction {
 					scg.CanTransaction = false
@@ -211,7 +313,7 @@ func (sc *SyncerCmd) syncerConfigs() (cfgs []syncer.SyncerConfig, watchInput boo
 					CanTransaction: false,
 					Output:         *outputRedis,
 					Input:          source,
-					Channel:        *config.Get().Channel.Clone(),
+					Channel:        *runCfg.Channel.Clone(),
 				})
 			}
 		} else if inputRedis.IsCluster() { // cluster    <-> cluster     ==> dynamical : multi/exec or update periodically
@@ -248,7 +350,7 @@ func (sc *SyncerCmd) syncerConfigs() (cfgs []syncer.SyncerConfig, watchInput boo
 							CanTransaction: true,
 							Output:         sortedOut[i],
 							Input:          source,
-							Channel:        *config.Get().Channel.Clone(),
+							Channel:        *runCfg.Channel.Clone(),
 						}
 						if !enableTransaction {
 							scg.CanTransaction = false
@@ -263,7 +365,7 @@ func (sc *SyncerCmd) syncerConfigs() (cfgs []syncer.SyncerConfig, watchInput boo
 							CanTransaction: false,
 							Output:         *outputRedis,
 							Input:          source,
-							Channel:        *config.Get().Channel.Clone(),
+							Channel:        *runCfg.Channel.Clone(),
 						})
 					}
 				}
@@ -283,7 +385,7 @@ func (sc *SyncerCmd) syncerConfigs() (cfgs []syncer.SyncerConfig, watchInput boo
 						CanTransaction: false,
 						Output:         *outputRedis,
 						Input:          source,
-						Channel:        *config.Get().Channel.Clone(),
+						Channel:        *runCfg.Channel.Clone(),
 					})
 				}
 			}
@@ -295,11 +397,19 @@ func (sc *SyncerCmd) syncerConfigs() (cfgs []syncer.SyncerConfig, watchInput boo
 		watchOutput = true
 	}
 
-	if len(cfgs) > 0 {
-		maxSize := config.Get().Channel.Storer.MaxSize / int64(len(cfgs))
-		for i := 0; i < len(cfgs); i++ {
-			cfgs[i].Channel.Storer.MaxSize = maxSize
-		}
+	// overrides of inputs, and syncers share maxSize of the directory of their channels
+	channels := make([]*config.ChannelConfig, len(cfgs))
+	shared := make(map[string]int64)
+	for i := range cfgs {
+		overrideSyncerConfig(runCfg, &cfgs[i])
+		channels[i] = runCfg.InputSettings(cfgs[i].Input.ShardAddresses()...).Channel
+		shared[channels[i].Storer.DirPath]++
+	}
+	limits := runCfg.StorerLimits()
+	for i := range cfgs {
+		dirPath := channels[i].Storer.DirPath
+		cfgs[i].Channel = *channels[i].Clone()
+		cfgs[i].Channel.Storer.MaxSize = limits[dirPath] / shared[dirPath]
 	}
 
 	for _, cc := range cfgs {
@@ -363,6 +473,7 @@ func checkMigrating(ctx context.Context, redisCfg config.RedisConfig) (bool, err
 				UserName:  redisCfg.UserName,
 				Password:  redisCfg.Password,
 				TlsEnable: redisCfg.TlsEnable,
+				Tls:       redisCfg.Tls,
 				Type:      config.RedisTypeStandalone,
 				Version:   redisCfg.Version,
 			})
@@ -438,10 +549,13 @@ func (sc *SyncerCmd) run() error {
 	sc.mutex.Unlock()
 
 	// syncer configurations
-	cfgs, watchIn, watchOut, txnMode, err := sc.syncerConfigs()
+	cfgs, watchIn, watchOut, txnMode, err := sc.syncerConfigs(config.Get())
 	if err != nil {
 		return err
 	}
+	sc.mutex.Lock()
+	sc.runMode = syncerRunMode{watchIn: watchIn, watchOut: watchOut, txnMode: txnMode}
+	sc.mutex.Unlock()
 
 	if watchIn || watchOut {
 		sc.checkTypology(runWait, watchIn, watchOut, txnMode)
@@ -522,6 +636,9 @@ func (sc *SyncerCmd) runCluster(runWait usync.WaitCloser, cli *cluster.Cluster,
 					} else {
 						role = newRole
 					}
+					if role != cluster.RoleCandidate {
+						recordRole(cfg.Input.Address(), cluster.RoleCandidate, role, nil)
+					}
 					continue
 				}
 
@@ -569,6 +686,7 @@ func (sc *SyncerCmd) runCluster(runWait usync.WaitCloser, cli *cluster.Cluster,
 					}
 					cancel()
 				}
+				recordRole(cfg.Input.Address(), role, cluster.RoleCandidate, err)
 				role = cluster.RoleCandidate
 				sc.delSyncer(cfg.Input.Address())
 
@@ -592,10 +710,31 @@ func (sc *SyncerCmd) runCluster(runWait usync.WaitCloser, cli *cluster.Cluster,
 	}
 }
 
+// recordRole records the role change of the input to the journal, the error of the syncer is the reason
+func recordRole(input string, from cluster.ClusterRole, to cluster.ClusterRole, err error) {
+	ev := journal.Event{
+		Input: input,
+		Actor: journal.ActorElection,
+		Type:  journal.TypeRole,
+		From:  from.String(),
+		To:    to.String(),
+	}
+	if err != nil {
+		ev.Reason = err.Error()
+		if errors.Is(err, syncer.ErrLeaderHandover) {
+			ev.Type = journal.TypeHandover
+		}
+	}
+	journal.Record(ev)
+}
+
 func (sc *SyncerCmd) clusterCampaign(ctx context.Context, elect *cluster.Election) (cluster.ClusterRole, error) {
 	ctx, cancel := context.WithTimeout(ctx, config.Get().Cluster.LeaseRenewInterval)
 	defer cancel()
+	ctx, span := telemetry.StartSpan(ctx, "cluster.election", attribute.String("peer", config.Get().Server.ListenPeer))
 	newRole, err := elect.Campaign(ctx, config.Get().Server.ListenPeer)
+	span.SetAttributes(attribute.String("role", newRole.String()))
+	telemetry.EndSpan(span, err)
 	sc.logger.Debugf("campaign : newRole(%v), error(%v)", newRole, err)
 	if err != nil {
 		sc.logger.Errorf("campaign : newRole(%v), error(%v)", newRole, err)
@@ -684,22 +823,32 @@ var (
 )
 
 func (sc *SyncerCmd) storageSize(ctx context.Context) {
-	if config.Get().Channel == nil || config.Get().Channel.Storer == nil {
+	runCfg := config.Get()
+	if runCfg.Channel == nil || runCfg.Channel.Storer == nil {
 		return
 	}
-	dirPath := config.Get().Channel.Storer.DirPath
-	size, _, err := ufs.GetDirectorySize(dirPath)
-	if err != nil {
-		sc.logger.Errorf("%v", err)
-	} else {
-		storerSizeGauge.Set(float64(size))
-		storerRatioGauge.Set(float64(size) / float64(config.Get().Channel.Storer.MaxSize))
+	var total, maxSize int64
+	for dirPath, limit := range runCfg.StorerLimits() {
+		size, _, err := ufs.GetDirectorySize(dirPath)
+		if err != nil {
+			sc.logger.Errorf("%v", err)
+			return
+		}
+		total += size
+		maxSize += limit
 	}
+	storerSizeGauge.Set(float64(total))
+	storerRatioGauge.Set(float64(total) / float64(maxSize))
 }
 
 func (sc *SyncerCmd) gcStaleCheckpoint(ctx context.Context) {
 	sc.logger.Debugf("gc stale checkpoints...")
 
+	// run ids of rump and file inputs are not run ids of input redis
+	if config.Get().Input.SyncType != config.TypeSync {
+		return
+	}
+
 	// masters and slaves
 	inputs := config.Get().Input.Redis.SelNodes(true, config.SelNodeStrategyMaster)
 	inputs = append(inputs, config.Get().Input.Redis.SelNodes(true, config.SelNodeStrategySlave)...)
@@ -724,10 +873,10 @@ func (sc *SyncerCmd) gcStaleCheckpoint(ctx context.Context) {
 		cli.Close()
 	}
 
-	gcStaleCp := func(cli client.Redis) {
-		data, err := checkpoint.GetAllCheckpointHash(cli)
+	gcStaleCp := func(store checkpoint.Store) {
+		data, err := store.GetAllCheckpointHash()
 		if err != nil {
-			sc.logger.Errorf("get checkpoint from hash error : redis(%v), err(%v)", cli.Addresses(), err)
+			sc.logger.Errorf("get checkpoint from hash error : store(%s), err(%v)", store.Name(), err)
 			return
 		}
 		if len(data)%2 == 1 {
@@ -741,40 +890,35 @@ func (sc *SyncerCmd) gcStaleCheckpoint(ctx context.Context) {
 
 			// run id maybe obsolete or a new run id
 			// delete stale checkpoints that have not been updated in the last 12 hours
-			total, deleted, err := checkpoint.DelStaleCheckpoint(cli, cpn, runId, config.Get().Channel.StaleCheckpointDuration, exist)
+			total, deleted, err := store.DelStaleCheckpoint(cpn, runId, config.Get().Channel.StaleCheckpointDuration, exist)
 			if err != nil {
 				sc.logger.Errorf("DelStaleCheckpoint : cp(%s), runId(%s), error(%v)", cpn, runId, err)
 			}
+			if deleted > 0 {
+				journal.Record(journal.Event{
+					Actor:  journal.ActorGc,
+					Type:   journal.TypeCheckpoint,
+					To:     "deleted",
+					Reason: fmt.Sprintf("stale checkpoints : store(%s), cp(%s), runId(%s), deleted(%d)", store.Name(), cpn, runId, deleted),
+				})
+			}
 			if !exist && total == deleted {
-				err = checkpoint.DelCheckpointHash(cli, runId)
+				err = store.DelCheckpointHash(runId)
 				sc.logger.Log(err, "delete runId from checkpoint hash error : runId(%s), err(%v)", runId, err)
 			}
 		}
 	}
 
-	if config.Get().Output.Redis.Type == config.RedisTypeCluster {
-		cli, err := client.NewRedis(*config.Get().Output.Redis)
-		if err != nil {
-			sc.logger.Errorf("new redis error : addr(%s), err(%v)", config.Get().Output.Redis.Address(), err)
-			return
-		}
-		gcStaleCp(cli)
-		cli.Close()
-	} else if config.Get().Output.Redis.Type == config.RedisTypeStandalone {
-		outputs := config.Get().Output.Redis.SelNodes(true, config.SelNodeStrategyMaster)
-		for _, out := range outputs {
-			cli, err := client.NewRedis(out)
-			if err != nil {
-				return
-			}
-			gcStaleCp(cli)
-			cli.Close()
-		}
+	err := sc.forEachCheckpointStore(ctx, config.Get(), true, func(store checkpoint.Store) error {
+		gcStaleCp(store)
+		return nil
+	})
+	if err != nil {
+		return
 	}
 
 	// @TODO maxSize
-	gcStaleStorer := func() {
-		dirPath := config.Get().Channel.Storer.DirPath
+	gcStaleStorer := func(dirPath string) {
 		entries, err := os.ReadDir(dirPath)
 		if err != nil {
 			sc.logger.Errorf("ReadDir : dir(%s), error(%v)", dirPath, err)
@@ -804,7 +948,54 @@ func (sc *SyncerCmd) gcStaleCheckpoint(ctx context.Context) {
 		}
 	}
 
-	gcStaleStorer()
+	for _, dirPath := range storerDirs(config.Get()) {
+		gcStaleStorer(dirPath)
+	}
+}
+
+// forEachCheckpointStore calls fn with checkpoint stores, including output redis, checkpoint redis and stores outside of redis
+func (sc *SyncerCmd) forEachCheckpointStore(ctx context.Context, runCfg *config.Config, selAllShards bool, fn func(store checkpoint.Store) error) error {
+	call := func(cfg *config.CheckpointStoreConfig, redisCfg config.RedisConfig) error {
+		store, err := checkpoint.NewStore(ctx, cfg, redisCfg)
+		if err != nil {
+			sc.logger.Errorf("new checkpoint store error : addr(%s), err(%v)", redisCfg.Address(), err)
+			return err
+		}
+		err = fn(store)
+		store.Close()
+		return err
+	}
+	for _, outputRedis := range runCfg.CheckpointRedises() {
+		if outputRedis.Type == config.RedisTypeCluster {
+			if err := call(nil, *outputRedis); err != nil {
+				return err
+			}
+		} else if outputRedis.Type == config.RedisTypeStandalone {
+			outputs := outputRedis.SelNodes(selAllShards, config.SelNodeStrategyMaster)
+			for _, out := range outputs {
+				if err := call(nil, out); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, cs := range runCfg.CheckpointStores() {
+		if err := call(cs, config.RedisConfig{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storerDirs returns distinct directories of channels
+func storerDirs(runCfg *config.Config) []string {
+	dirs := []string{}
+	for _, ch := range runCfg.Channels() {
+		if !slices.Contains(dirs, ch.Storer.DirPath) {
+			dirs = append(dirs, ch.Storer.DirPath)
+		}
+	}
+	return dirs
 }
 
 // monitor the typology of redis
@@ -833,9 +1024,19 @@ func (sc *SyncerCmd) checkTypology(wait usync.WaitCloser,
 
 	sc.logger.Debugf("cronjob, check typology of redis cluster : input(%s), output(%s), watch(%v, %v), ticker(%s), txnMode(%v)", prevInRedisCfg.Address(), prevOutRedisCfg.Address(), watchIn, watchOut, interval, txnMode)
 
+	cfgGen := config.Generation()
 	util.CronWithCtx(wait.Context(), interval, func(ctx context.Context) {
 		defer util.RecoverCallback(func(e interface{}) { wait.Close(errors.Join(syncer.ErrRestart, fmt.Errorf("panic : %v", e))) })
 
+		// configuration is reloaded
+		if gen := config.Generation(); gen != cfgGen {
+			cfgGen = gen
+			prevInRedisCfg = config.Get().Input.Redis
+			prevOutRedisCfg = config.Get().Output.Redis
+			allShards = config.Get().Input.Mode != config.InputModeStatic
+			syncFrom = config.Get().Input.SyncFrom
+		}
+
 		sc.logger.Debugf("diff typology")
 
 		restart := sc.diffTypology(ctx, watchIn, watchOut,
@@ -1023,19 +1224,46 @@ func (sc *SyncerCmd) diffTypology(ctx context.Context, watchIn bool, watchOut bo
 
 	if restart {
 		sc.logger.Infof("check typology, restart(%s)", reason)
+		journal.Record(journal.Event{Actor: journal.ActorTypology, Type: journal.TypeRestart, Reason: reason})
 	}
 
 	return restart
 }
 
 func (sc *SyncerCmd) fixConfig() (err error) {
-
-	// redis version
-	fixVersion := func(redisCfg *config.RedisConfig) error {
-		if redisCfg.Version != "" {
-			return nil
+	err = fixInputConfig(config.Get().Input)
+	if err != nil {
+		return
+	}
+	for _, outputRedis := range config.Get().OutputRedises() {
+		err = fixRedisConfig(outputRedis)
+		if err != nil {
+			return
+		}
+	}
+	for _, cpRedis := range config.Get().CheckpointRedises() {
+		err = fixRedisConfig(cpRedis)
+		if err != nil {
+			return
 		}
+	}
+
+	// fix concurrency
+
+	return nil
+}
+
+// fixInputConfig fixes input redis, the redis of file input is a placeholder, it can't be connected
+func fixInputConfig(inputCfg *config.InputConfig) error {
+	if inputCfg.SyncType == config.TypeFile {
+		return redis.FixTopology(inputCfg.Redis)
+	}
+	return fixRedisConfig(inputCfg.Redis)
+}
 
+// fixRedisConfig fixes redis version and typology
+func fixRedisConfig(redisCfg *config.RedisConfig) error {
+	if redisCfg.Version == "" {
 		cli, err := client.NewRedis(*redisCfg)
 		if err != nil {
 			log.Errorf("new redis error : addr(%s), error(%v)", redisCfg.Address(), err)
@@ -1054,29 +1282,10 @@ func (sc *SyncerCmd) fixConfig() (err error) {
 		}
 
 		redisCfg.Version = ver
-		return nil
-	}
-	err = fixVersion(config.Get().Input.Redis)
-	if err != nil {
-		return
-	}
-
-	err = fixVersion(config.Get().Output.Redis)
-	if err != nil {
-		return
 	}
 
 	// addresses
-	if err = redis.FixTopology(config.Get().Input.Redis); err != nil {
-		return
-	}
-	if err = redis.FixTopology(config.Get().Output.Redis); err != nil {
-		return
-	}
-
-	// fix concurrency
-
-	return nil
+	return redis.FixTopology(redisCfg)
 }
 
 func shardsEqual(shardA []*config.RedisClusterShard, shardB []*config.RedisClusterShard) bool {
@@ -2,15 +2,18 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-contrib/pprof"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/soheilhy/cmux"
@@ -20,10 +23,13 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/api/auth"
 	pb "github.com/mgtv-tech/redis-GunYu/pkg/api/golang"
+	"github.com/mgtv-tech/redis-GunYu/pkg/cluster"
+	unet "github.com/mgtv-tech/redis-GunYu/pkg/io/net"
+	"github.com/mgtv-tech/redis-GunYu/pkg/journal"
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis/checkpoint"
-	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client"
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
 	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
 	"github.com/mgtv-tech/redis-GunYu/pkg/util"
@@ -37,12 +43,24 @@ func (sc *SyncerCmd) startServer() {
 		return
 	}
 
+	serverCfg := config.Get().Server
+
 	// listen
 	listener, err := net.Listen("tcp", listen)
 	if err != nil {
 		sc.waitCloser.Close(err)
 		return
 	}
+	if serverCfg.Tls != nil {
+		tlsCfg, err := unet.NewServerTlsConfig(serverCfg.Tls.TlsOptions())
+		if err != nil {
+			listener.Close()
+			sc.logger.Errorf("server tls config error : %v", err)
+			sc.waitCloser.Close(err)
+			return
+		}
+		listener = tls.NewListener(listener, tlsCfg)
+	}
 
 	m := cmux.New(listener)
 	sc.multiListener = m
@@ -54,6 +72,21 @@ func (sc *SyncerCmd) startServer() {
 
 	// grpc server
 	ServerOptions := []grpc.ServerOption{}
+	if serverCfg.Tls != nil {
+		ServerOptions = append(ServerOptions, grpc.Creds(auth.NewServerCredentials()))
+	}
+	verifier := auth.PeerVerifier{}
+	if serverCfg.Tls != nil && serverCfg.Tls.ClientAuth {
+		verifier.RequireCert = true
+	}
+	if serverCfg.Auth != nil && serverCfg.Auth.PeerToken != "" {
+		verifier.Token = serverCfg.Auth.PeerToken.Value
+	}
+	if verifier.RequireCert || verifier.Token != nil {
+		ServerOptions = append(ServerOptions,
+			grpc.ChainUnaryInterceptor(verifier.UnaryInterceptor()),
+			grpc.ChainStreamInterceptor(verifier.StreamInterceptor()))
+	}
 	svr := grpc.NewServer(ServerOptions...)
 	pb.RegisterApiServiceServer(svr, sc)
 	reflection.Register(svr)
@@ -74,8 +107,9 @@ func (sc *SyncerCmd) startServer() {
 	sc.httpHandler(engine)
 
 	httpSvr := &http.Server{
-		Addr:    listen,
-		Handler: engine,
+		Addr:        listen,
+		Handler:     engine,
+		ConnContext: connContext,
 	}
 	sc.httpSvr = httpSvr
 
@@ -90,7 +124,7 @@ func (sc *SyncerCmd) startServer() {
 		m.Serve()
 	}, nil)
 
-	sc.logger.Infof("start grpc and http server, listening on %s", listen)
+	sc.logger.Infof("start grpc and http server, listening on %s, tls(%v), auth(%v)", listen, serverCfg.Tls != nil, serverCfg.Auth != nil)
 }
 
 func (sc *SyncerCmd) stopServer() {
@@ -146,6 +180,14 @@ func (sc *SyncerCmd) Sync(req *pb.SyncRequest, stream pb.ApiService_SyncServer)
 func (sc *SyncerCmd) httpHandler(engine *gin.Engine) {
 	httpCfg := config.Get().Server
 
+	if httpCfg.Auth != nil || (httpCfg.Tls != nil && httpCfg.Tls.ClientAuth) {
+		authCfg := httpCfg.Auth
+		if authCfg == nil {
+			authCfg = &config.ServerAuthConfig{}
+		}
+		engine.Use(sc.authHandler(authCfg))
+	}
+
 	// metrics
 	engine.GET(httpCfg.MetricRoutePath, func(ctx *gin.Context) {
 		h := promhttp.Handler()
@@ -204,11 +246,22 @@ func (sc *SyncerCmd) httpHandler(engine *gin.Engine) {
 		}
 	})
 
+	syncerGroup.POST("config/reload", func(ctx *gin.Context) {
+		diff, err := sc.reloadConfig()
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, diff)
+	})
+
 	syncerGroup.POST("restart", func(ctx *gin.Context) {
+		recordApi(ctx, "", journal.TypeRestart, "", "", "")
 		sc.getRunWait().Close(errors.Join(context.Canceled, syncer.ErrRestart))
 	})
 
 	syncerGroup.POST("stop", func(ctx *gin.Context) {
+		recordApi(ctx, "", journal.TypeStop, "", "", "")
 		sc.getRunWait().Close(syncer.ErrStopSync)
 	})
 
@@ -221,6 +274,7 @@ func (sc *SyncerCmd) httpHandler(engine *gin.Engine) {
 		for _, input := range inputs {
 			sync := sc.getSyncer(input)
 			if sync.sync != nil {
+				recordApi(ctx, input, journal.TypePause, sync.sync.State().String(), syncer.SyncerStatePause.String(), "")
 				sync.sync.Pause()
 			}
 		}
@@ -235,11 +289,103 @@ func (sc *SyncerCmd) httpHandler(engine *gin.Engine) {
 		for _, input := range inputs {
 			sync := sc.getSyncer(input)
 			if sync.sync != nil {
+				recordApi(ctx, input, journal.TypeResume, sync.sync.State().String(), syncer.SyncerStateReadyRun.String(), "")
 				sync.sync.Resume()
 			}
 		}
 	})
 
+	// delayed replica
+	type delayStatus struct {
+		Input       string
+		Delay       string
+		Paused      bool
+		FastForward time.Time
+	}
+	syncerGroup.GET("delay", func(ctx *gin.Context) {
+		sts := []delayStatus{}
+		sc.mutex.Lock()
+		for key, val := range sc.syncers {
+			ds := val.sync.Delay().Status()
+			sts = append(sts, delayStatus{
+				Input:       key,
+				Delay:       config.Get().InputSettings(key).Output.Delay.String(),
+				Paused:      ds.Paused,
+				FastForward: ds.FastForward,
+			})
+		}
+		sc.mutex.Unlock()
+		ctx.JSON(http.StatusOK, sts)
+	})
+	delayHandler := func(fn func(*syncer.DelayControl)) gin.HandlerFunc {
+		return func(ctx *gin.Context) {
+			inputs := sc.parseInputsFromQuery(ctx)
+			if len(inputs) == 0 {
+				ctx.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			for _, input := range inputs {
+				sync := sc.getSyncer(input)
+				if sync.sync != nil {
+					fn(sync.sync.Delay())
+				}
+			}
+		}
+	}
+	syncerGroup.POST("delay/pause", delayHandler((*syncer.DelayControl).Pause))
+	syncerGroup.POST("delay/resume", delayHandler((*syncer.DelayControl).Resume))
+	syncerGroup.POST("delay/fastforward", delayHandler((*syncer.DelayControl).FastForward))
+
+	// dangerous command guard
+	type guardStatus struct {
+		Input  string
+		Window *syncer.GuardWindow
+	}
+	syncerGroup.GET("guard", func(ctx *gin.Context) {
+		sts := []guardStatus{}
+		sc.mutex.Lock()
+		for key, val := range sc.syncers {
+			sts = append(sts, guardStatus{Input: key, Window: val.sync.Guard().Window()})
+		}
+		sc.mutex.Unlock()
+		ctx.JSON(http.StatusOK, sts)
+	})
+	guardHandler := func(fn func(*syncer.GuardControl) bool) gin.HandlerFunc {
+		return func(ctx *gin.Context) {
+			inputs := sc.parseInputsFromQuery(ctx)
+			if len(inputs) == 0 {
+				ctx.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			decided := []string{}
+			for _, input := range inputs {
+				sync := sc.getSyncer(input)
+				if sync.sync != nil && fn(sync.sync.Guard()) {
+					decided = append(decided, input)
+				}
+			}
+			ctx.JSON(http.StatusOK, decided)
+		}
+	}
+	syncerGroup.POST("guard/approve", guardHandler((*syncer.GuardControl).Approve))
+	syncerGroup.POST("guard/skip", guardHandler((*syncer.GuardControl).Skip))
+
+	// lag of inputs, only leaders measure the lag
+	type lagStatus struct {
+		Input  string
+		Leader bool
+		syncer.LagStatus
+	}
+	syncerGroup.GET("lag", func(ctx *gin.Context) {
+		sts := []lagStatus{}
+		sc.mutex.Lock()
+		for key, val := range sc.syncers {
+			sts = append(sts, lagStatus{Input: key, Leader: val.sync.IsLeader(), LagStatus: val.sync.Lag().Status()})
+		}
+		sc.mutex.Unlock()
+		ctx.JSON(http.StatusOK, sts)
+	})
+
 	syncerGroup.POST("handover", func(ctx *gin.Context) {
 		inputs := sc.parseInputsFromQuery(ctx)
 		if len(inputs) == 0 {
@@ -249,12 +395,144 @@ func (sc *SyncerCmd) httpHandler(engine *gin.Engine) {
 		for _, input := range inputs {
 			sync := sc.getSyncer(input)
 			if sync.wait != nil && sync.sync.IsLeader() {
+				recordApi(ctx, input, journal.TypeHandover, cluster.RoleLeader.String(), cluster.RoleCandidate.String(), "")
 				sync.wait.Close(syncer.ErrLeaderHandover)
 			}
 		}
 	})
 
 	syncerGroup.POST("fullsync", sc.fullSyncHandler)
+
+	// journal
+	syncerGroup.GET("events", sc.eventsHandler)
+	syncerGroup.GET("events/stream", sc.eventStreamHandler)
+}
+
+// recordApi records the API call to the journal, the actor is the client
+func recordApi(ctx *gin.Context, input string, typ string, from string, to string, reason string) {
+	journal.Record(journal.Event{
+		Input:  input,
+		Actor:  apiActor(ctx),
+		Type:   typ,
+		From:   from,
+		To:     to,
+		Reason: reason,
+	})
+}
+
+func apiActor(ctx *gin.Context) string {
+	return fmt.Sprintf("%s(%s)", journal.ActorApi, ctx.ClientIP())
+}
+
+// parseEventQuery parses inputs and since from the query, since is overridden by the Last-Event-ID header
+func parseEventQuery(ctx *gin.Context) (journal.Query, error) {
+	q := journal.Query{}
+	for _, in := range strings.Split(ctx.Query("input"), ",") {
+		if in != "" {
+			q.Inputs = append(q.Inputs, in)
+		}
+	}
+	since := ctx.Query("since")
+	if id := ctx.GetHeader("Last-Event-ID"); id != "" {
+		since = id
+	}
+	if since != "" {
+		seq, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("invalid since : %s", since)
+		}
+		q.Since = seq
+	}
+	return q, nil
+}
+
+// eventsHandler returns events of inputs in order, the latest 100 events by default
+func (sc *SyncerCmd) eventsHandler(ctx *gin.Context) {
+	q, err := parseEventQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	q.Limit = 100
+	if limit := ctx.Query("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit : %s", limit)})
+			return
+		}
+	}
+	jn, err := journal.Get()
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := jn.Query(q)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, events)
+}
+
+// eventStreamHandler streams events as server-sent events, the id of an event is its sequence,
+// events after since are sent first, so a client resumes from the latest received event after reconnecting
+func (sc *SyncerCmd) eventStreamHandler(ctx *gin.Context) {
+	q, err := parseEventQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	jn, err := journal.Get()
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	// subscribe before querying, events between them are deduplicated by sequences
+	sub := jn.Subscribe(q.Inputs, 256)
+	defer sub.Close()
+	var events []journal.Event
+	if q.Since > 0 {
+		if events, err = jn.Query(q); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Status(http.StatusOK)
+	last := q.Since
+	send := func(ev journal.Event) {
+		if ev.Seq <= last {
+			return
+		}
+		last = ev.Seq
+		ctx.Render(-1, sse.Event{Id: strconv.FormatInt(ev.Seq, 10), Event: ev.Type, Data: ev})
+	}
+	for _, ev := range events {
+		send(ev)
+	}
+	ctx.Writer.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			send(ev)
+		case <-keepalive.C:
+			ctx.Writer.WriteString(": keepalive\n\n")
+		case <-ctx.Request.Context().Done():
+			return
+		case <-sc.waitCloser.Done():
+			return
+		}
+		ctx.Writer.Flush()
+	}
 }
 
 func (sc *SyncerCmd) parseInputsFromQuery(ctx *gin.Context) []string {
@@ -362,6 +640,8 @@ func (sc *SyncerCmd) fullSyncHandler(ginCtx *gin.Context) {
 	for _, input := range inputs {
 		si := sc.getSyncer(input)
 		if si.sync != nil {
+			recordApi(ginCtx, input, journal.TypeFullSync, si.sync.State().String(), syncer.SyncerStatePause.String(),
+				fmt.Sprintf("flushdb(%v)", flushdb))
 			si.sync.Pause()
 			si.sync.DelRunId()
 		}
@@ -380,7 +660,7 @@ func (sc *SyncerCmd) fullSyncHandler(ginCtx *gin.Context) {
 
 	// delete checkpoints,
 	// if flush all nodes of cluster, ignore... @TODO
-	err := sc.delCheckpoints(ctx, inputs)
+	err := sc.delCheckpoints(ctx, inputs, apiActor(ginCtx))
 	if err != nil {
 		sc.resume(ctx, inputs)
 		sc.logger.Errorf("delCheckpoint error : %v", err)
@@ -397,6 +677,10 @@ func (sc *SyncerCmd) fullSyncHandler(ginCtx *gin.Context) {
 }
 
 func (sc *SyncerCmd) takeover(ctx context.Context, inputs []string) error {
+	httpCli, err := peerHttpClient()
+	if err != nil {
+		return err
+	}
 	takeover := func() error {
 		cg := usync.NewConGroup(20)
 		group := cg.NewGroup(ctx, usync.WithCancelIfError(false))
@@ -406,16 +690,18 @@ func (sc *SyncerCmd) takeover(ctx context.Context, inputs []string) error {
 		}
 
 		for _, vv := range syncers {
-			req, _ := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s/syncer/handover", vv), nil)
+			req, _ := http.NewRequestWithContext(ctx, http.MethodPost, peerUrl(vv, "/syncer/handover"), nil)
 			query := url.Values{}
 			query.Set("inputs", strings.Join(inputs, ","))
 			req.URL.RawQuery = query.Encode()
+			setPeerAuthorization(req)
 
 			group.Go(func(ctx context.Context) error {
-				resp, err := http.DefaultClient.Do(req)
+				resp, err := httpCli.Do(req)
 				if err != nil {
 					return err
 				}
+				resp.Body.Close()
 				if resp.StatusCode != 200 {
 					return fmt.Errorf("status code : %d", resp.StatusCode)
 				}
@@ -425,7 +711,7 @@ func (sc *SyncerCmd) takeover(ctx context.Context, inputs []string) error {
 		return group.Wait()
 	}
 
-	err := util.RetryLinearJitter(ctx, func() error {
+	err = util.RetryLinearJitter(ctx, func() error {
 		return takeover()
 	}, 10, time.Second, 0.3)
 	if err != nil {
@@ -465,8 +751,17 @@ func (sc *SyncerCmd) takeover(ctx context.Context, inputs []string) error {
 }
 
 func (sc *SyncerCmd) filterOutput(ctx context.Context, inputs []string) ([]config.RedisConfig, error) {
+	if len(config.Get().Inputs) > 0 {
+		return sc.filterSyncerOutput(inputs)
+	}
 	outputCfgs := []config.RedisConfig{}
 	allInputs := sc.allInputs(ctx)
+	if config.Get().Output.Redis.IsSharded() || config.Get().Output.Redis.Proxy { // keys of each input are distributed to all outputs
+		if len(allInputs) != len(inputs) {
+			return nil, errors.New("output redis is sharded or proxy, can't select related outputs")
+		}
+		return sc.allOutputs(ctx), nil
+	}
 	if len(allInputs) == len(inputs) {
 		outputCfgs = sc.allOutputs(ctx)
 	} else { // partial : select related outputs
@@ -510,6 +805,26 @@ func (sc *SyncerCmd) filterOutput(ctx context.Context, inputs []string) ([]confi
 	return outputCfgs, nil
 }
 
+// filterSyncerOutput selects outputs of running syncers, outputs may be overridden by inputs
+func (sc *SyncerCmd) filterSyncerOutput(inputs []string) ([]config.RedisConfig, error) {
+	outputCfgs := []config.RedisConfig{}
+	for _, input := range inputs {
+		sy := sc.getSyncer(input)
+		if sy.sync == nil {
+			return nil, fmt.Errorf("no syncer : %s", input)
+		}
+		cfg := sy.sync.Config()
+		if cfg.Output.IsCluster() && !cfg.CanTransaction { // the whole cluster
+			return nil, fmt.Errorf("slots of input and output are inconsistent : %s", input)
+		}
+		if cfg.Output.IsSharded() || cfg.Output.Proxy {
+			return nil, fmt.Errorf("output redis is sharded or proxy, can't select related outputs : %s", input)
+		}
+		outputCfgs = append(outputCfgs, cfg.Output)
+	}
+	return outputCfgs, nil
+}
+
 func (sc *SyncerCmd) flushdb(ctx context.Context, inputs []string, flushCmd string) error {
 	// check outputs
 	outputCfgs, err := sc.filterOutput(ctx, inputs)
@@ -546,8 +861,9 @@ func (sc *SyncerCmd) flushdb(ctx context.Context, inputs []string, flushCmd stri
 	}, 10, time.Second, 0.3)
 }
 
-func (sc *SyncerCmd) delCheckpoints(ctx context.Context, inputs []string) error {
-	runIdMap := make(map[string]struct{}, len(inputs)*2)
+// delCheckpoints deletes checkpoints of inputs, deletions are recorded to the journal with the actor
+func (sc *SyncerCmd) delCheckpoints(ctx context.Context, inputs []string, actor string) error {
+	runIdMap := make(map[string]string, len(inputs)*2) // run id : input
 	for _, in := range inputs {
 		sy := sc.getSyncer(in)
 		if sy.wait == nil {
@@ -555,14 +871,14 @@ func (sc *SyncerCmd) delCheckpoints(ctx context.Context, inputs []string) error
 		}
 		runIds := sy.sync.RunIds()
 		for _, id := range runIds {
-			runIdMap[id] = struct{}{}
+			runIdMap[id] = in
 		}
 	}
 
-	delCheckpoint := func(cli client.Redis) error {
-		data, err := checkpoint.GetAllCheckpointHash(cli)
+	delCheckpoint := func(store checkpoint.Store) error {
+		data, err := store.GetAllCheckpointHash()
 		if err != nil {
-			return fmt.Errorf("get checkpoint from hash error : redis(%v), err(%v)", cli.Addresses(), err)
+			return fmt.Errorf("get checkpoint from hash error : store(%s), err(%v)", store.Name(), err)
 		}
 		if len(data)%2 == 1 {
 			return fmt.Errorf("the number of values of checkpoint hash is not even : addr(%v)", data)
@@ -570,44 +886,26 @@ func (sc *SyncerCmd) delCheckpoints(ctx context.Context, inputs []string) error
 		for i := 0; i < len(data)-1; i += 2 {
 			runId := data[i]
 			cpn := data[i+1]
-			_, exist := runIdMap[runId]
+			input, exist := runIdMap[runId]
 			if exist {
-				err = checkpoint.DelCheckpoint(cli, cpn, runId)
+				err = store.DelCheckpoint(cpn, runId)
 				if err != nil {
 					return fmt.Errorf("DelStaleCheckpoint : cp(%s), runId(%s), error(%v)", cpn, runId, err)
 				}
+				journal.Record(journal.Event{
+					Input:  input,
+					Actor:  actor,
+					Type:   journal.TypeCheckpoint,
+					To:     "deleted",
+					Reason: fmt.Sprintf("full sync : store(%s), cp(%s), runId(%s)", store.Name(), cpn, runId),
+				})
 			}
 		}
 		return nil
 	}
 
-	if config.Get().Output.Redis.Type == config.RedisTypeCluster {
-		cli, err := client.NewRedis(*config.Get().Output.Redis)
-		if err != nil {
-			sc.logger.Errorf("new redis error : addr(%s), err(%v)", config.Get().Output.Redis.Address(), err)
-			return err
-		}
-		err = delCheckpoint(cli)
-		cli.Close()
-		if err != nil {
-			return err
-		}
-	} else if config.Get().Output.Redis.Type == config.RedisTypeStandalone {
-		outputs := config.Get().Output.Redis.SelNodes(config.Get().Input.Mode != config.InputModeStatic, config.SelNodeStrategyMaster)
-		for _, out := range outputs {
-			cli, err := client.NewRedis(out)
-			if err != nil {
-				return err
-			}
-			err = delCheckpoint(cli)
-			cli.Close()
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
+	runCfg := config.Get()
+	return sc.forEachCheckpointStore(ctx, runCfg, runCfg.Input.Mode != config.InputModeStatic, delCheckpoint)
 }
 
 func (sc *SyncerCmd) resume(ctx context.Context, inputs []string) error {
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/journal"
+	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+)
+
+func TestEventsHandler(t *testing.T) {
+	assert.Nil(t, journal.Start(t.TempDir(), 1024*1024))
+	defer journal.Stop()
+	journal.Record(journal.Event{Input: "127.0.0.1:6379", Actor: journal.ActorSyncer, Type: journal.TypeSyncerState, From: "ready_run", To: "run"})
+	journal.Record(journal.Event{Input: "127.0.0.1:6380", Actor: journal.ActorSyncer, Type: journal.TypeSyncerState, From: "ready_run", To: "run"})
+	journal.Record(journal.Event{Actor: journal.ActorTypology, Type: journal.TypeRestart, Reason: "output nodes were changed"})
+
+	sc := &SyncerCmd{waitCloser: usync.NewWaitCloser(nil)}
+	defer sc.waitCloser.Close(nil)
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.GET("/syncer/events", sc.eventsHandler)
+	engine.GET("/syncer/events/stream", sc.eventStreamHandler)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	get := func(query string) (int, []journal.Event) {
+		resp, err := http.Get(server.URL + "/syncer/events?" + query)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		events := []journal.Event{}
+		json.NewDecoder(resp.Body).Decode(&events)
+		return resp.StatusCode, events
+	}
+	code, events := get("input=127.0.0.1:6379")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, "run", events[0].To)
+	assert.Equal(t, journal.TypeRestart, events[1].Type)
+
+	_, events = get("limit=1")
+	assert.Equal(t, int64(3), events[0].Seq)
+	code, _ = get("since=x")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// stream events after since, then new events
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/syncer/events/stream?input=127.0.0.1:6380", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() (id string, ev journal.Event) {
+		for {
+			line, err := reader.ReadString('\n')
+			if !assert.Nil(t, err) {
+				return
+			}
+			line = strings.TrimRight(line, "\n")
+			if line == "" && id != "" {
+				return
+			}
+			if v, ok := strings.CutPrefix(line, "id:"); ok {
+				id = v
+			} else if v, ok := strings.CutPrefix(line, "data:"); ok {
+				assert.Nil(t, json.Unmarshal([]byte(v), &ev))
+			}
+		}
+	}
+	id, ev := readEvent()
+	assert.Equal(t, "2", id)
+	assert.Equal(t, "127.0.0.1:6380", ev.Input)
+	id, _ = readEvent()
+	assert.Equal(t, "3", id)
+
+	// the stream subscribed before sending history
+	journal.Record(journal.Event{Input: "127.0.0.1:6379", Type: journal.TypePause})
+	journal.Record(journal.Event{Input: "127.0.0.1:6380", Type: journal.TypePause})
+	id, ev = readEvent()
+	assert.Equal(t, "5", id)
+	assert.Equal(t, journal.TypePause, ev.Type)
+}
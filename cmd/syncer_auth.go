@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/api/auth"
+	unet "github.com/mgtv-tech/redis-GunYu/pkg/io/net"
+)
+
+type httpRole int
+
+const (
+	httpRoleNone   httpRole = 0
+	httpRoleViewer httpRole = 1
+	httpRoleAdmin  httpRole = 2
+)
+
+func (r httpRole) String() string {
+	switch r {
+	case httpRoleViewer:
+		return "viewer"
+	case httpRoleAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+type tlsStateCtxKey struct{}
+
+// connContext stores tls state of connection, request.TLS is nil since connections are wrapped by cmux
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	if st, ok := unet.TlsState(auth.UnwrapConn(c)); ok {
+		return context.WithValue(ctx, tlsStateCtxKey{}, st)
+	}
+	return ctx
+}
+
+func tlsStateFromContext(ctx context.Context) *tls.ConnectionState {
+	st, _ := ctx.Value(tlsStateCtxKey{}).(*tls.ConnectionState)
+	return st
+}
+
+// routeRole returns the minimum role to access the route
+//   - health check : none
+//   - configurations may contain secrets : admin
+//   - other read-only routes : viewer
+//   - others are destructive : admin
+func routeRole(method string, path string) httpRole {
+	if path == "/debug/health" {
+		return httpRoleNone
+	}
+	if path == "/syncer/config" {
+		return httpRoleAdmin
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return httpRoleViewer
+	}
+	return httpRoleAdmin
+}
+
+// requestRole
+// a verified client certificate is regarded as a peer, so it has admin role
+func requestRole(authCfg *config.ServerAuthConfig, req *http.Request) httpRole {
+	if unet.VerifiedTlsState(tlsStateFromContext(req.Context())) {
+		return httpRoleAdmin
+	}
+	token := auth.BearerToken(req.Header.Get("Authorization"))
+	if token == "" {
+		return httpRoleNone
+	}
+	if authCfg.PeerToken != "" && secretEqual(token, authCfg.PeerToken) {
+		return httpRoleAdmin
+	}
+	for _, t := range authCfg.AdminTokens {
+		if secretEqual(token, t) {
+			return httpRoleAdmin
+		}
+	}
+	for _, t := range authCfg.ViewerTokens {
+		if secretEqual(token, t) {
+			return httpRoleViewer
+		}
+	}
+	return httpRoleNone
+}
+
+// secretEqual : an unresolvable secret matches nothing
+func secretEqual(token string, secret config.Secret) bool {
+	val, err := secret.Value()
+	if err != nil || val == "" {
+		return false
+	}
+	return auth.TokenEqual(token, val)
+}
+
+func (sc *SyncerCmd) authHandler(authCfg *config.ServerAuthConfig) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		path := ctx.FullPath()
+		if path == "" {
+			path = ctx.Request.URL.Path
+		}
+		need := routeRole(ctx.Request.Method, path)
+		if need == httpRoleNone {
+			return
+		}
+		role := requestRole(authCfg, ctx.Request)
+		if role >= need {
+			return
+		}
+		sc.logger.Warnf("unauthorized request : remote(%s), method(%s), path(%s), role(%s), need(%s)",
+			ctx.ClientIP(), ctx.Request.Method, path, role, need)
+		if role == httpRoleNone {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+		} else {
+			ctx.AbortWithStatus(http.StatusForbidden)
+		}
+	}
+}
+
+// peerHttpClient is used to call http APIs of peers
+func peerHttpClient() (*http.Client, error) {
+	tlsCfg := config.Get().Server.Tls
+	if tlsCfg == nil {
+		return http.DefaultClient, nil
+	}
+	cliCfg, err := unet.NewClientTlsConfig(tlsCfg.TlsOptions())
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: cliCfg,
+		},
+	}, nil
+}
+
+func peerUrl(peer string, path string) string {
+	return config.Get().Server.HttpScheme() + "://" + peer + "/" + strings.TrimPrefix(path, "/")
+}
+
+func setPeerAuthorization(req *http.Request) {
+	authCfg := config.Get().Server.Auth
+	if authCfg != nil && authCfg.PeerToken != "" {
+		if token, err := authCfg.PeerToken.Value(); err == nil {
+			req.Header.Set("Authorization", auth.BearerHeader(token))
+		}
+	}
+}
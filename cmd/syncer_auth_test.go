@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/api/auth"
+)
+
+func TestRouteRole(t *testing.T) {
+	assert.Equal(t, httpRoleNone, routeRole(http.MethodGet, "/debug/health"))
+	assert.Equal(t, httpRoleViewer, routeRole(http.MethodGet, "/syncer/status"))
+	assert.Equal(t, httpRoleAdmin, routeRole(http.MethodGet, "/syncer/config"))
+	assert.Equal(t, httpRoleAdmin, routeRole(http.MethodPost, "/syncer/restart"))
+	assert.Equal(t, httpRoleAdmin, routeRole(http.MethodDelete, "/"))
+}
+
+func TestRequestRole(t *testing.T) {
+	authCfg := &config.ServerAuthConfig{
+		AdminTokens:  config.SliceSecret{"admin"},
+		ViewerTokens: config.SliceSecret{"viewer"},
+		PeerToken:    "peer",
+	}
+	role := func(token string) httpRole {
+		req := httptest.NewRequest(http.MethodGet, "/syncer/status", nil)
+		if token != "" {
+			req.Header.Set("Authorization", auth.BearerHeader(token))
+		}
+		return requestRole(authCfg, req)
+	}
+	assert.Equal(t, httpRoleNone, role(""))
+	assert.Equal(t, httpRoleNone, role("unknown"))
+	assert.Equal(t, httpRoleViewer, role("viewer"))
+	assert.Equal(t, httpRoleAdmin, role("admin"))
+	assert.Equal(t, httpRoleAdmin, role("peer"))
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+	"github.com/mgtv-tech/redis-GunYu/syncer"
+)
+
+var (
+	// restart fields which change SyncerConfig, only affected syncers are restarted
+	syncerConfigFields = []string{"input.redis", "input.mode", "input.syncFrom", "output.redis", "output.replayTransaction"}
+)
+
+// isOverrideOutputRedis returns true if the field is inputs[i].output.redis, which changes SyncerConfig
+func isOverrideOutputRedis(field string) bool {
+	return strings.HasPrefix(field, "inputs[") && strings.HasSuffix(field, "].output.redis")
+}
+
+// Reload reloads the configuration file, it's called by SIGHUP
+func (sc *SyncerCmd) Reload() error {
+	_, err := sc.reloadConfig()
+	return err
+}
+
+// reloadConfig
+// 1. parses the configuration file and diffs it against the running configuration
+// 2. live changes are picked up by syncers without restarting
+// 3. restarts affected syncers if settings of input or output are changed,
+// restarts all syncers if the inputs or the mode of syncers are changed
+// 4. ignored changes need to restart the process
+func (sc *SyncerCmd) reloadConfig() (*config.ConfigDiff, error) {
+	path := config.GetFlag().ConfigPath
+	if path == "" {
+		return nil, errors.New("no configuration file")
+	}
+
+	sc.reloadMutex.Lock()
+	defer sc.reloadMutex.Unlock()
+
+	newCfg, err := config.LoadConfig(path)
+	if err != nil {
+		sc.logger.Errorf("reload config : load config error : path(%s), error(%v)", path, err)
+		return nil, err
+	}
+
+	cur := config.Get()
+	diff := cur.Diff(newCfg)
+	if diff.Empty() {
+		sc.logger.Infof("reload config : no change")
+		return diff, nil
+	}
+	if diff.InputRedis {
+		if err = fixInputConfig(newCfg.Input); err != nil {
+			sc.logger.Errorf("reload config : fix input error : %v", err)
+			return nil, err
+		}
+	}
+	if diff.OutputRedis {
+		if err = fixRedisConfig(newCfg.Output.Redis); err != nil {
+			sc.logger.Errorf("reload config : fix output error : %v", err)
+			return nil, err
+		}
+	}
+
+	for _, i := range diff.OverrideRedis {
+		if err = fixRedisConfig(newCfg.Inputs[i].Output.Redis); err != nil {
+			sc.logger.Errorf("reload config : fix output of inputs[%d] error : %v", i, err)
+			return nil, err
+		}
+	}
+
+	for _, cpRedis := range newCfg.CheckpointRedises() {
+		if err = fixRedisConfig(cpRedis); err != nil {
+			sc.logger.Errorf("reload config : fix checkpoint redis error : %v", err)
+			return nil, err
+		}
+	}
+
+	merged := cur.Merge(newCfg, diff)
+
+	var cfgs []syncer.SyncerConfig
+	var runMode syncerRunMode
+	if diff.NeedRestart() {
+		cfgs, runMode.watchIn, runMode.watchOut, runMode.txnMode, err = sc.syncerConfigs(merged)
+		if err != nil {
+			sc.logger.Errorf("reload config : syncer configs error : %v", err)
+			return nil, err
+		}
+	}
+
+	config.Reload(merged)
+	log.SetLevel(config.GetLogLevel())
+
+	sc.logger.Infof("reload config : live(%v), restart(%v), ignored(%v)", diff.Live, diff.Restart, diff.Ignored)
+	if len(diff.Ignored) > 0 {
+		sc.logger.Warnf("reload config : restart the process to apply changes : %v", diff.Ignored)
+	}
+
+	if diff.NeedRestart() {
+		sc.restartSyncers(diff, cfgs, runMode)
+	}
+	return diff, nil
+}
+
+func (sc *SyncerCmd) restartSyncers(diff *config.ConfigDiff, cfgs []syncer.SyncerConfig, runMode syncerRunMode) {
+	sc.mutex.RLock()
+	runWait := sc.runWait
+	sameMode := sc.runMode == runMode && len(sc.syncers) == len(cfgs)
+	syncers := make([]syncer.Syncer, len(cfgs))
+	for i, cfg := range cfgs {
+		sy, ok := sc.syncers[cfg.Input.Address()]
+		if !ok {
+			sameMode = false
+			break
+		}
+		syncers[i] = sy.sync
+	}
+	sc.mutex.RUnlock()
+
+	if !sameMode {
+		sc.logger.Infof("reload config : inputs or mode of syncers are changed, restart all syncers")
+		if runWait != nil {
+			runWait.Close(errors.Join(syncer.ErrRestart, fmt.Errorf("config is reloaded")))
+		}
+		return
+	}
+
+	// other fields are used by all syncers
+	all := false
+	for _, field := range diff.Restart {
+		if !slices.Contains(syncerConfigFields, field) && !isOverrideOutputRedis(field) {
+			all = true
+			break
+		}
+	}
+
+	for i, cfg := range cfgs {
+		prev := syncers[i].Config()
+		if !all && prev.CanTransaction == cfg.CanTransaction &&
+			reflect.DeepEqual(prev.Input, cfg.Input) && reflect.DeepEqual(prev.Output, cfg.Output) {
+			continue
+		}
+		sc.logger.Infof("reload config : restart syncer : input(%s)", cfg.Input.Address())
+		syncers[i].Restart(cfg)
+	}
+}
@@ -1,29 +1,36 @@
 package config
 
 import (
+	"crypto/tls"
 	"fmt"
+	gnet "net"
 	"os"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/io/net"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/sharding"
 )
 
 var (
-	cfg *Config
+	cfgPtr atomic.Pointer[Config]
 )
 
 func init() {
-	cfg = &Config{}
+	cfgPtr.Store(&Config{})
 }
 
+// Get returns the running configuration, it may be replaced by Reload, so don't hold it for long time
 func Get() *Config {
-	return cfg
+	return cfgPtr.Load()
 }
 
 type Config struct {
@@ -32,8 +39,11 @@ type Config struct {
 	Channel *ChannelConfig
 	Filter  FilterConfig
 	Cluster *ClusterConfig
-	Log     *LogConfig   `yaml:"log"`
-	Server  ServerConfig `yaml:"server"`
+	Log     *LogConfig             `yaml:"log"`
+	Server  ServerConfig           `yaml:"server"`
+	Inputs  []*InputOverrideConfig `yaml:"inputs"`
+
+	raw *configRaw
 }
 
 func (c *Config) GetLog() *LogConfig {
@@ -55,23 +65,17 @@ func (c *Config) fix() error {
 		c.Log = &LogConfig{}
 	}
 
-	for _, fix := range []fixInter{c.Input, c.Output, c.Channel, c.Log} {
+	for _, fix := range []fixInter{c.Input, c.Output, c.Channel, c.Log, &c.Filter} {
 		if err := fix.fix(); err != nil {
 			return err
 		}
 	}
 
-	if c.Output.Redis.Type == RedisTypeCluster {
-		if c.Output.TargetDb == -1 || c.Output.TargetDb == 0 {
-			c.Filter.DbBlacklist = []int{}
-		} else {
-			return newConfigError("redis is cluster, but targetdb is not 0")
-		}
-		for _, db := range c.Output.TargetDbMap {
-			if db != 0 {
-				return newConfigError("redis is cluster, but targetdb is not 0 : %d", db)
-			}
-		}
+	if err := fixOutputDb(c.Output, &c.Filter); err != nil {
+		return err
+	}
+	if err := c.fixInputs(); err != nil {
+		return err
 	}
 
 	if c.Cluster != nil {
@@ -84,6 +88,9 @@ func (c *Config) fix() error {
 			c.Cluster = nil
 		}
 	}
+	if err := c.fixCheckpointStores(); err != nil {
+		return err
+	}
 	if err := c.Server.fix(); err != nil {
 		return err
 	}
@@ -91,6 +98,45 @@ func (c *Config) fix() error {
 	return nil
 }
 
+// fixCheckpointStores uses cluster.metaEtcd if etcd of checkpoint store is absent
+func (c *Config) fixCheckpointStores() error {
+	outputs := []*OutputConfig{c.Output}
+	for _, in := range c.Inputs {
+		outputs = append(outputs, in.Output)
+	}
+	for _, of := range outputs {
+		cs := of.CheckpointStore
+		if cs == nil || cs.Type != CheckpointStoreEtcd || cs.Etcd != nil {
+			continue
+		}
+		if c.Cluster == nil {
+			return newConfigError("etcd checkpoint store : neither checkpointStore.etcd nor cluster.metaEtcd is configured")
+		}
+		cs.Etcd = c.Cluster.MetaEtcd
+	}
+	return nil
+}
+
+// fixOutputDb checks databases if the output is a cluster or proxy, they only have db 0
+func fixOutputDb(of *OutputConfig, fc *FilterConfig) error {
+	if of.Redis.Type != RedisTypeCluster && !of.Redis.Proxy {
+		return nil
+	}
+	if of.TargetDb == -1 || of.TargetDb == 0 {
+		if !of.Redis.Proxy {
+			fc.DbBlacklist = []int{}
+		}
+	} else {
+		return newConfigError("redis is cluster or proxy, but targetdb is not 0")
+	}
+	for _, db := range of.TargetDbMap {
+		if db != 0 {
+			return newConfigError("redis is cluster or proxy, but targetdb is not 0 : %d", db)
+		}
+	}
+	return nil
+}
+
 type ServerConfig struct {
 	Listen          string
 	ListenPort      int    `yaml:"-"`
@@ -99,6 +145,150 @@ type ServerConfig struct {
 
 	CheckRedisTypologyTicker time.Duration `yaml:"checkRedisTypologyTicker"` // seconds
 	GracefullStopTimeout     time.Duration `yaml:"gracefullStopTimeout"`
+
+	Tls   *ServerTlsConfig   `yaml:"tls"`   // http and grpc share the listener
+	Auth  *ServerAuthConfig  `yaml:"auth"`  // http routes and peer api
+	Psync *ServerPsyncConfig `yaml:"psync"` // redis replicas replicate input from syncer
+	Otlp  *ServerOtlpConfig  `yaml:"otlp"`  // exports metrics and traces to an OTLP collector
+
+	Journal *ServerJournalConfig `yaml:"journal"` // events of syncers
+}
+
+// ServerJournalConfig persists sync events, e.g. state changes, role changes and API calls
+type ServerJournalConfig struct {
+	DirPath string `yaml:"dirPath"`
+	MaxSize int64  `yaml:"maxSize"` // the journal is rotated if it exceeds maxSize, a rotated journal is kept, default is 10MiB
+}
+
+func (jc *ServerJournalConfig) fix() error {
+	if jc.DirPath == "" {
+		jc.DirPath = os.TempDir() + "/redis-gunyu-journal/"
+	}
+	if jc.MaxSize == 0 {
+		jc.MaxSize = 10 * 1024 * 1024
+	} else if jc.MaxSize < 1024*1024 {
+		return newConfigError("server.journal.maxSize should be at least 1MiB : %d", jc.MaxSize)
+	}
+	return nil
+}
+
+// ServerPsyncConfig serves the channel of each input node as a redis master
+type ServerPsyncConfig struct {
+	Listen   string `yaml:"listen"`   // ip:port, the i-th input node is served on port+i
+	Password Secret `yaml:"password"` // replicas authenticate by masterauth
+	port     int
+}
+
+func (pc *ServerPsyncConfig) fix() error {
+	host, port, err := gnet.SplitHostPort(pc.Listen)
+	if err != nil {
+		return newConfigError("invalid server.psync.listen : %v", err)
+	}
+	pc.port, err = strconv.Atoi(port)
+	if err != nil || pc.port <= 0 || pc.port > 65535 {
+		return newConfigError("invalid server.psync.listen : %s", pc.Listen)
+	}
+	pc.Listen = gnet.JoinHostPort(host, port)
+	return pc.Password.fix("server.psync.password")
+}
+
+// Address returns the listening address of the i-th input node
+func (pc *ServerPsyncConfig) Address(i int) string {
+	host, _, _ := gnet.SplitHostPort(pc.Listen)
+	return gnet.JoinHostPort(host, strconv.Itoa(pc.port+i))
+}
+
+// ServerOtlpConfig exports metrics and traces by OTLP/HTTP
+type ServerOtlpConfig struct {
+	Endpoint    string            `yaml:"endpoint"` // host:port of the collector
+	Insecure    bool              `yaml:"insecure"` // http instead of https
+	Headers     map[string]Secret `yaml:"headers"`  // e.g. authorization
+	Interval    time.Duration     `yaml:"interval"` // interval of exporting metrics, default is 15s
+	Metrics     *bool             `yaml:"metrics" default:"true"`
+	Traces      *bool             `yaml:"traces" default:"true"`
+	SampleRatio float64           `yaml:"sampleRatio"` // ratio of sampled traces, (0, 1], default is 1
+}
+
+func (oc *ServerOtlpConfig) fix() error {
+	if _, _, err := gnet.SplitHostPort(oc.Endpoint); err != nil {
+		return newConfigError("invalid server.otlp.endpoint : %v", err)
+	}
+	for name, val := range oc.Headers {
+		if err := val.fix("server.otlp.headers." + name); err != nil {
+			return err
+		}
+	}
+	if oc.Interval == 0 {
+		oc.Interval = 15 * time.Second
+	} else if oc.Interval < time.Second {
+		oc.Interval = time.Second
+	}
+	if oc.Metrics == nil {
+		metrics := true
+		oc.Metrics = &metrics
+	}
+	if oc.Traces == nil {
+		traces := true
+		oc.Traces = &traces
+	}
+	if oc.SampleRatio == 0 {
+		oc.SampleRatio = 1
+	} else if oc.SampleRatio < 0 || oc.SampleRatio > 1 {
+		return newConfigError("server.otlp.sampleRatio should be in (0, 1] : %v", oc.SampleRatio)
+	}
+	return nil
+}
+
+type ServerTlsConfig struct {
+	CertFile   string `yaml:"certFile"`
+	KeyFile    string `yaml:"keyFile"`
+	CaFile     string `yaml:"caFile"`     // verify client certificates, and peers verify the server certificate
+	ClientAuth bool   `yaml:"clientAuth"` // grpc peer api requires a verified client certificate
+	ServerName string `yaml:"serverName"` // used by peers, default is the host of listenPeer
+	MinVersion string `yaml:"minVersion"` // 1.2, 1.3
+}
+
+func (tc *ServerTlsConfig) TlsOptions() net.TlsOptions {
+	return net.TlsOptions{
+		CaFile:     tc.CaFile,
+		CertFile:   tc.CertFile,
+		KeyFile:    tc.KeyFile,
+		ServerName: tc.ServerName,
+		MinVersion: tc.MinVersion,
+	}
+}
+
+func (tc *ServerTlsConfig) fix() error {
+	if tc.CertFile == "" || tc.KeyFile == "" {
+		return newConfigError("server.tls : certFile and keyFile are required")
+	}
+	if tc.ClientAuth && tc.CaFile == "" {
+		return newConfigError("server.tls : clientAuth requires caFile")
+	}
+	if _, err := net.ParseTlsVersion(tc.MinVersion); err != nil {
+		return newConfigError("server.tls : %v", err)
+	}
+	return nil
+}
+
+type ServerAuthConfig struct {
+	AdminTokens  SliceSecret `yaml:"adminTokens"`  // all routes
+	ViewerTokens SliceSecret `yaml:"viewerTokens"` // read-only routes
+	PeerToken    Secret      `yaml:"peerToken"`    // sent by peers, has admin role
+}
+
+func (ac *ServerAuthConfig) Enabled() bool {
+	return ac != nil && (len(ac.AdminTokens) > 0 || len(ac.ViewerTokens) > 0 || ac.PeerToken != "")
+}
+
+func (ac *ServerAuthConfig) fix() error {
+	if err := ac.AdminTokens.fix("server.auth.adminTokens"); err != nil {
+		return err
+	}
+	if err := ac.ViewerTokens.fix("server.auth.viewerTokens"); err != nil {
+		return err
+	}
+	return ac.PeerToken.fix("server.auth.peerToken")
 }
 
 func (sc *ServerConfig) fix() error {
@@ -135,9 +325,49 @@ func (sc *ServerConfig) fix() error {
 		sc.MetricRoutePath = "/" + sc.MetricRoutePath
 	}
 
+	if sc.Tls != nil {
+		if sc.Tls.CertFile == "" && sc.Tls.KeyFile == "" {
+			sc.Tls = nil
+		} else if err := sc.Tls.fix(); err != nil {
+			return err
+		}
+	}
+	if !sc.Auth.Enabled() {
+		sc.Auth = nil
+	} else if err := sc.Auth.fix(); err != nil {
+		return err
+	}
+	if sc.Psync != nil {
+		if sc.Psync.Listen == "" {
+			sc.Psync = nil
+		} else if err := sc.Psync.fix(); err != nil {
+			return err
+		}
+	}
+	if sc.Otlp != nil {
+		if sc.Otlp.Endpoint == "" {
+			sc.Otlp = nil
+		} else if err := sc.Otlp.fix(); err != nil {
+			return err
+		}
+	}
+	if sc.Journal == nil {
+		sc.Journal = &ServerJournalConfig{}
+	}
+	if err := sc.Journal.fix(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+func (sc *ServerConfig) HttpScheme() string {
+	if sc.Tls != nil {
+		return "https"
+	}
+	return "http"
+}
+
 func withPrefixTag(prefix, tag string) string {
 	if len(prefix) == 0 {
 		return tag
@@ -149,18 +379,38 @@ type InputConfig struct {
 	Redis              *RedisConfig
 	RdbParallel        int `yaml:"rdbParallel"`
 	rdbParallelLimiter chan struct{}
-	Mode               InputMode       `yaml:"mode"`
-	SyncFrom           SelNodeStrategy `yaml:"syncFrom"`
-	SyncDelayTestKey   string          `yaml:"syncDelayTestKey"`
+	Mode               InputMode        `yaml:"mode"`
+	SyncFrom           SelNodeStrategy  `yaml:"syncFrom"`
+	SyncDelayTestKey   string           `yaml:"syncDelayTestKey"`
+	SyncType           string           `yaml:"syncType"` // sync|rump|file, default is sync
+	Rump               RumpConfig       `yaml:"rump"`
+	File               *InputFileConfig `yaml:"file"`
 }
 
 func (ic *InputConfig) fix() error {
+	ic.SyncType = strings.ToLower(ic.SyncType)
+	switch ic.SyncType {
+	case "":
+		ic.SyncType = TypeSync
+	case TypeSync, TypeRump:
+	case TypeFile:
+		if ic.File == nil || ic.File.isEmpty() {
+			return newConfigError("input.file is empty")
+		}
+		// files are a standalone input redis, input.redis is ignored
+		ic.Redis = &RedisConfig{Addresses: []string{ic.File.Id()}, Type: RedisTypeStandalone}
+	default:
+		return newConfigError("unknown input.syncType : %s", ic.SyncType)
+	}
 	if ic.Redis == nil {
 		return newConfigError("input.redis is nil")
 	}
 	if err := ic.Redis.fix(); err != nil {
 		return err
 	}
+	if ic.Redis.Sharding != nil || ic.Redis.Proxy {
+		return newConfigError("input.redis does not support sharding or proxy")
+	}
 	if ic.RdbParallel <= 0 {
 		ic.RdbParallel = 100
 	}
@@ -168,9 +418,48 @@ func (ic *InputConfig) fix() error {
 	if ic.SyncFrom == 0 {
 		ic.SyncFrom = SelNodeStrategyPreferSlave
 	}
+	ic.Rump.fix()
 	return nil
 }
 
+// InputFileConfig syncs from persistence files of redis instead of redis
+type InputFileConfig struct {
+	Rdb string `yaml:"rdb"` // rdb file
+	Aof string `yaml:"aof"` // aof file, or the directory or manifest of multi-part aof(redis 7.0), it's replayed after the rdb
+}
+
+func (fc *InputFileConfig) isEmpty() bool {
+	return fc.Rdb == "" && fc.Aof == ""
+}
+
+// Id returns the path of the first file, it's the address of the input
+func (fc *InputFileConfig) Id() string {
+	if fc.Rdb != "" {
+		return fc.Rdb
+	}
+	return fc.Aof
+}
+
+// RumpConfig fetches keys by SCAN, DUMP and PTTL, for input redis which rejects PSYNC
+type RumpConfig struct {
+	ScanCount      int  `yaml:"scanCount"`      // COUNT of SCAN, default is 1000
+	Parallel       int  `yaml:"parallel"`       // connections fetching DUMP and PTTL of each node, default is 4
+	KeysPerSecond  int  `yaml:"keysPerSecond"`  // keys fetched per second of each node, 0 is unlimited
+	KeyspaceNotify bool `yaml:"keyspaceNotify"` // syncs keys of keyspace notifications after the scan
+}
+
+func (rc *RumpConfig) fix() {
+	if rc.ScanCount <= 0 {
+		rc.ScanCount = 1000
+	}
+	if rc.Parallel <= 0 {
+		rc.Parallel = 4
+	}
+	if rc.KeysPerSecond < 0 {
+		rc.KeysPerSecond = 0
+	}
+}
+
 func (ic *InputConfig) RdbLimiter() chan struct{} {
 	return ic.rdbParallelLimiter
 }
@@ -239,24 +528,30 @@ func (sc *StorerConfig) fix() error {
 
 type OutputConfig struct {
 	Redis                  *RedisConfig
-	ResumeFromBreakPoint   *bool         `yaml:"resumeFromBreakPoint" default:"true"`
-	ReplaceHashTag         bool          `yaml:"replaceHashTag"`
-	KeyExists              string        `yaml:"keyExists"` // replace|ignore|error
-	KeyExistsLog           bool          `yaml:"keyExistsLog"`
-	FunctionExists         string        `yaml:"functionExists"`
-	MaxProtoBulkLen        int           `yaml:"maxProtoBulkLen"` // proto-max-bulk-len, default value of redis is 512MiB
-	TargetDbCfg            *int          `yaml:"targetDb" default:"-1"`
-	TargetDb               int           `yaml:"-"`
-	TargetDbMap            map[int]int   `yaml:"targetDbMap"`
-	BatchCmdCount          uint          `yaml:"batchCmdCount"`
-	BatchTicker            time.Duration `yaml:"batchTicker"`
-	BatchBufferSize        uint64        `yaml:"batchBufferSize"`
-	KeepaliveTicker        time.Duration `yaml:"keepaliveTicker"`
-	ReplayRdbParallel      int           `yaml:"replayRdbParallel"`
-	ReplayRdbEnableRestore *bool         `yaml:"replayRdbEnableRestore" default:"true"`
-	UpdateCheckpointTicker time.Duration `yaml:"updateCheckpointTicker"`
-	ReplayTransaction      *bool         `yaml:"replayTransaction" default:"true"`
-	Stats                  OutputStats   `yaml:"stats"`
+	ResumeFromBreakPoint   *bool                  `yaml:"resumeFromBreakPoint" default:"true"`
+	ReplaceHashTag         bool                   `yaml:"replaceHashTag"`
+	KeyExists              string                 `yaml:"keyExists"` // replace|ignore|error
+	KeyExistsLog           bool                   `yaml:"keyExistsLog"`
+	FunctionExists         string                 `yaml:"functionExists"`
+	MaxProtoBulkLen        int                    `yaml:"maxProtoBulkLen"` // proto-max-bulk-len, default value of redis is 512MiB
+	TargetDbCfg            *int                   `yaml:"targetDb" default:"-1"`
+	TargetDb               int                    `yaml:"-"`
+	TargetDbMap            map[int]int            `yaml:"targetDbMap"`
+	BatchCmdCount          uint                   `yaml:"batchCmdCount"`
+	BatchTicker            time.Duration          `yaml:"batchTicker"`
+	BatchBufferSize        uint64                 `yaml:"batchBufferSize"`
+	KeepaliveTicker        time.Duration          `yaml:"keepaliveTicker"`
+	ReplayRdbParallel      int                    `yaml:"replayRdbParallel"`
+	ReplayRdbEnableRestore *bool                  `yaml:"replayRdbEnableRestore" default:"true"`
+	UpdateCheckpointTicker time.Duration          `yaml:"updateCheckpointTicker"`
+	ReplayTransaction      *bool                  `yaml:"replayTransaction" default:"true"`
+	Stats                  OutputStats            `yaml:"stats"`
+	CheckpointRedis        *RedisConfig           `yaml:"checkpointRedis"` // stores checkpoints instead of output redis, required by proxies
+	CheckpointStore        *CheckpointStoreConfig `yaml:"checkpointStore"`
+	RewriteNonIdempotent   bool                   `yaml:"rewriteNonIdempotent"` // rewrites non-idempotent commands with the state of input
+	Bidirectional          *BidirectionalConfig   `yaml:"bidirectional"`
+	Delay                  time.Duration          `yaml:"delay"` // delayed replica, commands are replayed after they have been ingested for the delay
+	Guard                  *GuardConfig           `yaml:"guard"`
 }
 
 func (of *OutputConfig) fix() error {
@@ -285,6 +580,28 @@ func (of *OutputConfig) fix() error {
 		return newConfigError("resume from breakpoint, but targetdb is not -1 : db(%d)", of.TargetDb)
 	}
 
+	if of.CheckpointRedis != nil {
+		if len(of.CheckpointRedis.Addresses) == 0 {
+			of.CheckpointRedis = nil
+		} else if err := of.CheckpointRedis.fix(); err != nil {
+			return err
+		} else if of.CheckpointRedis.Proxy || of.CheckpointRedis.IsSharded() {
+			return newConfigError("checkpoint redis should not be a proxy or sharded")
+		}
+	}
+	if of.CheckpointStore != nil {
+		if of.CheckpointStore.isEmpty() {
+			of.CheckpointStore = nil
+		} else if err := of.CheckpointStore.fix(); err != nil {
+			return err
+		} else if of.CheckpointStore.IsExternal() && of.CheckpointRedis != nil {
+			return newConfigError("checkpointRedis is only used by the redis checkpoint store : %s", of.CheckpointStore.Type)
+		}
+	}
+	if of.Redis.Proxy && *of.ResumeFromBreakPoint && of.CheckpointRedis == nil && of.ExternalCheckpointStore() == nil {
+		return newConfigError("output redis is proxy, checkpointRedis or checkpointStore is required to resume from breakpoint")
+	}
+
 	if of.ReplayRdbParallel <= 0 {
 		// @TODO docker
 		of.ReplayRdbParallel = runtime.NumCPU() * 4
@@ -298,6 +615,27 @@ func (of *OutputConfig) fix() error {
 		of.ReplayTransaction = &txn
 	}
 
+	if of.Bidirectional != nil {
+		if of.Bidirectional.isEmpty() {
+			of.Bidirectional = nil
+		} else if err := of.Bidirectional.fix(); err != nil {
+			return err
+		} else if !*of.ReplayTransaction || of.Redis.Proxy || of.Redis.IsSharded() {
+			return newConfigError("bidirectional sync requires transactions, output redis should not be a proxy or sharded")
+		} else if of.Bidirectional.Conflict == ConflictLww && of.RewriteNonIdempotent {
+			// rewritten scripts can't be guarded by the script of lww, transactions are replayed exactly once
+			return newConfigError("rewriteNonIdempotent can't be used with conflict lww")
+		}
+	}
+
+	if of.Guard != nil {
+		if of.Guard.isEmpty() {
+			of.Guard = nil
+		} else {
+			of.Guard.fix()
+		}
+	}
+
 	of.KeyExists = strings.ToLower(of.KeyExists)
 	if !slices.Contains([]string{"replace", "ignore", "error"}, of.KeyExists) {
 		of.KeyExists = "replace"
@@ -315,6 +653,13 @@ func (of *OutputConfig) fix() error {
 	if of.KeepaliveTicker <= time.Second {
 		of.KeepaliveTicker = time.Second * 3
 	}
+	if of.Delay < 0 {
+		of.Delay = 0
+	}
+	if of.Delay > 0 && of.RewriteNonIdempotent {
+		// commands are rewritten with the present state of input, it's not the delayed state
+		return newConfigError("rewriteNonIdempotent can't be used with delay")
+	}
 	if of.UpdateCheckpointTicker <= time.Millisecond || of.UpdateCheckpointTicker > 10*time.Second {
 		of.UpdateCheckpointTicker = time.Second // 1 second
 	}
@@ -337,6 +682,140 @@ func (of *OutputConfig) fix() error {
 	return nil
 }
 
+// ExternalCheckpointStore returns the checkpoint store if checkpoints are not stored in redis, or nil
+func (of *OutputConfig) ExternalCheckpointStore() *CheckpointStoreConfig {
+	if of.CheckpointStore != nil && of.CheckpointStore.IsExternal() {
+		return of.CheckpointStore
+	}
+	return nil
+}
+
+const (
+	CheckpointStoreRedis = "redis"
+	CheckpointStoreFile  = "file"
+	CheckpointStoreEtcd  = "etcd"
+)
+
+// CheckpointStoreConfig specifies where checkpoints are stored,
+// redis stores them in output redis or checkpointRedis, file and etcd store them outside of redis
+type CheckpointStoreConfig struct {
+	Type   string      `yaml:"type"`   // redis|file|etcd, default is redis
+	Dir    string      `yaml:"dir"`    // file : directory of checkpoints
+	Etcd   *EtcdConfig `yaml:"etcd"`   // etcd : default is cluster.metaEtcd
+	Prefix string      `yaml:"prefix"` // etcd : prefix of keys
+}
+
+func (sc *CheckpointStoreConfig) isEmpty() bool {
+	return sc.Type == "" && sc.Dir == "" && sc.Prefix == "" && (sc.Etcd == nil || len(sc.Etcd.Endpoints) == 0)
+}
+
+func (sc *CheckpointStoreConfig) fix() error {
+	sc.Type = strings.ToLower(sc.Type)
+	switch sc.Type {
+	case "", CheckpointStoreRedis:
+		sc.Type = CheckpointStoreRedis
+	case CheckpointStoreFile:
+		if sc.Dir == "" {
+			sc.Dir = os.TempDir() + "/redis-gunyu-checkpoint/"
+		}
+	case CheckpointStoreEtcd:
+		if sc.Etcd != nil && len(sc.Etcd.Endpoints) == 0 {
+			sc.Etcd = nil
+		}
+		if sc.Etcd != nil {
+			if err := sc.Etcd.fix(); err != nil {
+				return err
+			}
+		}
+		if sc.Prefix == "" {
+			sc.Prefix = "/redis-gunyu/checkpoint/"
+		} else if !strings.HasSuffix(sc.Prefix, "/") {
+			sc.Prefix += "/"
+		}
+	default:
+		return newConfigError("unknown checkpoint store : %s", sc.Type)
+	}
+	return nil
+}
+
+// IsExternal returns true if checkpoints are stored outside of redis
+func (sc *CheckpointStoreConfig) IsExternal() bool {
+	return sc.Type == CheckpointStoreFile || sc.Type == CheckpointStoreEtcd
+}
+
+// Id identifies the storage, outputs with the same id share checkpoints
+func (sc *CheckpointStoreConfig) Id() string {
+	switch sc.Type {
+	case CheckpointStoreFile:
+		return sc.Type + ":" + sc.Dir
+	case CheckpointStoreEtcd:
+		if sc.Etcd != nil {
+			return sc.Type + ":" + strings.Join(sc.Etcd.Endpoints, ",") + sc.Prefix
+		}
+		return sc.Type + ":" + sc.Prefix
+	}
+	return sc.Type
+}
+
+const (
+	ConflictNone = "none"
+	ConflictLww  = "lww"
+)
+
+// BidirectionalConfig syncs two redis to each other, writes applied by the syncer are tagged with the origin id,
+// so the syncer of the opposite direction drops them instead of echoing them back
+type BidirectionalConfig struct {
+	OriginId     string        `yaml:"originId"`     // id of input, it tags writes applied to output
+	Conflict     string        `yaml:"conflict"`     // none|lww, default is none
+	LwwRecordTtl time.Duration `yaml:"lwwRecordTtl"` // lww : expiration of write records, default is 24 hours
+}
+
+func (bc *BidirectionalConfig) isEmpty() bool {
+	return bc.OriginId == "" && bc.Conflict == ""
+}
+
+func (bc *BidirectionalConfig) fix() error {
+	if bc.OriginId == "" {
+		return newConfigError("bidirectional.originId is empty")
+	}
+	bc.Conflict = strings.ToLower(bc.Conflict)
+	switch bc.Conflict {
+	case "":
+		bc.Conflict = ConflictNone
+	case ConflictNone, ConflictLww:
+	default:
+		return newConfigError("unknown conflict rule : %s", bc.Conflict)
+	}
+	if bc.LwwRecordTtl <= 0 {
+		bc.LwwRecordTtl = 24 * time.Hour
+	}
+	return nil
+}
+
+// GuardConfig quarantines dangerous commands, the replay is held until the operator approves or skips them
+type GuardConfig struct {
+	Commands                SliceString `yaml:"commands"`                // e.g. flushall, flushdb, swapdb
+	MaxDeletedKeysPerSecond int         `yaml:"maxDeletedKeysPerSecond"` // keys deleted by del, unlink and getdel, 0 is unlimited
+}
+
+func (gc *GuardConfig) isEmpty() bool {
+	return len(gc.Commands) == 0 && gc.MaxDeletedKeysPerSecond <= 0
+}
+
+func (gc *GuardConfig) fix() {
+	for i, cmd := range gc.Commands {
+		gc.Commands[i] = strings.ToLower(strings.TrimSpace(cmd))
+	}
+	if gc.MaxDeletedKeysPerSecond < 0 {
+		gc.MaxDeletedKeysPerSecond = 0
+	}
+}
+
+// Quarantines returns true if the command is held for approval
+func (gc *GuardConfig) Quarantines(cmd string) bool {
+	return gc != nil && slices.Contains(gc.Commands, strings.ToLower(cmd))
+}
+
 type OutputStats struct {
 	DisableLog  bool          `yaml:"disableLog"`
 	LogInterval time.Duration `yaml:"logInterval"`
@@ -351,6 +830,50 @@ type FilterConfig struct {
 type FilterKeyConfig struct {
 	PrefixKeyWhitelist SliceString `yaml:"prefixKeyWhitelist"`
 	PrefixKeyBlacklist SliceString `yaml:"prefixKeyBlacklist"`
+	SlotRanges         SliceString `yaml:"slotRanges"` // keeps keys in slot ranges or with hash tags, e.g. ["0-5460", "8000"]
+	HashTags           SliceString `yaml:"hashTags"`
+	SlotMetrics        bool        `yaml:"slotMetrics"` // counts keys per slot besides per slot range
+	slotRanges         []RedisSlotRange
+}
+
+func (fc *FilterConfig) fix() error {
+	if fc.KeyFilter == nil {
+		return nil
+	}
+	return fc.KeyFilter.fix()
+}
+
+func (kc *FilterKeyConfig) fix() error {
+	kc.slotRanges = nil
+	for _, sr := range kc.SlotRanges {
+		left, right, found := strings.Cut(strings.TrimSpace(sr), "-")
+		if !found {
+			right = left
+		}
+		l, err := strconv.Atoi(strings.TrimSpace(left))
+		if err != nil {
+			return newConfigError("filter slot range : %s, %v", sr, err)
+		}
+		r, err := strconv.Atoi(strings.TrimSpace(right))
+		if err != nil {
+			return newConfigError("filter slot range : %s, %v", sr, err)
+		}
+		if l < 0 || r >= 16384 || l > r {
+			return newConfigError("filter slot range is out of [0, 16383] : %s", sr)
+		}
+		kc.slotRanges = append(kc.slotRanges, RedisSlotRange{Left: l, Right: r})
+	}
+	for _, tag := range kc.HashTags {
+		if tag == "" {
+			return newConfigError("filter hash tag is empty")
+		}
+	}
+	return nil
+}
+
+// GetSlotRanges returns parsed slot ranges
+func (kc *FilterKeyConfig) GetSlotRanges() []RedisSlotRange {
+	return kc.slotRanges
 }
 
 type LogHandlerFileConfig struct {
@@ -385,6 +908,7 @@ type LogConfig struct {
 }
 
 func LogModuleName(prefix string) string {
+	cfg := Get()
 	if cfg == nil || cfg.Log == nil || cfg.Log.ModuleName == nil {
 		return prefix
 	}
@@ -395,17 +919,25 @@ func LogModuleName(prefix string) string {
 }
 
 func SetLogLevel(l zapcore.Level) {
-	cfg.Log.level = l
+	Get().Log.level = l
 }
 
 func GetLogLevel() zapcore.Level {
-	return cfg.Log.level
+	return Get().Log.level
 }
 
 func (lc *LogConfig) fix() error {
 	if err := lc.Handler.fix(); err != nil {
 		return err
 	}
+	lc.level = zapcore.InfoLevel
+	if len(lc.LevelStr) > 0 {
+		level, err := zapcore.ParseLevel(lc.LevelStr)
+		if err != nil {
+			return newConfigError("log.level : %v", err)
+		}
+		lc.level = level
+	}
 	if lc.Caller == nil {
 		caller := true
 		lc.Caller = &caller
@@ -422,17 +954,31 @@ func (lc *LogConfig) fix() error {
 }
 
 func InitConfig(path string) error {
-	data, err := os.ReadFile(path)
+	c, err := LoadConfig(path)
 	if err != nil {
 		return err
 	}
-	if err = yaml.Unmarshal(data, cfg); err != nil {
-		return err
+	cfgPtr.Store(c)
+	return nil
+}
+
+// LoadConfig parses the configuration file, it doesn't replace the running configuration
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
-	if err = cfg.fix(); err != nil {
-		return err
+	c := &Config{raw: &configRaw{}}
+	if err = yaml.Unmarshal(data, c); err != nil {
+		return nil, err
 	}
-	return nil
+	if err = yaml.Unmarshal(data, c.raw); err != nil {
+		return nil, err
+	}
+	if err = c.fix(); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 func GetAddressesFromRedisConfigSlice(rcfg []RedisConfig) []string {
@@ -446,17 +992,20 @@ func GetAddressesFromRedisConfigSlice(rcfg []RedisConfig) []string {
 type RedisConfig struct {
 	Addresses      SliceString
 	shards         []*RedisClusterShard
-	UserName       string    `yaml:"userName"`
-	Password       string    `yaml:"password"`
-	TlsEnable      bool      `yaml:"tlsEnable"`
-	Type           RedisType // for new redis client
-	Otype          RedisType // original type
+	UserName       Secret          `yaml:"userName"`
+	Password       Secret          `yaml:"password"`
+	TlsEnable      bool            `yaml:"tlsEnable"`
+	Tls            *RedisTlsConfig `yaml:"tls"` // verifies the server certificate, implies tlsEnable
+	Type           RedisType       // for new redis client
+	Otype          RedisType       // original type
 	Version        string
 	slotLeft       int // @TODO remove it
 	slotRight      int
 	slotsMap       map[string]*RedisSlots
 	slots          RedisSlots
 	ClusterOptions *RedisClusterOptions `yaml:"clusterOptions"`
+	Sharding       *RedisShardingConfig `yaml:"sharding"` // client side sharding of standalone redis
+	Proxy          bool                 `yaml:"proxy"`    // addresses are proxies(twemproxy, codis, envoy), which reject select, transactions and commands across keys
 	isMigrating    bool
 	KeepAlive      int           `yaml:"keepAlive"` // Maximum keep alive connecion in each node
 	AliveTime      time.Duration `yaml:"aliveTime"` // Keep alive timeout
@@ -469,6 +1018,7 @@ func (rc *RedisConfig) Clone() *RedisConfig {
 		UserName:       rc.UserName,
 		Password:       rc.Password,
 		TlsEnable:      rc.TlsEnable,
+		Tls:            rc.Tls,
 		Type:           rc.Type,
 		Otype:          rc.Type,
 		Version:        rc.Version,
@@ -477,6 +1027,8 @@ func (rc *RedisConfig) Clone() *RedisConfig {
 		slotsMap:       make(map[string]*RedisSlots),
 		slots:          *rc.slots.Clone(),
 		ClusterOptions: rc.ClusterOptions.Clone(),
+		Sharding:       rc.Sharding,
+		Proxy:          rc.Proxy,
 		isMigrating:    rc.isMigrating,
 		KeepAlive:      rc.KeepAlive,
 		AliveTime:      rc.AliveTime,
@@ -500,6 +1052,85 @@ func (rc *RedisConfig) SetMigrating(m bool) {
 	rc.isMigrating = m
 }
 
+type RedisTlsConfig struct {
+	CaFile             string `yaml:"caFile"` // empty means system roots
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	ServerName         string `yaml:"serverName"` // SNI, default is the host of address
+	MinVersion         string `yaml:"minVersion"` // 1.2, 1.3
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+func (tc *RedisTlsConfig) fix() error {
+	if (tc.CertFile == "") != (tc.KeyFile == "") {
+		return newConfigError("redis tls : certFile and keyFile must be set together")
+	}
+	if _, err := net.ParseTlsVersion(tc.MinVersion); err != nil {
+		return newConfigError("redis tls : %v", err)
+	}
+	return nil
+}
+
+func (tc *RedisTlsConfig) isEmpty() bool {
+	return *tc == RedisTlsConfig{}
+}
+
+// TlsConfig returns nil if tls is disabled.
+// tlsEnable without tls options doesn't verify the server certificate, keeps compatible with old versions
+func (rc *RedisConfig) TlsConfig() (*tls.Config, error) {
+	if !rc.TlsEnable {
+		return nil, nil
+	}
+	if rc.Tls == nil {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	return net.NewClientTlsConfig(net.TlsOptions{
+		CaFile:             rc.Tls.CaFile,
+		CertFile:           rc.Tls.CertFile,
+		KeyFile:            rc.Tls.KeyFile,
+		ServerName:         rc.Tls.ServerName,
+		MinVersion:         rc.Tls.MinVersion,
+		InsecureSkipVerify: rc.Tls.InsecureSkipVerify,
+	})
+}
+
+// RedisShardingConfig distributes keys to standalone redis like twemproxy or codis
+type RedisShardingConfig struct {
+	Distribution string      `yaml:"distribution"` // ketama, modula
+	Hash         string      `yaml:"hash"`         // fnv1a_64, fnv1a_32, md5, crc16, crc32a
+	HashTag      string      `yaml:"hashTag"`      // two characters, e.g. "{}"
+	Names        SliceString `yaml:"names"`        // names of servers, default is the address
+	Weights      SliceInt    `yaml:"weights"`      // weights of servers, default is 1
+}
+
+func (sc *RedisShardingConfig) fix(addrs []string) error {
+	if len(sc.Names) == 0 {
+		sc.Names = slices.Clone(addrs)
+	}
+	if len(sc.Names) != len(addrs) {
+		return newConfigError("redis sharding : the amount of names does not equal addresses : %d != %d", len(sc.Names), len(addrs))
+	}
+	_, err := sharding.New(sc.Options())
+	if err != nil {
+		return newConfigError("redis %v", err)
+	}
+	return nil
+}
+
+func (sc *RedisShardingConfig) isEmpty() bool {
+	return sc.Distribution == "" && sc.Hash == "" && sc.HashTag == "" && len(sc.Names) == 0 && len(sc.Weights) == 0
+}
+
+func (sc *RedisShardingConfig) Options() sharding.Options {
+	return sharding.Options{
+		Distribution: sc.Distribution,
+		Hash:         sc.Hash,
+		HashTag:      sc.HashTag,
+		Names:        sc.Names,
+		Weights:      sc.Weights,
+	}
+}
+
 type RedisClusterOptions struct {
 	HandleMoveErr bool `yaml:"handleMoveErr" default:"true"`
 	HandleAskErr  bool `yaml:"handleAskErr" default:"true"`
@@ -668,6 +1299,25 @@ func (rn *RedisNode) IsHealth() bool {
 	return rn.Health == healthOnline
 }
 
+// UseTlsPort replaces the port of address with tls port
+func (rn *RedisNode) UseTlsPort() {
+	if rn.TlsPort <= 0 {
+		return
+	}
+	host, _, err := gnet.SplitHostPort(rn.Address)
+	if err != nil {
+		return
+	}
+	rn.Address = gnet.JoinHostPort(host, strconv.Itoa(rn.TlsPort))
+}
+
+func (rcs *RedisClusterShard) UseTlsPort() {
+	rcs.Master.UseTlsPort()
+	for i := range rcs.Slaves {
+		rcs.Slaves[i].UseTlsPort()
+	}
+}
+
 func (rn *RedisNode) AddressEqual(b *RedisNode) bool {
 	return rn.Ip == b.Ip && rn.Port == b.Port && rn.TlsPort == b.TlsPort
 }
@@ -735,6 +1385,42 @@ func (rc *RedisConfig) fix() error {
 	if rc.AliveTime < time.Minute {
 		rc.AliveTime = time.Minute
 	}
+	if err := rc.UserName.fix("redis userName"); err != nil {
+		return err
+	}
+	if err := rc.Password.fix("redis password"); err != nil {
+		return err
+	}
+	if rc.Tls != nil {
+		if rc.Tls.isEmpty() {
+			rc.Tls = nil
+		} else {
+			if err := rc.Tls.fix(); err != nil {
+				return err
+			}
+			rc.TlsEnable = true
+		}
+	}
+	if rc.Proxy {
+		if !rc.IsStanalone() {
+			return newConfigError("redis proxy should be standalone type")
+		}
+		if rc.Sharding != nil && !rc.Sharding.isEmpty() {
+			return newConfigError("redis proxy does not support sharding")
+		}
+	}
+	if rc.Sharding != nil {
+		if rc.Sharding.isEmpty() {
+			rc.Sharding = nil
+		} else {
+			if !rc.IsStanalone() {
+				return newConfigError("redis sharding only supports standalone redis")
+			}
+			if err := rc.Sharding.fix(rc.Addresses); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -742,6 +1428,15 @@ func (rc *RedisConfig) Address() string {
 	return rc.Addresses[0]
 }
 
+// ShardAddresses returns the addresses, and all node addresses of their shards
+func (rc *RedisConfig) ShardAddresses() []string {
+	addrs := slices.Clone(rc.Addresses)
+	for _, shard := range rc.GetClusterShards() {
+		addrs = append(addrs, shard.AllAddresses()...)
+	}
+	return addrs
+}
+
 func (rc *RedisConfig) IsCluster() bool {
 	return rc.Type == RedisTypeCluster
 }
@@ -750,6 +1445,11 @@ func (rc *RedisConfig) IsStanalone() bool {
 	return rc.Type == RedisTypeStandalone
 }
 
+// IsSharded returns true if keys are distributed to multiple standalone redis by client
+func (rc *RedisConfig) IsSharded() bool {
+	return rc.IsStanalone() && rc.Sharding != nil && len(rc.Addresses) > 1
+}
+
 func (rc *RedisConfig) Index(i int) RedisConfig {
 	addr := rc.Addresses[i]
 	slots := rc.GetSlots(addr)
@@ -758,9 +1458,11 @@ func (rc *RedisConfig) Index(i int) RedisConfig {
 		UserName:    rc.UserName,
 		Password:    rc.Password,
 		TlsEnable:   rc.TlsEnable,
+		Tls:         rc.Tls,
 		Type:        rc.Type,
 		Otype:       rc.Type,
 		Version:     rc.Version,
+		Proxy:       rc.Proxy,
 		isMigrating: rc.isMigrating,
 		KeepAlive:   rc.KeepAlive,
 		AliveTime:   rc.AliveTime,
@@ -813,6 +1515,7 @@ func (rc *RedisConfig) SelNodeByAddress(addr string) *RedisConfig {
 		UserName:       rc.UserName,
 		Password:       rc.Password,
 		TlsEnable:      rc.TlsEnable,
+		Tls:            rc.Tls,
 		Type:           rc.Type,
 		Otype:          rc.Type,
 		ClusterOptions: rc.ClusterOptions.Clone(),
@@ -885,6 +1588,7 @@ func (rc *RedisConfig) SelNodes(selAllShards bool, sel SelNodeStrategy) []RedisC
 			UserName:       rc.UserName,
 			Password:       rc.Password,
 			TlsEnable:      rc.TlsEnable,
+			Tls:            rc.Tls,
 			Type:           rc.Type,
 			Otype:          rc.Type,
 			ClusterOptions: rc.ClusterOptions.Clone(),
@@ -1,10 +1,15 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 )
 
 func TestInitConfig(t *testing.T) {
@@ -110,3 +115,525 @@ func TestSelNodes(t *testing.T) {
 	})
 
 }
+
+func TestRedisTlsConfig(t *testing.T) {
+	cfg := RedisConfig{Addresses: []string{"127.0.0.1:6379"}}
+	assert.Nil(t, cfg.fix())
+	tlsCfg, err := cfg.TlsConfig()
+	assert.Nil(t, err)
+	assert.Nil(t, tlsCfg)
+
+	// tlsEnable only, compatible with old versions
+	cfg.TlsEnable = true
+	tlsCfg, err = cfg.TlsConfig()
+	assert.Nil(t, err)
+	assert.True(t, tlsCfg.InsecureSkipVerify)
+
+	// tls options imply tlsEnable
+	cfg = RedisConfig{Addresses: []string{"127.0.0.1:6379"}, Tls: &RedisTlsConfig{ServerName: "redis.local", MinVersion: "1.2"}}
+	assert.Nil(t, cfg.fix())
+	assert.True(t, cfg.TlsEnable)
+	tlsCfg, err = cfg.TlsConfig()
+	assert.Nil(t, err)
+	assert.False(t, tlsCfg.InsecureSkipVerify)
+	assert.Equal(t, "redis.local", tlsCfg.ServerName)
+	assert.Equal(t, "redis.local", cfg.Index(0).Tls.ServerName)
+
+	cfg = RedisConfig{Addresses: []string{"127.0.0.1:6379"}, Tls: &RedisTlsConfig{CertFile: "cert.pem"}}
+	assert.NotNil(t, cfg.fix())
+}
+
+func TestRedisShardingConfig(t *testing.T) {
+	addrs := []string{"127.0.0.1:6379", "127.0.0.1:6380"}
+
+	// empty sharding is ignored
+	cfg := RedisConfig{Addresses: addrs, Sharding: &RedisShardingConfig{}}
+	assert.Nil(t, cfg.fix())
+	assert.Nil(t, cfg.Sharding)
+	assert.False(t, cfg.IsSharded())
+
+	cfg = RedisConfig{Addresses: addrs, Sharding: &RedisShardingConfig{Distribution: "ketama"}}
+	assert.Nil(t, cfg.fix())
+	assert.True(t, cfg.IsSharded())
+	assert.Equal(t, SliceString(addrs), cfg.Sharding.Names)
+	assert.Nil(t, cfg.Index(1).Sharding)
+
+	cfg = RedisConfig{Addresses: addrs, Sharding: &RedisShardingConfig{Distribution: "modula", Hash: "crc32"}}
+	assert.NotNil(t, cfg.fix())
+	cfg = RedisConfig{Addresses: addrs, Sharding: &RedisShardingConfig{Distribution: "ketama", Names: []string{"a"}}}
+	assert.NotNil(t, cfg.fix())
+	cfg = RedisConfig{Addresses: addrs, Sharding: &RedisShardingConfig{Distribution: "ketama", Weights: []int{1, 0}}}
+	assert.NotNil(t, cfg.fix())
+	cfg = RedisConfig{Addresses: addrs, Type: RedisTypeCluster, Sharding: &RedisShardingConfig{Distribution: "ketama"}}
+	assert.NotNil(t, cfg.fix())
+}
+
+func TestOutputProxy(t *testing.T) {
+	newOutput := func() *OutputConfig {
+		return &OutputConfig{Redis: &RedisConfig{Addresses: []string{"127.0.0.1:22121"}, Proxy: true}}
+	}
+
+	// checkpoint redis is required to resume from breakpoint
+	of := newOutput()
+	assert.NotNil(t, of.fix())
+
+	of = newOutput()
+	of.CheckpointRedis = &RedisConfig{Addresses: []string{"127.0.0.1:6379"}}
+	assert.Nil(t, of.fix())
+	assert.True(t, of.Redis.Index(0).Proxy)
+
+	resume := false
+	of = newOutput()
+	of.ResumeFromBreakPoint = &resume
+	of.CheckpointRedis = &RedisConfig{}
+	assert.Nil(t, of.fix())
+	assert.Nil(t, of.CheckpointRedis)
+
+	// proxies only have db 0
+	of.TargetDbMap = map[int]int{1: 2}
+	assert.NotNil(t, fixOutputDb(of, &FilterConfig{}))
+
+	of = newOutput()
+	of.Redis.Type = RedisTypeCluster
+	assert.NotNil(t, of.fix())
+}
+
+func TestCheckpointStore(t *testing.T) {
+	newOutput := func(cs *CheckpointStoreConfig) *OutputConfig {
+		return &OutputConfig{Redis: &RedisConfig{Addresses: []string{"127.0.0.1:22121"}, Proxy: true}, CheckpointStore: cs}
+	}
+
+	// empty store is redis
+	of := newOutput(&CheckpointStoreConfig{Etcd: &EtcdConfig{}})
+	of.CheckpointRedis = &RedisConfig{Addresses: []string{"127.0.0.1:6379"}}
+	assert.Nil(t, of.fix())
+	assert.Nil(t, of.CheckpointStore)
+
+	// proxy resumes from the store outside of redis
+	of = newOutput(&CheckpointStoreConfig{Type: "File"})
+	assert.Nil(t, of.fix())
+	assert.Equal(t, CheckpointStoreFile, of.CheckpointStore.Type)
+	assert.NotEmpty(t, of.CheckpointStore.Dir)
+	assert.NotNil(t, of.ExternalCheckpointStore())
+
+	of = newOutput(&CheckpointStoreConfig{Type: CheckpointStoreFile})
+	of.CheckpointRedis = &RedisConfig{Addresses: []string{"127.0.0.1:6379"}}
+	assert.NotNil(t, of.fix())
+
+	of = newOutput(&CheckpointStoreConfig{Type: "mysql"})
+	assert.NotNil(t, of.fix())
+
+	// etcd of cluster by default
+	cfg := &Config{Output: newOutput(&CheckpointStoreConfig{Type: CheckpointStoreEtcd, Prefix: "/cp"})}
+	assert.Nil(t, cfg.Output.fix())
+	assert.Equal(t, "/cp/", cfg.Output.CheckpointStore.Prefix)
+	assert.NotNil(t, cfg.fixCheckpointStores())
+	cfg.Cluster = &ClusterConfig{MetaEtcd: &EtcdConfig{Endpoints: []string{"127.0.0.1:2379"}}}
+	assert.Nil(t, cfg.fixCheckpointStores())
+	assert.Equal(t, cfg.Cluster.MetaEtcd, cfg.Output.CheckpointStore.Etcd)
+	assert.Len(t, cfg.CheckpointStores(), 1)
+	assert.Len(t, cfg.CheckpointRedises(), 0)
+
+	// redis store uses checkpointRedis
+	cfg = &Config{Output: newOutput(&CheckpointStoreConfig{Type: CheckpointStoreRedis})}
+	cfg.Output.CheckpointRedis = &RedisConfig{Addresses: []string{"127.0.0.1:6379"}}
+	assert.Nil(t, cfg.Output.fix())
+	assert.Nil(t, cfg.Output.ExternalCheckpointStore())
+	assert.Equal(t, []*RedisConfig{cfg.Output.CheckpointRedis}, cfg.CheckpointRedises())
+}
+
+func TestRewriteNonIdempotent(t *testing.T) {
+	of := &OutputConfig{Redis: &RedisConfig{Addresses: []string{"127.0.0.1:6379"}}, RewriteNonIdempotent: true}
+	assert.Nil(t, of.fix())
+
+	of = &OutputConfig{Redis: &RedisConfig{Addresses: []string{"127.0.0.1:6379"}}, RewriteNonIdempotent: true, Delay: time.Hour}
+	assert.ErrorIs(t, of.fix(), ErrInvalidConfig)
+}
+
+func TestBidirectional(t *testing.T) {
+	newOutput := func(bc *BidirectionalConfig) *OutputConfig {
+		return &OutputConfig{Redis: &RedisConfig{Addresses: []string{"127.0.0.1:6379"}}, Bidirectional: bc}
+	}
+
+	of := newOutput(&BidirectionalConfig{})
+	assert.Nil(t, of.fix())
+	assert.Nil(t, of.Bidirectional)
+
+	of = newOutput(&BidirectionalConfig{OriginId: "region-a"})
+	assert.Nil(t, of.fix())
+	assert.Equal(t, ConflictNone, of.Bidirectional.Conflict)
+	assert.Equal(t, 24*time.Hour, of.Bidirectional.LwwRecordTtl)
+
+	of = newOutput(&BidirectionalConfig{OriginId: "region-a", Conflict: "LWW", LwwRecordTtl: time.Hour})
+	assert.Nil(t, of.fix())
+	assert.Equal(t, ConflictLww, of.Bidirectional.Conflict)
+	assert.Equal(t, time.Hour, of.Bidirectional.LwwRecordTtl)
+
+	assert.NotNil(t, newOutput(&BidirectionalConfig{Conflict: ConflictNone}).fix())
+	assert.NotNil(t, newOutput(&BidirectionalConfig{OriginId: "region-a", Conflict: "fww"}).fix())
+	of = newOutput(&BidirectionalConfig{OriginId: "region-a", Conflict: ConflictLww})
+	of.RewriteNonIdempotent = true
+	assert.NotNil(t, of.fix())
+
+	// requires transactions
+	txn := false
+	of = newOutput(&BidirectionalConfig{OriginId: "region-a", Conflict: "NONE"})
+	of.ReplayTransaction = &txn
+	assert.NotNil(t, of.fix())
+	of = newOutput(&BidirectionalConfig{OriginId: "region-a"})
+	of.Redis.Proxy = true
+	of.CheckpointRedis = &RedisConfig{Addresses: []string{"127.0.0.1:6380"}}
+	assert.NotNil(t, of.fix())
+}
+
+func TestGuard(t *testing.T) {
+	newOutput := func(gc *GuardConfig) *OutputConfig {
+		return &OutputConfig{Redis: &RedisConfig{Addresses: []string{"127.0.0.1:6379"}}, Guard: gc}
+	}
+
+	of := newOutput(&GuardConfig{MaxDeletedKeysPerSecond: -1})
+	assert.Nil(t, of.fix())
+	assert.Nil(t, of.Guard)
+	assert.False(t, of.Guard.Quarantines("flushall"))
+
+	of = newOutput(&GuardConfig{Commands: []string{"FLUSHALL", " flushdb"}, MaxDeletedKeysPerSecond: 1000})
+	assert.Nil(t, of.fix())
+	assert.True(t, of.Guard.Quarantines("flushall"))
+	assert.True(t, of.Guard.Quarantines("FLUSHDB"))
+	assert.False(t, of.Guard.Quarantines("swapdb"))
+	assert.Equal(t, 1000, of.Guard.MaxDeletedKeysPerSecond)
+}
+
+func TestRumpConfig(t *testing.T) {
+	newInput := func(syncType string) *InputConfig {
+		return &InputConfig{Redis: &RedisConfig{Addresses: []string{"127.0.0.1:6379"}}, SyncType: syncType}
+	}
+
+	ic := newInput("")
+	assert.Nil(t, ic.fix())
+	assert.Equal(t, TypeSync, ic.SyncType)
+	assert.Equal(t, 1000, ic.Rump.ScanCount)
+	assert.Equal(t, 4, ic.Rump.Parallel)
+
+	ic = newInput("RUMP")
+	ic.Rump = RumpConfig{ScanCount: 100, KeysPerSecond: -1}
+	assert.Nil(t, ic.fix())
+	assert.Equal(t, TypeRump, ic.SyncType)
+	assert.Equal(t, 100, ic.Rump.ScanCount)
+	assert.Equal(t, 0, ic.Rump.KeysPerSecond)
+
+	assert.NotNil(t, newInput("dump").fix())
+}
+
+func TestInputFileConfig(t *testing.T) {
+	ic := &InputConfig{SyncType: "file", Redis: &RedisConfig{Addresses: []string{"127.0.0.1:6379"}, Type: RedisTypeCluster}}
+	assert.NotNil(t, ic.fix())
+
+	ic.File = &InputFileConfig{Aof: "/data/appendonlydir"}
+	assert.Nil(t, ic.fix())
+	assert.Equal(t, "/data/appendonlydir", ic.Redis.Address())
+	assert.Equal(t, RedisTypeStandalone, ic.Redis.Type)
+
+	ic.File.Rdb = "/data/dump.rdb"
+	assert.Nil(t, ic.fix())
+	assert.Equal(t, "/data/dump.rdb", ic.Redis.Address())
+}
+
+func TestServerPsyncConfig(t *testing.T) {
+	sc := &ServerConfig{Psync: &ServerPsyncConfig{}}
+	assert.Nil(t, sc.fix())
+	assert.Nil(t, sc.Psync)
+
+	sc.Psync = &ServerPsyncConfig{Listen: "0.0.0.0:16379", Password: "pwd"}
+	assert.Nil(t, sc.fix())
+	assert.Equal(t, "0.0.0.0:16379", sc.Psync.Address(0))
+	assert.Equal(t, "0.0.0.0:16381", sc.Psync.Address(2))
+
+	for _, listen := range []string{"16379", "0.0.0.0:x", "0.0.0.0:70000"} {
+		sc.Psync = &ServerPsyncConfig{Listen: listen}
+		assert.NotNil(t, sc.fix(), listen)
+	}
+}
+
+func TestServerOtlpConfig(t *testing.T) {
+	sc := &ServerConfig{Otlp: &ServerOtlpConfig{}}
+	assert.Nil(t, sc.fix())
+	assert.Nil(t, sc.Otlp)
+
+	sc.Otlp = &ServerOtlpConfig{Endpoint: "127.0.0.1:4318"}
+	assert.Nil(t, sc.fix())
+	assert.Equal(t, 15*time.Second, sc.Otlp.Interval)
+	assert.True(t, *sc.Otlp.Metrics)
+	assert.True(t, *sc.Otlp.Traces)
+	assert.Equal(t, float64(1), sc.Otlp.SampleRatio)
+
+	for _, oc := range []*ServerOtlpConfig{
+		{Endpoint: "4318"},
+		{Endpoint: "127.0.0.1:4318", SampleRatio: 1.5},
+		{Endpoint: "127.0.0.1:4318", Headers: map[string]Secret{"authorization": "env:REDIS_GUNYU_OTLP_NOT_EXIST"}},
+	} {
+		sc.Otlp = oc
+		assert.NotNil(t, sc.fix(), oc.Endpoint)
+	}
+}
+
+func TestServerJournalConfig(t *testing.T) {
+	sc := &ServerConfig{}
+	assert.Nil(t, sc.fix())
+	assert.NotEmpty(t, sc.Journal.DirPath)
+	assert.Equal(t, int64(10*1024*1024), sc.Journal.MaxSize)
+
+	sc.Journal = &ServerJournalConfig{DirPath: "/data/journal", MaxSize: 1024}
+	assert.NotNil(t, sc.fix())
+}
+
+func TestFilterKeyConfig(t *testing.T) {
+	kc := FilterKeyConfig{SlotRanges: []string{"0-100", " 200 ", "16000-16383"}, HashTags: []string{"tenant1"}}
+	assert.Nil(t, kc.fix())
+	assert.Equal(t, []RedisSlotRange{{0, 100}, {200, 200}, {16000, 16383}}, kc.GetSlotRanges())
+
+	for _, sr := range []string{"100-0", "0-16384", "a-1", "-1"} {
+		kc = FilterKeyConfig{SlotRanges: []string{sr}}
+		assert.NotNil(t, kc.fix(), sr)
+	}
+	kc = FilterKeyConfig{HashTags: []string{""}}
+	assert.NotNil(t, kc.fix())
+}
+
+func TestUseTlsPort(t *testing.T) {
+	shard := &RedisClusterShard{
+		Master: RedisNode{Address: "127.0.0.1:6379", Port: 6379, TlsPort: 16379},
+		Slaves: []RedisNode{{Address: "127.0.0.1:6380", Port: 6380}},
+	}
+	shard.UseTlsPort()
+	assert.Equal(t, "127.0.0.1:16379", shard.Master.Address)
+	assert.Equal(t, "127.0.0.1:6380", shard.Slaves[0].Address)
+}
+
+func TestSecret(t *testing.T) {
+	plain := Secret("pass")
+	val, err := plain.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "pass", val)
+	assert.Equal(t, secretRedacted, plain.String())
+	assert.Equal(t, secretRedacted, fmt.Sprintf("%v", RedisConfig{Password: plain}.Password))
+
+	t.Setenv("GUNYU_TEST_SECRET", "envpass")
+	val, err = Secret("env:GUNYU_TEST_SECRET").Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "envpass", val)
+	_, err = Secret("env:GUNYU_TEST_SECRET_NOT_EXIST").Value()
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+
+	// file, reloaded after rotation
+	path := filepath.Join(t.TempDir(), "password")
+	assert.Nil(t, os.WriteFile(path, []byte("v1\n"), 0600))
+	fs := Secret("file:" + path)
+	val, err = fs.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", val)
+	assert.Nil(t, os.WriteFile(path, []byte("rotated\n"), 0600))
+	val, err = fs.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "rotated", val)
+	assert.Equal(t, "file:"+path, fs.String())
+
+	// redacted in API outputs
+	cfg := RedisConfig{Addresses: []string{"127.0.0.1:6379"}, Password: "pass"}
+	data, err := yaml.Marshal(cfg)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "pass\n")
+	data, err = json.Marshal(cfg)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), `"pass"`)
+
+	cfg.Password = Secret("file:" + path + ".not.exist")
+	assert.NotNil(t, cfg.fix())
+}
+
+func TestConfigDiff(t *testing.T) {
+	dir := t.TempDir()
+	load := func(extra string) *Config {
+		path := filepath.Join(dir, "config.yaml")
+		data := `
+input:
+  redis:
+    addresses: [127.0.0.1:6379]
+output:
+  redis:
+    addresses: [127.0.0.1:6380]
+channel:
+  storer:
+    dirPath: ` + dir + `
+` + extra
+		assert.Nil(t, os.WriteFile(path, []byte(data), 0600))
+		c, err := LoadConfig(path)
+		assert.Nil(t, err)
+		return c
+	}
+
+	cur := load("")
+	assert.True(t, cur.Diff(load("")).Empty())
+
+	// live
+	nc := load(`filter:
+  commandBlacklist: [flushall]
+log:
+  level: debug
+`)
+	nc.Output.BatchCmdCount = 10
+	diff := cur.Diff(nc)
+	assert.ElementsMatch(t, []string{"filter", "output.batchCmdCount", "log.level"}, diff.Live)
+	assert.False(t, diff.NeedRestart())
+	assert.Empty(t, diff.Ignored)
+
+	merged := cur.Merge(nc, diff)
+	assert.Equal(t, SliceString{"flushall"}, merged.Filter.CmdBlacklist)
+	assert.Equal(t, uint(10), merged.Output.BatchCmdCount)
+	assert.Equal(t, "debug", merged.Log.level.String())
+	assert.True(t, cur.Output.Redis == merged.Output.Redis) // reserve the typology
+	assert.True(t, cur.Input.Redis == merged.Input.Redis)
+
+	// restart syncers and ignored
+	nc = load(`server:
+  listen: 127.0.0.1:18002
+`)
+	nc.Input.Redis.Password = "pass"
+	diff = cur.Diff(nc)
+	assert.Equal(t, []string{"input.redis"}, diff.Restart)
+	assert.True(t, diff.InputRedis)
+	assert.False(t, diff.OutputRedis)
+	assert.Equal(t, []string{"server"}, diff.Ignored)
+
+	merged = cur.Merge(nc, diff)
+	assert.Equal(t, cur.Server.Listen, merged.Server.Listen)
+	assert.Equal(t, Secret("pass"), merged.Input.Redis.Password)
+}
+
+func TestInputOverride(t *testing.T) {
+	dir := t.TempDir()
+	load := func(inputs string) (*Config, error) {
+		path := filepath.Join(dir, "config.yaml")
+		data := `
+input:
+  redis:
+    addresses: [127.0.0.1:6379, 127.0.0.1:6389]
+output:
+  redis:
+    addresses: [127.0.0.1:6380]
+    password: pass
+  batchCmdCount: 20
+  keyExists: ignore
+  targetDbMap: {0: 1}
+filter:
+  commandBlacklist: [flushall]
+channel:
+  storer:
+    dirPath: ` + dir + `
+` + inputs
+		assert.Nil(t, os.WriteFile(path, []byte(data), 0600))
+		return LoadConfig(path)
+	}
+
+	c, err := load(`inputs:
+  - addresses: [127.0.0.1:6379]
+    output:
+      batchCmdCount: 50
+      targetDbMap: {2: 3}
+    filter:
+      keyFilter:
+        prefixKeyBlacklist: [tmp]
+  - addresses: [127.0.0.1:6389]
+    output:
+      redis:
+        addresses: [127.0.0.1:6390]
+      replayTransaction: false
+    channel:
+      storer:
+        maxSize: 1024
+`)
+	assert.Nil(t, err)
+
+	// merged over global sections
+	st := c.InputSettings("127.0.0.1:6379")
+	assert.Equal(t, uint(50), st.Output.BatchCmdCount)
+	assert.Equal(t, "ignore", st.Output.KeyExists)
+	assert.Equal(t, map[int]int{0: 1, 2: 3}, st.Output.TargetDbMap)
+	assert.True(t, st.Output.Redis == c.Output.Redis)
+	assert.Equal(t, SliceString{"flushall"}, st.Filter.CmdBlacklist)
+	assert.Equal(t, SliceString{"tmp"}, st.Filter.KeyFilter.PrefixKeyBlacklist)
+	assert.True(t, st.Channel != c.Channel)
+	assert.Equal(t, c.Channel.Storer.MaxSize, st.Channel.Storer.MaxSize)
+
+	// own output
+	in := c.InputOverride("127.0.0.2:6379", "127.0.0.1:6389")
+	assert.NotNil(t, in)
+	assert.True(t, in.HasOwnOutput())
+	assert.Equal(t, SliceString{"127.0.0.1:6390"}, in.Output.Redis.Addresses)
+	assert.Equal(t, Secret(""), in.Output.Redis.Password) // not inherited from another output
+	assert.False(t, *in.Output.ReplayTransaction)
+	assert.Equal(t, uint(20), in.Output.BatchCmdCount)
+	assert.Equal(t, int64(1024), in.Channel.Storer.MaxSize)
+	assert.Len(t, c.OutputRedises(), 2)
+
+	// channels in the same directory share the minimum maxSize
+	assert.Equal(t, map[string]int64{c.Channel.Storer.DirPath: 1024}, c.StorerLimits())
+
+	// global is not modified
+	assert.Equal(t, uint(20), c.Output.BatchCmdCount)
+	assert.Equal(t, map[int]int{0: 1}, c.Output.TargetDbMap)
+	assert.Nil(t, c.Filter.KeyFilter)
+	assert.True(t, *c.Output.ReplayTransaction)
+
+	// not overridden
+	st = c.InputSettings("127.0.0.3:6379")
+	assert.True(t, st.Output == c.Output)
+	assert.True(t, st.Filter == &c.Filter)
+
+	// diff
+	nc, err := load(`inputs:
+  - addresses: [127.0.0.1:6379]
+    output:
+      batchCmdCount: 60
+      targetDbMap: {2: 3}
+    filter:
+      keyFilter:
+        prefixKeyBlacklist: [tmp]
+  - addresses: [127.0.0.1:6389]
+    output:
+      redis:
+        addresses: [127.0.0.1:6391]
+      replayTransaction: false
+    channel:
+      storer:
+        maxSize: 1024
+`)
+	assert.Nil(t, err)
+	diff := c.Diff(nc)
+	assert.Equal(t, []string{"inputs[0].output.batchCmdCount"}, diff.Live)
+	assert.Equal(t, []string{"inputs[1].output.redis"}, diff.Restart)
+	assert.Equal(t, []int{1}, diff.OverrideRedis)
+	merged := c.Merge(nc, diff)
+	assert.True(t, merged.Inputs[0].Output.Redis == c.Output.Redis)
+	assert.True(t, merged.Inputs[1].Channel == c.Inputs[1].Channel)
+	assert.Equal(t, SliceString{"127.0.0.1:6391"}, merged.Inputs[1].Output.Redis.Addresses)
+
+	// invalid
+	_, err = load(`inputs:
+  - addresses: [127.0.0.1:6379]
+  - addresses: [127.0.0.1:6379]
+`)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	_, err = load(`inputs:
+  - addresses: [127.0.0.1:6379]
+    output:
+      redis:
+        addresses: [127.0.0.1:6390, 127.0.0.1:6391]
+`)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	_, err = load(`inputs:
+  - output:
+      batchCmdCount: 50
+`)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
@@ -44,10 +44,12 @@ type DiffCmdFlags struct {
 }
 
 type AofCmdFlags struct {
-	Action string
-	Path   string
-	Offset int64
-	Size   int64
+	Action     string
+	Path       string
+	Offset     int64
+	Size       int64
+	FilterCmds string // comma separated command names
+	FilterKeys string // comma separated key prefixes
 }
 
 func LoadFlags() error {
@@ -64,9 +66,11 @@ func LoadFlags() error {
 	flag.IntVar(&flagVar.DiffCmd.Parallel, "diff.parallel", -1, "")
 
 	flag.StringVar(&flagVar.AofCmd.Action, "aof.action", "parse", "parse/verify/cmd")
-	flag.StringVar(&flagVar.AofCmd.Path, "aof.path", "", "aof path")
+	flag.StringVar(&flagVar.AofCmd.Path, "aof.path", "", "aof path, or the directory or manifest of multi-part aof(redis 7.0)")
 	flag.Int64Var(&flagVar.AofCmd.Offset, "aof.offset", 0, "aof offset")
 	flag.Int64Var(&flagVar.AofCmd.Size, "aof.size", -1, "aof size")
+	flag.StringVar(&flagVar.AofCmd.FilterCmds, "aof.filter.cmds", "", "print commands of these names, separated by comma")
+	flag.StringVar(&flagVar.AofCmd.FilterKeys, "aof.filter.keys", "", "print commands of keys with these prefixes, separated by comma")
 
 	tmpCfg := Config{}
 	FlagsParseToStruct("sync", &tmpCfg)
@@ -76,12 +80,12 @@ func LoadFlags() error {
 	version.Init()
 
 	if flagVar.Cmd == "sync" && len(flagVar.ConfigPath) == 0 {
-		cfg = &tmpCfg
-		FlagsSetToStruct(cfg)
+		FlagsSetToStruct(&tmpCfg)
 
-		if err := cfg.fix(); err != nil {
+		if err := tmpCfg.fix(); err != nil {
 			return err
 		}
+		cfgPtr.Store(&tmpCfg)
 	}
 
 	return nil
@@ -145,7 +149,12 @@ func flagsParseType(kind reflect.Kind, val reflect.Value, tag string, defVal str
 	var err error
 	switch kind {
 	case reflect.String:
-		flag.StringVar(val.Addr().Interface().(*string), tag, defVal, usage)
+		if val.Type().Name() == "string" {
+			flag.StringVar(val.Addr().Interface().(*string), tag, defVal, usage)
+		} else {
+			yy := (interface{})(val.Addr().Interface())
+			flag.Var((yy).(flag.Value), tag, usage)
+		}
 	case reflect.Int, reflect.Int64:
 		rVal := int64(0)
 		if defVal != "" {
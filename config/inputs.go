@@ -0,0 +1,264 @@
+package config
+
+import (
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// InputOverrideConfig overrides output, filter and channel for some input nodes,
+// the overridden fields are merged over the global sections, e.g.
+//
+//	inputs:
+//	  - addresses: [127.0.0.1:6379]
+//	    output:
+//	      targetDbMap: {0: 1}
+//	      batchCmdCount: 50
+//	    filter:
+//	      commandBlacklist: [flushdb]
+type InputOverrideConfig struct {
+	Addresses SliceString    `yaml:"addresses"` // input nodes, an address of cluster matches all nodes of its shard
+	Output    *OutputConfig  `yaml:"output"`
+	Filter    *FilterConfig  `yaml:"filter"`
+	Channel   *ChannelConfig `yaml:"channel"`
+
+	raw inputOverrideRaw
+}
+
+type inputOverrideRaw struct {
+	Addresses SliceString `yaml:"addresses"`
+	Output    yaml.Node   `yaml:"output"`
+	Filter    yaml.Node   `yaml:"filter"`
+	Channel   yaml.Node   `yaml:"channel"`
+}
+
+// configRaw keeps the global sections of the configuration file, overrides are merged over them
+type configRaw struct {
+	Output  yaml.Node `yaml:"output"`
+	Filter  yaml.Node `yaml:"filter"`
+	Channel yaml.Node `yaml:"channel"`
+}
+
+func (ic *InputOverrideConfig) UnmarshalYAML(value *yaml.Node) error {
+	if err := value.Decode(&ic.raw); err != nil {
+		return err
+	}
+	ic.Addresses = ic.raw.Addresses
+	return nil
+}
+
+// HasOwnOutput returns true if output.redis is overridden
+func (ic *InputOverrideConfig) HasOwnOutput() bool {
+	return hasYamlKey(&ic.raw.Output, "redis")
+}
+
+func (ic *InputOverrideConfig) match(addrs []string) bool {
+	for _, addr := range addrs {
+		if slices.Contains(ic.Addresses, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// merge decodes the global section and then the overridden one, the result is validated by fix
+func (ic *InputOverrideConfig) merge(c *Config) error {
+	ic.Output = &OutputConfig{}
+	global := &c.raw.Output
+	if ic.HasOwnOutput() {
+		// the own redis is another output, its credentials and tls are not inherited from the global redis
+		global = withoutYamlKey(global, "redis")
+	}
+	if err := decodeYamlNodes(ic.Output, global, &ic.raw.Output); err != nil {
+		return err
+	}
+	if !ic.HasOwnOutput() {
+		ic.Output.Redis = c.Output.Redis
+	}
+	if err := ic.Output.fix(); err != nil {
+		return err
+	}
+	if ic.HasOwnOutput() && ic.Output.Redis.IsStanalone() && !ic.Output.Redis.IsSharded() && len(ic.Output.Redis.Addresses) != 1 {
+		return newConfigError("standalone output should have one address : %v", ic.Output.Redis.Addresses)
+	}
+
+	ic.Filter = &FilterConfig{}
+	if err := decodeYamlNodes(ic.Filter, &c.raw.Filter, &ic.raw.Filter); err != nil {
+		return err
+	}
+	if err := ic.Filter.fix(); err != nil {
+		return err
+	}
+	if err := fixOutputDb(ic.Output, ic.Filter); err != nil {
+		return err
+	}
+
+	ic.Channel = &ChannelConfig{}
+	if err := decodeYamlNodes(ic.Channel, &c.raw.Channel, &ic.raw.Channel); err != nil {
+		return err
+	}
+	return ic.Channel.fix()
+}
+
+func (c *Config) fixInputs() error {
+	if len(c.Inputs) == 0 {
+		return nil
+	}
+	if c.raw == nil {
+		return newConfigError("inputs is only supported by configuration file")
+	}
+	addrs := make(map[string]struct{})
+	for i, in := range c.Inputs {
+		if in == nil || len(in.Addresses) == 0 {
+			return newConfigError("inputs[%d] : addresses is empty", i)
+		}
+		for _, addr := range in.Addresses {
+			if _, ok := addrs[addr]; ok {
+				return newConfigError("inputs[%d] : duplicate address %s", i, addr)
+			}
+			addrs[addr] = struct{}{}
+		}
+		if err := in.merge(c); err != nil {
+			return newConfigError("inputs[%d] : %v", i, err)
+		}
+	}
+	return nil
+}
+
+// InputSettings are the output, filter and channel of an input node
+type InputSettings struct {
+	Output  *OutputConfig
+	Filter  *FilterConfig
+	Channel *ChannelConfig
+}
+
+// InputOverride returns the override which matches one of addresses, or nil
+func (c *Config) InputOverride(addrs ...string) *InputOverrideConfig {
+	for _, in := range c.Inputs {
+		if in.match(addrs) {
+			return in
+		}
+	}
+	return nil
+}
+
+// InputSettings returns the settings of an input node, addrs are addresses of the node and its shard
+func (c *Config) InputSettings(addrs ...string) InputSettings {
+	if in := c.InputOverride(addrs...); in != nil {
+		return InputSettings{Output: in.Output, Filter: in.Filter, Channel: in.Channel}
+	}
+	return InputSettings{Output: c.Output, Filter: &c.Filter, Channel: c.Channel}
+}
+
+// OutputRedises returns the global output redis, and overridden ones
+func (c *Config) OutputRedises() []*RedisConfig {
+	rcs := []*RedisConfig{c.Output.Redis}
+	for _, in := range c.Inputs {
+		if in.HasOwnOutput() {
+			rcs = append(rcs, in.Output.Redis)
+		}
+	}
+	return rcs
+}
+
+// CheckpointRedises returns redis which store checkpoints, they are output redis or checkpoint redis of outputs
+func (c *Config) CheckpointRedises() []*RedisConfig {
+	rcs := []*RedisConfig{}
+	add := func(of *OutputConfig) {
+		if of.ExternalCheckpointStore() != nil {
+			return
+		}
+		rc := of.Redis
+		if of.CheckpointRedis != nil {
+			rc = of.CheckpointRedis
+		}
+		if !slices.Contains(rcs, rc) {
+			rcs = append(rcs, rc)
+		}
+	}
+	add(c.Output)
+	for _, in := range c.Inputs {
+		add(in.Output)
+	}
+	return rcs
+}
+
+// CheckpointStores returns distinct checkpoint stores outside of redis
+func (c *Config) CheckpointStores() []*CheckpointStoreConfig {
+	stores := []*CheckpointStoreConfig{}
+	add := func(of *OutputConfig) {
+		cs := of.ExternalCheckpointStore()
+		if cs == nil {
+			return
+		}
+		if slices.ContainsFunc(stores, func(s *CheckpointStoreConfig) bool { return s.Id() == cs.Id() }) {
+			return
+		}
+		stores = append(stores, cs)
+	}
+	add(c.Output)
+	for _, in := range c.Inputs {
+		add(in.Output)
+	}
+	return stores
+}
+
+// Channels returns the global channel, and overridden ones
+func (c *Config) Channels() []*ChannelConfig {
+	ccs := []*ChannelConfig{c.Channel}
+	for _, in := range c.Inputs {
+		ccs = append(ccs, in.Channel)
+	}
+	return ccs
+}
+
+// StorerLimits returns maxSize of each directory of channels,
+// channels may be overridden with the same directory, the minimum maxSize of them is the limit of the directory
+func (c *Config) StorerLimits() map[string]int64 {
+	limits := make(map[string]int64)
+	for _, ch := range c.Channels() {
+		limit, ok := limits[ch.Storer.DirPath]
+		if !ok || ch.Storer.MaxSize < limit {
+			limits[ch.Storer.DirPath] = ch.Storer.MaxSize
+		}
+	}
+	return limits
+}
+
+func decodeYamlNodes(out interface{}, nodes ...*yaml.Node) error {
+	for _, node := range nodes {
+		if node.IsZero() {
+			continue
+		}
+		if err := node.Decode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutYamlKey returns a copy of the mapping node without the key
+func withoutYamlKey(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return node
+	}
+	ret := *node
+	ret.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != key {
+			ret.Content = append(ret.Content, node.Content[i], node.Content[i+1])
+		}
+	}
+	return &ret
+}
+
+func hasYamlKey(node *yaml.Node, key string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+var (
+	generation atomic.Int64
+)
+
+// Generation is increased after the configuration is reloaded,
+// long-running components compare it to pick up live changes
+func Generation() int64 {
+	return generation.Load()
+}
+
+// ConfigDiff is the difference between the running configuration and the reloaded one
+type ConfigDiff struct {
+	Live    []string // applied without restarting syncers
+	Restart []string // affected syncers are restarted
+	Ignored []string // requires restarting the process
+
+	InputRedis    bool  // input.redis is changed, the topology should be fixed before reloading
+	OutputRedis   bool  // output.redis is changed, the topology should be fixed before reloading
+	OverrideRedis []int // indexes of inputs whose output.redis is changed, the topology should be fixed before reloading
+}
+
+func (cd *ConfigDiff) Empty() bool {
+	return len(cd.Live) == 0 && len(cd.Restart) == 0 && len(cd.Ignored) == 0
+}
+
+func (cd *ConfigDiff) NeedRestart() bool {
+	return len(cd.Restart) > 0
+}
+
+// Diff compares the configuration with a new one, both of them should be fixed
+func (c *Config) Diff(nc *Config) *ConfigDiff {
+	diff := &ConfigDiff{}
+
+	// live
+	diffField(&diff.Live, "filter", c.Filter, nc.Filter)
+	diffField(&diff.Live, "log.level", c.Log.level, nc.Log.level)
+
+	// restart syncers
+	diff.InputRedis = !c.Input.Redis.settingsEqual(nc.Input.Redis)
+	if diff.InputRedis {
+		diff.Restart = append(diff.Restart, "input.redis")
+	}
+	diffField(&diff.Restart, "input.mode", c.Input.Mode, nc.Input.Mode)
+	diffField(&diff.Restart, "input.syncFrom", c.Input.SyncFrom, nc.Input.SyncFrom)
+	diffField(&diff.Restart, "input.syncDelayTestKey", c.Input.SyncDelayTestKey, nc.Input.SyncDelayTestKey)
+	diffField(&diff.Restart, "input.syncType", c.Input.SyncType, nc.Input.SyncType)
+	diffField(&diff.Restart, "input.rump", c.Input.Rump, nc.Input.Rump)
+	diffField(&diff.Restart, "input.file", c.Input.File, nc.Input.File)
+	diff.OutputRedis = !c.Output.Redis.settingsEqual(nc.Output.Redis)
+	if diff.OutputRedis {
+		diff.Restart = append(diff.Restart, "output.redis")
+	}
+	diff.diffOutput("output.", c.Output, nc.Output)
+	diff.diffInputs(c.Inputs, nc.Inputs)
+
+	// restart process
+	diffField(&diff.Ignored, "input.rdbParallel", c.Input.RdbParallel, nc.Input.RdbParallel)
+	diffField(&diff.Ignored, "channel", c.Channel, nc.Channel)
+	diffField(&diff.Ignored, "cluster", c.Cluster, nc.Cluster)
+	diffField(&diff.Ignored, "server", c.Server, nc.Server)
+	diffField(&diff.Ignored, "log.handler", c.Log.Handler, nc.Log.Handler)
+	diffField(&diff.Ignored, "log.others", []interface{}{c.Log.StacktraceLevelStr, c.Log.Caller, c.Log.Func, c.Log.ModuleName},
+		[]interface{}{nc.Log.StacktraceLevelStr, nc.Log.Caller, nc.Log.Func, nc.Log.ModuleName})
+
+	return diff
+}
+
+// diffOutput compares fields of output except redis
+func (cd *ConfigDiff) diffOutput(prefix string, a, b *OutputConfig) {
+	// live
+	diffField(&cd.Live, prefix+"batchCmdCount", a.BatchCmdCount, b.BatchCmdCount)
+	diffField(&cd.Live, prefix+"batchTicker", a.BatchTicker, b.BatchTicker)
+	diffField(&cd.Live, prefix+"batchBufferSize", a.BatchBufferSize, b.BatchBufferSize)
+	diffField(&cd.Live, prefix+"keyExists", a.KeyExists, b.KeyExists)
+	diffField(&cd.Live, prefix+"keyExistsLog", a.KeyExistsLog, b.KeyExistsLog)
+	diffField(&cd.Live, prefix+"stats", a.Stats, b.Stats)
+	diffField(&cd.Live, prefix+"delay", a.Delay, b.Delay)
+	diffField(&cd.Live, prefix+"guard", a.Guard, b.Guard)
+
+	// restart syncers
+	diffField(&cd.Restart, prefix+"resumeFromBreakPoint", *a.ResumeFromBreakPoint, *b.ResumeFromBreakPoint)
+	diffField(&cd.Restart, prefix+"replaceHashTag", a.ReplaceHashTag, b.ReplaceHashTag)
+	diffField(&cd.Restart, prefix+"functionExists", a.FunctionExists, b.FunctionExists)
+	diffField(&cd.Restart, prefix+"maxProtoBulkLen", a.MaxProtoBulkLen, b.MaxProtoBulkLen)
+	diffField(&cd.Restart, prefix+"targetDb", a.TargetDb, b.TargetDb)
+	diffField(&cd.Restart, prefix+"targetDbMap", a.TargetDbMap, b.TargetDbMap)
+	diffField(&cd.Restart, prefix+"keepaliveTicker", a.KeepaliveTicker, b.KeepaliveTicker)
+	diffField(&cd.Restart, prefix+"replayRdbParallel", a.ReplayRdbParallel, b.ReplayRdbParallel)
+	diffField(&cd.Restart, prefix+"replayRdbEnableRestore", *a.ReplayRdbEnableRestore, *b.ReplayRdbEnableRestore)
+	diffField(&cd.Restart, prefix+"updateCheckpointTicker", a.UpdateCheckpointTicker, b.UpdateCheckpointTicker)
+	diffField(&cd.Restart, prefix+"replayTransaction", *a.ReplayTransaction, *b.ReplayTransaction)
+	diffField(&cd.Restart, prefix+"rewriteNonIdempotent", a.RewriteNonIdempotent, b.RewriteNonIdempotent)
+	diffField(&cd.Restart, prefix+"bidirectional", a.Bidirectional, b.Bidirectional)
+	if (a.CheckpointRedis == nil) != (b.CheckpointRedis == nil) ||
+		(a.CheckpointRedis != nil && !a.CheckpointRedis.settingsEqual(b.CheckpointRedis)) {
+		cd.Restart = append(cd.Restart, prefix+"checkpointRedis")
+	}
+	if (a.CheckpointStore == nil) != (b.CheckpointStore == nil) ||
+		(a.CheckpointStore != nil && a.CheckpointStore.Id() != b.CheckpointStore.Id()) {
+		cd.Restart = append(cd.Restart, prefix+"checkpointStore")
+	}
+}
+
+// diffInputs compares overrides by index, channels of overrides are ignored like the global one
+func (cd *ConfigDiff) diffInputs(a, b []*InputOverrideConfig) {
+	for i, nin := range b {
+		prefix := fmt.Sprintf("inputs[%d].", i)
+		if i >= len(a) || !reflect.DeepEqual(a[i].Addresses, nin.Addresses) {
+			cd.Restart = append(cd.Restart, prefix+"addresses")
+			if nin.HasOwnOutput() {
+				cd.OverrideRedis = append(cd.OverrideRedis, i)
+			}
+			continue
+		}
+		in := a[i]
+		if in.HasOwnOutput() != nin.HasOwnOutput() ||
+			(nin.HasOwnOutput() && !in.Output.Redis.settingsEqual(nin.Output.Redis)) {
+			cd.Restart = append(cd.Restart, prefix+"output.redis")
+			if nin.HasOwnOutput() {
+				cd.OverrideRedis = append(cd.OverrideRedis, i)
+			}
+		}
+		diffField(&cd.Live, prefix+"filter", in.Filter, nin.Filter)
+		cd.diffOutput(prefix+"output.", in.Output, nin.Output)
+		diffField(&cd.Ignored, prefix+"channel", in.Channel, nin.Channel)
+	}
+	if len(a) > len(b) {
+		cd.Restart = append(cd.Restart, "inputs")
+	}
+}
+
+func diffField(list *[]string, name string, a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return false
+	}
+	*list = append(*list, name)
+	return true
+}
+
+// settingsEqual compares configured fields, ignores the typology which is fixed at runtime,
+// and cluster options which are modified by outputs
+func (rc *RedisConfig) settingsEqual(b *RedisConfig) bool {
+	return reflect.DeepEqual(rc.Addresses, b.Addresses) &&
+		rc.UserName == b.UserName && rc.Password == b.Password &&
+		rc.TlsEnable == b.TlsEnable && reflect.DeepEqual(rc.Tls, b.Tls) &&
+		rc.Otype == b.Otype && reflect.DeepEqual(rc.Sharding, b.Sharding) && rc.Proxy == b.Proxy &&
+		rc.KeepAlive == b.KeepAlive && rc.AliveTime == b.AliveTime
+}
+
+// Merge returns a configuration which applies the new one according to diff,
+// ignored fields are kept, and unchanged redis configurations are kept to reserve the runtime typology.
+// if input.redis or output.redis(including overridden ones) is changed, nc's typology should be fixed before merging
+func (c *Config) Merge(nc *Config, diff *ConfigDiff) *Config {
+	merged := *c
+
+	merged.Filter = nc.Filter
+
+	output := *nc.Output
+	if !diff.OutputRedis {
+		output.Redis = c.Output.Redis
+	}
+	merged.Output = &output
+
+	input := *nc.Input
+	input.RdbParallel = c.Input.RdbParallel
+	input.rdbParallelLimiter = c.Input.rdbParallelLimiter
+	if !diff.InputRedis {
+		input.Redis = c.Input.Redis
+	}
+	merged.Input = &input
+
+	merged.Inputs = nil
+	for i, nin := range nc.Inputs {
+		in := *nin
+		inOutput := *nin.Output
+		in.Output = &inOutput
+		var prev *InputOverrideConfig
+		if i < len(c.Inputs) && reflect.DeepEqual(c.Inputs[i].Addresses, nin.Addresses) {
+			prev = c.Inputs[i]
+			in.Channel = prev.Channel
+		}
+		if !in.HasOwnOutput() {
+			inOutput.Redis = merged.Output.Redis
+		} else if prev != nil && prev.HasOwnOutput() && prev.Output.Redis.settingsEqual(nin.Output.Redis) {
+			inOutput.Redis = prev.Output.Redis
+		}
+		merged.Inputs = append(merged.Inputs, &in)
+	}
+
+	log := *c.Log
+	log.LevelStr = nc.Log.LevelStr
+	log.level = nc.Log.level
+	merged.Log = &log
+
+	return &merged
+}
+
+// Reload replaces the running configuration
+func Reload(c *Config) {
+	cfgPtr.Store(c)
+	generation.Add(1)
+}
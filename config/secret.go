@@ -0,0 +1,143 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	secretFilePrefix = "file:"
+	secretEnvPrefix  = "env:"
+	secretRedacted   = "******"
+)
+
+var (
+	ErrSecretNotFound = errors.New("secret not found")
+
+	secretFiles = &secretFileCache{files: make(map[string]*secretFile)}
+)
+
+// Secret is a credential, it is a plaintext, or a reference :
+//   - file:/path/to/file, the content of file, and it's reloaded if the file is modified
+//   - env:NAME, the environment variable
+//
+// plaintext is redacted in logs and APIs
+type Secret string
+
+func (s Secret) IsRef() bool {
+	return strings.HasPrefix(string(s), secretFilePrefix) || strings.HasPrefix(string(s), secretEnvPrefix)
+}
+
+// Value resolves the secret
+func (s Secret) Value() (string, error) {
+	str := string(s)
+	if path, ok := strings.CutPrefix(str, secretFilePrefix); ok {
+		return secretFiles.get(path)
+	}
+	if name, ok := strings.CutPrefix(str, secretEnvPrefix); ok {
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("%w : env(%s)", ErrSecretNotFound, name)
+		}
+		return val, nil
+	}
+	return str, nil
+}
+
+// String returns the reference, or the redacted plaintext
+func (s Secret) String() string {
+	if s == "" || s.IsRef() {
+		return string(s)
+	}
+	return secretRedacted
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", s.String())), nil
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// for flag
+func (s *Secret) Set(val string) error {
+	*s = Secret(val)
+	return nil
+}
+
+func (s Secret) fix(name string) error {
+	if _, err := s.Value(); err != nil {
+		return newConfigError("%s : %v", name, err)
+	}
+	return nil
+}
+
+type SliceSecret []Secret
+
+// for flag
+func (ss *SliceSecret) String() string {
+	return "slicesecret" // tag
+}
+
+// for flag
+func (ss *SliceSecret) Set(val string) error {
+	*ss = nil
+	for _, v := range strings.Split(val, ",") {
+		*ss = append(*ss, Secret(v))
+	}
+	return nil
+}
+
+func (ss SliceSecret) fix(name string) error {
+	for _, s := range ss {
+		if err := s.fix(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type secretFile struct {
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+type secretFileCache struct {
+	mux   sync.Mutex
+	files map[string]*secretFile
+}
+
+// get rereads the file if its modification time or size is changed,
+// so rotated credentials are picked up by new connections
+func (sc *secretFileCache) get(path string) (string, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("%w : file(%s), error(%v)", ErrSecretNotFound, path, err)
+	}
+
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+
+	sf, ok := sc.files[path]
+	if ok && sf.modTime.Equal(st.ModTime()) && sf.size == st.Size() {
+		return sf.value, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%w : file(%s), error(%v)", ErrSecretNotFound, path, err)
+	}
+	sf = &secretFile{
+		value:   strings.TrimRight(string(data), "\r\n"),
+		modTime: st.ModTime(),
+		size:    st.Size(),
+	}
+	sc.files[path] = sf
+	return sf.value, nil
+}
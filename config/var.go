@@ -32,6 +32,7 @@ const (
 	TypeDump    = "dump"
 	TypeSync    = "sync"
 	TypeRump    = "rump"
+	TypeFile    = "file"
 
 	CheckpointKey        = "redis-gunyu-checkpoint"
 	CheckpointKeyHashKey = "redis-gunyu-checkpoint-hash"
@@ -239,10 +240,10 @@ type EtcdConfig struct {
 	DialKeepAliveTimeout time.Duration `json:"dial-keep-alive-timeout"`
 
 	// Username is a user name for authentication.
-	Username string `json:"username"`
+	Username Secret `json:"username"`
 
 	// Password is a password for authentication.
-	Password string `json:"password"`
+	Password Secret `json:"password"`
 
 	// RejectOldCluster when set will refuse to create a client against an outdated cluster.
 	RejectOldCluster bool `json:"reject-old-cluster"`
@@ -260,6 +261,12 @@ func (ec *EtcdConfig) fix() error {
 	if ec.DialTimeout == 0 {
 		ec.DialTimeout = 10 * time.Second
 	}
+	if err := ec.Username.fix("etcd username"); err != nil {
+		return err
+	}
+	if err := ec.Password.fix("etcd password"); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -48,12 +48,18 @@ func runCmd() error {
 
 func handleSignal(c cmd.Cmd) {
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGPIPE, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGABRT)
+	signal.Notify(signals, syscall.SIGPIPE, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGABRT, syscall.SIGHUP)
 	for {
 		sig := <-signals
 		log.Infof("received signal: %s", sig)
 		switch sig {
 		case syscall.SIGPIPE:
+		case syscall.SIGHUP:
+			if r, ok := c.(cmd.Reloader); ok {
+				if err := r.Reload(); err != nil {
+					log.Errorf("cmd(%s) reload error : %v", c.Name(), err)
+				}
+			}
 		default:
 			ctx, cancel := context.WithTimeout(context.Background(), config.Get().Server.GracefullStopTimeout)
 			defer cancel()
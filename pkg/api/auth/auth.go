@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	unet "github.com/mgtv-tech/redis-GunYu/pkg/io/net"
+)
+
+const (
+	authorizationKey = "authorization"
+	bearerPrefix     = "Bearer "
+)
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+)
+
+// TokenEqual compares tokens in constant time
+func TokenEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// BearerToken extracts token from "Bearer xxx"
+func BearerToken(header string) string {
+	if len(header) > len(bearerPrefix) && strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return header[len(bearerPrefix):]
+	}
+	return ""
+}
+
+func BearerHeader(token string) string {
+	return bearerPrefix + token
+}
+
+// UnwrapConn returns the underlying connection of multiplexed connection
+func UnwrapConn(conn net.Conn) net.Conn {
+	if mc, ok := conn.(*cmux.MuxConn); ok {
+		return mc.Conn
+	}
+	return conn
+}
+
+// serverCreds
+// tls handshake is done by listener before cmux, so just exposes the tls state to grpc handlers
+type serverCreds struct{}
+
+func NewServerCredentials() credentials.TransportCredentials {
+	return &serverCreds{}
+}
+
+func (sc *serverCreds) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("server credentials only")
+}
+
+func (sc *serverCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	st, ok := unet.TlsState(UnwrapConn(conn))
+	if !ok {
+		return conn, nil, nil
+	}
+	return conn, credentials.TLSInfo{
+		State:          *st,
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}, nil
+}
+
+func (sc *serverCreds) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (sc *serverCreds) Clone() credentials.TransportCredentials {
+	return &serverCreds{}
+}
+
+func (sc *serverCreds) OverrideServerName(string) error {
+	return nil
+}
+
+// PeerVerifier authorizes grpc calls from peers
+type PeerVerifier struct {
+	RequireCert bool                   // requires a verified client certificate
+	Token       func() (string, error) // requires token if it is not nil, it's called for each call to pick up rotated tokens
+}
+
+func (pv PeerVerifier) Verify(ctx context.Context) error {
+	if pv.RequireCert {
+		p, ok := peer.FromContext(ctx)
+		if !ok {
+			return status.Error(codes.Unauthenticated, "no peer")
+		}
+		info, ok := p.AuthInfo.(credentials.TLSInfo)
+		if !ok || !unet.VerifiedTlsState(&info.State) {
+			return status.Error(codes.Unauthenticated, "client certificate is required")
+		}
+	}
+	if pv.Token != nil {
+		token, err := pv.Token()
+		if err != nil {
+			return status.Error(codes.Internal, "token is unavailable")
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		vals := md.Get(authorizationKey)
+		if len(vals) == 0 || !TokenEqual(BearerToken(vals[0]), token) {
+			return status.Error(codes.Unauthenticated, "invalid token")
+		}
+	}
+	return nil
+}
+
+func (pv PeerVerifier) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := pv.Verify(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (pv PeerVerifier) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := pv.Verify(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// tokenCreds : per-RPC credentials of peers
+type tokenCreds struct {
+	token  func() (string, error)
+	secure bool
+}
+
+func NewTokenCredentials(token func() (string, error), secure bool) credentials.PerRPCCredentials {
+	return &tokenCreds{token: token, secure: secure}
+}
+
+func (tc *tokenCreds) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := tc.token()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{authorizationKey: BearerHeader(token)}, nil
+}
+
+func (tc *tokenCreds) RequireTransportSecurity() bool {
+	return tc.secure
+}
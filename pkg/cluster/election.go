@@ -25,6 +25,18 @@ const (
 	RoleLeader    ClusterRole = iota
 )
 
+func (cr ClusterRole) String() string {
+	switch cr {
+	case RoleCandidate:
+		return "candidate"
+	case RoleFollower:
+		return "follower"
+	case RoleLeader:
+		return "leader"
+	}
+	return "unknown"
+}
+
 type RoleInfo struct {
 	Address string
 	Role    ClusterRole
@@ -36,17 +48,7 @@ type Cluster struct {
 }
 
 func NewCluster(ctx context.Context, cfg config.EtcdConfig) (*Cluster, error) {
-	cli, err := clientv3.New(clientv3.Config{
-		Context:              ctx,
-		Endpoints:            cfg.Endpoints,
-		AutoSyncInterval:     cfg.AutoSyncInterval,
-		DialTimeout:          cfg.DialTimeout,
-		DialKeepAliveTime:    cfg.DialKeepAliveTime,
-		DialKeepAliveTimeout: cfg.DialKeepAliveTimeout,
-		Username:             cfg.Username,
-		Password:             cfg.Password,
-		RejectOldCluster:     cfg.RejectOldCluster,
-	})
+	cli, err := NewEtcdClient(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -61,6 +63,29 @@ func NewCluster(ctx context.Context, cfg config.EtcdConfig) (*Cluster, error) {
 	}, nil
 }
 
+// NewEtcdClient returns a client of etcd, it's shared by cluster and checkpoint store
+func NewEtcdClient(ctx context.Context, cfg config.EtcdConfig) (*clientv3.Client, error) {
+	username, err := cfg.Username.Value()
+	if err != nil {
+		return nil, err
+	}
+	password, err := cfg.Password.Value()
+	if err != nil {
+		return nil, err
+	}
+	return clientv3.New(clientv3.Config{
+		Context:              ctx,
+		Endpoints:            cfg.Endpoints,
+		AutoSyncInterval:     cfg.AutoSyncInterval,
+		DialTimeout:          cfg.DialTimeout,
+		DialKeepAliveTime:    cfg.DialKeepAliveTime,
+		DialKeepAliveTimeout: cfg.DialKeepAliveTimeout,
+		Username:             username,
+		Password:             password,
+		RejectOldCluster:     cfg.RejectOldCluster,
+	})
+}
+
 func (c *Cluster) Close() error {
 	if c.sess != nil {
 		err := c.sess.Close()
@@ -3,9 +3,12 @@ package filter
 import (
 	"strings"
 
-	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
 )
 
+const clusterSlots = 16384
+
 var (
 	NoRouteCmds = []string{
 		// cluster
@@ -28,6 +31,10 @@ type RedisCmdFilter struct {
 	cmdBlackTrie       *Trie
 	prefixKeyWhiteTrie *Trie
 	prefixKeyBlackTrie *Trie
+	dbBlackList        []int
+	slotWhiteList      []bool // indexed by slot
+	hashTagWhiteList   map[string]struct{}
+	slotObserver       func(slot uint16, filtered bool)
 }
 
 func (f *RedisCmdFilter) InsertCmdWhiteList(cmds []string, caseInsensitivity bool) {
@@ -88,6 +95,46 @@ func (f *RedisCmdFilter) InsertPrefixKeyBlackList(keys []string) {
 	}
 }
 
+func (f *RedisCmdFilter) InsertDbBlackList(dbs []int) {
+	f.dbBlackList = append(f.dbBlackList, dbs...)
+}
+
+// InsertSlotWhiteList keeps keys whose slots are in [left, right]
+func (f *RedisCmdFilter) InsertSlotWhiteList(left, right int) {
+	if f.slotWhiteList == nil {
+		f.slotWhiteList = make([]bool, clusterSlots)
+	}
+	for i := left; i <= right && i < clusterSlots; i++ {
+		f.slotWhiteList[i] = true
+	}
+}
+
+// InsertHashTagWhiteList keeps keys with the hash tags
+func (f *RedisCmdFilter) InsertHashTagWhiteList(tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	if f.hashTagWhiteList == nil {
+		f.hashTagWhiteList = make(map[string]struct{})
+	}
+	for _, tag := range tags {
+		f.hashTagWhiteList[tag] = struct{}{}
+	}
+}
+
+// SetSlotObserver observes results of the slot and hash tag filter
+func (f *RedisCmdFilter) SetSlotObserver(observer func(slot uint16, filtered bool)) {
+	f.slotObserver = observer
+}
+
+func (f *RedisCmdFilter) hasKeyFilter() bool {
+	return f.prefixKeyBlackTrie != nil || f.prefixKeyWhiteTrie != nil || f.hasSlotFilter()
+}
+
+func (f *RedisCmdFilter) hasSlotFilter() bool {
+	return f.slotWhiteList != nil || f.hashTagWhiteList != nil
+}
+
 func (f *RedisCmdFilter) FilterCmd(cmd string) bool {
 	if f.cmdBlackTrie != nil && f.cmdBlackTrie.Search(cmd) {
 		return true
@@ -105,43 +152,68 @@ func (f *RedisCmdFilter) FilterKey(key string) bool {
 	if f.prefixKeyWhiteTrie != nil && !f.prefixKeyWhiteTrie.IsPrefixMatch(key) {
 		return true
 	}
-	return false
+	return f.filterSlot(key)
+}
+
+// filterSlot keeps the key if its slot is in slot ranges, or its hash tag is in the list
+func (f *RedisCmdFilter) filterSlot(key string) bool {
+	if !f.hasSlotFilter() {
+		return false
+	}
+	slot := redis.KeyToSlot(key)
+	filtered := true
+	if f.slotWhiteList != nil && f.slotWhiteList[slot] {
+		filtered = false
+	} else if f.hashTagWhiteList != nil {
+		if tag := redis.KeyHashTag(key); tag != "" {
+			_, ok := f.hashTagWhiteList[tag]
+			filtered = !ok
+		}
+	}
+	if f.slotObserver != nil {
+		f.slotObserver(slot, filtered)
+	}
+	return filtered
 }
 
 // filter out
-func FilterDB(db int) bool {
+func (f *RedisCmdFilter) FilterDB(db int) bool {
 	if db == -1 {
 		return false
 	}
-	if len(config.Get().Filter.DbBlacklist) != 0 {
-		for _, e := range config.Get().Filter.DbBlacklist {
-			if e == db {
-				return true
-			}
+	for _, e := range f.dbBlackList {
+		if e == db {
+			return true
 		}
 	}
 	return false
 }
 
 func (f *RedisCmdFilter) FilterCmdKey(cmd string, args [][]byte) ([][]byte, bool) {
-	if f.prefixKeyBlackTrie == nil && f.prefixKeyWhiteTrie == nil {
+	if !f.hasKeyFilter() {
 		return args, false
 	}
-	cmdPos, ok := commandKeyPositions[cmd]
+	cmdPos, ok := common.CommandKeyPosition(cmd)
 	if !ok || len(args) == 0 {
 		return args, false
 	}
-	lastkey := cmdPos.last - 1
-	keystep := cmdPos.step
+	lastkey := cmdPos.Last - 1
+	keystep := cmdPos.Step
 
-	if lastkey < 0 {
-		lastkey = lastkey + len(args)
+	if cmdPos.Last < 0 {
+		lastkey = len(args) + cmdPos.Last
+	}
+	if lastkey >= len(args) { // e.g. subcommands without keys
+		lastkey = len(args) - 1
+	}
+	if first := cmdPos.First - 1; lastkey > first { // the index of the last key, e.g. keys of mset are followed by values
+		lastkey -= (lastkey - first) % keystep
 	}
 
 	array := make([]int, len(args))
 	number := 0
 	foutKey := false
-	for firstkey := cmdPos.first - 1; firstkey <= lastkey; firstkey += keystep {
+	for firstkey := cmdPos.First - 1; firstkey <= lastkey; firstkey += keystep {
 		key := string(args[firstkey])
 		if !f.FilterKey(key) {
 			array[number] = firstkey
@@ -158,16 +230,16 @@ func (f *RedisCmdFilter) FilterCmdKey(cmd string, args [][]byte) ([][]byte, bool
 	}
 
 	pass := true
-	newArgs := make([][]byte, number*cmdPos.step+len(args)-lastkey-cmdPos.step)
+	newArgs := make([][]byte, number*cmdPos.Step+len(args)-lastkey-cmdPos.Step)
 	for i := 0; i < number; i++ {
-		for j := 0; j < cmdPos.step; j++ {
-			newArgs[i*cmdPos.step+j] = args[array[i]+j]
+		for j := 0; j < cmdPos.Step; j++ {
+			newArgs[i*cmdPos.Step+j] = args[array[i]+j]
 		}
 	}
 
 	j := 0
-	for i := lastkey + cmdPos.step; i < len(args); i++ {
-		newArgs[number*cmdPos.step+j] = args[i]
+	for i := lastkey + cmdPos.Step; i < len(args); i++ {
+		newArgs[number*cmdPos.Step+j] = args[i]
 		j = j + 1
 	}
 
@@ -76,6 +76,36 @@ func TestFilter(t *testing.T) {
 			[]string{"re", "redis", "redis_1", "app", "a", "b", "ba", "baa"},
 			[]bool{true, true, true, false, false, true, false, false})
 	})
+	t.Run("filter db", func(t *testing.T) {
+		t.Parallel()
+		ft := &RedisCmdFilter{}
+		assert.False(t, ft.FilterDB(1))
+		ft.InsertDbBlackList([]int{1, 3})
+		assert.True(t, ft.FilterDB(1))
+		assert.False(t, ft.FilterDB(2))
+		assert.False(t, ft.FilterDB(-1))
+	})
+	t.Run("filter slot", func(t *testing.T) {
+		t.Parallel()
+		ft := &RedisCmdFilter{}
+		ft.InsertSlotWhiteList(12000, 12200) // foo : 12182, bar : 5061
+		ft.InsertHashTagWhiteList([]string{"tenant1"})
+		observed := map[uint16]bool{}
+		ft.SetSlotObserver(func(slot uint16, filtered bool) {
+			observed[slot] = filtered
+		})
+		filterKeyChecker(t, ft,
+			[]string{"foo", "bar", "{foo}.x", "{tenant1}.x", "tenant1", "{tenant2}.x"},
+			[]bool{false, true, false, false, true, true})
+		assert.False(t, observed[12182])
+		assert.True(t, observed[5061])
+
+		filterCmdKeyChecker(t, ft, []cmdKey{
+			{"set", [][]byte{[]byte("bar"), []byte("v")}, [][]byte{[]byte("bar"), []byte("v")}, true},
+			{"mset", [][]byte{[]byte("foo"), []byte("v1"), []byte("bar"), []byte("v2")},
+				[][]byte{[]byte("foo"), []byte("v1")}, false},
+		})
+	})
 	t.Run("filter cmd key", func(t *testing.T) {
 		t.Parallel()
 		ft := &RedisCmdFilter{}
@@ -93,6 +123,8 @@ func TestFilter(t *testing.T) {
 			{"set", [][]byte{[]byte("redis")}, [][]byte{[]byte("redis")}, true},
 			{"del", [][]byte{[]byte("info")}, [][]byte{[]byte("info")}, true},
 			{"del", [][]byte{[]byte("key1")}, [][]byte{[]byte("key1")}, false},
+			{"unlink", [][]byte{[]byte("key1"), []byte("info")}, [][]byte{[]byte("key1")}, false},
+			{"memory", [][]byte{[]byte("stats")}, [][]byte{[]byte("stats")}, false},
 		})
 
 		ft.InsertPrefixKeyBlackList([]string{"key1"})
@@ -117,3 +149,20 @@ func TestFilter(t *testing.T) {
 
 	})
 }
+
+func TestCommandKeyIndexes(t *testing.T) {
+	assert.Nil(t, CommandKeyIndexes("get", 1))
+	assert.Nil(t, CommandKeyIndexes("incr", 0))
+	assert.Equal(t, []int{0}, CommandKeyIndexes("incrby", 2))
+	assert.Equal(t, []int{0, 1}, CommandKeyIndexes("smove", 3))
+	assert.Equal(t, []int{0, 1}, CommandKeyIndexes("lmove", 4))
+	assert.Equal(t, []int{0, 2, 4}, CommandKeyIndexes("mset", 6))
+	assert.Equal(t, []int{0, 1, 2}, CommandKeyIndexes("del", 3))
+	assert.Equal(t, []int{0}, CommandKeyIndexes("hpexpireat", 5))
+	assert.Equal(t, []int{0}, CommandKeyIndexes("hsetex", 6))
+	assert.Equal(t, []int{0, 1, 2}, CommandKeyIndexes("unlink", 3))
+	assert.Equal(t, []int{0, 1}, CommandKeyIndexes("blpop", 3))
+	assert.Equal(t, []int{1, 2, 3}, CommandKeyIndexes("bitop", 4))
+	assert.Equal(t, []int{1}, CommandKeyIndexes("object", 2))
+	assert.Equal(t, []int{}, CommandKeyIndexes("memory", 1))
+}
@@ -0,0 +1,124 @@
+package net
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+type TlsOptions struct {
+	CaFile             string // PEM bundle, verifies the peer's certificate
+	CertFile           string // PEM certificate, presented to the peer
+	KeyFile            string
+	ServerName         string // client side, overrides the server name for SNI and verification
+	MinVersion         string // 1.0, 1.1, 1.2, 1.3
+	InsecureSkipVerify bool
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTlsVersion parses "1.2" or "tls1.2", an empty string returns 0(default of crypto/tls)
+func ParseTlsVersion(ver string) (uint16, error) {
+	if ver == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(ver), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("invalid tls version : %s", ver)
+	}
+	return v, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate in ca file : %s", caFile)
+	}
+	return pool, nil
+}
+
+func (opts *TlsOptions) base() (*tls.Config, error) {
+	minVer, err := ParseTlsVersion(opts.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion: minVer,
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load key pair : cert(%s), key(%s), error(%w)", opts.CertFile, opts.KeyFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// NewClientTlsConfig
+// server certificate is verified by CaFile, or system roots if CaFile is empty
+func NewClientTlsConfig(opts TlsOptions) (*tls.Config, error) {
+	cfg, err := opts.base()
+	if err != nil {
+		return nil, err
+	}
+	if opts.CaFile != "" {
+		cfg.RootCAs, err = loadCertPool(opts.CaFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cfg.ServerName = opts.ServerName
+	cfg.InsecureSkipVerify = opts.InsecureSkipVerify
+	return cfg, nil
+}
+
+// NewServerTlsConfig
+// if CaFile is not empty, client certificates are verified if given,
+// callers decide whether an unauthenticated client is acceptable by VerifiedTlsState
+func NewServerTlsConfig(opts TlsOptions) (*tls.Config, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("server tls requires certificate and key")
+	}
+	cfg, err := opts.base()
+	if err != nil {
+		return nil, err
+	}
+	if opts.CaFile != "" {
+		cfg.ClientCAs, err = loadCertPool(opts.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	cfg.NextProtos = []string{"h2", "http/1.1"}
+	return cfg, nil
+}
+
+// TlsState returns the connection state if conn is a tls connection
+func TlsState(conn net.Conn) (*tls.ConnectionState, bool) {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, false
+	}
+	st := tc.ConnectionState()
+	return &st, true
+}
+
+// VerifiedTlsState : client certificate is verified
+func VerifiedTlsState(st *tls.ConnectionState) bool {
+	return st != nil && st.HandshakeComplete && len(st.VerifiedChains) > 0
+}
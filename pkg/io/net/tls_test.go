@@ -0,0 +1,30 @@
+package net
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTlsVersion(t *testing.T) {
+	v, err := ParseTlsVersion("")
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(0), v)
+
+	v, err = ParseTlsVersion("1.2")
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), v)
+
+	v, err = ParseTlsVersion("TLS1.3")
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+
+	_, err = ParseTlsVersion("1.4")
+	assert.NotNil(t, err)
+}
+
+func TestServerTlsConfigRequiresKeyPair(t *testing.T) {
+	_, err := NewServerTlsConfig(TlsOptions{CertFile: "cert.pem"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,347 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+)
+
+/*
+journal :
+	events are appended to <dirPath>/events.log in JSON lines, the file is renamed to events.log.1 if it exceeds maxSize,
+	so the latest events are kept after restarting.
+	sequences of events increase monotonically in a process and across restarts, clients resume queries and streams from a sequence.
+	an event without input is about the process, e.g. restarting all syncers, it belongs to all inputs.
+*/
+
+const (
+	fileName = "events.log"
+
+	ActorApi      = "api"      // HTTP API, the client address is appended, e.g. api(10.0.0.1)
+	ActorInput    = "input"    // sync state machine of the input
+	ActorSyncer   = "syncer"   // syncer of the input
+	ActorElection = "election" // leadership of the input
+	ActorTypology = "typology" // typology of redis is changed
+	ActorGc       = "gc"       // stale checkpoints are deleted
+
+	TypeSyncState   = "sync_state"   // full syncing, incr syncing, etc.
+	TypeSyncerState = "syncer_state" // run, pause, stop
+	TypeRole        = "role"         // leader, follower, candidate
+	TypeRestart     = "restart"
+	TypeStop        = "stop"
+	TypeHandover    = "handover"
+	TypeFullSync    = "fullsync"
+	TypePause       = "pause"
+	TypeResume      = "resume"
+	TypeCheckpoint  = "checkpoint" // checkpoint is deleted
+)
+
+type Event struct {
+	Seq    int64
+	Time   time.Time
+	Input  string // empty if the event is about the process
+	Actor  string
+	Type   string
+	From   string // old state
+	To     string // new state
+	Reason string
+}
+
+func (ev *Event) match(inputs []string) bool {
+	return len(inputs) == 0 || ev.Input == "" || slices.Contains(inputs, ev.Input)
+}
+
+// Query selects events of inputs whose sequences are greater than Since, the latest Limit events are returned
+type Query struct {
+	Inputs []string // all inputs if it's empty
+	Since  int64
+	Limit  int // unlimited if it's zero
+}
+
+type Journal struct {
+	mux     sync.Mutex
+	dir     string
+	maxSize int64
+	file    *os.File
+	size    int64
+	seq     int64
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+// Open opens the journal in dir, the sequence continues from the latest event
+func Open(dir string, maxSize int64) (*Journal, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	jn := &Journal{
+		dir:     dir,
+		maxSize: maxSize,
+		subs:    make(map[*Subscription]struct{}),
+	}
+	for _, path := range []string{jn.path(), jn.rotatedPath()} {
+		events, err := readEvents(path, Query{})
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > 0 {
+			jn.seq = events[len(events)-1].Seq
+			break
+		}
+	}
+	if err := jn.openFile(); err != nil {
+		return nil, err
+	}
+	return jn, nil
+}
+
+func (jn *Journal) path() string {
+	return filepath.Join(jn.dir, fileName)
+}
+
+func (jn *Journal) rotatedPath() string {
+	return jn.path() + ".1"
+}
+
+func (jn *Journal) openFile() error {
+	file, err := os.OpenFile(jn.path(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	jn.file = file
+	jn.size = st.Size()
+	// the last event may be truncated if the process crashed, the next event starts from a new line
+	if jn.size > 0 {
+		last := make([]byte, 1)
+		if rf, err := os.Open(jn.path()); err == nil {
+			_, err = rf.ReadAt(last, jn.size-1)
+			rf.Close()
+			if err == nil && last[0] != '\n' {
+				n, _ := jn.file.Write([]byte{'\n'})
+				jn.size += int64(n)
+			}
+		}
+	}
+	return nil
+}
+
+func (jn *Journal) rotate() error {
+	if err := jn.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(jn.path(), jn.rotatedPath()); err != nil {
+		return err
+	}
+	return jn.openFile()
+}
+
+// Record appends the event and notifies subscribers, the sequence and time are assigned
+func (jn *Journal) Record(ev Event) (Event, error) {
+	jn.mux.Lock()
+	defer jn.mux.Unlock()
+	if jn.closed {
+		return ev, errors.New("journal is closed")
+	}
+
+	jn.seq++
+	ev.Seq = jn.seq
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return ev, err
+	}
+	line = append(line, '\n')
+	if jn.size > 0 && jn.size+int64(len(line)) > jn.maxSize {
+		if err = jn.rotate(); err != nil {
+			return ev, err
+		}
+	}
+	n, err := jn.file.Write(line)
+	jn.size += int64(n)
+	if err != nil {
+		return ev, err
+	}
+
+	for sub := range jn.subs {
+		if !ev.match(sub.inputs) {
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+			// the subscriber is too slow, it should resume from the latest received sequence
+			delete(jn.subs, sub)
+			close(sub.events)
+		}
+	}
+	return ev, nil
+}
+
+// Query reads events from the rotated journal and the current journal
+func (jn *Journal) Query(q Query) ([]Event, error) {
+	jn.mux.Lock()
+	defer jn.mux.Unlock()
+	events, err := readEvents(jn.rotatedPath(), q)
+	if err != nil {
+		return nil, err
+	}
+	current, err := readEvents(jn.path(), q)
+	if err != nil {
+		return nil, err
+	}
+	events = append(events, current...)
+	if q.Limit > 0 && len(events) > q.Limit {
+		events = events[len(events)-q.Limit:]
+	}
+	return events, nil
+}
+
+// Subscribe receives new events of inputs, the channel of events is closed if the subscriber is too slow or the journal is closed
+func (jn *Journal) Subscribe(inputs []string, size int) *Subscription {
+	sub := &Subscription{inputs: inputs, events: make(chan Event, size), jn: jn}
+	jn.mux.Lock()
+	defer jn.mux.Unlock()
+	if jn.closed {
+		close(sub.events)
+		return sub
+	}
+	jn.subs[sub] = struct{}{}
+	return sub
+}
+
+func (jn *Journal) unsubscribe(sub *Subscription) {
+	jn.mux.Lock()
+	defer jn.mux.Unlock()
+	if _, ok := jn.subs[sub]; ok {
+		delete(jn.subs, sub)
+		close(sub.events)
+	}
+}
+
+func (jn *Journal) Close() error {
+	jn.mux.Lock()
+	defer jn.mux.Unlock()
+	if jn.closed {
+		return nil
+	}
+	jn.closed = true
+	for sub := range jn.subs {
+		close(sub.events)
+	}
+	jn.subs = nil
+	return jn.file.Close()
+}
+
+type Subscription struct {
+	inputs []string
+	events chan Event
+	jn     *Journal
+}
+
+func (sub *Subscription) Events() <-chan Event {
+	return sub.events
+}
+
+func (sub *Subscription) Close() {
+	sub.jn.unsubscribe(sub)
+}
+
+func readEvents(path string, q Query) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	events := []Event{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		ev := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			// the last line may be truncated if the process crashed
+			log.Warnf("journal : corrupted event, path(%s), error(%v)", path, err)
+			continue
+		}
+		if ev.Seq <= q.Since || !ev.match(q.Inputs) {
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
+
+var (
+	mux sync.RWMutex
+	std *Journal
+)
+
+// Start opens the journal of the process
+func Start(dir string, maxSize int64) error {
+	jn, err := Open(dir, maxSize)
+	if err != nil {
+		return err
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	if std != nil {
+		jn.Close()
+		return errors.New("journal is started")
+	}
+	std = jn
+	return nil
+}
+
+func Stop() error {
+	mux.Lock()
+	jn := std
+	std = nil
+	mux.Unlock()
+	if jn == nil {
+		return nil
+	}
+	return jn.Close()
+}
+
+func get() *Journal {
+	mux.RLock()
+	defer mux.RUnlock()
+	return std
+}
+
+// Record appends the event to the journal of the process, it's ignored if the journal isn't started
+func Record(ev Event) {
+	jn := get()
+	if jn == nil {
+		return
+	}
+	if _, err := jn.Record(ev); err != nil {
+		log.Errorf("journal : record event, event(%v), error(%v)", ev, err)
+	}
+}
+
+func Get() (*Journal, error) {
+	jn := get()
+	if jn == nil {
+		return nil, errors.New("journal isn't started")
+	}
+	return jn, nil
+}
@@ -0,0 +1,128 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	jn, err := Open(dir, 1024*1024)
+	assert.Nil(t, err)
+
+	ev, err := jn.Record(Event{Input: "127.0.0.1:6379", Actor: ActorInput, Type: TypeSyncState, From: "started", To: "full_syncing"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), ev.Seq)
+	assert.False(t, ev.Time.IsZero())
+	jn.Record(Event{Input: "127.0.0.1:6380", Actor: ActorApi + "(127.0.0.1)", Type: TypePause, From: "run", To: "pause"})
+	jn.Record(Event{Actor: ActorTypology, Type: TypeRestart, Reason: "output nodes were changed"})
+	jn.Record(Event{Input: "127.0.0.1:6379", Actor: ActorInput, Type: TypeSyncState, From: "full_syncing", To: "full_synced"})
+
+	events, err := jn.Query(Query{Inputs: []string{"127.0.0.1:6379"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1, 3, 4}, seqs(events)) // events of the process belong to all inputs
+
+	events, _ = jn.Query(Query{Since: 1, Limit: 2})
+	assert.Equal(t, []int64{3, 4}, seqs(events))
+
+	// reopen
+	assert.Nil(t, jn.Close())
+	jn, err = Open(dir, 1024*1024)
+	assert.Nil(t, err)
+	defer jn.Close()
+	ev, _ = jn.Record(Event{Input: "127.0.0.1:6379", Type: TypeResume})
+	assert.Equal(t, int64(5), ev.Seq)
+	events, _ = jn.Query(Query{})
+	assert.Equal(t, 5, len(events))
+	assert.Equal(t, "full_syncing", events[0].To)
+}
+
+func TestJournalRotate(t *testing.T) {
+	dir := t.TempDir()
+	jn, err := Open(dir, 1024)
+	assert.Nil(t, err)
+	defer jn.Close()
+
+	for i := 0; i < 30; i++ {
+		_, err = jn.Record(Event{Input: "127.0.0.1:6379", Type: TypeRestart, Reason: "the numbers of input nodes were changed"})
+		assert.Nil(t, err)
+	}
+	st, err := os.Stat(filepath.Join(dir, fileName))
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, st.Size(), int64(1024))
+	_, err = os.Stat(filepath.Join(dir, fileName+".1"))
+	assert.Nil(t, err)
+
+	// events of the current and rotated journal are continuous
+	events, _ := jn.Query(Query{})
+	assert.Greater(t, len(events), 1)
+	assert.Equal(t, int64(30), events[len(events)-1].Seq)
+	for i := 1; i < len(events); i++ {
+		assert.Equal(t, events[i-1].Seq+1, events[i].Seq)
+	}
+}
+
+func TestJournalTruncated(t *testing.T) {
+	dir := t.TempDir()
+	jn, err := Open(dir, 1024*1024)
+	assert.Nil(t, err)
+	jn.Record(Event{Input: "127.0.0.1:6379", Type: TypePause})
+	jn.Close()
+
+	// crashed while writing an event
+	file, err := os.OpenFile(filepath.Join(dir, fileName), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	file.Write([]byte(`{"Seq":2,"Inp`))
+	file.Close()
+
+	jn, err = Open(dir, 1024*1024)
+	assert.Nil(t, err)
+	defer jn.Close()
+	ev, _ := jn.Record(Event{Input: "127.0.0.1:6379", Type: TypeResume})
+	assert.Equal(t, int64(2), ev.Seq)
+	events, _ := jn.Query(Query{})
+	assert.Equal(t, []int64{1, 2}, seqs(events))
+}
+
+func TestSubscribe(t *testing.T) {
+	jn, err := Open(t.TempDir(), 1024*1024)
+	assert.Nil(t, err)
+
+	sub := jn.Subscribe([]string{"127.0.0.1:6379"}, 2)
+	jn.Record(Event{Input: "127.0.0.1:6380", Type: TypePause})
+	jn.Record(Event{Input: "127.0.0.1:6379", Type: TypePause})
+	ev := <-sub.Events()
+	assert.Equal(t, int64(2), ev.Seq)
+	sub.Close()
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+
+	// a slow subscriber is dropped
+	slow := jn.Subscribe(nil, 1)
+	jn.Record(Event{Input: "127.0.0.1:6379", Type: TypeResume})
+	jn.Record(Event{Input: "127.0.0.1:6379", Type: TypeResume})
+	ev, ok = <-slow.Events()
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), ev.Seq)
+	_, ok = <-slow.Events()
+	assert.False(t, ok)
+	slow.Close()
+
+	// subscribers are closed with the journal
+	sub = jn.Subscribe(nil, 1)
+	assert.Nil(t, jn.Close())
+	_, ok = <-sub.Events()
+	assert.False(t, ok)
+	sub.Close()
+}
+
+func seqs(events []Event) []int64 {
+	ret := []int64{}
+	for _, ev := range events {
+		ret = append(ret, ev.Seq)
+	}
+	return ret
+}
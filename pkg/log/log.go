@@ -15,6 +15,7 @@ import (
 var (
 	glogger *zap.Logger
 	sugar   *zap.SugaredLogger
+	level   = zap.NewAtomicLevel()
 )
 
 func init() {
@@ -45,13 +46,13 @@ func InitLog(cfg config.LogConfig) error {
 		return ErrNoHandler
 	}
 
-	level := zapcore.InfoLevel
 	stLevel := zapcore.PanicLevel
 	if len(cfg.LevelStr) > 0 {
-		level, err = zapcore.ParseLevel(cfg.LevelStr)
+		lvl, err := zapcore.ParseLevel(cfg.LevelStr)
 		if err != nil {
 			return err
 		}
+		level.SetLevel(lvl)
 	}
 	if len(cfg.StacktraceLevelStr) > 0 {
 		stLevel, err = zapcore.ParseLevel(cfg.StacktraceLevelStr)
@@ -94,6 +95,11 @@ func InitLog(cfg config.LogConfig) error {
 	return nil
 }
 
+// SetLevel changes the level of loggers at runtime
+func SetLevel(lvl zapcore.Level) {
+	level.SetLevel(lvl)
+}
+
 func Sync() error {
 	return glogger.Sync()
 }
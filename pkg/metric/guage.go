@@ -16,6 +16,8 @@ type (
 		Inc(labels ...string)
 		// Add adds v to labels.
 		Add(v float64, labels ...string)
+		// Delete deletes the gauge of labels.
+		Delete(labels ...string) bool
 		Close() bool
 	}
 	Gauge interface {
@@ -94,6 +96,10 @@ func (gv *gaugeVec) Set(v float64, labels ...string) {
 	gv.gauge.WithLabelValues(labels...).Set(v)
 }
 
+func (gv *gaugeVec) Delete(labels ...string) bool {
+	return gv.gauge.DeleteLabelValues(labels...)
+}
+
 func (gv *gaugeVec) Close() bool {
 	return prom.Unregister(gv.gauge)
 }
@@ -1,7 +1,8 @@
 package rdb
 
 // var RdbVersion int64 = 10 // redis:7.0.0
-var RdbVersion int64 = 11 // redis:7.2.0
+// var RdbVersion int64 = 11 // redis:7.2.0
+var RdbVersion int64 = 12 // redis:7.4.0
 
 const (
 	RdbObjectString   = iota
@@ -41,6 +42,14 @@ const (
 	RdbTypeSetListpack      = 20
 	RdbTypeStreamListPacks3 = 21 // RDB_TYPE_STREAM_LISTPACKS_3
 
+	// hash with field ttl, >= redis 7.4
+	RdbTypeHashMetadataPreGa   = 22 // RDB_TYPE_HASH_METADATA_PRE_GA, redis 7.4 RC
+	RdbTypeHashListpackExPreGa = 23 // RDB_TYPE_HASH_LISTPACK_EX_PRE_GA, redis 7.4 RC
+	RdbTypeHashMetadata        = 24 // RDB_TYPE_HASH_METADATA
+	RdbTypeHashListpackEx      = 25 // RDB_TYPE_HASH_LISTPACK_EX
+
+	RdbFlagSlotInfo = 0xf4 // RDB_OPCODE_SLOT_INFO, redis 7.4
+
 	RdbTypeFunction2 = 0xf5
 	RdbTypeFunction  = 0xf6
 	RdbFlagModuleAux = 0xf7
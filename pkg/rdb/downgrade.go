@@ -0,0 +1,249 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/errors"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/types"
+	"github.com/mgtv-tech/redis-GunYu/pkg/util"
+)
+
+/*
+downgrade :
+	values of new encodings are re-serialized into encodings supported by the target redis,
+	so they are still restored by RESTORE rather than replayed element by element.
+	listpack and quicklist2 are converted to the plain encodings of hash, zset, list and set,
+	streams are converted to the stream type of the target by dropping new fields.
+	hashes with field ttl are not converted, ttl of fields can't be kept by older redis.
+*/
+
+// rdbVersionOfRedis returns the rdb version of redis, 0 if the version is unknown
+func rdbVersionOfRedis(version string) int64 {
+	switch {
+	case version == "":
+		return 0
+	case util.VersionGE(version, "7.4", util.VersionMinor):
+		return 12
+	case util.VersionGE(version, "7.2", util.VersionMinor):
+		return 11
+	case util.VersionGE(version, "7", util.VersionMajor):
+		return 10
+	case util.VersionGE(version, "5", util.VersionMajor):
+		return 9
+	case util.VersionGE(version, "4", util.VersionMajor):
+		return 8
+	case util.VersionGE(version, "3.2", util.VersionMinor):
+		return 7
+	}
+	return 6
+}
+
+// rdbVersionOfType returns the minimal rdb version which supports the type
+func rdbVersionOfType(rtype byte) int64 {
+	switch rtype {
+	case RdbTypeHashMetadataPreGa, RdbTypeHashListpackExPreGa, RdbTypeHashMetadata, RdbTypeHashListpackEx:
+		return 12
+	case RdbTypeSetListpack, RdbTypeStreamListPacks3:
+		return 11
+	case RdbTypeHashListpack, RdbTypeZSetListpack, RdbTypeQuicklist2, RDBTypeStreamListPacks2:
+		return 10
+	case RDBTypeStreamListPacks:
+		return 9
+	case RdbTypeZSet2, RdbTypeModule2:
+		return 8
+	case RdbTypeQuicklist:
+		return 7
+	}
+	return 6
+}
+
+// downgradeType returns the type which the value is converted to, false if it can't be restored by the rdb version
+func downgradeType(rtype byte, rdbVersion int64) (byte, bool) {
+	if rdbVersion == 0 || rdbVersionOfType(rtype) <= rdbVersion {
+		return rtype, true
+	}
+	var to byte
+	switch rtype {
+	case RdbTypeHashListpack:
+		to = RdbTypeHash
+	case RdbTypeZSetListpack:
+		to = RdbTypeZSet2
+	case RdbTypeQuicklist2:
+		to = RdbTypeList
+	case RdbTypeSetListpack:
+		to = RdbTypeSet
+	case RdbTypeStreamListPacks3:
+		to = RDBTypeStreamListPacks2
+		if rdbVersionOfType(to) > rdbVersion {
+			to = RDBTypeStreamListPacks
+		}
+	case RDBTypeStreamListPacks2:
+		to = RDBTypeStreamListPacks
+	default:
+		return rtype, false
+	}
+	return to, rdbVersionOfType(to) <= rdbVersion
+}
+
+// downgradeValue re-serializes the value into the encoding of type "to"
+func downgradeValue(rtype byte, to byte, data []byte) (val []byte, err error) {
+	defer util.Xrecover(&err)
+	if rtype == to {
+		return data, nil
+	}
+
+	r := NewRdbReader(bytes.NewReader(data))
+	w := &rdbValueWriter{}
+	switch rtype {
+	case RdbTypeHashListpack, RdbTypeZSetListpack, RdbTypeSetListpack:
+		entries := listpackEntries(r.ReadStringP())
+		switch rtype {
+		case RdbTypeHashListpack:
+			w.writeLength(uint64(len(entries) / 2))
+			for _, e := range entries {
+				w.writeString(e)
+			}
+		case RdbTypeZSetListpack:
+			w.writeLength(uint64(len(entries) / 2))
+			for i := 0; i+1 < len(entries); i += 2 {
+				score, err := strconv.ParseFloat(string(entries[i+1]), 64)
+				panicIfErr(err)
+				w.writeString(entries[i])
+				w.writeUint64(math.Float64bits(score))
+			}
+		case RdbTypeSetListpack:
+			w.writeLength(uint64(len(entries)))
+			for _, e := range entries {
+				w.writeString(e)
+			}
+		}
+	case RdbTypeQuicklist2:
+		entries := [][]byte{}
+		n := r.ReadLengthP()
+		for i := uint32(0); i < n; i++ {
+			container := r.ReadLengthP()
+			if container == quicklistNodeContainerPlain {
+				entries = append(entries, r.ReadStringP())
+			} else if container == quicklistNodeContainerPacked {
+				entries = append(entries, listpackEntries(r.ReadStringP())...)
+			} else {
+				panicIfErr(errors.Errorf("unknown quicklist container : %v", container))
+			}
+		}
+		w.writeLength(uint64(len(entries)))
+		for _, e := range entries {
+			w.writeString(e)
+		}
+	case RDBTypeStreamListPacks2, RdbTypeStreamListPacks3:
+		downgradeStream(r, w, rtype, to)
+	default:
+		return nil, errors.Errorf("can't downgrade type %d to %d", rtype, to)
+	}
+	return w.Bytes(), nil
+}
+
+// redis, rdb.c:rdbSaveObject, the stream is copied without fields which are not supported by "to"
+func downgradeStream(r *RdbReader, w *rdbValueWriter, rtype byte, to byte) {
+	copyLength := func() { w.writeLength(r.ReadLength64P()) }
+
+	listpacks := r.ReadLength64P()
+	w.writeLength(listpacks)
+	for i := uint64(0); i < listpacks; i++ {
+		w.writeString(r.ReadStringP()) // master id
+		w.writeString(r.ReadStringP()) // listpack
+	}
+	copyLength() // length
+	copyLength() // last id
+	copyLength()
+	if rtype >= RDBTypeStreamListPacks2 {
+		for i := 0; i < 5; i++ { // first id, max deleted entry id, entries added
+			if to >= RDBTypeStreamListPacks2 {
+				copyLength()
+			} else {
+				r.ReadLength64P()
+			}
+		}
+	}
+
+	groups := r.ReadLength64P()
+	w.writeLength(groups)
+	for i := uint64(0); i < groups; i++ {
+		w.writeString(r.ReadStringP()) // name
+		copyLength()                   // last id
+		copyLength()
+		if rtype >= RDBTypeStreamListPacks2 { // entries read
+			if to >= RDBTypeStreamListPacks2 {
+				copyLength()
+			} else {
+				r.ReadLength64P()
+			}
+		}
+		pending := r.ReadLength64P()
+		w.writeLength(pending)
+		for j := uint64(0); j < pending; j++ {
+			w.Write(r.Read16ByteP()) // stream id
+			w.Write(r.Read8ByteP())  // delivery time
+			copyLength()             // delivery count
+		}
+		consumers := r.ReadLength64P()
+		w.writeLength(consumers)
+		for j := uint64(0); j < consumers; j++ {
+			w.writeString(r.ReadStringP()) // name
+			w.Write(r.Read8ByteP())        // seen time
+			if rtype >= RdbTypeStreamListPacks3 {
+				activeTime := r.Read8ByteP()
+				if to >= RdbTypeStreamListPacks3 {
+					w.Write(activeTime)
+				}
+			}
+			pending := r.ReadLength64P()
+			w.writeLength(pending)
+			for k := uint64(0); k < pending; k++ {
+				w.Write(r.Read16ByteP())
+			}
+		}
+	}
+}
+
+func listpackEntries(data []byte) [][]byte {
+	lp := types.NewListpack(data)
+	entries := [][]byte{}
+	for lp.HasNext() {
+		entries = append(entries, lp.Next())
+	}
+	return entries
+}
+
+// rdbValueWriter writes values in rdb encoding, strings are not compressed
+type rdbValueWriter struct {
+	bytes.Buffer
+}
+
+// redis, rdb.c:rdbSaveLen
+func (w *rdbValueWriter) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.WriteByte(byte(n) | rdb6bitLen<<6)
+	case n < 1<<14:
+		w.WriteByte(byte(n>>8) | rdb14bitLen<<6)
+		w.WriteByte(byte(n))
+	case n <= math.MaxUint32:
+		w.WriteByte(rdb32bitLen)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	default:
+		w.WriteByte(rdb64bitLen)
+		binary.Write(w, binary.BigEndian, n)
+	}
+}
+
+func (w *rdbValueWriter) writeString(s []byte) {
+	w.writeLength(uint64(len(s)))
+	w.Write(s)
+}
+
+func (w *rdbValueWriter) writeUint64(n uint64) {
+	binary.Write(w, binary.LittleEndian, n)
+}
@@ -0,0 +1,105 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testListpack returns a listpack of short strings
+func testListpack(entries ...string) string {
+	lp := []byte{0, 0, 0, 0, 0, 0}
+	for _, e := range entries {
+		lp = append(lp, 0x80|byte(len(e)))
+		lp = append(lp, e...)
+		lp = append(lp, byte(len(e)+1))
+	}
+	lp = append(lp, 0xff)
+	binary.LittleEndian.PutUint32(lp, uint32(len(lp)))
+	binary.LittleEndian.PutUint16(lp[4:], uint16(len(entries)))
+	return string(lp)
+}
+
+func testLoadEntry(t *testing.T, data []byte, target string) *BinEntry {
+	l := NewLoader(bytes.NewReader(data), target)
+	assert.Nil(t, l.Header())
+	e, err := l.Next()
+	assert.Nil(t, err)
+	assert.NotNil(t, e)
+	return e
+}
+
+func testEntryCmds(e *BinEntry) []string {
+	cmds := []string{}
+	e.ObjectParser.ExecCmd(func(cmd string, args ...interface{}) error {
+		for _, arg := range args {
+			if b, ok := arg.([]byte); ok {
+				arg = string(b)
+			}
+			cmd += fmt.Sprintf(" %v", arg)
+		}
+		cmds = append(cmds, cmd)
+		return nil
+	})
+	return cmds
+}
+
+func testDowngrade(t *testing.T, rtype byte, value []byte, target string, expType byte) {
+	key := testRdbString("key")
+	orig := testLoadEntry(t, testRdb([]byte{rtype}, key, value), "7.4")
+
+	entry := testLoadEntry(t, testRdb([]byte{rtype}, key, value), target)
+	assert.True(t, entry.CanRestore())
+	dump := entry.DumpValue()
+	assert.Equal(t, expType, dump[0])
+	assert.Len(t, dump, entry.ObjectParser.ValueDumpSize())
+
+	// the converted value is loaded as the same value
+	conv := testLoadEntry(t, testRdb(dump[:1], key, dump[1:len(dump)-10]), "7.4")
+	assert.Equal(t, testEntryCmds(orig), testEntryCmds(conv))
+}
+
+func TestDowngrade(t *testing.T) {
+	str, l := testRdbString, testRdbLen
+	join := func(b ...[]byte) []byte { return bytes.Join(b, nil) }
+
+	testDowngrade(t, RdbTypeHashListpack, str(testListpack("f1", "v1", "f2", "v2")), "6.2", RdbTypeHash)
+	testDowngrade(t, RdbTypeZSetListpack, str(testListpack("m1", "1.5", "m2", "2")), "5.0", RdbTypeZSet2)
+	testDowngrade(t, RdbTypeSetListpack, str(testListpack("a", "b", "c")), "7.0", RdbTypeSet)
+	testDowngrade(t, RdbTypeQuicklist2, join(l(2),
+		l(quicklistNodeContainerPacked), str(testListpack("a", "b")),
+		l(quicklistNodeContainerPlain), str("c")), "6.2", RdbTypeList)
+
+	// not converted if the target supports it
+	testDowngrade(t, RdbTypeSetListpack, str(testListpack("a")), "7.2", RdbTypeSetListpack)
+
+	ms := testUint64LE(1700000000000)
+	stream := join(l(0), l(0), l(5), l(0), // listpacks, length, last id
+		l(1), l(0), l(0), l(0), l(0), // first id, max deleted entry id, entries added
+		l(1), str("g"), l(1), l(0), l(0), l(0), // group, last id, entries read, pel
+		l(1), str("c"), ms, ms, l(0)) // consumer, seen time, active time, pel
+	testDowngrade(t, RdbTypeStreamListPacks3, stream, "7.0", RDBTypeStreamListPacks2)
+	testDowngrade(t, RdbTypeStreamListPacks3, stream, "6.2", RDBTypeStreamListPacks)
+
+	// hashes with field ttl can't be restored by older redis
+	entry := testLoadEntry(t, testRdb([]byte{RdbTypeHashListpackExPreGa}, testRdbString("key"),
+		str(testListpack("f", "v", "0"))), "7.2")
+	assert.False(t, entry.CanRestore())
+
+	// values failed to be downgraded are replayed by commands
+	entry = testLoadEntry(t, testRdb([]byte{RdbTypeZSetListpack}, testRdbString("key"),
+		str(testListpack("m1", "score"))), "5.0")
+	assert.False(t, entry.CanRestore())
+}
+
+func TestRdbVersionOfRedis(t *testing.T) {
+	assert.Equal(t, int64(0), rdbVersionOfRedis(""))
+	assert.Equal(t, int64(12), rdbVersionOfRedis("8.0.1"))
+	assert.Equal(t, int64(11), rdbVersionOfRedis("7.2.4"))
+	assert.Equal(t, int64(10), rdbVersionOfRedis("7.0.15"))
+	assert.Equal(t, int64(9), rdbVersionOfRedis("6.2.6"))
+	assert.Equal(t, int64(8), rdbVersionOfRedis("4.0.14"))
+}
@@ -142,6 +142,11 @@ func (l *Loader) Next() (entry *BinEntry, err error) {
 			l.db = dbnum
 		case RdbFlagEOF:
 			return nil, nil // means EOF
+		case RdbFlagSlotInfo:
+			slotId := l.ReadLengthP()
+			slotSize := l.ReadLengthP()
+			expiresSlotSize := l.ReadLengthP()
+			l.logger.Debugf("RdbFlagSlotInfo : slot(%d), size(%d), expiressize(%d)", slotId, slotSize, expiresSlotSize)
 		case RdbFlagModuleAux:
 			_ = l.ReadLength64P() // uint64_t moduleid = rdbLoadLen(rdb,NULL);
 			rdbLoadCheckModuleValue(l)
@@ -167,26 +172,9 @@ func (l *Loader) Next() (entry *BinEntry, err error) {
 	}
 }
 
+// redis, rdb.c:rdbLoad, RDB_OPCODE_MODULE_AUX
 func rdbLoadCheckModuleValue(l *Loader) {
-	var whenOpCode uint32
-	for {
-		whenOpCode = l.ReadLengthP() // int when_opcode = rdbLoadLen(rdb,NULL);
-		_ = l.ReadLengthP()          // when
-		if whenOpCode == rdbModuleOpcodeEof {
-			break
-		}
-
-		switch whenOpCode {
-		case rdbModuleOpcodeSint:
-			fallthrough
-		case rdbModuleOpcodeUint:
-			_ = l.ReadLengthP()
-		case rdbModuleOpcodeString:
-			l.ReadStringP()
-		case rdbModuleOpcodeFloat:
-			l.ReadFloatP()
-		case rdbModuleOpcodeDouble:
-			l.ReadDoubleP()
-		}
-	}
+	_ = l.ReadLengthP() // int when_opcode = rdbLoadLen(rdb,NULL);
+	_ = l.ReadLengthP() // when
+	readModuleItems(l.RdbReader)
 }
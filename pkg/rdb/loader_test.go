@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"strconv"
 	"strings"
@@ -14,6 +16,7 @@ import (
 	"time"
 
 	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/util"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/suite"
 )
@@ -50,6 +53,10 @@ func TestFunctionSuite(t *testing.T) {
 	suite.Run(t, new(functionTestSuite))
 }
 
+func TestModuleSuite(t *testing.T) {
+	suite.Run(t, new(moduleTestSuite))
+}
+
 type baseSuite struct {
 	suite.Suite
 	rdbDumpData  string
@@ -93,6 +100,32 @@ func (bs *baseSuite) getBins(l *Loader, n int) map[string]*BinEntry {
 	return entries
 }
 
+// testRdb returns a rdb v12 with the entries in db 0
+func testRdb(entries ...[]byte) []byte {
+	b := []byte("REDIS0012")
+	b = append(b, RdbFlagSelectDB, 0)
+	b = append(b, bytes.Join(entries, nil)...)
+	b = append(b, RdbFlagEOF)
+	return append(b, make([]byte, 8)...) // no checksum
+}
+
+func testRdbLen(n uint64) []byte {
+	if n < 64 {
+		return []byte{byte(n)}
+	}
+	b := []byte{rdb64bitLen, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(b[1:], n)
+	return b
+}
+
+func testRdbString(s string) []byte {
+	return append(testRdbLen(uint64(len(s))), s...)
+}
+
+func testUint64LE(n uint64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, n)
+}
+
 func (bs *baseSuite) dir() string {
 	return bs.redisDirs[bs.redisVersion]
 }
@@ -498,6 +531,76 @@ func (ts *hashTestSuite) TestHashRdb8() {
 	ts.hash(expData, longStrs, RdbTypeHash)
 }
 
+// fields and values of the hash fixtures above are the same, a swapped field and value is not found by them
+func (ts *hashTestSuite) TestHashListpackFieldValue() {
+	data := testRdb([]byte{RdbTypeHashListpack}, testRdbString("key"), testRdbString(testListpack("f1", "v1", "f2", "v2")))
+	for _, target := range []string{"7.2", "6.0"} { // 6.0 replays commands of the downgraded hash
+		entry := testLoadEntry(ts.T(), data, target)
+		ts.Equal([]string{"HSET key f1 v1", "HSET key f2 v2"}, testEntryCmds(entry))
+	}
+}
+
+func (ts *hashTestSuite) TestHashRdb12FieldTtl() {
+	// redis7.4(rdb 12) : hashes with field ttl are RdbTypeHashMetadata or RdbTypeHashListpackEx
+	key := "test_hash_key"
+	now := uint64(time.Now().UnixMilli())
+	expireAt := now + 3600*1000
+	expiredAt := now - 1000
+
+	rdbLen, rdbStr, uint64LE := testRdbLen, testRdbString, testUint64LE
+	rdbData := func(rtype byte, value ...[]byte) []byte {
+		return testRdb([]byte{RdbFlagSlotInfo, 1, 1, 0}, []byte{rtype}, rdbStr(key), bytes.Join(value, nil))
+	}
+
+	// ttl of fields are relative to the min expiration
+	metadata := rdbData(RdbTypeHashMetadata, uint64LE(expiredAt), rdbLen(3),
+		rdbLen(0), rdbStr("f0"), rdbStr("v0"),
+		rdbLen(expireAt-expiredAt+1), rdbStr("f1"), rdbStr("v1"),
+		rdbLen(1), rdbStr("f2"), rdbStr("v2"))
+
+	lp := []byte{0, 0, 0, 0, 9, 0}
+	for _, s := range []string{"f0", "v0"} {
+		lp = append(lp, 0x80|byte(len(s)))
+		lp = append(lp, s...)
+		lp = append(lp, byte(len(s)+1))
+	}
+	lp = append(lp, 0, 1) // 7bit uint
+	for i, ms := range []uint64{expireAt, expiredAt} {
+		s := fmt.Sprintf("%d", i+1)
+		lp = append(lp, 0x82, 'f', s[0], 3, 0x82, 'v', s[0], 3)
+		lp = append(lp, 0xf4)
+		lp = append(lp, uint64LE(ms)...)
+		lp = append(lp, 9)
+	}
+	lp = append(lp, 0xff)
+	binary.LittleEndian.PutUint32(lp, uint32(len(lp)))
+	listpackEx := rdbData(RdbTypeHashListpackEx, uint64LE(expireAt), rdbStr(string(lp)))
+
+	for _, data := range [][]byte{metadata, listpackEx} {
+		for _, target := range []string{"7.4", "7.2"} {
+			l := NewLoader(bytes.NewReader(data), target)
+			ts.Nil(l.Header())
+			entries := ts.getBins(l, 1)
+			entry := entries[key]
+			ts.Equal(target == "7.4", entry.CanRestore())
+
+			cmds := []string{}
+			entry.ObjectParser.ExecCmd(func(cmd string, args ...interface{}) error {
+				cmds = append(cmds, fmt.Sprint(append([]interface{}{cmd}, args...)...))
+				return nil
+			})
+			exp := []string{
+				fmt.Sprint("HSET", []byte(key), []byte("f0"), []byte("v0")),
+				fmt.Sprint("HSET", []byte(key), []byte("f1"), []byte("v1")),
+			}
+			if target == "7.4" {
+				exp = append(exp, fmt.Sprint("HPEXPIREAT", []byte(key), strconv.FormatUint(expireAt, 10), "FIELDS", "1", []byte("f1")))
+			}
+			ts.Equal(exp, cmds)
+		}
+	}
+}
+
 func (ts *hashTestSuite) hash(hexData string, vals []interface{}, rdbType int) {
 	ts.Run("hash", func() {
 		key := "test_hash_key"
@@ -853,3 +956,169 @@ func (ts *functionTestSuite) TestRdb11() {
 		}
 	}
 }
+
+// module
+type moduleTestSuite struct {
+	baseSuite
+}
+
+func (ts *moduleTestSuite) moduleId(name string, encver uint64) uint64 {
+	var id uint64
+	for _, c := range []byte(name) {
+		id = id<<6 | uint64(strings.IndexByte(moduleTypeNameCharSet, c))
+	}
+	return id<<10 | encver
+}
+
+func (ts *moduleTestSuite) moduleValue(items ...interface{}) []byte {
+	b := []byte{}
+	for _, item := range items {
+		switch tt := item.(type) {
+		case uint64:
+			b = append(b, testRdbLen(rdbModuleOpcodeUint)...)
+			b = append(b, testRdbLen(tt)...)
+		case float64:
+			b = append(b, testRdbLen(rdbModuleOpcodeDouble)...)
+			b = append(b, testUint64LE(math.Float64bits(tt))...)
+		case float32:
+			b = append(b, testRdbLen(rdbModuleOpcodeFloat)...)
+			b = binary.LittleEndian.AppendUint32(b, math.Float32bits(tt))
+		case string:
+			b = append(b, testRdbLen(rdbModuleOpcodeString)...)
+			b = append(b, testRdbString(tt)...)
+		}
+	}
+	return append(b, testRdbLen(rdbModuleOpcodeEof)...)
+}
+
+func (ts *moduleTestSuite) moduleKey(key string, name string, encver uint64, items ...interface{}) []byte {
+	b := []byte{RdbTypeModule2}
+	b = append(b, testRdbString(key)...)
+	b = append(b, testRdbLen(ts.moduleId(name, encver))...)
+	return append(b, ts.moduleValue(items...)...)
+}
+
+// execCmds returns commands of the module key, or the error of parser
+func (ts *moduleTestSuite) execCmds(entry *BinEntry) (cmds [][]interface{}, err error) {
+	defer util.Xrecover(&err)
+	ts.Equal(RdbObjectModule, entry.ObjectParser.Type())
+	entry.ObjectParser.ExecCmd(func(cmd string, args ...interface{}) error {
+		cmds = append(cmds, append([]interface{}{cmd}, args...))
+		return nil
+	})
+	return
+}
+
+func (ts *moduleTestSuite) TestModule2() {
+	aux := []byte{RdbFlagModuleAux}
+	aux = append(aux, testRdbLen(ts.moduleId("ft_index0", 2))...)
+	aux = append(aux, 2, 1) // when_opcode, when
+	aux = append(aux, ts.moduleValue(uint64(1), "schema")...)
+
+	data := testRdb(aux,
+		ts.moduleKey("graph_key", "graphdata", 6, uint64(1), 1.5, float32(2), "labels"),
+		ts.moduleKey("json_key", "ReJSON-RL", 3, `{"a":1}`),
+		ts.moduleKey("old_json_key", "ReJSON-RL", 1, `{"a":1}`),
+		ts.moduleKey("cms_key", "CMSk-TYPE", 0, uint64(8), uint64(2), uint64(0), "counters"),
+		ts.moduleKey("search_key", "ft_invidx", 1, uint64(1), "blocks"),
+		ts.moduleKey("bloom_key", "MBbloom--", 4, uint64(2), uint64(1), uint64(0), uint64(2),
+			uint64(100), 0.01, uint64(7), 9.5, uint64(958), uint64(0), "bits", uint64(2)),
+	)
+	l := NewLoader(bytes.NewReader(data), "7.2")
+	ts.Nil(l.Header())
+	entries := ts.getBins(l, 6)
+	ts.Len(entries, 6)
+
+	cmds := func(key string) [][]interface{} {
+		cmds, err := ts.execCmds(entries[key])
+		ts.Nil(err)
+		return cmds
+	}
+
+	// they are restored by the raw payload
+	for _, key := range []string{"graph_key", "old_json_key", "cms_key", "search_key"} {
+		_, err := ts.execCmds(entries[key])
+		ts.ErrorIs(err, ErrUnsupportedModule, key)
+		ts.True(entries[key].CanRestore())
+		dump := entries[key].DumpValue()
+		ts.Equal(byte(RdbTypeModule2), dump[0])
+		ts.Len(dump, entries[key].ObjectParser.ValueDumpSize())
+	}
+	ts.Equal([][]interface{}{{"JSON.SET", []byte("json_key"), ".", []byte(`{"a":1}`)}}, cmds("json_key"))
+
+	bloom := cmds("bloom_key")
+	ts.Len(bloom, 2)
+	header := bloom[0][3].([]byte)
+	ts.Equal("1", bloom[0][2])
+	ts.Len(header, 20+53)
+	ts.Equal(uint64(2), binary.LittleEndian.Uint64(header))      // size
+	ts.Equal(uint32(1), binary.LittleEndian.Uint32(header[8:]))  // nfilters
+	ts.Equal(uint64(4), binary.LittleEndian.Uint64(header[20:])) // bytes of filter
+	ts.Equal([]interface{}{"BF.LOADCHUNK", []byte("bloom_key"), "5", []byte("bits")}, bloom[1])
+}
+
+// tsSamples returns uncompressed samples of RedisTimeSeries, the buffer of chunk is larger than samples
+func tsSamples(samples ...interface{}) string {
+	b := []byte{}
+	for i := 0; i < len(samples); i += 2 {
+		b = binary.LittleEndian.AppendUint64(b, samples[i].(uint64))
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(samples[i+1].(float64)))
+	}
+	return string(append(b, make([]byte, 16)...))
+}
+
+func (ts *moduleTestSuite) TestTimeSeries() {
+	series := func(key string, options uint64, rules uint64) []byte {
+		return ts.moduleKey(key, "TSDB-TYPE", 4,
+			key, uint64(3600000), uint64(4096), options, // name, retention, chunk size, options
+			uint64(3000), 2.5, uint64(3), // last timestamp, last value, total samples
+			uint64(2), uint64(0), // duplicate policy, no source key
+			uint64(2), "sensor", "s1", "area", "a1", // labels
+			rules,
+			uint64(2), // chunks
+			uint64(1000), uint64(2), uint64(4096), tsSamples(uint64(1000), 1.0, uint64(2000), -0.5),
+			uint64(3000), uint64(1), uint64(4096), tsSamples(uint64(3000), 2.5),
+		)
+	}
+	data := testRdb(
+		series("ts_key", 1, 0),
+		series("compressed_key", 0, 0),
+		ts.moduleKey("old_key", "TSDB-TYPE", 0, "old_key", uint64(0), uint64(2), uint64(1000), 1.0),
+	)
+	l := NewLoader(bytes.NewReader(data), "7.2")
+	ts.Nil(l.Header())
+	entries := ts.getBins(l, 3)
+	ts.Len(entries, 3)
+
+	cmds, err := ts.execCmds(entries["ts_key"])
+	ts.Nil(err)
+	key := []byte("ts_key")
+	ts.Equal([][]interface{}{
+		{"TS.CREATE", key, "RETENTION", "3600000", "CHUNK_SIZE", "4096", "UNCOMPRESSED", "DUPLICATE_POLICY", "LAST",
+			"LABELS", []byte("sensor"), []byte("s1"), []byte("area"), []byte("a1")},
+		{"TS.MADD", key, "1000", "1", key, "2000", "-0.5", key, "3000", "2.5"},
+	}, cmds)
+
+	for _, key := range []string{"compressed_key", "old_key"} {
+		_, err = ts.execCmds(entries[key])
+		ts.ErrorIs(err, ErrUnsupportedModule, key)
+	}
+
+	// compaction rules aren't re-emitted
+	l = NewLoader(bytes.NewReader(testRdb(series("rule_key", 1, 1))), "7.2")
+	ts.Nil(l.Header())
+	e, err := l.Next()
+	ts.Nil(err)
+	_, err = ts.execCmds(e)
+	ts.ErrorIs(err, ErrUnsupportedModule)
+}
+
+func (ts *moduleTestSuite) TestPreReleaseModule() {
+	b := []byte{RdbTypeModule}
+	b = append(b, testRdbString("key")...)
+	b = append(b, testRdbLen(ts.moduleId("graphdata", 0))...)
+	l := NewLoader(bytes.NewReader(testRdb(b)), "7.2")
+	ts.Nil(l.Header())
+	_, err := l.Next()
+	ts.ErrorIs(err, ErrUnsupportedModule)
+}
@@ -0,0 +1,408 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/errors"
+)
+
+/*
+module values :
+	values of RdbTypeModule2 are serialized by RedisModule_Save* functions, each item is prefixed by an opcode,
+	so they are read without knowing the module, and parsed by the module type parser registered by the name of module type.
+	module type parsers re-emit module keys as commands, if RESTORE is impossible across versions or the value is too big.
+	values which can't be re-emitted are restored by RESTORE of the raw payload, see ErrUnsupportedModule.
+*/
+
+// ModuleItem is an item saved by RedisModule_Save*
+type ModuleItem struct {
+	Opcode uint64 // rdbModuleOpcodeSint, rdbModuleOpcodeUint, rdbModuleOpcodeFloat, rdbModuleOpcodeDouble, rdbModuleOpcodeString
+	Uint   uint64 // signed and unsigned integers
+	Double float64
+	String []byte
+}
+
+// ModuleValue is the value of a module key
+type ModuleValue struct {
+	Name   string // name of module type, e.g. ReJSON-RL
+	EncVer uint64 // encoding version of module type
+	Items  []ModuleItem
+	pos    int
+}
+
+// redis, rdb.c:rdbLoadCheckModuleValue
+func readModuleItems(r *RdbReader) []ModuleItem {
+	items := []ModuleItem{}
+	for {
+		opcode := r.ReadLength64P()
+		if opcode == rdbModuleOpcodeEof {
+			return items
+		}
+		item := ModuleItem{Opcode: opcode}
+		switch opcode {
+		case rdbModuleOpcodeSint, rdbModuleOpcodeUint:
+			item.Uint = r.ReadLength64P()
+		case rdbModuleOpcodeFloat:
+			item.Double = float64(math.Float32frombits(r.ReadUint32P()))
+		case rdbModuleOpcodeDouble:
+			item.Double = r.ReadDoubleP()
+		case rdbModuleOpcodeString:
+			item.String = r.ReadStringP()
+		default:
+			panicIfErr(errors.Errorf("unknown module opcode : %d", opcode))
+		}
+		items = append(items, item)
+	}
+}
+
+func (mv *ModuleValue) next(opcode uint64) (ModuleItem, error) {
+	if mv.pos >= len(mv.Items) {
+		return ModuleItem{}, errors.Errorf("module value is too short : type(%s), items(%d)", mv.Name, len(mv.Items))
+	}
+	item := mv.Items[mv.pos]
+	if item.Opcode != opcode {
+		return item, errors.Errorf("opcode(%d) != %d : type(%s), item(%d)", item.Opcode, opcode, mv.Name, mv.pos)
+	}
+	mv.pos++
+	return item, nil
+}
+
+// LoadUnsigned is RedisModule_LoadUnsigned
+func (mv *ModuleValue) LoadUnsigned() (uint64, error) {
+	item, err := mv.next(rdbModuleOpcodeUint)
+	return item.Uint, err
+}
+
+// LoadSigned is RedisModule_LoadSigned
+func (mv *ModuleValue) LoadSigned() (int64, error) {
+	item, err := mv.next(rdbModuleOpcodeSint)
+	return int64(item.Uint), err
+}
+
+// LoadDouble is RedisModule_LoadDouble
+func (mv *ModuleValue) LoadDouble() (float64, error) {
+	item, err := mv.next(rdbModuleOpcodeDouble)
+	return item.Double, err
+}
+
+// LoadFloat is RedisModule_LoadFloat
+func (mv *ModuleValue) LoadFloat() (float64, error) {
+	item, err := mv.next(rdbModuleOpcodeFloat)
+	return item.Double, err
+}
+
+// LoadString is RedisModule_LoadString
+func (mv *ModuleValue) LoadString() ([]byte, error) {
+	item, err := mv.next(rdbModuleOpcodeString)
+	return item.String, err
+}
+
+// ModuleTypeParser re-emits the module key as commands
+type ModuleTypeParser func(key []byte, mv *ModuleValue, cb RdbObjExecutor) error
+
+var (
+	moduleTypeParsersMux sync.RWMutex
+	moduleTypeParsers    = map[string]ModuleTypeParser{
+		"ReJSON-RL": parseRedisJson,
+		"MBbloom--": parseBloomFilter,
+		"TSDB-TYPE": parseTimeSeries,
+		"MBbloomCF": restoreOnly, // cuckoo filter
+		"CMSk-TYPE": restoreOnly, // count-min sketch
+		"TopK-TYPE": restoreOnly,
+		// RediSearch 1.x keeps indexes in keys, they're derived from documents and restored as is.
+		// index definitions of RediSearch 2.x are saved in the aux data of the module, which isn't replayed,
+		// so indexes should be created on the target by FT.CREATE, documents are plain hashes or json keys
+		"ft_index0": restoreOnly,
+		"ft_invidx": restoreOnly,
+		"numericdx": restoreOnly,
+		"ft_tagidx": restoreOnly,
+	}
+)
+
+// RegisterModuleTypeParser registers the parser by the name of module type, it replaces the existing one
+func RegisterModuleTypeParser(name string, parser ModuleTypeParser) {
+	moduleTypeParsersMux.Lock()
+	defer moduleTypeParsersMux.Unlock()
+	moduleTypeParsers[name] = parser
+}
+
+func getModuleTypeParser(name string) ModuleTypeParser {
+	moduleTypeParsersMux.RLock()
+	defer moduleTypeParsersMux.RUnlock()
+	return moduleTypeParsers[name]
+}
+
+// restoreOnly is the parser of module types whose state can't be loaded exactly by commands,
+// counters of sketches are only increased by commands, they are restored by RESTORE of the raw payload
+func restoreOnly(key []byte, mv *ModuleValue, cb RdbObjExecutor) error {
+	return errors.Errorf("%w : %s is restored by the raw payload", ErrUnsupportedModule, mv.Name)
+}
+
+// RedisJSON, json_rdb_load : encoding version 2 and 3 save the json string
+func parseRedisJson(key []byte, mv *ModuleValue, cb RdbObjExecutor) error {
+	if mv.EncVer < 2 {
+		return errors.Errorf("%w : encoding version of RedisJSON(%d)", ErrUnsupportedModule, mv.EncVer)
+	}
+	json, err := mv.LoadString()
+	if err != nil {
+		return err
+	}
+	return cb("JSON.SET", key, ".", json)
+}
+
+const (
+	bloomChunkSize = 10 * 1024 * 1024 // RedisBloom, MAX_SCANDUMP_SIZE
+)
+
+// RedisBloom, rm_bloom.c:BFRdbLoad
+// it's restored by BF.LOADCHUNK, the first chunk is the header(sb_chain.h:dumpedChainHeader),
+// then bits of filters, the iterator of a chunk is the 1-based position of its end
+func parseBloomFilter(key []byte, mv *ModuleValue, cb RdbObjExecutor) error {
+	if mv.EncVer > 4 {
+		return errors.Errorf("%w : encoding version of RedisBloom(%d)", ErrUnsupportedModule, mv.EncVer)
+	}
+	size, err := mv.LoadUnsigned()
+	if err != nil {
+		return err
+	}
+	nfilters, err := mv.LoadUnsigned()
+	if err != nil {
+		return err
+	}
+	var options, growth uint64 = 0, 2
+	if mv.EncVer >= 2 {
+		if options, err = mv.LoadUnsigned(); err != nil {
+			return err
+		}
+	}
+	if mv.EncVer >= 4 {
+		if growth, err = mv.LoadUnsigned(); err != nil {
+			return err
+		}
+	}
+
+	header := &bytes.Buffer{}
+	le := binary.LittleEndian
+	binary.Write(header, le, size)
+	binary.Write(header, le, uint32(nfilters))
+	binary.Write(header, le, uint32(options))
+	binary.Write(header, le, uint32(growth))
+
+	filters := make([][]byte, 0, nfilters)
+	for i := uint64(0); i < nfilters; i++ {
+		var entries, hashes, bits, n2, linkSize uint64
+		var errRate, bpe float64
+		var bf []byte
+		if entries, err = mv.LoadUnsigned(); err != nil {
+			return err
+		}
+		if errRate, err = mv.LoadDouble(); err != nil {
+			return err
+		}
+		if hashes, err = mv.LoadUnsigned(); err != nil {
+			return err
+		}
+		if bpe, err = mv.LoadDouble(); err != nil {
+			return err
+		}
+		if mv.EncVer == 0 {
+			bits = uint64(float64(entries) * bpe)
+		} else if bits, err = mv.LoadUnsigned(); err != nil {
+			return err
+		}
+		if mv.EncVer >= 2 {
+			if n2, err = mv.LoadUnsigned(); err != nil {
+				return err
+			}
+		}
+		if bf, err = mv.LoadString(); err != nil {
+			return err
+		}
+		if linkSize, err = mv.LoadUnsigned(); err != nil {
+			return err
+		}
+
+		// dumpedChainLink
+		binary.Write(header, le, uint64(len(bf)))
+		binary.Write(header, le, bits)
+		binary.Write(header, le, linkSize)
+		binary.Write(header, le, errRate)
+		binary.Write(header, le, bpe)
+		binary.Write(header, le, uint32(hashes))
+		binary.Write(header, le, entries)
+		binary.Write(header, le, uint8(n2))
+		filters = append(filters, bf)
+	}
+
+	if err = cb("BF.LOADCHUNK", key, "1", header.Bytes()); err != nil {
+		return err
+	}
+	pos := 1
+	for _, bf := range filters {
+		for off := 0; off < len(bf); off += bloomChunkSize {
+			end := off + bloomChunkSize
+			if end > len(bf) {
+				end = len(bf)
+			}
+			chunk := bf[off:end]
+			pos += len(chunk)
+			if err = cb("BF.LOADCHUNK", key, strconv.Itoa(pos), chunk); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+const (
+	tsSizeRdbVer      = 2 // RedisTimeSeries, TS_SIZE_RDB_VER, chunks are saved instead of samples
+	tsDupPolicyRdbVer = 3 // TS_IS_RESSETED_DUP_POLICY_RDB_VER
+	tsMaxRdbVer       = 4 // TS_ALIGNMENT_TS_VER, alignment is only saved with compaction rules
+	tsOptUncompressed = 1 // SERIES_OPT_UNCOMPRESSED
+	tsSampleSize      = 16
+	tsMaddBatch       = 512
+)
+
+var (
+	// DuplicatePolicy, DP_NONE is not emitted
+	tsDuplicatePolicies = []string{"", "BLOCK", "LAST", "FIRST", "MIN", "MAX", "SUM"}
+)
+
+// RedisTimeSeries, rdb.c:series_rdb_load
+// a series with uncompressed chunks and without compaction rules is re-emitted by TS.CREATE and TS.MADD,
+// compressed chunks and aggregation contexts of rules are restored by the raw payload.
+// chunks are saved by chunk.c:Uncompressed_SaveToRDB, the samples are an array of {timestamp uint64, value double}
+func parseTimeSeries(key []byte, mv *ModuleValue, cb RdbObjExecutor) error {
+	if mv.EncVer < tsSizeRdbVer || mv.EncVer > tsMaxRdbVer {
+		return errors.Errorf("%w : encoding version of RedisTimeSeries(%d)", ErrUnsupportedModule, mv.EncVer)
+	}
+	if _, err := mv.LoadString(); err != nil { // name of key
+		return err
+	}
+	retention, err := mv.LoadUnsigned()
+	if err != nil {
+		return err
+	}
+	chunkSize, err := mv.LoadUnsigned()
+	if err != nil {
+		return err
+	}
+	options, err := mv.LoadUnsigned()
+	if err != nil {
+		return err
+	}
+	if options&tsOptUncompressed == 0 {
+		return errors.Errorf("%w : compressed chunks of RedisTimeSeries", ErrUnsupportedModule)
+	}
+	// last timestamp, last value and total samples are recovered by samples
+	if _, err = mv.LoadUnsigned(); err != nil {
+		return err
+	}
+	if _, err = mv.LoadDouble(); err != nil {
+		return err
+	}
+	if _, err = mv.LoadUnsigned(); err != nil {
+		return err
+	}
+	var dupPolicy uint64
+	if mv.EncVer >= tsDupPolicyRdbVer {
+		if dupPolicy, err = mv.LoadUnsigned(); err != nil {
+			return err
+		}
+		if dupPolicy >= uint64(len(tsDuplicatePolicies)) {
+			return errors.Errorf("%w : duplicate policy of RedisTimeSeries(%d)", ErrUnsupportedModule, dupPolicy)
+		}
+	}
+	// the source key is set by the compaction rule of source series
+	hasSrcKey, err := mv.LoadUnsigned()
+	if err != nil {
+		return err
+	}
+	if hasSrcKey != 0 {
+		if _, err = mv.LoadString(); err != nil {
+			return err
+		}
+	}
+
+	create := []interface{}{key, "RETENTION", strconv.FormatUint(retention, 10),
+		"CHUNK_SIZE", strconv.FormatUint(chunkSize, 10), "UNCOMPRESSED"}
+	if dupPolicy != 0 {
+		create = append(create, "DUPLICATE_POLICY", tsDuplicatePolicies[dupPolicy])
+	}
+	nlabels, err := mv.LoadUnsigned()
+	if err != nil {
+		return err
+	}
+	if nlabels > 0 {
+		create = append(create, "LABELS")
+	}
+	for i := uint64(0); i < nlabels; i++ {
+		for j := 0; j < 2; j++ {
+			label, err := mv.LoadString()
+			if err != nil {
+				return err
+			}
+			create = append(create, label)
+		}
+	}
+	nrules, err := mv.LoadUnsigned()
+	if err != nil {
+		return err
+	}
+	if nrules > 0 {
+		return errors.Errorf("%w : compaction rules of RedisTimeSeries", ErrUnsupportedModule)
+	}
+
+	nchunks, err := mv.LoadUnsigned()
+	if err != nil {
+		return err
+	}
+	chunks := make([][]byte, 0, nchunks)
+	for i := uint64(0); i < nchunks; i++ {
+		if _, err = mv.LoadUnsigned(); err != nil { // base timestamp
+			return err
+		}
+		nsamples, err := mv.LoadUnsigned()
+		if err != nil {
+			return err
+		}
+		if _, err = mv.LoadUnsigned(); err != nil { // size
+			return err
+		}
+		samples, err := mv.LoadString()
+		if err != nil {
+			return err
+		}
+		if nsamples*tsSampleSize > uint64(len(samples)) {
+			return errors.Errorf("samples of chunk are too short : samples(%d), bytes(%d)", nsamples, len(samples))
+		}
+		chunks = append(chunks, samples[:nsamples*tsSampleSize])
+	}
+
+	if err = cb("TS.CREATE", create...); err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	madd := []interface{}{}
+	for _, samples := range chunks {
+		for off := 0; off < len(samples); off += tsSampleSize {
+			ts := le.Uint64(samples[off:])
+			val := math.Float64frombits(le.Uint64(samples[off+8:]))
+			madd = append(madd, key, strconv.FormatUint(ts, 10), strconv.FormatFloat(val, 'g', -1, 64))
+			if len(madd) == tsMaddBatch*3 {
+				if err = cb("TS.MADD", madd...); err != nil {
+					return err
+				}
+				madd = []interface{}{}
+			}
+		}
+	}
+	if len(madd) > 0 {
+		return cb("TS.MADD", madd...)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"time"
 
 	"github.com/mgtv-tech/redis-GunYu/config"
 	"github.com/mgtv-tech/redis-GunYu/pkg/digest"
@@ -18,6 +19,9 @@ import (
 
 var (
 	maxBinEntryBuffer = 16 * 1024 * 1024
+
+	// ErrUnsupportedModule means the module value can't be parsed or re-emitted as commands
+	ErrUnsupportedModule = errors.New("unsupported module")
 )
 
 func panicIfErr(err error) {
@@ -81,13 +85,16 @@ func NewParser(t byte, targetRedisVersion string, rdbVersion int64) (Parser, err
 		p.rdbVersion = rdbVersion
 		p.otype = RdbObjectZSet
 		return p, nil
-	case RdbTypeHash, RdbTypeHashZipmap, RdbTypeHashZiplist, RdbTypeHashListpack:
+	case RdbTypeHash, RdbTypeHashZipmap, RdbTypeHashZiplist, RdbTypeHashListpack,
+		RdbTypeHashMetadataPreGa, RdbTypeHashListpackExPreGa, RdbTypeHashMetadata, RdbTypeHashListpackEx:
 		// hash
 		p := &HashPaser{}
 		p.rtype = t
 		p.targetRedisVersion = targetRedisVersion
 		p.rdbVersion = rdbVersion
 		p.otype = RdbObjectHash
+		// hashes with field ttl can't be restored to redis < 7.4
+		p.forceExecCmd = p.withFieldTtl() && !supportHashFieldTtl(targetRedisVersion)
 		return p, nil
 	case RDBTypeStreamListPacks, RDBTypeStreamListPacks2, RdbTypeStreamListPacks3:
 		// @TODO implement other logical, (>=redis 5)
@@ -139,6 +146,13 @@ type BaseParser struct {
 	readEntries        uint32
 	historyEntries     uint32
 	forceExecCmd       bool
+	dump               *valueDump // value converted for the target, see valueDump()
+}
+
+type valueDump struct {
+	rtype byte
+	data  []byte
+	err   error
 }
 
 func (bp *BaseParser) Type() int {
@@ -168,8 +182,35 @@ func (bp *BaseParser) IsSplited() bool {
 	return bp.totalEntries-bp.readEntries > 0
 }
 
+// CanRestore returns false if the value must be replayed by commands,
+// values of new encodings can be restored if they can be downgraded for the target
 func (bp *BaseParser) CanRestore() bool {
-	return !bp.forceExecCmd
+	if bp.forceExecCmd {
+		return false
+	}
+	if _, ok := downgradeType(bp.rtype, rdbVersionOfRedis(bp.targetRedisVersion)); !ok {
+		return false
+	}
+	return bp.valueDump().err == nil
+}
+
+// valueDump converts the value to the encoding of the target once,
+// the value is the original one if it's not converted
+func (bp *BaseParser) valueDump() *valueDump {
+	if bp.dump != nil {
+		return bp.dump
+	}
+	bp.dump = &valueDump{rtype: bp.rtype, data: bp.buf.Bytes()}
+	if to, ok := downgradeType(bp.rtype, rdbVersionOfRedis(bp.targetRedisVersion)); ok && to != bp.rtype {
+		val, err := downgradeValue(bp.rtype, to, bp.dump.data)
+		if err != nil {
+			log.Warnf("downgrade value error, replay it by commands : key(%s), type(%d), target(%s), err(%v)", bp.key, bp.rtype, bp.targetRedisVersion, err)
+			bp.dump.err = err
+		} else {
+			bp.dump.rtype, bp.dump.data = to, val
+		}
+	}
+	return bp.dump
 }
 
 func (bp *BaseParser) DB() uint32 {
@@ -178,7 +219,8 @@ func (bp *BaseParser) DB() uint32 {
 
 // redis, cluster.c:verifyDumpPayload
 func (bp *BaseParser) CreateValueDump() []byte {
-	return CreateValueDump(bp.rtype, bp.buf.Bytes())
+	dump := bp.valueDump()
+	return CreateValueDump(dump.rtype, dump.data)
 }
 
 func CreateValueDump(rtype byte, data []byte) []byte {
@@ -193,8 +235,9 @@ func CreateValueDump(rtype byte, data []byte) []byte {
 	return b.Bytes()
 }
 
+// ValueDumpSize is the size of the dump payload sent to the target, after the value is downgraded
 func (bp *BaseParser) ValueDumpSize() int {
-	return 1 + bp.buf.Len() + 2 + 8
+	return 1 + len(bp.valueDump().data) + 2 + 8
 }
 
 func (bp *BaseParser) readBufferBegin(lr *Loader) {
@@ -222,12 +265,16 @@ func (bp *BaseParser) readBufferEnd(lr *Loader) {
 
 type HashPaser struct {
 	BaseParser
+	minExpire uint64 // RdbTypeHashMetadata, ttl of fields are relative to it
+	ttlWarned bool
 }
 
 // RdbTypeHash
 // RdbTypeHashZipmap
 // RdbTypeHashZiplist
 // RdbTypeHashListpack
+// RdbTypeHashMetadata, RdbTypeHashListpackEx
+// redis7.4(rdb 12) : hashes with field ttl are RdbTypeHashMetadata or RdbTypeHashListpackEx
 // redis7.2(rdb 11) : if (sdslen(field)>64 || sdslen(value) > 64), RdbTypeHash; else RdbTypeHashListpack
 // redis4.0(rdb 8) : if (ziplistLen(ziplist) < 512) then RdbTypeHashZiplist; else RdbTypeHash,
 func (hp *HashPaser) ReadBuffer(lr *Loader) {
@@ -235,16 +282,25 @@ func (hp *HashPaser) ReadBuffer(lr *Loader) {
 	hp.cmd = "HSET"
 	r := NewRdbReader(io.TeeReader(lr, &hp.buf))
 	switch hp.rtype {
-	case RdbTypeHash:
+	case RdbTypeHash, RdbTypeHashMetadataPreGa, RdbTypeHashMetadata:
 		var n uint32
 		if hp.totalEntries-hp.readEntries == 0 {
+			if hp.rtype == RdbTypeHashMetadata {
+				hp.minExpire = r.ReadUint64P()
+			}
 			rlen := r.ReadLengthP()
 			n = rlen
 			hp.totalEntries = n
 		} else {
 			n = hp.totalEntries - hp.readEntries
+			if last, ok := lr.lastEntry.ObjectParser.(*HashPaser); ok {
+				hp.minExpire = last.minExpire
+			}
 		}
 		for i := 0; i < int(n); i++ {
+			if hp.rtype != RdbTypeHash {
+				r.ReadLength64P() // ttl
+			}
 			r.ReadStringP()
 			r.ReadStringP()
 			hp.readEntries++
@@ -254,6 +310,11 @@ func (hp *HashPaser) ReadBuffer(lr *Loader) {
 		}
 	case RdbTypeHashZipmap, RdbTypeHashZiplist, RdbTypeHashListpack:
 		r.ReadStringP()
+	case RdbTypeHashListpackExPreGa, RdbTypeHashListpackEx:
+		if hp.rtype == RdbTypeHashListpackEx {
+			hp.minExpire = r.ReadUint64P()
+		}
+		r.ReadStringP()
 	default:
 		panic(fmt.Errorf("unknown hash type : %v", hp.rtype))
 	}
@@ -266,24 +327,69 @@ func (hp *HashPaser) ExecCmd(cb RdbObjExecutor) {
 		hp.ziplist(cb)
 	case RdbTypeHashZipmap:
 		hp.zipmap(cb)
-	case RdbTypeHash:
+	case RdbTypeHash, RdbTypeHashMetadataPreGa, RdbTypeHashMetadata:
 		hp.hash(cb)
 	case RdbTypeHashListpack:
 		hp.listpack(cb)
+	case RdbTypeHashListpackExPreGa, RdbTypeHashListpackEx:
+		hp.listpackEx(cb)
+	}
+}
+
+func (hp *HashPaser) withFieldTtl() bool {
+	switch hp.rtype {
+	case RdbTypeHashMetadataPreGa, RdbTypeHashListpackExPreGa, RdbTypeHashMetadata, RdbTypeHashListpackEx:
+		return true
 	}
+	return false
+}
+
+func supportHashFieldTtl(redisVersion string) bool {
+	return util.VersionGE(redisVersion, "7.4", util.VersionMinor)
 }
 
 func (hp *HashPaser) hash(cb RdbObjExecutor) {
 	r := NewRdbReader(bytes.NewReader(hp.buf.Bytes()))
 	curNo := hp.readEntries - hp.historyEntries
 	if hp.FirstBin() {
+		if hp.rtype == RdbTypeHashMetadata {
+			r.ReadUint64P()
+		}
 		r.ReadLengthP()
 	}
 	for i := 0; i < int(curNo); i++ {
+		var expireAt uint64
+		switch hp.rtype {
+		case RdbTypeHashMetadata:
+			if ttl := r.ReadLength64P(); ttl != 0 {
+				expireAt = ttl + hp.minExpire - 1
+			}
+		case RdbTypeHashMetadataPreGa:
+			expireAt = r.ReadLength64P()
+		}
 		field := r.ReadStringP()
 		value := r.ReadStringP()
-		panicIfErr(cb(hp.cmd, hp.key, field, value))
+		hp.hset(cb, field, value, expireAt)
+	}
+}
+
+// hset sets the field, and replays its ttl, expireAt is unix time in milliseconds, 0 means no ttl
+func (hp *HashPaser) hset(cb RdbObjExecutor, field, value []byte, expireAt uint64) {
+	if expireAt != 0 && expireAt <= uint64(time.Now().UnixMilli()) { // expired
+		return
 	}
+	panicIfErr(cb(hp.cmd, hp.key, field, value))
+	if expireAt == 0 {
+		return
+	}
+	if !supportHashFieldTtl(hp.targetRedisVersion) {
+		if !hp.ttlWarned {
+			hp.ttlWarned = true
+			log.Warnf("redis(%s) does not support hash field expiration, ttl of fields are dropped : key(%s)", hp.targetRedisVersion, hp.key)
+		}
+		return
+	}
+	panicIfErr(cb("HPEXPIREAT", hp.key, strconv.FormatUint(expireAt, 10), "FIELDS", "1", field))
 }
 
 func (hp *HashPaser) ziplist(cb RdbObjExecutor) {
@@ -310,9 +416,28 @@ func (hp *HashPaser) listpack(cb RdbObjExecutor) {
 		panicIfErr(fmt.Errorf("hash list pack is not even : %d", length))
 	}
 	for i := 0; i < length/2; i++ {
-		member := listp.Next()
-		score := listp.Next()
-		panicIfErr(cb(hp.cmd, hp.key, score, member))
+		field := listp.Next()
+		value := listp.Next()
+		panicIfErr(cb(hp.cmd, hp.key, field, value))
+	}
+}
+
+// listpack of field, value, ttl triplets, ttl is 0 if the field has no ttl
+func (hp *HashPaser) listpackEx(cb RdbObjExecutor) {
+	r := NewRdbReader(bytes.NewReader(hp.buf.Bytes()))
+	if hp.rtype == RdbTypeHashListpackEx {
+		r.ReadUint64P()
+	}
+	listp := types.NewListpack(r.ReadStringP())
+	length := int(listp.NumElements())
+	if length%3 != 0 {
+		panicIfErr(fmt.Errorf("hash list pack ex is not a multiple of 3 : %d", length))
+	}
+	for i := 0; i < length/3; i++ {
+		field := listp.Next()
+		value := listp.Next()
+		expireAt := listp.NextInteger()
+		hp.hset(cb, field, value, uint64(expireAt))
 	}
 }
 
@@ -982,8 +1107,9 @@ func (sp *StreamParser) ExecCmd(cb RdbObjExecutor) {
 // module
 type ModuleParser struct {
 	BaseParser
-	id   uint64
-	name string
+	id    uint64
+	name  string
+	value *ModuleValue
 }
 
 func (mp *ModuleParser) ReadBuffer(lr *Loader) {
@@ -991,8 +1117,9 @@ func (mp *ModuleParser) ReadBuffer(lr *Loader) {
 	r := NewRdbReader(io.TeeReader(lr, &mp.buf))
 
 	// RdbTypeModule2, RdbTypeModule
+	// values of pre-release modules aren't delimited by opcodes, they can't be skipped, neither does redis load them
 	if mp.rtype == RdbTypeModule {
-		panic(errors.New("does not support module type 1"))
+		panicIfErr(fmt.Errorf("%w : pre-release module type(%d), key(%s)", ErrUnsupportedModule, mp.rtype, mp.key))
 	}
 
 	// skip 64 bit
@@ -1003,19 +1130,46 @@ func (mp *ModuleParser) ReadBuffer(lr *Loader) {
 
 	mp.id = moduleId
 	mp.name = moduleName
-	//
-	// parse modules
-	log.Errorf("module parser error : %s", moduleName)
+	mp.value = &ModuleValue{
+		Name:   moduleName,
+		EncVer: moduleId & 1023,
+		Items:  readModuleItems(r),
+	}
 	mp.readBufferEnd(lr)
 }
 
+// ExecCmd re-emits the module key by the parser of module type, see RegisterModuleTypeParser.
+// it panics with ErrUnsupportedModule if there is no parser or the parser fails before emitting commands,
+// the raw payload should be restored then
 func (mp *ModuleParser) ExecCmd(cb RdbObjExecutor) {
-	log.Warnf("unsupported module : id(%d), name(%s)", mp.id, mp.name)
+	parser := getModuleTypeParser(mp.name)
+	if parser == nil {
+		panicIfErr(fmt.Errorf("%w : id(%d), name(%s), key(%s)", ErrUnsupportedModule, mp.id, mp.name, mp.key))
+	}
+	mp.value.pos = 0
+	emitted := false
+	err := parser(mp.key, mp.value, func(cmd string, args ...interface{}) error {
+		emitted = true
+		return cb(cmd, args...)
+	})
+	if err == nil {
+		return
+	}
+	if !emitted && !errors.Is(err, ErrUnsupportedModule) {
+		err = fmt.Errorf("%w : %w", ErrUnsupportedModule, err)
+	}
+	panicIfErr(fmt.Errorf("module parser error : name(%s), encver(%d), key(%s), err(%w)", mp.name, mp.value.EncVer, mp.key, err))
 }
 
 // function
 type FunctionParser struct {
 	BaseParser
+	existsPolicy string // flush|append|replace, default is output.functionExists
+}
+
+// SetExistsPolicy sets the policy if the function exists in the target
+func (fp *FunctionParser) SetExistsPolicy(policy string) {
+	fp.existsPolicy = policy
 }
 
 func (fp *FunctionParser) ReadBuffer(lr *Loader) {
@@ -1034,9 +1188,13 @@ func (fp *FunctionParser) ExecCmd(cb RdbObjExecutor) {
 	if util.VersionGE(fp.targetRedisVersion, "7", util.VersionMajor) {
 		val := fp.CreateValueDump()
 
-		if config.Get().Output.FunctionExists == "flush" {
+		policy := fp.existsPolicy
+		if policy == "" {
+			policy = config.Get().Output.FunctionExists
+		}
+		if policy == "flush" {
 			panicIfErr(cb("FUNCTION", "RESTORE", val, "FLUSH"))
-		} else if config.Get().Output.FunctionExists == "append" {
+		} else if policy == "append" {
 			panicIfErr(cb("FUNCTION", "RESTORE", val))
 		} else {
 			panicIfErr(cb("FUNCTION", "RESTORE", val, "REPLACE"))
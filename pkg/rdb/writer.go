@@ -0,0 +1,76 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/errors"
+)
+
+// DumpWriter writes a rdb file from payloads of DUMP, the rdb has no checksum
+type DumpWriter struct {
+	w    *bufio.Writer
+	buf  rdbValueWriter
+	db   int64
+	size int64
+}
+
+func NewDumpWriter(w io.Writer) *DumpWriter {
+	return &DumpWriter{
+		w:  bufio.NewWriterSize(w, 64*1024),
+		db: -1,
+	}
+}
+
+// Header writes the magic string and the rdb version
+func (dw *DumpWriter) Header() error {
+	return dw.write([]byte(fmt.Sprintf("REDIS%04d", RdbVersion)))
+}
+
+// Write writes a key of the db, expireAt is the unix time in milliseconds, 0 means no expiration
+// dump is the payload of DUMP : type, value, rdb version(2 bytes) and crc64(8 bytes)
+func (dw *DumpWriter) Write(db int64, key []byte, expireAt int64, dump []byte) error {
+	if len(dump) < 11 {
+		return errors.Errorf("dump payload is too short : key(%s), len(%d)", key, len(dump))
+	}
+	footer := dump[len(dump)-10:]
+	if version := int64(binary.LittleEndian.Uint16(footer)); version > RdbVersion {
+		return errors.Errorf("unsupported rdb version of dump payload : key(%s), version(%d)", key, version)
+	}
+
+	dw.buf.Reset()
+	if db != dw.db {
+		dw.buf.WriteByte(RdbFlagSelectDB)
+		dw.buf.writeLength(uint64(db))
+		dw.db = db
+	}
+	if expireAt > 0 {
+		dw.buf.WriteByte(RdbFlagExpiryMS)
+		dw.buf.writeUint64(uint64(expireAt))
+	}
+	dw.buf.WriteByte(dump[0])
+	dw.buf.writeString(key)
+	dw.buf.Write(dump[1 : len(dump)-10])
+	return dw.write(dw.buf.Bytes())
+}
+
+// Footer writes EOF and a zero checksum, then flushes the rdb
+func (dw *DumpWriter) Footer() error {
+	if err := dw.write([]byte{RdbFlagEOF, 0, 0, 0, 0, 0, 0, 0, 0}); err != nil {
+		return err
+	}
+	return dw.w.Flush()
+}
+
+// Size returns bytes of the rdb
+func (dw *DumpWriter) Size() int64 {
+	return dw.size
+}
+
+func (dw *DumpWriter) write(p []byte) error {
+	n, err := dw.w.Write(p)
+	dw.size += int64(n)
+	return err
+}
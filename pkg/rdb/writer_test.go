@@ -0,0 +1,38 @@
+package rdb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpWriter(t *testing.T) {
+	dump := func(rtype byte, value []byte) []byte {
+		return append(append([]byte{rtype}, value...), 12, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	}
+
+	buf := &bytes.Buffer{}
+	w := NewDumpWriter(buf)
+	assert.Nil(t, w.Header())
+	assert.Nil(t, w.Write(0, []byte("k1"), 0, dump(RdbTypeString, testRdbString("v1"))))
+	assert.Nil(t, w.Write(2, []byte("k2"), 1700000000000, dump(RdbTypeSetListpack, testRdbString(testListpack("a", "b")))))
+	assert.Nil(t, w.Footer())
+	assert.Equal(t, int64(buf.Len()), w.Size())
+
+	// payloads of newer rdb versions are rejected
+	assert.NotNil(t, w.Write(0, []byte("k3"), 0, append([]byte{RdbTypeString, 0}, 99, 0, 0, 0, 0, 0, 0, 0, 0, 0)))
+
+	l := NewLoader(bytes.NewReader(buf.Bytes()), "7.4")
+	assert.Nil(t, l.Header())
+	e, err := l.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, e.DB)
+	assert.Equal(t, []string{"set k1 v1"}, testEntryCmds(e))
+
+	e, err = l.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, e.DB)
+	assert.Equal(t, uint64(1700000000000), e.ExpireAt)
+	assert.Equal(t, []string{"SADD k2 a", "SADD k2 b"}, testEntryCmds(e))
+}
@@ -19,10 +19,11 @@ var (
 	ErrRestoreRdb = errors.New("restore rdb error")
 )
 
-func RestoreRdbEntry(cli client.Redis, e *rdb.BinEntry) (err error) {
+// RestoreRdbEntry restores the entry according to the output configuration
+func RestoreRdbEntry(cli client.Redis, e *rdb.BinEntry, cfg *config.OutputConfig) (err error) {
 
 	var ttlms uint64
-	if config.Get().Output.ReplaceHashTag {
+	if cfg.ReplaceHashTag {
 		e.Key = bytes.Replace(e.Key, []byte("{"), []byte(""), 1)
 		e.Key = bytes.Replace(e.Key, []byte("}"), []byte(""), 1)
 	}
@@ -38,13 +39,16 @@ func RestoreRdbEntry(cli client.Redis, e *rdb.BinEntry) (err error) {
 
 	ot := e.ObjectParser.Type()
 	if ot == rdb.RdbObjectFunction || ot == rdb.RdbObjectAux {
+		if fp, ok := e.ObjectParser.(*rdb.FunctionParser); ok {
+			fp.SetExistsPolicy(cfg.FunctionExists)
+		}
 		return restoreOnce(cli, e)
 	}
 
-	restoreCmd := *config.Get().Output.ReplayRdbEnableRestore
+	restoreCmd := *cfg.ReplayRdbEnableRestore
 	if restoreCmd &&
-		(!e.CanRestore() || e.ObjectParser.ValueDumpSize() > config.Get().Output.MaxProtoBulkLen ||
-			e.ObjectParser.IsSplited()) {
+		(e.ObjectParser.IsSplited() || !e.CanRestore() ||
+			e.ObjectParser.ValueDumpSize() > cfg.MaxProtoBulkLen) {
 		restoreCmd = false
 	}
 
@@ -55,9 +59,9 @@ func RestoreRdbEntry(cli client.Redis, e *rdb.BinEntry) (err error) {
 				return err
 			}
 			if exist {
-				switch config.Get().Output.KeyExists {
+				switch cfg.KeyExists {
 				case "replace":
-					if config.Get().Output.KeyExistsLog {
+					if cfg.KeyExistsLog {
 						log.Infof("replace key: %s", e.Key)
 					}
 					_, err := common.Int64(cli.Do("del", e.Key))
@@ -65,7 +69,7 @@ func RestoreRdbEntry(cli client.Redis, e *rdb.BinEntry) (err error) {
 						return fmt.Errorf("del exist key error : key(%s), error(%w)", e.Key, err)
 					}
 				case "ignore":
-					if config.Get().Output.KeyExistsLog {
+					if cfg.KeyExistsLog {
 						log.Warnf("output key exist, ignore it : %s", e.Key)
 					}
 				case "error":
@@ -75,20 +79,25 @@ func RestoreRdbEntry(cli client.Redis, e *rdb.BinEntry) (err error) {
 		}
 
 		err = restoreBigRdbEntry(cli, e)
-		if err != nil {
-			return err
-		}
-		if e.ExpireAt != 0 {
-			r, err := common.Int64(cli.Do("pexpire", e.Key, ttlms))
-			if err != nil && r != 1 {
-				return fmt.Errorf("expire key error : key(%s), error(%w)", e.Key, err)
+		if errors.Is(err, rdb.ErrUnsupportedModule) {
+			// the module key can't be re-emitted, restore the raw payload
+			log.Warnf("restore the module key by dump payload : key(%s), error(%v)", e.Key, err)
+		} else {
+			if err != nil {
+				return err
 			}
+			if e.ExpireAt != 0 {
+				r, err := common.Int64(cli.Do("pexpire", e.Key, ttlms))
+				if err != nil && r != 1 {
+					return fmt.Errorf("expire key error : key(%s), error(%w)", e.Key, err)
+				}
+			}
+			return nil
 		}
-		return nil
 	}
 
 	params := []interface{}{e.Key, ttlms, e.DumpValue()}
-	if util.VersionGE(config.Get().Output.Redis.Version, "5", util.VersionMajor) {
+	if util.VersionGE(cfg.Redis.Version, "5", util.VersionMajor) {
 		if e.IdleTime != 0 {
 			params = append(params, "IDLETIME")
 			params = append(params, e.IdleTime)
@@ -105,15 +114,15 @@ RESTORE:
 		  but in 4.0 kernel is "BUSYKEY Target key name already exists"*/
 		if strings.Contains(err.Error(), "Target key name is busy") ||
 			strings.Contains(err.Error(), "BUSYKEY Target key name already exists") {
-			switch config.Get().Output.KeyExists {
+			switch cfg.KeyExists {
 			case "replace":
-				if config.Get().Output.KeyExistsLog {
+				if cfg.KeyExistsLog {
 					log.Infof("replace key: %s", e.Key)
 				}
 				params = append(params, "REPLACE")
 				goto RESTORE
 			case "ignore":
-				if config.Get().Output.KeyExistsLog {
+				if cfg.KeyExistsLog {
 					log.Warnf("output key exist, ignore it : %s", e.Key)
 				}
 			case "error":
@@ -136,9 +145,9 @@ RESTORE:
 
 func restoreOnce(cli client.Redis, e *rdb.BinEntry) (err error) {
 	defer util.Xrecover(&err, ErrRestoreRdb)
+	// functions and scripts are not routed by keys, they are restored to all nodes of cluster
 	e.ObjectParser.ExecCmd(func(cmd string, args ...interface{}) error {
-		_, err := cli.Do(cmd, args...)
-		return err
+		return client.DoOnAllNodes(cli, cmd, args...)
 	})
 	return nil
 }
@@ -238,14 +238,14 @@ func SetCheckpoint(cli client.Redis, cp *CheckpointInfo) error {
 // update checkpoint run id as first element of ids
 // @TODO update and create timestamp for GC
 // it is not transactional
-func UpdateCheckpoint(outCli client.Redis, localCheckpoint string, ids []string) error {
+func UpdateCheckpoint(store Store, localCheckpoint string, ids []string) error {
 	if len(ids) == 0 {
 		return nil
 	}
 	id1 := ids[0]
 
 	// retry to get previous checkpoint name
-	cpName, cpRunId, err := GetCheckpointHash(outCli, ids)
+	cpName, cpRunId, err := store.GetCheckpointHash(ids)
 	if err != nil {
 		return err
 	}
@@ -258,7 +258,7 @@ func UpdateCheckpoint(outCli client.Redis, localCheckpoint string, ids []string)
 			Version: config.Version,
 		}
 		if len(cpName) > 0 { // restore old checkpoint
-			cpKv, _, err = GetCheckpoint(outCli, cpName, ids)
+			cpKv, _, err = store.GetCheckpoint(cpName, ids)
 			if err != nil {
 				return err
 			}
@@ -267,23 +267,23 @@ func UpdateCheckpoint(outCli client.Redis, localCheckpoint string, ids []string)
 		oldId := cpKv.RunId
 		cpKv.Key = localCheckpoint
 		cpKv.RunId = id1
-		err = SetCheckpoint(outCli, cpKv)
+		err = store.SetCheckpoint(cpKv)
 		if err != nil {
 			return err
 		}
-		err = SetCheckpointHash(outCli, id1, localCheckpoint) // runId : checkpointName
+		err = store.SetCheckpointHash(id1, localCheckpoint) // runId : checkpointName
 		if err != nil {
 			return err
 		}
 
 		if len(oldId) > 0 && oldId != "?" {
 			// delete old checkpoint
-			err = DelCheckpoint(outCli, cpName, oldId)
+			err = store.DelCheckpoint(cpName, oldId)
 			if err != nil {
 				return err
 			}
 			if oldId != id1 { // delete old runid from checkpoint hash
-				err = DelCheckpointHash(outCli, oldId)
+				err = store.DelCheckpointHash(oldId)
 				if err != nil {
 					return err
 				}
@@ -18,6 +18,7 @@ type CheckpointInfo struct {
 	Version string
 	Offset  int64
 	Mtime   int64
+	Db      int // db of the last replayed command, only used by stores outside of redis
 }
 
 const (
@@ -1,7 +1,10 @@
 package checkpoint
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/exp/slices"
@@ -31,4 +34,75 @@ func TestGetCheckpointSet(t *testing.T) {
 	}
 }
 
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	defer store.Close()
+
+	// no checkpoint
+	cp, db, err := store.GetCheckpoint("cp1", []string{"id1", "id2"})
+	assert.Nil(t, err)
+	assert.Equal(t, -1, db)
+	assert.Equal(t, "?", cp.RunId)
+
+	assert.Nil(t, store.SetCheckpoint(&CheckpointInfo{Key: "cp1", RunId: "id2", Version: "v1", Offset: 10, Db: 3}))
+	assert.Nil(t, store.SetCheckpointHash("id2", "cp1"))
+	cp, db, err = store.GetCheckpoint("cp1", []string{"id1", "id2"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, db)
+	assert.Equal(t, "id2", cp.RunId)
+	assert.Equal(t, int64(10), cp.Offset)
+
+	// empty version keeps the stored one
+	assert.Nil(t, store.SetCheckpoint(&CheckpointInfo{Key: "cp1", RunId: "id2", Offset: 20}))
+	cp, _, err = store.GetCheckpoint("cp1", []string{"id2"})
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", cp.Version)
+	assert.Equal(t, int64(20), cp.Offset)
+
+	// move checkpoint to a new name and run id
+	assert.Nil(t, UpdateCheckpoint(store, "cp2", []string{"id1", "id2"}))
+	cp, _, err = store.GetCheckpoint("cp2", []string{"id1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "id1", cp.RunId)
+	assert.Equal(t, int64(20), cp.Offset)
+	cp, _, err = store.GetCheckpoint("cp1", []string{"id2"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), cp.Offset)
+
+	hash, err := store.GetAllCheckpointHash()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"id1", "cp2"}, hash)
+
+	// stale checkpoints
+	total, deleted, err := store.DelStaleCheckpoint("cp2", "id1", time.Hour, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 0}, []int{total, deleted})
+	total, deleted, err = store.DelStaleCheckpoint("cp2", "id1", 0, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 0}, []int{total, deleted})
+	total, deleted, err = store.DelStaleCheckpoint("cp2", "id1", 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 1}, []int{total, deleted})
+
+	assert.Nil(t, store.DelCheckpointHash("id1"))
+	hash, err = store.GetAllCheckpointHash()
+	assert.Nil(t, err)
+	assert.Empty(t, hash)
+}
+
+func TestWriteJson(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hash.json")
+	assert.Nil(t, writeJson(path, map[string]string{"id1": "cp1"}))
+	assert.Nil(t, writeJson(path, map[string]string{"id2": "cp2"}))
+	hash := make(map[string]string)
+	assert.Nil(t, readJson(path, &hash))
+	assert.Equal(t, map[string]string{"id2": "cp2"}, hash)
+	_, err := os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	// the directory doesn't exist
+	assert.NotNil(t, writeJson(filepath.Join(path, "x", "y.json"), hash))
+}
+
 // @TODO unit test cases, corner cases
@@ -0,0 +1,170 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/cluster"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+)
+
+/*
+etcd store :
+	$prefix/hash/$runid : cp_name
+	$prefix/checkpoints/$cp_name/$runid : checkpoint
+*/
+
+const etcdStoreTimeout = 5 * time.Second
+
+// EtcdStore stores checkpoints in etcd
+type EtcdStore struct {
+	cli    *clientv3.Client
+	prefix string
+}
+
+func NewEtcdStore(ctx context.Context, cfg config.EtcdConfig, prefix string) (*EtcdStore, error) {
+	cli, err := cluster.NewEtcdClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdStore{cli: cli, prefix: prefix}, nil
+}
+
+func (es *EtcdStore) hashKey(runId string) string {
+	return es.prefix + "hash/" + runId
+}
+
+func (es *EtcdStore) checkpointKey(checkpointName string, runId string) string {
+	return es.prefix + "checkpoints/" + checkpointName + "/" + runId
+}
+
+func (es *EtcdStore) getCheckpoint(ctx context.Context, checkpointName string, runId string) (*CheckpointInfo, error) {
+	resp, err := es.cli.Get(ctx, es.checkpointKey(checkpointName, runId))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	cp := &CheckpointInfo{}
+	if err = json.Unmarshal(resp.Kvs[0].Value, cp); err != nil {
+		return nil, fmt.Errorf("unmarshal checkpoint(%s) error : %w", resp.Kvs[0].Key, err)
+	}
+	return cp, nil
+}
+
+func (es *EtcdStore) GetCheckpoint(checkpointName string, runIds []string) (*CheckpointInfo, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdStoreTimeout)
+	defer cancel()
+	return latestCheckpoint(checkpointName, runIds, func(runId string) (*CheckpointInfo, error) {
+		return es.getCheckpoint(ctx, checkpointName, runId)
+	})
+}
+
+func (es *EtcdStore) SetCheckpoint(cp *CheckpointInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdStoreTimeout)
+	defer cancel()
+
+	var old *CheckpointInfo
+	if cp.Version == "" {
+		var err error
+		if old, err = es.getCheckpoint(ctx, cp.Key, cp.RunId); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(mergeCheckpoint(old, cp))
+	if err != nil {
+		return err
+	}
+	_, err = es.cli.Put(ctx, es.checkpointKey(cp.Key, cp.RunId), string(data))
+	return err
+}
+
+func (es *EtcdStore) DelCheckpoint(checkpointName string, runId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdStoreTimeout)
+	defer cancel()
+	if _, err := es.cli.Delete(ctx, es.checkpointKey(checkpointName, runId)); err != nil {
+		return err
+	}
+	log.Infof("clear checkpoint : prefix(%s), cpName(%s), runId(%s)", es.prefix, checkpointName, runId)
+	return nil
+}
+
+func (es *EtcdStore) DelStaleCheckpoint(checkpointName string, runId string, beforeNow time.Duration, exceptNewest bool) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdStoreTimeout)
+	defer cancel()
+
+	cp, err := es.getCheckpoint(ctx, checkpointName, runId)
+	if err != nil {
+		return 0, 0, err
+	}
+	if cp == nil || cp.Offset <= 0 {
+		return 0, 0, nil
+	}
+	if !isStale(cp, beforeNow, exceptNewest) {
+		return 1, 0, nil
+	}
+	if _, err = es.cli.Delete(ctx, es.checkpointKey(checkpointName, runId)); err != nil {
+		return 1, 0, err
+	}
+	log.Infof("del lagacy checkpoint : prefix(%s), checkpoint(%+v)", es.prefix, cp)
+	return 1, 1, nil
+}
+
+func (es *EtcdStore) GetCheckpointHash(runIds []string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdStoreTimeout)
+	defer cancel()
+	for _, runId := range runIds {
+		resp, err := es.cli.Get(ctx, es.hashKey(runId))
+		if err != nil {
+			return "", "", err
+		}
+		if len(resp.Kvs) > 0 {
+			return string(resp.Kvs[0].Value), runId, nil
+		}
+	}
+	return "", "", nil
+}
+
+func (es *EtcdStore) SetCheckpointHash(runId string, checkpointName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdStoreTimeout)
+	defer cancel()
+	_, err := es.cli.Put(ctx, es.hashKey(runId), checkpointName)
+	return err
+}
+
+func (es *EtcdStore) DelCheckpointHash(runId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdStoreTimeout)
+	defer cancel()
+	_, err := es.cli.Delete(ctx, es.hashKey(runId))
+	return err
+}
+
+func (es *EtcdStore) GetAllCheckpointHash() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdStoreTimeout)
+	defer cancel()
+	prefix := es.hashKey("")
+	resp, err := es.cli.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+	kvs := make([]string, 0, len(resp.Kvs)*2)
+	for _, kv := range resp.Kvs {
+		kvs = append(kvs, strings.TrimPrefix(string(kv.Key), prefix), string(kv.Value))
+	}
+	return kvs, nil
+}
+
+func (es *EtcdStore) Name() string {
+	return "etcd(" + es.prefix + ")"
+}
+
+func (es *EtcdStore) Close() error {
+	return es.cli.Close()
+}
@@ -0,0 +1,261 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+)
+
+/*
+file store :
+	$dir/hash.json : {runId:cp_name, ...}
+	$dir/checkpoints/$cp_name.json : {runId:checkpoint, ...}
+*/
+
+// stores of a process share the lock, a directory should not be shared by processes
+var fileStoreMux sync.Mutex
+
+// FileStore stores checkpoints in local files
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "checkpoints"), 0777); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) hashPath() string {
+	return filepath.Join(fs.dir, "hash.json")
+}
+
+func (fs *FileStore) checkpointPath(checkpointName string) string {
+	return filepath.Join(fs.dir, "checkpoints", url.PathEscape(checkpointName)+".json")
+}
+
+// readJson reads a json file, a nonexistent file is empty
+func readJson(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unmarshal file(%s) error : %w", path, err)
+	}
+	return nil
+}
+
+// writeJson writes a temporary file and renames it, file is never partially written.
+// the temporary file is synced before renaming, and the directory is synced after renaming,
+// otherwise the file may be empty or torn after a crash
+func writeJson(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	df, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = df.Sync()
+	if cerr := df.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (fs *FileStore) readCheckpoints(checkpointName string) (map[string]*CheckpointInfo, error) {
+	cps := make(map[string]*CheckpointInfo)
+	err := readJson(fs.checkpointPath(checkpointName), &cps)
+	return cps, err
+}
+
+func (fs *FileStore) writeCheckpoints(checkpointName string, cps map[string]*CheckpointInfo) error {
+	if len(cps) == 0 {
+		err := os.Remove(fs.checkpointPath(checkpointName))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return writeJson(fs.checkpointPath(checkpointName), cps)
+}
+
+func (fs *FileStore) readHash() (map[string]string, error) {
+	hash := make(map[string]string)
+	err := readJson(fs.hashPath(), &hash)
+	return hash, err
+}
+
+func (fs *FileStore) GetCheckpoint(checkpointName string, runIds []string) (*CheckpointInfo, int, error) {
+	fileStoreMux.Lock()
+	defer fileStoreMux.Unlock()
+
+	cps, err := fs.readCheckpoints(checkpointName)
+	if err != nil {
+		return nil, 0, err
+	}
+	return latestCheckpoint(checkpointName, runIds, func(runId string) (*CheckpointInfo, error) {
+		return cps[runId], nil
+	})
+}
+
+func (fs *FileStore) SetCheckpoint(cp *CheckpointInfo) error {
+	fileStoreMux.Lock()
+	defer fileStoreMux.Unlock()
+
+	cps, err := fs.readCheckpoints(cp.Key)
+	if err != nil {
+		return err
+	}
+	cps[cp.RunId] = mergeCheckpoint(cps[cp.RunId], cp)
+	return fs.writeCheckpoints(cp.Key, cps)
+}
+
+func (fs *FileStore) DelCheckpoint(checkpointName string, runId string) error {
+	fileStoreMux.Lock()
+	defer fileStoreMux.Unlock()
+
+	cps, err := fs.readCheckpoints(checkpointName)
+	if err != nil {
+		return err
+	}
+	if _, ok := cps[runId]; !ok {
+		return nil
+	}
+	delete(cps, runId)
+	if err = fs.writeCheckpoints(checkpointName, cps); err != nil {
+		return err
+	}
+	log.Infof("clear checkpoint : dir(%s), cpName(%s), runId(%s)", fs.dir, checkpointName, runId)
+	return nil
+}
+
+func (fs *FileStore) DelStaleCheckpoint(checkpointName string, runId string, beforeNow time.Duration, exceptNewest bool) (int, int, error) {
+	fileStoreMux.Lock()
+	defer fileStoreMux.Unlock()
+
+	cps, err := fs.readCheckpoints(checkpointName)
+	if err != nil {
+		return 0, 0, err
+	}
+	cp, ok := cps[runId]
+	if !ok || cp.Offset <= 0 {
+		return 0, 0, nil
+	}
+	if !isStale(cp, beforeNow, exceptNewest) {
+		return 1, 0, nil
+	}
+	delete(cps, runId)
+	if err = fs.writeCheckpoints(checkpointName, cps); err != nil {
+		return 1, 0, err
+	}
+	log.Infof("del lagacy checkpoint : dir(%s), checkpoint(%+v)", fs.dir, cp)
+	return 1, 1, nil
+}
+
+func (fs *FileStore) GetCheckpointHash(runIds []string) (string, string, error) {
+	fileStoreMux.Lock()
+	defer fileStoreMux.Unlock()
+
+	hash, err := fs.readHash()
+	if err != nil {
+		return "", "", err
+	}
+	for _, runId := range runIds {
+		if cpName, ok := hash[runId]; ok {
+			return cpName, runId, nil
+		}
+	}
+	return "", "", nil
+}
+
+func (fs *FileStore) SetCheckpointHash(runId string, checkpointName string) error {
+	fileStoreMux.Lock()
+	defer fileStoreMux.Unlock()
+
+	hash, err := fs.readHash()
+	if err != nil {
+		return err
+	}
+	hash[runId] = checkpointName
+	return writeJson(fs.hashPath(), hash)
+}
+
+func (fs *FileStore) DelCheckpointHash(runId string) error {
+	fileStoreMux.Lock()
+	defer fileStoreMux.Unlock()
+
+	hash, err := fs.readHash()
+	if err != nil {
+		return err
+	}
+	if _, ok := hash[runId]; !ok {
+		return nil
+	}
+	delete(hash, runId)
+	return writeJson(fs.hashPath(), hash)
+}
+
+func (fs *FileStore) GetAllCheckpointHash() ([]string, error) {
+	fileStoreMux.Lock()
+	defer fileStoreMux.Unlock()
+
+	hash, err := fs.readHash()
+	if err != nil {
+		return nil, err
+	}
+	runIds := make([]string, 0, len(hash))
+	for runId := range hash {
+		runIds = append(runIds, runId)
+	}
+	sort.Strings(runIds)
+	kvs := make([]string, 0, len(hash)*2)
+	for _, runId := range runIds {
+		kvs = append(kvs, runId, hash[runId])
+	}
+	return kvs, nil
+}
+
+func (fs *FileStore) Name() string {
+	return "file(" + fs.dir + ")"
+}
+
+func (fs *FileStore) Close() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client"
+)
+
+// Store stores checkpoints and the checkpoint hash (run id : checkpoint name)
+type Store interface {
+	// GetCheckpoint returns the latest checkpoint of run ids and its db, db is -1 if there is no checkpoint
+	GetCheckpoint(checkpointName string, runIds []string) (*CheckpointInfo, int, error)
+	SetCheckpoint(cp *CheckpointInfo) error
+	DelCheckpoint(checkpointName string, runId string) error
+	// DelStaleCheckpoint deletes checkpoints which are not updated in beforeNow, returns the amount of checkpoints and deleted ones
+	DelStaleCheckpoint(checkpointName string, runId string, beforeNow time.Duration, exceptNewest bool) (int, int, error)
+
+	// GetCheckpointHash returns checkpoint name and run id
+	GetCheckpointHash(runIds []string) (string, string, error)
+	SetCheckpointHash(runId string, checkpointName string) error
+	DelCheckpointHash(runId string) error
+	// GetAllCheckpointHash returns [runId, checkpointName, runId, checkpointName, ...]
+	GetAllCheckpointHash() ([]string, error)
+
+	Name() string
+	Close() error
+}
+
+// NewStore returns the checkpoint store of configuration,
+// checkpoints are stored in redisCfg if the store is absent or redis
+func NewStore(ctx context.Context, cfg *config.CheckpointStoreConfig, redisCfg config.RedisConfig) (Store, error) {
+	if cfg == nil || !cfg.IsExternal() {
+		cli, err := client.NewRedis(redisCfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisStore(cli), nil
+	}
+	switch cfg.Type {
+	case config.CheckpointStoreFile:
+		return NewFileStore(cfg.Dir)
+	case config.CheckpointStoreEtcd:
+		if cfg.Etcd == nil {
+			return nil, fmt.Errorf("etcd of checkpoint store is nil")
+		}
+		return NewEtcdStore(ctx, *cfg.Etcd, cfg.Prefix)
+	}
+	return nil, fmt.Errorf("unknown checkpoint store : %s", cfg.Type)
+}
+
+// RedisStore stores checkpoints in hashes of redis
+type RedisStore struct {
+	cli client.Redis
+}
+
+func NewRedisStore(cli client.Redis) *RedisStore {
+	return &RedisStore{cli: cli}
+}
+
+func (rs *RedisStore) GetCheckpoint(checkpointName string, runIds []string) (*CheckpointInfo, int, error) {
+	return GetCheckpoint(rs.cli, checkpointName, runIds)
+}
+
+func (rs *RedisStore) SetCheckpoint(cp *CheckpointInfo) error {
+	return SetCheckpoint(rs.cli, cp)
+}
+
+func (rs *RedisStore) DelCheckpoint(checkpointName string, runId string) error {
+	return DelCheckpoint(rs.cli, checkpointName, runId)
+}
+
+func (rs *RedisStore) DelStaleCheckpoint(checkpointName string, runId string, beforeNow time.Duration, exceptNewest bool) (int, int, error) {
+	return DelStaleCheckpoint(rs.cli, checkpointName, runId, beforeNow, exceptNewest)
+}
+
+func (rs *RedisStore) GetCheckpointHash(runIds []string) (string, string, error) {
+	return GetCheckpointHash(rs.cli, runIds)
+}
+
+func (rs *RedisStore) SetCheckpointHash(runId string, checkpointName string) error {
+	return SetCheckpointHash(rs.cli, runId, checkpointName)
+}
+
+func (rs *RedisStore) DelCheckpointHash(runId string) error {
+	return DelCheckpointHash(rs.cli, runId)
+}
+
+func (rs *RedisStore) GetAllCheckpointHash() ([]string, error) {
+	return GetAllCheckpointHash(rs.cli)
+}
+
+func (rs *RedisStore) Name() string {
+	return fmt.Sprintf("redis%v", rs.cli.Addresses())
+}
+
+func (rs *RedisStore) Close() error {
+	return rs.cli.Close()
+}
+
+// latestCheckpoint returns the latest checkpoint of run ids, getter returns nil if the run id has no checkpoint.
+// it's used by stores outside of redis, which have one checkpoint for a name and a run id
+func latestCheckpoint(checkpointName string, runIds []string, getter func(runId string) (*CheckpointInfo, error)) (*CheckpointInfo, int, error) {
+	cpi := &CheckpointInfo{
+		Key:     checkpointName,
+		RunId:   "?",
+		Offset:  -1,
+		Version: config.Version,
+	}
+	found := false
+	for _, runId := range runIds {
+		tcpi, err := getter(runId)
+		if err != nil {
+			return nil, 0, err
+		}
+		if tcpi == nil {
+			continue
+		}
+		if !found || (tcpi.Offset > cpi.Offset) ||
+			(tcpi.Offset == cpi.Offset && tcpi.Mtime > cpi.Mtime) {
+			*cpi = *tcpi
+			found = true
+		}
+	}
+	if !found {
+		return cpi, -1, nil
+	}
+	return cpi, cpi.Db, nil
+}
+
+// mergeCheckpoint merges cp into the stored one like hset, empty version keeps the stored one
+func mergeCheckpoint(old *CheckpointInfo, cp *CheckpointInfo) *CheckpointInfo {
+	merged := *cp
+	merged.Mtime = time.Now().UnixNano()
+	if merged.Version == "" && old != nil {
+		merged.Version = old.Version
+	}
+	return &merged
+}
+
+// isStale returns true if the checkpoint should be deleted
+func isStale(cp *CheckpointInfo, beforeNow time.Duration, exceptNewest bool) bool {
+	if exceptNewest { // a checkpoint name of run id only has one checkpoint, it's the newest
+		return false
+	}
+	return cp.Mtime <= time.Now().Add(-1*beforeNow).UnixNano()
+}
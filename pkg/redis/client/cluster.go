@@ -28,9 +28,14 @@ type reply struct {
 }
 
 func NewRedisCluster(cfg config.RedisConfig) (Redis, error) {
+	tlsCfg, err := cfg.TlsConfig()
+	if err != nil {
+		return nil, err
+	}
 	options := &cluster.Options{
 		StartNodes:  cfg.Addresses,
 		Password:    cfg.Password,
+		TlsConfig:   tlsCfg,
 		KeepAlive:   cfg.KeepAlive,
 		AliveTime:   cfg.AliveTime,
 		ConnTimeout: 5 * time.Second,
@@ -97,6 +102,21 @@ func (cc *ClusterRedis) NewBatcher() common.CmdBatcher {
 	return cc.client.NewBatcher()
 }
 
+// NewTxnBatcher returns a batcher which executes commands of a node in a transaction
+func (cc *ClusterRedis) NewTxnBatcher() *cluster.TxnBatcher {
+	return cc.client.NewTxnBatcher()
+}
+
+// NodeOfCmd returns the address of node which the command is sent to
+func (cc *ClusterRedis) NodeOfCmd(cmd string, args ...interface{}) (string, error) {
+	return cc.client.NodeOfCmd(cmd, args...)
+}
+
+// MasterSlots returns slots of master nodes
+func (cc *ClusterRedis) MasterSlots() map[string]*config.RedisSlots {
+	return cc.client.MasterSlots()
+}
+
 // @TODO
 // multi/exec : if slots are crossing, doesn't return error
 func (cc *ClusterRedis) Send(cmd string, args ...interface{}) error {
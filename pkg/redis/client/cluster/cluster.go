@@ -15,6 +15,7 @@
 package redis
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"strconv"
@@ -42,7 +43,9 @@ type Options struct {
 	KeepAlive int           // Maximum keep alive connecion in each node
 	AliveTime time.Duration // Keep alive timeout
 
-	Password string
+	Password config.Secret // resolved for each new connection
+
+	TlsConfig *tls.Config // nil means plaintext
 
 	HandleMoveError bool
 	HandleAskError  bool
@@ -67,7 +70,9 @@ type Cluster struct {
 	updateTime time.Time
 	updateList chan updateMesg
 
-	password string // the whole cluster should only has one password
+	password config.Secret // the whole cluster should only has one password
+
+	tlsConfig *tls.Config
 
 	rwLock sync.RWMutex
 
@@ -102,6 +107,7 @@ func NewCluster(options *Options) (*Cluster, error) {
 		updateList:      make(chan updateMesg),
 		closeCh:         make(chan struct{}),
 		password:        options.Password,
+		tlsConfig:       options.TlsConfig,
 		handleMoveError: options.HandleMoveError,
 		handleAskError:  options.HandleAskError,
 		logger:          log.WithLogger(config.LogModuleName("[redis cluster] ")),
@@ -118,6 +124,7 @@ func NewCluster(options *Options) (*Cluster, error) {
 			keepAlive:    options.KeepAlive,
 			aliveTime:    options.AliveTime,
 			password:     options.Password,
+			tlsConfig:    options.TlsConfig,
 		}
 
 		err := cluster.update(node)
@@ -588,6 +595,7 @@ func (cluster *Cluster) update(node *redisNode) error {
 				keepAlive:    cluster.keepAlive,
 				aliveTime:    cluster.aliveTime,
 				password:     cluster.password,
+				tlsConfig:    cluster.tlsConfig,
 			}
 		}
 
@@ -647,6 +655,41 @@ func (cluster *Cluster) inform(node *redisNode) {
 	}
 }
 
+// NodeOfCmd returns the address of node which the command is sent to, or empty if it's unnecessary to send
+func (cluster *Cluster) NodeOfCmd(cmd string, args ...interface{}) (string, error) {
+	node, err := cluster.ChooseNodeWithCmd(cmd, args...)
+	if err != nil || node == nil {
+		return "", err
+	}
+	return node.address, nil
+}
+
+// MasterSlots returns slots of master nodes, the key is address of node
+func (cluster *Cluster) MasterSlots() map[string]*config.RedisSlots {
+	cluster.rwLock.RLock()
+	defer cluster.rwLock.RUnlock()
+
+	nodeSlots := make(map[string]*config.RedisSlots)
+	for slot := 0; slot < kClusterSlots; slot++ {
+		node := cluster.slots[slot]
+		if node == nil {
+			continue
+		}
+		slots, ok := nodeSlots[node.address]
+		if !ok {
+			slots = &config.RedisSlots{}
+			nodeSlots[node.address] = slots
+		}
+		last := len(slots.Ranges) - 1
+		if last >= 0 && slots.Ranges[last].Right == slot-1 {
+			slots.Ranges[last].Right = slot
+		} else {
+			slots.Ranges = append(slots.Ranges, config.RedisSlotRange{Left: slot, Right: slot})
+		}
+	}
+	return nodeSlots
+}
+
 func (cluster *Cluster) getNodeByAddr(addr string) (*redisNode, error) {
 	cluster.rwLock.RLock()
 	defer cluster.rwLock.RUnlock()
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
 )
 
 func TestNilError(t *testing.T) {
@@ -69,3 +71,21 @@ func TestChooseNodeWithCmd(t *testing.T) {
 		assert.Equal(t, node, expect, "should be equal")
 	}
 }
+
+func TestMasterSlots(t *testing.T) {
+	n1 := &redisNode{address: "127.0.0.1:7000"}
+	n2 := &redisNode{address: "127.0.0.1:7001"}
+	cluster := &Cluster{nodes: map[string]*redisNode{n1.address: n1, n2.address: n2}}
+	for i := 0; i < kClusterSlots; i++ {
+		if i <= 100 || i >= 16000 {
+			cluster.slots[i] = n1
+		} else if i != 200 {
+			cluster.slots[i] = n2
+		}
+	}
+
+	slots := cluster.MasterSlots()
+	assert.Len(t, slots, 2)
+	assert.Equal(t, []config.RedisSlotRange{{Left: 0, Right: 100}, {Left: 16000, Right: 16383}}, slots[n1.address].Ranges)
+	assert.Equal(t, []config.RedisSlotRange{{Left: 101, Right: 199}, {Left: 201, Right: 15999}}, slots[n2.address].Ranges)
+}
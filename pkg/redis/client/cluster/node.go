@@ -18,12 +18,14 @@ package redis
 import (
 	"bufio"
 	"container/list"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/mgtv-tech/redis-GunYu/config"
 	"github.com/mgtv-tech/redis-GunYu/pkg/util"
 )
 
@@ -44,7 +46,9 @@ type redisNode struct {
 
 	closed bool
 
-	password string
+	password config.Secret
+
+	tlsConfig *tls.Config
 
 	accessTime atomic.Int64
 }
@@ -118,7 +122,7 @@ func (node *redisNode) getConn() (*redisConn, error) {
 	if node.conns.Len() <= 0 {
 		node.mutex.Unlock()
 
-		c, err := net.DialTimeout("tcp", node.address, node.connTimeout)
+		c, err := node.dial()
 		if err != nil {
 			return nil, err
 		}
@@ -132,7 +136,12 @@ func (node *redisNode) getConn() (*redisConn, error) {
 		}
 
 		if node.password != "" {
-			err = conn.auth(node.password)
+			password, err := node.password.Value()
+			if err != nil {
+				conn.shutdown()
+				return nil, err
+			}
+			err = conn.auth(password)
 			if err != nil {
 				return nil, err
 			}
@@ -149,6 +158,14 @@ func (node *redisNode) getConn() (*redisConn, error) {
 	return elem.Value.(*redisConn), nil
 }
 
+func (node *redisNode) dial() (net.Conn, error) {
+	if node.tlsConfig != nil {
+		dialer := &net.Dialer{Timeout: node.connTimeout}
+		return tls.DialWithDialer(dialer, "tcp", node.address, node.tlsConfig)
+	}
+	return net.DialTimeout("tcp", node.address, node.connTimeout)
+}
+
 func (node *redisNode) releaseConn(conn *redisConn) {
 	node.mutex.Lock()
 	defer node.mutex.Unlock()
@@ -8,7 +8,8 @@ import (
 	"github.com/mgtv-tech/redis-GunYu/pkg/util"
 )
 
-type txnBatcher struct {
+// TxnBatcher executes commands in a transaction, all commands should be hashed in the same node
+type TxnBatcher struct {
 	err     error
 	cluster *Cluster
 	cmds    []string
@@ -16,16 +17,29 @@ type txnBatcher struct {
 	node    *redisNode
 }
 
-func (tb *txnBatcher) joinError(err error) error {
+func (tb *TxnBatcher) joinError(err error) error {
 	tb.err = errors.Join(tb.err, err)
 	return err
 }
 
-func (tb *txnBatcher) Len() int {
+// NewTxnBatcher returns a transaction batcher, the node is chosen by the first command
+func (cluster *Cluster) NewTxnBatcher() *TxnBatcher {
+	return &TxnBatcher{cluster: cluster}
+}
+
+// Node returns the address of the node, or empty if no command is put
+func (tb *TxnBatcher) Node() string {
+	if tb.node == nil {
+		return ""
+	}
+	return tb.node.address
+}
+
+func (tb *TxnBatcher) Len() int {
 	return len(tb.cmds)
 }
 
-func (tb *txnBatcher) Put(cmd string, args ...interface{}) error {
+func (tb *TxnBatcher) Put(cmd string, args ...interface{}) error {
 	node, err := tb.cluster.ChooseNodeWithCmd(cmd, args...)
 	if err != nil {
 		return tb.joinError(fmt.Errorf("run ChooseNodeWithCmd error : %w", err))
@@ -51,10 +65,13 @@ func (tb *txnBatcher) Put(cmd string, args ...interface{}) error {
 	return nil
 }
 
-func (tb *txnBatcher) Exec() ([]interface{}, error) {
+func (tb *TxnBatcher) Exec() ([]interface{}, error) {
 	if tb.err != nil {
 		return nil, tb.err
 	}
+	if tb.node == nil {
+		return []interface{}{}, nil
+	}
 	conn, err := tb.node.getConn()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,168 @@
+package common
+
+import "strconv"
+
+// KeyPosition is the position of keys in a command, it's same as the first key, last key and step of COMMAND INFO,
+// a negative last key counts from the end of arguments
+type KeyPosition struct {
+	First, Last, Step int
+}
+
+var genericKeyPos = KeyPosition{1, 1, 1}
+
+// refers to redisShake, under MIT LICENSE
+var commandKeyPositions = map[string]KeyPosition{
+	"set":              genericKeyPos,
+	"setnx":            genericKeyPos,
+	"setex":            genericKeyPos,
+	"psetex":           genericKeyPos,
+	"append":           genericKeyPos,
+	"setbit":           genericKeyPos,
+	"bitfield":         genericKeyPos,
+	"setrange":         genericKeyPos,
+	"move":             genericKeyPos,
+	"incr":             genericKeyPos,
+	"decr":             genericKeyPos,
+	"rpush":            genericKeyPos,
+	"lpush":            genericKeyPos,
+	"rpushx":           genericKeyPos,
+	"lpushx":           genericKeyPos,
+	"linsert":          genericKeyPos,
+	"rpop":             genericKeyPos,
+	"lpop":             genericKeyPos,
+	"brpop":            {1, -2, 1},
+	"brpoplpush":       {1, 2, 1},
+	"blpop":            {1, -2, 1},
+	"lset":             genericKeyPos,
+	"ltrim":            genericKeyPos,
+	"lrem":             genericKeyPos,
+	"rpoplpush":        {1, 2, 1},
+	"lmove":            {1, 2, 1},
+	"blmove":           {1, 2, 1},
+	"sadd":             genericKeyPos,
+	"srem":             genericKeyPos,
+	"smove":            {1, 2, 1},
+	"spop":             genericKeyPos,
+	"sinterstore":      {1, -1, 1},
+	"sunionstore":      {1, -1, 1},
+	"sdiffstore":       {1, -1, 1},
+	"zadd":             genericKeyPos,
+	"zincrby":          genericKeyPos,
+	"zrem":             genericKeyPos,
+	"zremrangebyscore": genericKeyPos,
+	"zremrangebyrank":  genericKeyPos,
+	"zremrangebylex":   genericKeyPos,
+	"zpopmin":          genericKeyPos,
+	"zpopmax":          genericKeyPos,
+	"hset":             genericKeyPos,
+	"hsetnx":           genericKeyPos,
+	"hmset":            genericKeyPos,
+	"hincrby":          genericKeyPos,
+	"hincrbyfloat":     genericKeyPos,
+	"hdel":             genericKeyPos,
+	"hexpire":          genericKeyPos, // hash field expiration, redis 7.4
+	"hpexpire":         genericKeyPos,
+	"hexpireat":        genericKeyPos,
+	"hpexpireat":       genericKeyPos,
+	"hpersist":         genericKeyPos,
+	"hsetex":           genericKeyPos, // redis 8.0
+	"hgetex":           genericKeyPos,
+	"hgetdel":          genericKeyPos,
+	"incrby":           genericKeyPos,
+	"decrby":           genericKeyPos,
+	"incrbyfloat":      genericKeyPos,
+	"getset":           genericKeyPos,
+	"getdel":           genericKeyPos,
+	"mset":             {1, -1, 2},
+	"msetnx":           {1, -1, 2},
+	"rename":           {1, 2, 1},
+	"renamenx":         {1, 2, 1},
+	"expire":           genericKeyPos,
+	"expireat":         genericKeyPos,
+	"pexpire":          genericKeyPos,
+	"pexpireat":        genericKeyPos,
+	"persist":          genericKeyPos,
+	"restore":          genericKeyPos,
+	"restore-asking":   genericKeyPos,
+	"bitop":            {2, -1, 1},
+	"geoadd":           genericKeyPos,
+	"pfadd":            genericKeyPos,
+	"pfmerge":          {1, -1, 1},
+	"del":              {1, -1, 1},
+	"unlink":           {1, -1, 1},
+	"copy":             {1, 2, 1},
+	"zrangestore":      {1, 2, 1},
+	"geosearchstore":   {1, 2, 1},
+	"object":           {2, 2, 1}, // the first argument is a subcommand
+	"memory":           {2, 2, 1},
+	"xgroup":           {2, 2, 1},
+	"xinfo":            {2, 2, 1},
+}
+
+// numkeys commands, e.g. zunionstore destination numkeys key [key ...],
+// the value is the index of numkeys, arguments before numkeys are keys
+var numKeysPositions = map[string]int{
+	"zunionstore": 1,
+	"zinterstore": 1,
+	"zdiffstore":  1,
+	"lmpop":       0,
+	"zmpop":       0,
+}
+
+// CommandKeyPosition returns the position of keys of the command(in lowercase), it returns false if the command is unknown
+func CommandKeyPosition(cmd string) (KeyPosition, bool) {
+	pos, ok := commandKeyPositions[cmd]
+	return pos, ok
+}
+
+// CommandKeyIndexes returns indexes of keys in args, args don't contain the command name.
+// it returns nil if the command(in lowercase) is unknown
+func CommandKeyIndexes(cmd string, args []interface{}) []int {
+	if numkeys, ok := numKeysPositions[cmd]; ok {
+		if numkeys >= len(args) {
+			return nil
+		}
+		var nr int
+		var err error
+		switch v := args[numkeys].(type) {
+		case []byte:
+			nr, err = strconv.Atoi(string(v))
+		case string:
+			nr, err = strconv.Atoi(v)
+		default:
+			return nil
+		}
+		if err != nil || nr < 0 || numkeys+1+nr > len(args) {
+			return nil
+		}
+		indexes := []int{}
+		for i := 0; i < numkeys; i++ {
+			indexes = append(indexes, i)
+		}
+		for i := 0; i < nr; i++ {
+			indexes = append(indexes, numkeys+1+i)
+		}
+		return indexes
+	}
+	return KeyIndexes(cmd, len(args))
+}
+
+// KeyIndexes returns indexes of keys by the position of keys, numkeys commands are not supported
+func KeyIndexes(cmd string, argc int) []int {
+	pos, ok := commandKeyPositions[cmd]
+	if !ok || argc == 0 {
+		return nil
+	}
+	lastkey := pos.Last - 1
+	if pos.Last < 0 { // -1 is the last argument
+		lastkey = argc + pos.Last
+	}
+	if lastkey >= argc {
+		lastkey = argc - 1
+	}
+	indexes := []int{}
+	for i := pos.First - 1; i <= lastkey; i += pos.Step {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
@@ -37,7 +37,12 @@ func NewRedisConn(cfg config.RedisConfig) (*RedisConn, error) {
 	var err error
 	dialer.Timeout = 3 * time.Second
 	if cfg.TlsEnable {
-		r.conn, err = tls.DialWithDialer(&dialer, "tcp", cfg.Address(), &tls.Config{InsecureSkipVerify: true})
+		var tlsCfg *tls.Config
+		tlsCfg, err = cfg.TlsConfig()
+		if err != nil {
+			return nil, fmt.Errorf("tls config error. address(%s), err(%w)", cfg.Address(), err)
+		}
+		r.conn, err = tls.DialWithDialer(&dialer, "tcp", cfg.Address(), tlsCfg)
 	} else {
 		r.conn, err = dialer.Dial("tcp", cfg.Address())
 	}
@@ -51,11 +56,15 @@ func NewRedisConn(cfg config.RedisConfig) (*RedisConn, error) {
 	// auth
 	if cfg.Password != "" {
 		var reply string
-		var err error
-		if cfg.UserName != "" {
-			reply, err = r.doGetString("auth", cfg.UserName, cfg.Password)
+		userName, password, err := resolveCredentials(cfg)
+		if err != nil {
+			r.conn.Close()
+			return nil, err
+		}
+		if userName != "" {
+			reply, err = r.doGetString("auth", userName, password)
 		} else {
-			reply, err = r.doGetString("auth", cfg.Password)
+			reply, err = r.doGetString("auth", password)
 		}
 		if err != nil {
 			return nil, err
@@ -77,6 +86,19 @@ func NewRedisConn(cfg config.RedisConfig) (*RedisConn, error) {
 	return r, nil
 }
 
+// resolveCredentials resolves secrets for each connection, rotated credentials are used by new connections
+func resolveCredentials(cfg config.RedisConfig) (string, string, error) {
+	userName, err := cfg.UserName.Value()
+	if err != nil {
+		return "", "", err
+	}
+	password, err := cfg.Password.Value()
+	if err != nil {
+		return "", "", err
+	}
+	return userName, password, nil
+}
+
 func (r *RedisConn) Close() error {
 	// @TODO graceful close
 	err := r.conn.Close()
@@ -2,6 +2,8 @@ package client
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 
 	"github.com/mgtv-tech/redis-GunYu/config"
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
@@ -33,5 +35,31 @@ func NewRedis(cfg config.RedisConfig) (Redis, error) {
 	if cfg.IsCluster() {
 		return NewRedisCluster(cfg)
 	}
+	if cfg.Proxy {
+		return NewRedisProxy(cfg)
+	}
+	if cfg.IsSharded() {
+		return NewRedisSharded(cfg)
+	}
 	return conn.NewRedisConn(cfg)
 }
+
+// DoOnAllNodes executes the command on all nodes of cluster, or once on others,
+// it's used by commands which are not routed by keys, e.g. loading scripts and functions.
+// sharded redis executes them on all shards
+func DoOnAllNodes(cli Redis, cmd string, args ...interface{}) error {
+	if cli.RedisType() != config.RedisTypeCluster {
+		_, err := cli.Do(cmd, args...)
+		return err
+	}
+	var errs []error
+	cli.IterateNodes(func(addr string, reply interface{}, err error) {
+		if re, ok := reply.(common.RedisError); ok && err == nil {
+			err = re
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node(%s) : %w", addr, err))
+		}
+	}, cmd, args...)
+	return errors.Join(errs...)
+}
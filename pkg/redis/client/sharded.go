@@ -0,0 +1,408 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/conn"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/sharding"
+)
+
+// ShardedRedis distributes commands to multiple standalone redis by keys, like twemproxy.
+// commands without keys are sent to the home shard, which owns the checkpoint key,
+// so "info keyspace" and checkpoints are in the same redis.
+// It also sends commands to a proxy, select is dropped and multi-key commands are split into single-key forms.
+type ShardedRedis struct {
+	conns    []*conn.RedisConn
+	sharding *sharding.Sharding // nil for proxy
+	proxy    bool
+	home     int
+	recvChan chan reply
+	batcher  common.CmdBatcher
+	cfg      config.RedisConfig
+	logger   log.Logger
+}
+
+func NewRedisSharded(cfg config.RedisConfig) (Redis, error) {
+	sd, err := sharding.New(cfg.Sharding.Options())
+	if err != nil {
+		return nil, err
+	}
+	sr := &ShardedRedis{
+		sharding: sd,
+		home:     sd.Index(config.CheckpointKey),
+		recvChan: make(chan reply, RecvChanSize),
+		cfg:      cfg,
+		logger:   log.WithLogger(config.LogModuleName("[Redis sharded] ")),
+	}
+	for i := range cfg.Addresses {
+		c, err := conn.NewRedisConn(cfg.Index(i))
+		if err != nil {
+			sr.Close()
+			return nil, err
+		}
+		sr.conns = append(sr.conns, c)
+	}
+	return sr, nil
+}
+
+// NewRedisProxy returns a client of proxy, which only supports commands of single key and db 0
+func NewRedisProxy(cfg config.RedisConfig) (Redis, error) {
+	c, err := conn.NewRedisConn(cfg.Index(0))
+	if err != nil {
+		return nil, err
+	}
+	return &ShardedRedis{
+		conns:    []*conn.RedisConn{c},
+		proxy:    true,
+		recvChan: make(chan reply, RecvChanSize),
+		cfg:      cfg,
+		logger:   log.WithLogger(config.LogModuleName("[Redis proxy] ")),
+	}, nil
+}
+
+func (sr *ShardedRedis) Close() error {
+	var err error
+	for _, c := range sr.conns {
+		err = errors.Join(err, c.Close())
+	}
+	return err
+}
+
+func (sr *ShardedRedis) Addresses() []string {
+	return sr.cfg.Addresses
+}
+
+func (sr *ShardedRedis) RedisType() config.RedisType {
+	return config.RedisTypeStandalone
+}
+
+func (sr *ShardedRedis) Do(cmd string, args ...interface{}) (interface{}, error) {
+	sc, err := sr.split(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(sc.parts))
+	for i, part := range sc.parts {
+		replies[i], err = sr.conns[part.shard].Do(cmd, part.args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sc.merge(replies), nil
+}
+
+func (sr *ShardedRedis) IterateNodes(result func(string, interface{}, error), cmd string, args ...interface{}) {
+	for i, c := range sr.conns {
+		ret, err := c.Do(cmd, args...)
+		result(sr.cfg.Addresses[i], ret, err)
+	}
+}
+
+func (sr *ShardedRedis) Send(cmd string, args ...interface{}) error {
+	return sr.getBatcher().Put(cmd, args...)
+}
+
+func (sr *ShardedRedis) SendAndFlush(cmd string, args ...interface{}) error {
+	err := sr.getBatcher().Put(cmd, args...)
+	if err != nil {
+		return err
+	}
+	return sr.Flush()
+}
+
+// not thread safe
+func (sr *ShardedRedis) getBatcher() common.CmdBatcher {
+	if sr.batcher == nil {
+		sr.batcher = sr.NewBatcher()
+	}
+	return sr.batcher
+}
+
+func (sr *ShardedRedis) Receive() (interface{}, error) {
+	ret := <-sr.recvChan
+	return ret.answer, ret.err
+}
+
+func (sr *ShardedRedis) ReceiveString() (string, error) {
+	ret := <-sr.recvChan
+	return common.String(ret.answer, ret.err)
+}
+
+func (sr *ShardedRedis) ReceiveBool() (bool, error) {
+	ret := <-sr.recvChan
+	return common.Bool(ret.answer, ret.err)
+}
+
+func (sr *ShardedRedis) BufioReader() *bufio.Reader {
+	return nil
+}
+
+func (sr *ShardedRedis) BufioWriter() *bufio.Writer {
+	return nil
+}
+
+// send batcher and put the return into recvChan
+func (sr *ShardedRedis) Flush() error {
+	if sr.batcher == nil {
+		return nil
+	}
+	ret, err := sr.batcher.Exec()
+	sr.batcher = nil
+	if err != nil {
+		sr.recvChan <- reply{err: err}
+		return err
+	}
+	for _, ele := range ret {
+		sr.recvChan <- reply{answer: ele}
+	}
+	return nil
+}
+
+func (sr *ShardedRedis) NewBatcher() common.CmdBatcher {
+	return &shardedBatcher{redis: sr}
+}
+
+type cmdPart struct {
+	shard int
+	args  []interface{}
+}
+
+type shardedCmd struct {
+	cmd   string
+	parts []cmdPart
+	merge func([]interface{}) interface{}
+}
+
+// split splits a command to shards, multi-key commands are split by keys and replies are merged
+func (sr *ShardedRedis) split(cmd string, args []interface{}) (*shardedCmd, error) {
+	sc := &shardedCmd{cmd: cmd, merge: firstReply}
+	upper := strings.ToUpper(cmd)
+	if sr.proxy && (upper == "SELECT" || upper == "SWAPDB") { // proxies only have db 0
+		return sc, nil
+	}
+	switch upper {
+	case "SELECT", "FLUSHDB", "FLUSHALL", "SWAPDB", "FUNCTION", "SCRIPT":
+		for i := range sr.conns {
+			sc.parts = append(sc.parts, cmdPart{shard: i, args: args})
+		}
+	case "PING", "INFO", "ECHO", "TIME", "CONFIG", "CLIENT", "COMMAND":
+		sc.parts = []cmdPart{{shard: sr.home, args: args}}
+	case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
+		return nil, fmt.Errorf("sharded redis or proxy does not support transaction : %s", cmd)
+	case "DEL", "UNLINK", "EXISTS", "TOUCH":
+		parts, err := sr.splitKeys(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		sc.parts = parts
+		sc.merge = sumReplies
+	case "MSET":
+		parts, err := sr.splitKeys(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		sc.parts = parts
+	case "MSETNX":
+		parts, err := sr.splitKeys(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) > 1 {
+			return nil, errors.Join(common.ErrCrossSlots, fmt.Errorf("keys of msetnx are not in the same shard, or it's a proxy"))
+		}
+		sc.parts = parts
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("illegal %s parameter : %v", cmd, args)
+		}
+		nr, err := strconv.Atoi(argString(args[1]))
+		if err != nil || nr < 0 || len(args) < 2+nr {
+			return nil, fmt.Errorf("illegal %s key number : %v", cmd, args[1])
+		}
+		shard := sr.home
+		for i := 0; i < nr; i++ {
+			cur := sr.shardOf(args[2+i])
+			if i == 0 {
+				shard = cur
+			} else if shard != cur {
+				return nil, errors.Join(common.ErrCrossSlots, fmt.Errorf("keys of %s are not in the same shard", cmd))
+			}
+		}
+		sc.parts = []cmdPart{{shard: shard, args: args}}
+	default:
+		shard, err := sr.shardOfCmd(strings.ToLower(cmd), args)
+		if err != nil {
+			return nil, err
+		}
+		sc.parts = []cmdPart{{shard: shard, args: args}}
+	}
+	return sc, nil
+}
+
+// shardOfCmd returns the shard of keys of the command, keys should be in the same shard.
+// unknown commands are routed by the first argument, commands without keys are routed to the home shard
+func (sr *ShardedRedis) shardOfCmd(cmd string, args []interface{}) (int, error) {
+	keys := common.CommandKeyIndexes(cmd, args)
+	if keys == nil {
+		if len(args) == 0 {
+			return sr.home, nil
+		}
+		return sr.shardOf(args[0]), nil
+	}
+	shard := sr.home
+	for i, idx := range keys {
+		cur := sr.shardOf(args[idx])
+		if i == 0 {
+			shard = cur
+		} else if shard != cur {
+			return 0, errors.Join(common.ErrCrossSlots, fmt.Errorf("keys of %s are not in the same shard", cmd))
+		}
+	}
+	return shard, nil
+}
+
+func (sr *ShardedRedis) shardOf(key interface{}) int {
+	if sr.sharding == nil {
+		return 0
+	}
+	return sr.sharding.Index(argString(key))
+}
+
+// splitKeys groups keys by shards, step is the number of arguments of each key.
+// for proxy, each key is a part
+func (sr *ShardedRedis) splitKeys(args []interface{}, step int) ([]cmdPart, error) {
+	if len(args) == 0 || len(args)%step != 0 {
+		return nil, fmt.Errorf("wrong number of arguments : %d", len(args))
+	}
+	parts := []cmdPart{}
+	index := make(map[int]int)
+	for i := 0; i < len(args); i += step {
+		shard := sr.shardOf(args[i])
+		p, ok := index[shard]
+		if !ok || sr.proxy {
+			p = len(parts)
+			index[shard] = p
+			parts = append(parts, cmdPart{shard: shard})
+		}
+		parts[p].args = append(parts[p].args, args[i:i+step]...)
+	}
+	return parts, nil
+}
+
+func firstReply(replies []interface{}) interface{} {
+	if len(replies) == 0 { // dropped
+		return "OK"
+	}
+	for _, r := range replies {
+		if _, ok := r.(error); ok {
+			return r
+		}
+	}
+	return replies[0]
+}
+
+func sumReplies(replies []interface{}) interface{} {
+	sum := int64(0)
+	for _, r := range replies {
+		switch v := r.(type) {
+		case int64:
+			sum += v
+		case error:
+			return v
+		}
+	}
+	return sum
+}
+
+func argString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(arg)
+}
+
+// shardedBatcher groups commands by shards, executes them in parallel, and returns replies in order
+type shardedBatcher struct {
+	redis *ShardedRedis
+	cmds  []*shardedCmd
+	err   error
+}
+
+func (sb *shardedBatcher) Put(cmd string, args ...interface{}) error {
+	sc, err := sb.redis.split(cmd, args)
+	if err != nil {
+		if sb.err == nil {
+			sb.err = err
+		}
+		return err
+	}
+	sb.cmds = append(sb.cmds, sc)
+	return nil
+}
+
+func (sb *shardedBatcher) Len() int {
+	return len(sb.cmds)
+}
+
+func (sb *shardedBatcher) Exec() ([]interface{}, error) {
+	if sb.err != nil {
+		return nil, sb.err
+	}
+
+	type position struct {
+		shard int
+		index int
+	}
+	batchers := make([]common.CmdBatcher, len(sb.redis.conns))
+	positions := make([][]position, len(sb.cmds))
+	for i, sc := range sb.cmds {
+		for _, part := range sc.parts {
+			if batchers[part.shard] == nil {
+				batchers[part.shard] = sb.redis.conns[part.shard].NewBatcher()
+			}
+			positions[i] = append(positions[i], position{shard: part.shard, index: batchers[part.shard].Len()})
+			batchers[part.shard].Put(sc.cmd, part.args...)
+		}
+	}
+
+	shardReplies := make([][]interface{}, len(batchers))
+	shardErrs := make([]error, len(batchers))
+	wg := sync.WaitGroup{}
+	for i, b := range batchers {
+		if b == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, b common.CmdBatcher) {
+			defer wg.Done()
+			shardReplies[i], shardErrs[i] = b.Exec()
+		}(i, b)
+	}
+	wg.Wait()
+	for i, err := range shardErrs {
+		if err != nil {
+			return nil, fmt.Errorf("shard(%s) : %w", sb.redis.cfg.Addresses[i], err)
+		}
+	}
+
+	replies := make([]interface{}, 0, len(sb.cmds))
+	for i, sc := range sb.cmds {
+		parts := make([]interface{}, len(positions[i]))
+		for j, pos := range positions[i] {
+			parts[j] = shardReplies[pos.shard][pos.index]
+		}
+		replies = append(replies, sc.merge(parts))
+	}
+	return replies, nil
+}
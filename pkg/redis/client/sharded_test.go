@@ -0,0 +1,139 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/conn"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/sharding"
+)
+
+func TestShardedSplit(t *testing.T) {
+	sd, err := sharding.New(sharding.Options{Names: []string{"a", "b", "c"}, HashTag: "{}"})
+	assert.Nil(t, err)
+	sr := &ShardedRedis{conns: make([]*conn.RedisConn, 3), sharding: sd, home: 1}
+
+	sc, err := sr.split("select", []interface{}{"1"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(sc.parts))
+
+	sc, err = sr.split("info", []interface{}{"keyspace"})
+	assert.Nil(t, err)
+	assert.Equal(t, []cmdPart{{shard: 1, args: []interface{}{"keyspace"}}}, sc.parts)
+
+	sc, err = sr.split("set", []interface{}{[]byte("k1"), []byte("v")})
+	assert.Nil(t, err)
+	assert.Equal(t, sd.Index("k1"), sc.parts[0].shard)
+
+	keys := []interface{}{}
+	for _, k := range []string{"k1", "k2", "k3", "k4", "k5", "k6"} {
+		keys = append(keys, []byte(k))
+	}
+	sc, err = sr.split("del", keys)
+	assert.Nil(t, err)
+	total := 0
+	for _, p := range sc.parts {
+		for _, k := range p.args {
+			assert.Equal(t, p.shard, sd.Index(string(k.([]byte))))
+		}
+		total += len(p.args)
+	}
+	assert.Equal(t, len(keys), total)
+	assert.Equal(t, int64(3), sc.merge([]interface{}{int64(1), int64(2)}))
+
+	sc, err = sr.split("mset", []interface{}{"{u}1", "v", "{u}2", "v"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(sc.parts))
+
+	_, err = sr.split("mset", []interface{}{"k1", "v", "k2"})
+	assert.NotNil(t, err)
+
+	sc, err = sr.split("evalsha", []interface{}{"sha", "2", "{u}1", "{u}2", "arg"})
+	assert.Nil(t, err)
+	assert.Equal(t, sd.Index("u"), sc.parts[0].shard)
+
+	sc, err = sr.split("eval", []interface{}{"return 1", "0"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sc.parts[0].shard)
+
+	_, err = sr.split("multi", nil)
+	assert.NotNil(t, err)
+
+	other := "k2"
+	for i := 2; sd.Index(other) == sd.Index("k1"); i++ {
+		other = fmt.Sprintf("k%d", i)
+	}
+	_, err = sr.split("msetnx", []interface{}{"k1", "v", other, "v"})
+	assert.True(t, errors.Is(err, common.ErrCrossSlots))
+
+	// keys are not the first argument
+	for _, c := range []struct {
+		cmd  string
+		args []interface{}
+	}{
+		{"bitop", []interface{}{"and", "{u}dest", "{u}1", "{u}2"}},
+		{"OBJECT", []interface{}{"encoding", "{u}1"}},
+		{"memory", []interface{}{"usage", "{u}1"}},
+		{"xgroup", []interface{}{"create", "{u}1", "group", "$"}},
+		{"zunionstore", []interface{}{"{u}dest", "2", "{u}1", "{u}2", "weights", "1", "2"}},
+	} {
+		sc, err = sr.split(c.cmd, c.args)
+		assert.Nil(t, err, c.cmd)
+		assert.Equal(t, sd.Index("u"), sc.parts[0].shard, c.cmd)
+	}
+	sc, err = sr.split("memory", []interface{}{"stats"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sc.parts[0].shard)
+
+	// keys of multi-key commands are in different shards
+	for _, c := range []struct {
+		cmd  string
+		args []interface{}
+	}{
+		{"rename", []interface{}{"k1", other}},
+		{"smove", []interface{}{"k1", other, "m"}},
+		{"lmove", []interface{}{"k1", other, "left", "right"}},
+		{"rpoplpush", []interface{}{"k1", other}},
+		{"sinterstore", []interface{}{"k1", other}},
+		{"zunionstore", []interface{}{"k1", "1", other}},
+		{"copy", []interface{}{"k1", other}},
+		{"bitop", []interface{}{"or", "k1", other}},
+	} {
+		_, err = sr.split(c.cmd, c.args)
+		assert.True(t, errors.Is(err, common.ErrCrossSlots), c.cmd)
+	}
+	sc, err = sr.split("rename", []interface{}{"{u}1", "{u}2"})
+	assert.Nil(t, err)
+	assert.Equal(t, sd.Index("u"), sc.parts[0].shard)
+}
+
+func TestProxySplit(t *testing.T) {
+	sr := &ShardedRedis{conns: make([]*conn.RedisConn, 1), proxy: true}
+
+	sc, err := sr.split("select", []interface{}{"1"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(sc.parts))
+	assert.Equal(t, "OK", sc.merge(nil))
+
+	sc, err = sr.split("del", []interface{}{"k1", "k2", "k3"})
+	assert.Nil(t, err)
+	assert.Equal(t, []cmdPart{{args: []interface{}{"k1"}}, {args: []interface{}{"k2"}}, {args: []interface{}{"k3"}}}, sc.parts)
+
+	sc, err = sr.split("mset", []interface{}{"k1", "v1", "k2", "v2"})
+	assert.Nil(t, err)
+	assert.Equal(t, []cmdPart{{args: []interface{}{"k1", "v1"}}, {args: []interface{}{"k2", "v2"}}}, sc.parts)
+
+	_, err = sr.split("msetnx", []interface{}{"k1", "v1", "k2", "v2"})
+	assert.True(t, errors.Is(err, common.ErrCrossSlots))
+
+	sc, err = sr.split("set", []interface{}{"k1", "v1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []cmdPart{{args: []interface{}{"k1", "v1"}}}, sc.parts)
+
+	_, err = sr.split("multi", nil)
+	assert.NotNil(t, err)
+}
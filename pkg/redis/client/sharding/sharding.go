@@ -0,0 +1,189 @@
+package sharding
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/digest"
+)
+
+// distributions and hash functions are compatible with twemproxy
+const (
+	DistributionKetama = "ketama"
+	DistributionModula = "modula"
+
+	HashFnv1a64 = "fnv1a_64"
+	HashFnv1a32 = "fnv1a_32"
+	HashMd5     = "md5"
+	HashCrc16   = "crc16"
+	HashCrc32a  = "crc32a"
+
+	ketamaPointsPerServer = 160
+	ketamaPointsPerHash   = 4
+)
+
+type Options struct {
+	Distribution string
+	Hash         string
+	HashTag      string   // two characters, e.g. "{}", empty means the whole key
+	Names        []string // names of servers, ketama hashes names to the continuum
+	Weights      []int    // weights of servers, default is 1
+}
+
+type point struct {
+	value uint32
+	index int
+}
+
+// Sharding maps a key to a server
+type Sharding struct {
+	hash      func(string) uint32
+	hashTag   string
+	modula    bool
+	continuum []point
+}
+
+func New(opts Options) (*Sharding, error) {
+	if len(opts.Names) == 0 {
+		return nil, fmt.Errorf("sharding : no server")
+	}
+	if len(opts.Weights) != 0 && len(opts.Weights) != len(opts.Names) {
+		return nil, fmt.Errorf("sharding : the amount of weights does not equal servers : %d != %d", len(opts.Weights), len(opts.Names))
+	}
+	if opts.HashTag != "" && len(opts.HashTag) != 2 {
+		return nil, fmt.Errorf("sharding : hash tag should be two characters : %s", opts.HashTag)
+	}
+	hash, err := HashFunc(opts.Hash)
+	if err != nil {
+		return nil, err
+	}
+	weights := make([]int, len(opts.Names))
+	for i := range weights {
+		weights[i] = 1
+		if len(opts.Weights) != 0 {
+			if opts.Weights[i] <= 0 {
+				return nil, fmt.Errorf("sharding : weight should be greater than 0 : %s(%d)", opts.Names[i], opts.Weights[i])
+			}
+			weights[i] = opts.Weights[i]
+		}
+	}
+
+	s := &Sharding{
+		hash:    hash,
+		hashTag: opts.HashTag,
+	}
+	switch strings.ToLower(opts.Distribution) {
+	case "", DistributionKetama:
+		s.continuum = ketamaContinuum(opts.Names, weights)
+	case DistributionModula:
+		s.modula = true
+		for i, w := range weights {
+			for j := 0; j < w; j++ {
+				s.continuum = append(s.continuum, point{index: i})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("sharding : unknown distribution : %s", opts.Distribution)
+	}
+	return s, nil
+}
+
+// HashFunc returns the hash function by name, default is fnv1a_64
+func HashFunc(name string) (func(string) uint32, error) {
+	switch strings.ToLower(name) {
+	case "", HashFnv1a64:
+		return hashFnv1a64, nil
+	case HashFnv1a32:
+		return func(key string) uint32 {
+			h := fnv.New32a()
+			h.Write([]byte(key))
+			return h.Sum32()
+		}, nil
+	case HashMd5:
+		return func(key string) uint32 {
+			return ketamaHash(key, 0)
+		}, nil
+	case HashCrc16:
+		return func(key string) uint32 {
+			return uint32(digest.Crc16(key))
+		}, nil
+	case HashCrc32a:
+		return func(key string) uint32 {
+			return crc32.ChecksumIEEE([]byte(key))
+		}, nil
+	}
+	return nil, fmt.Errorf("sharding : unknown hash : %s", name)
+}
+
+// Index returns the index of server which the key belongs to
+func (s *Sharding) Index(key string) int {
+	hash := s.hash(s.hashKey(key))
+	if s.modula {
+		return s.continuum[hash%uint32(len(s.continuum))].index
+	}
+	i := sort.Search(len(s.continuum), func(i int) bool {
+		return s.continuum[i].value >= hash
+	})
+	if i == len(s.continuum) {
+		i = 0
+	}
+	return s.continuum[i].index
+}
+
+// hashKey returns the content between hash tags, or the whole key if there is no tag or the content is empty
+func (s *Sharding) hashKey(key string) string {
+	if s.hashTag == "" {
+		return key
+	}
+	left := strings.IndexByte(key, s.hashTag[0])
+	if left < 0 {
+		return key
+	}
+	right := strings.IndexByte(key[left+1:], s.hashTag[1])
+	if right <= 0 {
+		return key
+	}
+	return key[left+1 : left+1+right]
+}
+
+// twemproxy truncates the 64 bits fnv1a to 32 bits
+func hashFnv1a64(key string) uint32 {
+	hash := uint32(0xcbf29ce484222325 & 0xffffffff)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= uint32(0x100000001b3 & 0xffffffff)
+	}
+	return hash
+}
+
+func ketamaHash(key string, alignment int) uint32 {
+	sum := md5.Sum([]byte(key))
+	return binary.LittleEndian.Uint32(sum[alignment*4:])
+}
+
+func ketamaContinuum(names []string, weights []int) []point {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	continuum := []point{}
+	for i, name := range names {
+		pct := float64(weights[i]) / float64(total)
+		points := int(pct*ketamaPointsPerServer/ketamaPointsPerHash*float64(len(names))+0.0000000001) * ketamaPointsPerHash
+		for p := 0; p < points/ketamaPointsPerHash; p++ {
+			host := fmt.Sprintf("%s-%d", name, p)
+			for x := 0; x < ketamaPointsPerHash; x++ {
+				continuum = append(continuum, point{value: ketamaHash(host, x), index: i})
+			}
+		}
+	}
+	sort.SliceStable(continuum, func(i, j int) bool {
+		return continuum[i].value < continuum[j].value
+	})
+	return continuum
+}
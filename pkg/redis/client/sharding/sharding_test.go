@@ -0,0 +1,89 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashFunc(t *testing.T) {
+	fnv, err := HashFunc(HashFnv1a64)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0x84222325), fnv(""))
+
+	crc16, err := HashFunc(HashCrc16)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0x31c3), crc16("123456789"))
+
+	crc32a, err := HashFunc(HashCrc32a)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0xcbf43926), crc32a("123456789"))
+
+	_, err = HashFunc("murmur")
+	assert.NotNil(t, err)
+}
+
+func TestSharding(t *testing.T) {
+	names := []string{"127.0.0.1:6379", "127.0.0.1:6380", "127.0.0.1:6381"}
+
+	t.Run("ketama", func(t *testing.T) {
+		sd, err := New(Options{Distribution: DistributionKetama, Names: names})
+		assert.Nil(t, err)
+		assert.Equal(t, 160*len(names), len(sd.continuum))
+
+		counts := make([]int, len(names))
+		for i := 0; i < 3000; i++ {
+			key := fmt.Sprintf("key%d", i)
+			idx := sd.Index(key)
+			assert.Equal(t, idx, sd.Index(key))
+			counts[idx]++
+		}
+		for _, c := range counts {
+			assert.Greater(t, c, 500)
+		}
+	})
+
+	t.Run("modula", func(t *testing.T) {
+		sd, err := New(Options{Distribution: DistributionModula, Hash: HashCrc16, Names: names})
+		assert.Nil(t, err)
+		assert.Equal(t, int(0x31c3%3), sd.Index("123456789"))
+	})
+
+	t.Run("weights", func(t *testing.T) {
+		sd, err := New(Options{Distribution: DistributionModula, Hash: HashCrc32a, Names: names[:2], Weights: []int{1, 3}})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(sd.continuum))
+
+		sd, err = New(Options{Distribution: DistributionKetama, Names: names[:2], Weights: []int{1, 3}})
+		assert.Nil(t, err)
+		assert.Equal(t, 320, len(sd.continuum))
+		counts := make([]int, 2)
+		for i := 0; i < 4000; i++ {
+			counts[sd.Index(fmt.Sprintf("key%d", i))]++
+		}
+		assert.Greater(t, counts[1], counts[0]*2)
+	})
+
+	t.Run("hash tag", func(t *testing.T) {
+		sd, err := New(Options{Distribution: DistributionKetama, HashTag: "{}", Names: names})
+		assert.Nil(t, err)
+		assert.Equal(t, "user1", sd.hashKey("{user1}.name"))
+		assert.Equal(t, "{}.name", sd.hashKey("{}.name"))
+		assert.Equal(t, "{user1.name", sd.hashKey("{user1.name"))
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, sd.Index("user1"), sd.Index(fmt.Sprintf("a%d{user1}b", i)))
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := New(Options{Distribution: "random", Names: names})
+		assert.NotNil(t, err)
+		_, err = New(Options{HashTag: "{", Names: names})
+		assert.NotNil(t, err)
+		_, err = New(Options{Names: names, Weights: []int{1}})
+		assert.NotNil(t, err)
+		_, err = New(Options{})
+		assert.NotNil(t, err)
+	})
+}
@@ -2,8 +2,21 @@ package redis
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestGetSlotDistribution(t *testing.T) {
 
 }
+
+func TestKeyToSlot(t *testing.T) {
+	assert.Equal(t, uint16(12182), KeyToSlot("foo"))
+	assert.Equal(t, KeyToSlot("user1000"), KeyToSlot("{user1000}.following"))
+	assert.Equal(t, KeyToSlot("bar"), KeyToSlot("foo{bar}{zap}"))
+	assert.Equal(t, KeyToSlot("{bar"), KeyToSlot("foo{{bar}}zap"))
+
+	assert.Equal(t, "", KeyHashTag("foo{}{bar}"))
+	assert.Equal(t, "", KeyHashTag("foo{bar"))
+	assert.Equal(t, "bar", KeyHashTag("foo{bar}{zap}"))
+}
@@ -1,6 +1,10 @@
 package redis
 
-import "github.com/mgtv-tech/redis-GunYu/pkg/digest"
+import (
+	"strings"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/digest"
+)
 
 type SlotOwner struct {
 	Master            string
@@ -10,19 +14,21 @@ type SlotOwner struct {
 }
 
 func KeyToSlot(key string) uint16 {
-	hashtag := ""
-	for i, s := range key {
-		if s == '{' {
-			for k := i; k < len(key); k++ {
-				if key[k] == '}' {
-					hashtag = key[i+1 : k]
-					break
-				}
-			}
-		}
-	}
-	if len(hashtag) > 0 {
+	if hashtag := KeyHashTag(key); len(hashtag) > 0 {
 		return digest.Crc16(hashtag) & 0x3fff
 	}
 	return digest.Crc16(key) & 0x3fff
 }
+
+// KeyHashTag returns the content between the first '{' and the following '}', same as redis cluster
+func KeyHashTag(key string) string {
+	s := strings.IndexByte(key, '{')
+	if s < 0 {
+		return ""
+	}
+	e := strings.IndexByte(key[s+1:], '}')
+	if e <= 0 {
+		return ""
+	}
+	return key[s+1 : s+1+e]
+}
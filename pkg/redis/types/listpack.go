@@ -130,6 +130,11 @@ func (lp *Listpack) Next() []byte {
 	return []byte(strconv.FormatInt(val, 10))
 }
 
+// HasNext returns false if it reaches the end of listpack
+func (lp *Listpack) HasNext() bool {
+	return lp.data[lp.p] != 0xFF
+}
+
 func (lp *Listpack) End() {
 	last := lp.data[lp.p]
 	if last != 0xFF {
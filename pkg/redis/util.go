@@ -572,6 +572,11 @@ func FixTopology(redisCfg *config.RedisConfig) error {
 		if err != nil {
 			return err
 		}
+		if redisCfg.TlsEnable {
+			for _, shard := range shards {
+				shard.UseTlsPort()
+			}
+		}
 		redisCfg.SetClusterShards(shards)
 
 		// migration
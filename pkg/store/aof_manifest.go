@@ -0,0 +1,184 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+multi-part aof(redis 7.0) :
+	appendonlydir contains a manifest, a base file(rdb or aof) and incremental aof files, e.g.
+		appendonly.aof.manifest :
+			file appendonly.aof.1.base.rdb seq 1 type b
+			file appendonly.aof.1.incr.aof seq 1 type i
+			file appendonly.aof.2.incr.aof seq 2 type i
+	history files(type h) are going to be deleted, they are ignored.
+*/
+
+const (
+	AofManifestTypeBase    = 'b'
+	AofManifestTypeHistory = 'h'
+	AofManifestTypeIncr    = 'i'
+)
+
+type AofManifestFile struct {
+	Name string
+	Seq  int64
+	Type byte
+}
+
+// IsRdb returns true if the base file is a rdb
+func (f *AofManifestFile) IsRdb() bool {
+	return strings.HasSuffix(f.Name, ".rdb")
+}
+
+type AofManifest struct {
+	Dir   string
+	Base  *AofManifestFile // nil if there is no base file
+	Incrs []*AofManifestFile
+}
+
+// Path returns the path of the file in the manifest
+func (m *AofManifest) Path(f *AofManifestFile) string {
+	return filepath.Join(m.Dir, f.Name)
+}
+
+// Files returns paths of the base file and incremental files in order
+func (m *AofManifest) Files() []string {
+	files := []string{}
+	if m.Base != nil {
+		files = append(files, m.Path(m.Base))
+	}
+	for _, f := range m.Incrs {
+		files = append(files, m.Path(f))
+	}
+	return files
+}
+
+// IsAofManifest returns true if the path is a manifest or a directory of multi-part aof
+func IsAofManifest(path string) bool {
+	if strings.HasSuffix(path, ".manifest") {
+		return true
+	}
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// ParseAofManifest parses the manifest, path is the manifest or the directory of multi-part aof
+func ParseAofManifest(path string) (*AofManifest, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		matches, err := filepath.Glob(filepath.Join(path, "*.manifest"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) != 1 {
+			return nil, fmt.Errorf("expect a manifest in directory : dir(%s), manifests(%v)", path, matches)
+		}
+		path = matches[0]
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := &AofManifest{Dir: filepath.Dir(path)}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		f, err := parseAofManifestLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest : file(%s), line(%d), error(%w)", path, line, err)
+		}
+		switch f.Type {
+		case AofManifestTypeBase:
+			if m.Base != nil {
+				return nil, fmt.Errorf("invalid manifest : file(%s), line(%d), error(more than one base file)", path, line)
+			}
+			m.Base = f
+		case AofManifestTypeIncr:
+			m.Incrs = append(m.Incrs, f)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(m.Incrs, func(i, j int) bool { return m.Incrs[i].Seq < m.Incrs[j].Seq })
+	return m, nil
+}
+
+// redis, aof.c:aofLoadManifestFromFile, a line is pairs of key and value
+func parseAofManifestLine(line string) (*AofManifestFile, error) {
+	args, err := splitAofManifestArgs(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(args)%2 != 0 {
+		return nil, fmt.Errorf("odd arguments : %s", line)
+	}
+	f := &AofManifestFile{}
+	for i := 0; i < len(args); i += 2 {
+		switch args[i] {
+		case "file":
+			f.Name = args[i+1]
+		case "seq":
+			if f.Seq, err = strconv.ParseInt(args[i+1], 10, 64); err != nil {
+				return nil, err
+			}
+		case "type":
+			if len(args[i+1]) != 1 {
+				return nil, fmt.Errorf("unknown file type : %s", args[i+1])
+			}
+			f.Type = args[i+1][0]
+		}
+	}
+	if f.Name == "" || f.Type == 0 {
+		return nil, fmt.Errorf("file name or type is missing : %s", line)
+	}
+	return f, nil
+}
+
+// file names with special characters are quoted by redis
+func splitAofManifestArgs(line string) ([]string, error) {
+	args := []string{}
+	for line = strings.TrimLeft(line, " \t"); line != ""; line = strings.TrimLeft(line, " \t") {
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+			continue
+		}
+		end := 1
+		for ; end < len(line) && line[end] != '"'; end++ {
+			if line[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(line) {
+			return nil, fmt.Errorf("unbalanced quotes : %s", line)
+		}
+		arg, err := strconv.Unquote(line[:end+1])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		line = line[end+1:]
+	}
+	return args, nil
+}
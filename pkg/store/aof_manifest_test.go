@@ -0,0 +1,39 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAofManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `file appendonly.aof.2.incr.aof seq 2 type i
+file appendonly.aof.1.base.rdb seq 1 type b
+file appendonly.aof.1.incr.aof seq 1 type i
+file appendonly.aof.0.base.aof seq 0 type h
+file "append only\x41.aof" type i seq 3
+`
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"), []byte(manifest), 0666))
+
+	assert.True(t, IsAofManifest(dir))
+	assert.True(t, IsAofManifest("appendonly.aof.manifest"))
+	assert.False(t, IsAofManifest("appendonly.aof"))
+
+	m, err := ParseAofManifest(dir)
+	assert.Nil(t, err)
+	assert.True(t, m.Base.IsRdb())
+	assert.Equal(t, []string{
+		filepath.Join(dir, "appendonly.aof.1.base.rdb"),
+		filepath.Join(dir, "appendonly.aof.1.incr.aof"),
+		filepath.Join(dir, "appendonly.aof.2.incr.aof"),
+		filepath.Join(dir, "append onlyA.aof"),
+	}, m.Files())
+
+	for _, line := range []string{"file a.aof seq 1", "file a.aof seq x type i", "file \"a.aof seq 1 type i"} {
+		_, err := parseAofManifestLine(line)
+		assert.NotNil(t, err, line)
+	}
+}
@@ -93,3 +93,32 @@ func (ts *aofReaderTestSuite) TestCorrupted() {
 		file.Close()
 	})
 }
+
+func (ts *aofReaderTestSuite) TestIngestTime() {
+	writer, err := NewAofRotater("1", ts.tempDir, 1000, 100000000, config.FlushPolicy{})
+	ts.Nil(err)
+	ts.Nil(writer.write([]byte("abc")))
+
+	// the writing file
+	rec, ok := writer.ingestTime(1002)
+	ts.True(ok)
+	ts.Equal(int64(1003), rec.right)
+	_, ok = writer.ingestTime(1004)
+	ts.False(ok)
+
+	// a new window
+	next := rec.ns + int64(aofTimestampWindow)
+	writer.right.Add(3)
+	writer.stamp(next)
+	ts.Nil(writer.close())
+
+	records, err := readAofTimestamps(ts.tempDir, 1000)
+	ts.Nil(err)
+	ts.Len(records, 2)
+	rec, ok = searchAofTimestamp(records, 1004)
+	ts.True(ok)
+	ts.Equal(int64(1006), rec.right)
+	ts.Equal(next, rec.ns)
+	_, ok = searchAofTimestamp(records, 1007)
+	ts.False(ok)
+}
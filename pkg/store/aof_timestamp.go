@@ -0,0 +1,66 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// *
+// timestamp file of aof : $offset.ts, records are appended when data is written
+// record : 16B [ right offset(8) + unix nano(8) ]
+// a record means data before the right offset has been ingested at the time,
+// writes in the same window are merged into one record
+// *
+const (
+	aofTimestampSize   = 16
+	aofTimestampWindow = 100 * time.Millisecond
+)
+
+type aofTimestamp struct {
+	right int64
+	ns    int64
+}
+
+func aofTimestampFilePath(dir string, offset int64) string {
+	return fmt.Sprintf("%s%c%d.ts", dir, os.PathSeparator, offset)
+}
+
+// searchAofTimestamp returns the first record whose right offset is not less than offset
+func searchAofTimestamp(records []aofTimestamp, offset int64) (aofTimestamp, bool) {
+	i := sort.Search(len(records), func(i int) bool { return records[i].right >= offset })
+	if i == len(records) {
+		return aofTimestamp{}, false
+	}
+	return records[i], true
+}
+
+func readAofTimestamps(dir string, offset int64) ([]aofTimestamp, error) {
+	data, err := os.ReadFile(aofTimestampFilePath(dir, offset))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	records := make([]aofTimestamp, 0, len(data)/aofTimestampSize)
+	for i := 0; i+aofTimestampSize <= len(data); i += aofTimestampSize { // ignore a partial record
+		records = append(records, aofTimestamp{
+			right: int64(binary.LittleEndian.Uint64(data[i:])),
+			ns:    int64(binary.LittleEndian.Uint64(data[i+8:])),
+		})
+	}
+	return records, nil
+}
+
+func writeAofTimestamp(w io.Writer, ts aofTimestamp) error {
+	var buf [aofTimestampSize]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(ts.right))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(ts.ns))
+	_, err := w.Write(buf[:])
+	return err
+}
@@ -159,6 +159,9 @@ type AofRotater struct {
 	dirtyDataSize atomic.Int64
 	lastFlushTime time.Time
 	flushPolicy   config.FlushPolicy
+	tsFile        *os.File
+	timestamps    []aofTimestamp // the last record is written when the window is over or the file is closed
+	tsWindow      int64
 }
 
 func NewAofRotater(id string, dir string, offset int64, maxLogSize int64, flush config.FlushPolicy) (*AofRotater, error) {
@@ -216,6 +219,15 @@ func (w *AofRotater) openFile(offset int64) error {
 		return err
 	}
 
+	tsFile, err := os.OpenFile(aofTimestampFilePath(w.dir, offset), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
+	if err != nil {
+		gc()
+		return err
+	}
+	w.tsFile = tsFile
+	w.timestamps = nil
+	w.tsWindow = 0
+
 	w.file = file
 	w.filepath = filepath
 	w.left = offset
@@ -253,6 +265,7 @@ func (w *AofRotater) write(buf []byte) error {
 		w.filesize += int64(n)
 		w.right.Add(int64(n))
 		writeDataCounter.Add(float64(n), w.Id)
+		w.stamp(time.Now().UnixNano())
 		w.getObserver().Write(w.left, int64(n))
 	}
 	if err != nil {
@@ -274,6 +287,50 @@ func (w *AofRotater) write(buf []byte) error {
 	return w.flush()
 }
 
+// stamp records the ingestion time of written data
+func (w *AofRotater) stamp(now int64) {
+	ts := aofTimestamp{right: w.right.Load(), ns: now}
+	n := len(w.timestamps)
+	if n > 0 && now-w.tsWindow < int64(aofTimestampWindow) {
+		w.timestamps[n-1] = ts
+		return
+	}
+	if n > 0 {
+		if err := writeAofTimestamp(w.tsFile, w.timestamps[n-1]); err != nil {
+			w.logger.Errorf("write timestamp error : file(%s), error(%v)", w.filepath, err)
+		}
+	}
+	w.timestamps = append(w.timestamps, ts)
+	w.tsWindow = now
+}
+
+// ingestTime returns the record of offset if it's in the writing file
+func (w *AofRotater) ingestTime(offset int64) (aofTimestamp, bool) {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	if w.aofClosed.Load() || offset <= w.left {
+		return aofTimestamp{}, false
+	}
+	return searchAofTimestamp(w.timestamps, offset)
+}
+
+func (w *AofRotater) closeTimestamps(remove bool) error {
+	if w.tsFile == nil {
+		return nil
+	}
+	var err error
+	if n := len(w.timestamps); n > 0 && !remove {
+		err = writeAofTimestamp(w.tsFile, w.timestamps[n-1])
+	}
+	err = errors.Join(err, w.tsFile.Close())
+	if remove {
+		err = errors.Join(err, os.Remove(aofTimestampFilePath(w.dir, w.left)))
+	}
+	w.tsFile = nil
+	w.timestamps = nil
+	return err
+}
+
 func (w *AofRotater) flush() error {
 	if w.flushPolicy.EveryWrite {
 		return w.file.Sync()
@@ -315,7 +372,7 @@ func (w *AofRotater) closeAof() error { // ensure close() and write() are in sam
 		}
 
 		if w.filesize == headerSize {
-			err := ret(nil)
+			err := errors.Join(ret(nil), w.closeTimestamps(true))
 			w.getObserver().Close(w.left, int64(0))
 			err = errors.Join(err, os.Remove(w.filepath))
 			if err != nil {
@@ -326,6 +383,10 @@ func (w *AofRotater) closeAof() error { // ensure close() and write() are in sam
 			return nil
 		}
 
+		if err := w.closeTimestamps(false); err != nil {
+			w.logger.Errorf("close timestamp file error : file(%s), error(%v)", w.filepath, err)
+		}
+
 		crc := w.crc.Sum64()
 		binary.LittleEndian.PutUint64(w.header[1:], crc)
 		binary.LittleEndian.PutUint32(w.header[1+8:], uint32(w.filesize-headerSize))
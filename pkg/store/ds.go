@@ -104,6 +104,9 @@ type dataSetAof struct {
 
 	writer  *AofWriter
 	readers []*AofRotateReader
+
+	timestamps []aofTimestamp // loaded from the timestamp file after the file is closed
+	tsLoaded   bool
 }
 
 func (a *dataSetAof) Size() int64 {
@@ -196,6 +199,33 @@ func (a *dataSetAof) Left() int64 {
 	return a.left
 }
 
+// ingestTime returns the timestamp record of offset
+func (a *dataSetAof) ingestTime(dir string, offset int64) (aofTimestamp, bool) {
+	a.mux.RLock()
+	writer := a.writer
+	a.mux.RUnlock()
+	if writer != nil {
+		if ts, ok := writer.ingestTime(offset); ok {
+			return ts, true
+		}
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if a.writer != nil { // the file is being closed
+		return aofTimestamp{}, false
+	}
+	if !a.tsLoaded {
+		records, err := readAofTimestamps(dir, a.left)
+		if err != nil {
+			log.Errorf("read aof timestamps error : aof(%d), error(%v)", a.left, err)
+		}
+		a.timestamps = records
+		a.tsLoaded = true
+	}
+	return searchAofTimestamp(a.timestamps, offset)
+}
+
 func (a *dataSetAof) incrSize(delta int64) {
 	a.rtSize.Add(delta)
 }
@@ -479,6 +509,7 @@ func (ds *dataSet) gcLogs(dir string, maxSize int64) {
 				} else {
 					log.Infof("GC Logs, remove aof file : file(%s)", aoffn)
 				}
+				os.Remove(aofTimestampFilePath(dir, aof.left))
 				size -= aof.rtSize.Load()
 				delete(ds.aofMap, ds.aofSegs[z].left)
 				ds.aofSegs = ds.aofSegs[z+1:]
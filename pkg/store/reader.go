@@ -3,6 +3,7 @@ package store
 import (
 	"bufio"
 	"fmt"
+	"time"
 
 	"github.com/mgtv-tech/redis-GunYu/pkg/log"
 	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
@@ -15,6 +16,7 @@ type Reader struct {
 	size   int64
 	runId  string
 	left   int64
+	storer *Storer
 	logger log.Logger
 }
 
@@ -63,3 +65,11 @@ func (r *Reader) IoReader() *bufio.Reader {
 func (r *Reader) IsAof() bool {
 	return r.aof != nil
 }
+
+// IngestTime returns the time when data before offset was written, see Storer.IngestTime
+func (r *Reader) IngestTime(offset int64) (time.Time, int64, bool) {
+	if r.storer == nil {
+		return time.Time{}, 0, false
+	}
+	return r.storer.IngestTime(offset)
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+/*
+redis aof :
+	aof files written by redis, they don't have the header of aof files of the storer.
+	commands are multibulk, annotations(redis 7.0), e.g. #TS:1700000000, are skipped.
+	an aof with rdb preamble is not supported, the rdb should be a base file of multi-part aof,
+	unless the reader starts from the end of the preamble, see NewRedisAofReaderFrom.
+*/
+
+var ErrAofRdbPreamble = errors.New("aof with rdb preamble is not supported")
+
+type RedisAofCommand struct {
+	File   string   // path of the aof file
+	Offset int64    // offset of the command in the file
+	Args   [][]byte // the command name and arguments
+}
+
+// RedisAofReader reads commands of redis aof files in order
+type RedisAofReader struct {
+	files  []string
+	index  int
+	file   *os.File
+	reader *bufio.Reader
+	offset int64
+	start  int64 // offset of the first file
+}
+
+func NewRedisAofReader(files []string) *RedisAofReader {
+	return &RedisAofReader{files: files, index: -1}
+}
+
+// NewRedisAofReaderFrom returns a reader which starts from the offset of the first file, e.g. the end of rdb preamble
+func NewRedisAofReaderFrom(files []string, offset int64) *RedisAofReader {
+	return &RedisAofReader{files: files, index: -1, start: offset}
+}
+
+func (r *RedisAofReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// File returns the path of current file, and the offset after the last command
+func (r *RedisAofReader) File() (string, int64) {
+	if r.index < 0 || r.index >= len(r.files) {
+		return "", 0
+	}
+	return r.files[r.index], r.offset
+}
+
+func (r *RedisAofReader) nextFile() error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+	r.index++
+	if r.index >= len(r.files) {
+		return io.EOF
+	}
+	file, err := os.Open(r.files[r.index])
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.offset = 0
+	if r.index == 0 && r.start > 0 {
+		if _, err = file.Seek(r.start, io.SeekStart); err != nil {
+			return err
+		}
+		r.offset = r.start
+	}
+	r.reader = bufio.NewReaderSize(file, 64*1024)
+	if r.offset > 0 {
+		return nil
+	}
+	if magic, _ := r.reader.Peek(5); bytes.Equal(magic, []byte("REDIS")) {
+		return fmt.Errorf("%w : %s", ErrAofRdbPreamble, r.files[r.index])
+	}
+	return nil
+}
+
+// Next returns the next command, it returns io.EOF after all files are read.
+// a truncated command returns io.ErrUnexpectedEOF
+func (r *RedisAofReader) Next() (*RedisAofCommand, error) {
+	for {
+		if r.file == nil {
+			if err := r.nextFile(); err != nil {
+				return nil, err
+			}
+		}
+		b, err := r.reader.Peek(1)
+		if err == io.EOF {
+			if err = r.nextFile(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == '#' { // annotation
+			line, err := r.readLine()
+			if err != nil {
+				return nil, r.wrapErr(err)
+			}
+			r.offset += int64(len(line))
+			continue
+		}
+		cmd, err := r.readCommand()
+		if err != nil {
+			return nil, r.wrapErr(err)
+		}
+		return cmd, nil
+	}
+}
+
+func (r *RedisAofReader) wrapErr(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("read aof : file(%s), offset(%d), error(%w)", r.files[r.index], r.offset, err)
+}
+
+// readLine returns the line including \r\n
+func (r *RedisAofReader) readLine() ([]byte, error) {
+	line, err := r.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid line : %q", line)
+	}
+	return line, nil
+}
+
+func (r *RedisAofReader) readInt(prefix byte) (int64, int, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, 0, err
+	}
+	if line[0] != prefix {
+		return 0, 0, fmt.Errorf("expect '%c' : %q", prefix, line)
+	}
+	n, err := strconv.ParseInt(string(line[1:len(line)-2]), 10, 64)
+	if err != nil || n < 0 {
+		return 0, 0, fmt.Errorf("invalid length : %q", line)
+	}
+	return n, len(line), nil
+}
+
+// readCommand reads a multibulk command, offset is increased if the command is complete
+func (r *RedisAofReader) readCommand() (*RedisAofCommand, error) {
+	argc, size, err := r.readInt('*')
+	if err != nil {
+		return nil, err
+	}
+	if argc == 0 {
+		return nil, errors.New("empty command")
+	}
+	cmd := &RedisAofCommand{
+		File:   r.files[r.index],
+		Offset: r.offset,
+		Args:   make([][]byte, 0, argc),
+	}
+	total := int64(size)
+	for i := int64(0); i < argc; i++ {
+		n, size, err := r.readInt('$')
+		if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, n+2)
+		if _, err = io.ReadFull(r.reader, arg); err != nil {
+			return nil, err
+		}
+		if arg[n] != '\r' || arg[n+1] != '\n' {
+			return nil, errors.New("bulk string is not terminated by CRLF")
+		}
+		cmd.Args = append(cmd.Args, arg[:n])
+		total += int64(size) + n + 2
+	}
+	r.offset += total
+	return cmd, nil
+}
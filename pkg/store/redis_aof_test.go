@@ -0,0 +1,69 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisAofReader(t *testing.T) {
+	dir := t.TempDir()
+	aof1 := filepath.Join(dir, "appendonly.aof.1.incr.aof")
+	aof2 := filepath.Join(dir, "appendonly.aof.2.incr.aof")
+	assert.Nil(t, os.WriteFile(aof1, []byte("*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n#TS:1700000000\r\n*3\r\n$3\r\nset\r\n$1\r\nk\r\n$1\r\nv\r\n"), 0666))
+	assert.Nil(t, os.WriteFile(aof2, []byte("*2\r\n$3\r\ndel\r\n$1\r\nk\r\n*2\r\n$3\r\ndel\r\n$1"), 0666))
+
+	r := NewRedisAofReader([]string{aof1, aof2})
+	defer r.Close()
+
+	cmd, err := r.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("SELECT"), []byte("0")}, cmd.Args)
+	assert.Equal(t, int64(0), cmd.Offset)
+
+	cmd, err = r.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("set"), []byte("k"), []byte("v")}, cmd.Args)
+	assert.Equal(t, aof1, cmd.File)
+	assert.Equal(t, int64(39), cmd.Offset)
+
+	cmd, err = r.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("del"), []byte("k")}, cmd.Args)
+	assert.Equal(t, aof2, cmd.File)
+
+	// the last command is truncated
+	_, err = r.Next()
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	file, offset := r.File()
+	assert.Equal(t, aof2, file)
+	assert.Equal(t, int64(20), offset)
+
+	// rdb preamble
+	assert.Nil(t, os.WriteFile(aof1, []byte("REDIS0011"), 0666))
+	r = NewRedisAofReader([]string{aof1})
+	_, err = r.Next()
+	assert.True(t, errors.Is(err, ErrAofRdbPreamble))
+	r.Close()
+
+	// starts from the end of rdb preamble
+	assert.Nil(t, os.WriteFile(aof1, []byte("REDIS0011*1\r\n$4\r\nping\r\n"), 0666))
+	r = NewRedisAofReaderFrom([]string{aof1, aof2}, 9)
+	cmd, err = r.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("ping")}, cmd.Args)
+	assert.Equal(t, int64(9), cmd.Offset)
+	cmd, err = r.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, aof2, cmd.File)
+	assert.Equal(t, int64(0), cmd.Offset)
+	r.Close()
+
+	r = NewRedisAofReader(nil)
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+}
@@ -222,6 +222,23 @@ func (s *Storer) LatestOffset() int64 {
 	return s.getDataSet().Right()
 }
 
+// IngestTime returns the time when data before offset was written to the storer,
+// and the right offset of data written at the same time. it returns false if the time is unknown
+func (s *Storer) IngestTime(offset int64) (time.Time, int64, bool) {
+	s.mux.RLock()
+	dir := s.dir
+	s.mux.RUnlock()
+	aof := s.getDataSet().IndexAof(offset - 1)
+	if aof == nil {
+		return time.Time{}, 0, false
+	}
+	ts, ok := aof.ingestTime(dir, offset)
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	return time.Unix(0, ts.ns), ts.right, true
+}
+
 func (s *Storer) IsValidOffset(offset int64) bool {
 	ds := s.getDataSet()
 	return ds.InRange(offset)
@@ -293,6 +310,7 @@ func (s *Storer) GetReader(offset int64, verifyCrc bool) (*Reader, error) {
 
 	rd.left = offset
 	rd.aof = rr
+	rd.storer = s
 	rd.reader = reader
 	rd.size = -1
 	rd.logger = log.WithLogger(config.LogModuleName("[Reader(aof)] "))
@@ -519,6 +537,7 @@ func (s *Storer) initDataSet() *dataSet {
 		} else {
 			s.logger.Infof("remove aof file : aof(%s)", opath)
 		}
+		os.Remove(aofTimestampFilePath(s.dir, a.Left()))
 	}
 
 	return ds
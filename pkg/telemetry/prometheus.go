@@ -0,0 +1,131 @@
+package telemetry
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+)
+
+// promProducer converts metrics of the prometheus registry to OTLP :
+// counters are cumulative monotonic sums, gauges and untyped metrics are gauges,
+// histograms are cumulative histograms, summaries are exported as <name>_sum and <name>_count
+type promProducer struct {
+	gatherer prometheus.Gatherer
+	start    time.Time
+}
+
+func newPromProducer(gatherer prometheus.Gatherer) *promProducer {
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	return &promProducer{gatherer: gatherer, start: time.Now()}
+}
+
+func (pp *promProducer) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	families, err := pp.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return nil, err
+	}
+	now := time.Now()
+	metrics := make([]metricdata.Metrics, 0, len(families))
+	for _, mf := range families {
+		metrics = append(metrics, pp.convert(mf, now)...)
+	}
+	return []metricdata.ScopeMetrics{{
+		Scope:   instrumentation.Scope{Name: config.AppName, Version: config.Version},
+		Metrics: metrics,
+	}}, err
+}
+
+func (pp *promProducer) convert(mf *dto.MetricFamily, now time.Time) []metricdata.Metrics {
+	name := mf.GetName()
+	help := mf.GetHelp()
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		sum := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+		for _, m := range mf.GetMetric() {
+			sum.DataPoints = append(sum.DataPoints, pp.point(m, m.GetCounter().GetValue(), now))
+		}
+		return []metricdata.Metrics{{Name: name, Description: help, Data: sum}}
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		gauge := metricdata.Gauge[float64]{}
+		for _, m := range mf.GetMetric() {
+			val := m.GetGauge().GetValue()
+			if mf.GetType() == dto.MetricType_UNTYPED {
+				val = m.GetUntyped().GetValue()
+			}
+			gauge.DataPoints = append(gauge.DataPoints, pp.point(m, val, now))
+		}
+		return []metricdata.Metrics{{Name: name, Description: help, Data: gauge}}
+	case dto.MetricType_HISTOGRAM:
+		hist := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+		for _, m := range mf.GetMetric() {
+			hist.DataPoints = append(hist.DataPoints, pp.histogram(m, now))
+		}
+		return []metricdata.Metrics{{Name: name, Description: help, Data: hist}}
+	case dto.MetricType_SUMMARY:
+		sum := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+		count := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+		for _, m := range mf.GetMetric() {
+			sum.DataPoints = append(sum.DataPoints, pp.point(m, m.GetSummary().GetSampleSum(), now))
+			count.DataPoints = append(count.DataPoints, pp.point(m, float64(m.GetSummary().GetSampleCount()), now))
+		}
+		return []metricdata.Metrics{
+			{Name: name + "_sum", Description: help, Data: sum},
+			{Name: name + "_count", Description: help, Data: count},
+		}
+	}
+	return nil
+}
+
+func (pp *promProducer) attributes(m *dto.Metric) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(m.GetLabel()))
+	for _, l := range m.GetLabel() {
+		kvs = append(kvs, attribute.String(l.GetName(), l.GetValue()))
+	}
+	return attribute.NewSet(kvs...)
+}
+
+func (pp *promProducer) point(m *dto.Metric, val float64, now time.Time) metricdata.DataPoint[float64] {
+	return metricdata.DataPoint[float64]{
+		Attributes: pp.attributes(m),
+		StartTime:  pp.start,
+		Time:       now,
+		Value:      val,
+	}
+}
+
+// histogram converts cumulative buckets of prometheus to counts of buckets
+func (pp *promProducer) histogram(m *dto.Metric, now time.Time) metricdata.HistogramDataPoint[float64] {
+	h := m.GetHistogram()
+	buckets := append([]*dto.Bucket{}, h.GetBucket()...)
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].GetUpperBound() < buckets[j].GetUpperBound() })
+
+	dp := metricdata.HistogramDataPoint[float64]{
+		Attributes: pp.attributes(m),
+		StartTime:  pp.start,
+		Time:       now,
+		Count:      h.GetSampleCount(),
+		Sum:        h.GetSampleSum(),
+	}
+	var prev uint64
+	for _, b := range buckets {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			break
+		}
+		dp.Bounds = append(dp.Bounds, b.GetUpperBound())
+		dp.BucketCounts = append(dp.BucketCounts, b.GetCumulativeCount()-prev)
+		prev = b.GetCumulativeCount()
+	}
+	dp.BucketCounts = append(dp.BucketCounts, h.GetSampleCount()-prev) // +Inf
+	return dp
+}
@@ -0,0 +1,133 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+)
+
+/*
+telemetry :
+	exports metrics and traces to a collector by OTLP/HTTP, see server.otlp.
+	metrics are gathered from the prometheus registry, so OTLP exports the same metrics as /prometheus.
+	spans are created by the global tracer provider, they are no-op if traces are not exported.
+*/
+
+var (
+	mux            sync.Mutex
+	meterProvider  *sdkmetric.MeterProvider
+	tracerProvider *sdktrace.TracerProvider
+)
+
+// Start exports metrics and traces, instance identifies this process, e.g. the listening address
+func Start(cfg *config.ServerOtlpConfig, instance string) error {
+	mux.Lock()
+	defer mux.Unlock()
+	if meterProvider != nil || tracerProvider != nil {
+		return errors.New("telemetry is started")
+	}
+
+	headers := make(map[string]string, len(cfg.Headers))
+	for name, secret := range cfg.Headers {
+		val, err := secret.Value()
+		if err != nil {
+			return err
+		}
+		headers[name] = val
+	}
+	res := resource.NewSchemaless(
+		attribute.String("service.name", config.AppName),
+		attribute.String("service.version", config.Version),
+		attribute.String("service.instance.id", instance),
+	)
+
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.Warnf("otlp error : %v", err)
+	}))
+
+	if *cfg.Metrics {
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(cfg.Endpoint), otlpmetrichttp.WithHeaders(headers)}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		exporter, err := otlpmetrichttp.New(context.Background(), opts...)
+		if err != nil {
+			return err
+		}
+		reader := sdkmetric.NewPeriodicReader(exporter,
+			sdkmetric.WithInterval(cfg.Interval),
+			sdkmetric.WithProducer(newPromProducer(nil)))
+		meterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res))
+	}
+
+	if *cfg.Traces {
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint), otlptracehttp.WithHeaders(headers)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(context.Background(), opts...)
+		if err != nil {
+			shutdown(context.Background())
+			return err
+		}
+		tracerProvider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(res),
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))))
+		otel.SetTracerProvider(tracerProvider)
+	}
+
+	log.Infof("otlp : endpoint(%s), metrics(%v), traces(%v)", cfg.Endpoint, *cfg.Metrics, *cfg.Traces)
+	return nil
+}
+
+// Stop flushes metrics and spans, and stops exporting
+func Stop(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	mux.Lock()
+	defer mux.Unlock()
+	return shutdown(ctx)
+}
+
+func shutdown(ctx context.Context) error {
+	var errs []error
+	if meterProvider != nil {
+		errs = append(errs, meterProvider.Shutdown(ctx))
+		meterProvider = nil
+	}
+	if tracerProvider != nil {
+		errs = append(errs, tracerProvider.Shutdown(ctx))
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		tracerProvider = nil
+	}
+	return errors.Join(errs...)
+}
+
+// StartSpan starts a span, it's a child of the span in ctx
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(config.AppName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan records the error and ends the span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
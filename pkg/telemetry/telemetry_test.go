@@ -0,0 +1,174 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	collmetric "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	colltrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
+)
+
+// collector is a stand-in of OTLP/HTTP collector
+type collector struct {
+	mux     sync.Mutex
+	metrics []*collmetric.ExportMetricsServiceRequest
+	traces  []*colltrace.ExportTraceServiceRequest
+	headers http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.headers = r.Header.Clone()
+	switch r.URL.Path {
+	case "/v1/metrics":
+		req := &collmetric.ExportMetricsServiceRequest{}
+		if err = proto.Unmarshal(body, req); err == nil {
+			c.metrics = append(c.metrics, req)
+		}
+		body, _ = proto.Marshal(&collmetric.ExportMetricsServiceResponse{})
+	case "/v1/traces":
+		req := &colltrace.ExportTraceServiceRequest{}
+		if err = proto.Unmarshal(body, req); err == nil {
+			c.traces = append(c.traces, req)
+		}
+		body, _ = proto.Marshal(&colltrace.ExportTraceServiceResponse{})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(body)
+}
+
+func TestExport(t *testing.T) {
+	coll := &collector{}
+	server := httptest.NewServer(coll)
+	defer server.Close()
+
+	counter := metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: "telemetry_test",
+		Subsystem: "export",
+		Name:      "counter",
+		Labels:    []string{"input"},
+	})
+	counter.Add(3, "127.0.0.1:6379")
+
+	yes := true
+	cfg := &config.ServerOtlpConfig{
+		Endpoint:    strings.TrimPrefix(server.URL, "http://"),
+		Insecure:    true,
+		Headers:     map[string]config.Secret{"Authorization": "Bearer token"},
+		Interval:    time.Hour,
+		Metrics:     &yes,
+		Traces:      &yes,
+		SampleRatio: 1,
+	}
+	assert.Nil(t, Start(cfg, "127.0.0.1:18001"))
+	assert.NotNil(t, Start(cfg, "127.0.0.1:18001"))
+
+	ctx, parent := StartSpan(context.Background(), "full_sync", attribute.String("input", "127.0.0.1:6379"))
+	_, child := StartSpan(ctx, "output.rdb_replay")
+	EndSpan(child, errors.New("restore error"))
+	EndSpan(parent, nil)
+
+	assert.Nil(t, Stop(5*time.Second))
+
+	coll.mux.Lock()
+	defer coll.mux.Unlock()
+	assert.Equal(t, "Bearer token", coll.headers.Get("Authorization"))
+
+	// metrics are flushed when stopping
+	found := false
+	for _, req := range coll.metrics {
+		for _, rm := range req.ResourceMetrics {
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					if m.Name != "telemetry_test_export_counter" {
+						continue
+					}
+					found = true
+					sum := m.GetSum()
+					assert.NotNil(t, sum)
+					assert.True(t, sum.IsMonotonic)
+					assert.Equal(t, float64(3), sum.DataPoints[0].GetAsDouble())
+					assert.Equal(t, "input", sum.DataPoints[0].Attributes[0].Key)
+				}
+			}
+		}
+	}
+	assert.True(t, found)
+
+	spans := map[string][]byte{}
+	parents := map[string][]byte{}
+	for _, req := range coll.traces {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s.SpanId
+					parents[s.Name] = s.ParentSpanId
+					if s.Name == "output.rdb_replay" {
+						assert.Equal(t, "restore error", s.Status.Message)
+					}
+				}
+			}
+		}
+	}
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, spans["full_sync"], parents["output.rdb_replay"])
+}
+
+func TestPromProducer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "hist", Buckets: []float64{1, 10}})
+	reg.MustRegister(hist)
+	for _, v := range []float64{0.5, 2, 5, 100} {
+		hist.Observe(v)
+	}
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "gauge"}, []string{"node"})
+	reg.MustRegister(gauge)
+	gauge.WithLabelValues("a").Set(7)
+
+	sms, err := newPromProducer(reg).Produce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(sms))
+	metrics := map[string]metricdata.Aggregation{}
+	for _, m := range sms[0].Metrics {
+		metrics[m.Name] = m.Data
+	}
+
+	h := metrics["hist"].(metricdata.Histogram[float64])
+	assert.Equal(t, []float64{1, 10}, h.DataPoints[0].Bounds)
+	assert.Equal(t, []uint64{1, 2, 1}, h.DataPoints[0].BucketCounts)
+	assert.Equal(t, uint64(4), h.DataPoints[0].Count)
+	assert.Equal(t, 107.5, h.DataPoints[0].Sum)
+
+	g := metrics["gauge"].(metricdata.Gauge[float64])
+	assert.Equal(t, float64(7), g.DataPoints[0].Value)
+	node, _ := g.DataPoints[0].Attributes.Value("node")
+	assert.Equal(t, "a", node.AsString())
+}
@@ -0,0 +1,306 @@
+package syncer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
+)
+
+/*
+bidirectional sync :
+	the output tags each transaction with "hset $originKey origin $originId" after multi,
+	the syncer of opposite direction reads the tagged transaction from its input, and drops it.
+
+	full sync : writes of restoring rdb are tagged in the same way by originTagger.
+
+	lww : a write of a single key is versioned by "$time:$originId" when the syncer reads it from input,
+	the version is recorded in "$lwwKey{$key}" of input by lwwOriginScript, and the write is applied to output
+	by lwwScript in the tagged transaction only if it's not older than the record of output.
+	a write of input is superseded if a write of the opposite region was applied to input after it,
+	the record set by the opposite direction is newer than the records seen in the tagged transactions of input,
+	so the superseded write is dropped, and both regions keep the write applied last.
+	without lww, concurrent writes of the same key are applied in the order of arrival, the regions may diverge.
+*/
+
+const originMarkerField = "origin"
+
+var (
+	originKeyPrefix = config.CheckpointKey + "-origin"
+	lwwKeyPrefix    = config.CheckpointKey + "-lww-"
+
+	loopCounter = metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "output",
+		Name:      "loop_cmd",
+		Labels:    []string{"input"},
+	})
+	lwwSupersededCounter = metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "output",
+		Name:      "lww_superseded_cmd",
+		Labels:    []string{"input"},
+	})
+)
+
+// originKey returns the key of marker, it's in slots of output cluster which can replay transactions
+func originKey(output config.RedisConfig, canTransaction bool) string {
+	if canTransaction && output.IsCluster() {
+		return choseKeyInSlots(originKeyPrefix, output.GetAllSlots())
+	}
+	return originKeyPrefix
+}
+
+// isOriginMarker returns true if the command tags a transaction applied by a syncer
+func isOriginMarker(cmd string, argv [][]byte) bool {
+	return cmd == "hset" && len(argv) == 3 && bytes.HasPrefix(argv[0], []byte(originKeyPrefix)) &&
+		string(argv[1]) == originMarkerField
+}
+
+// untaggedCommands are sent as is by originTagger, they don't write keys
+var untaggedCommands = map[string]struct{}{
+	"select": {},
+	"ping":   {},
+	"exists": {},
+}
+
+type taggedReply struct {
+	reply interface{}
+	err   error
+}
+
+// originTagger tags writes of full sync, the writes sent before flush are applied in a transaction
+// which is tagged with the origin marker, e.g. the chunks of a big key.
+// untagged commands are sent to the underlying connection, they shouldn't be mixed with unreceived writes
+type originTagger struct {
+	client.Redis
+	key      string
+	originId string
+	cmds     [][]interface{} // sent writes
+	replies  []taggedReply   // replies of flushed writes
+}
+
+func newOriginTagger(cli client.Redis, key string, originId string) *originTagger {
+	return &originTagger{Redis: cli, key: key, originId: originId}
+}
+
+func isTaggedCommand(cmd string) bool {
+	_, ok := untaggedCommands[strings.ToLower(cmd)]
+	return !ok
+}
+
+func (ot *originTagger) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if !isTaggedCommand(cmd) {
+		return ot.Redis.Do(cmd, args...)
+	}
+	if err := ot.SendAndFlush(cmd, args...); err != nil {
+		return nil, err
+	}
+	return ot.Receive()
+}
+
+func (ot *originTagger) Send(cmd string, args ...interface{}) error {
+	if !isTaggedCommand(cmd) {
+		return ot.Redis.Send(cmd, args...)
+	}
+	ot.cmds = append(ot.cmds, append([]interface{}{cmd}, args...))
+	return nil
+}
+
+func (ot *originTagger) SendAndFlush(cmd string, args ...interface{}) error {
+	if !isTaggedCommand(cmd) {
+		return ot.Redis.SendAndFlush(cmd, args...)
+	}
+	if err := ot.Send(cmd, args...); err != nil {
+		return err
+	}
+	return ot.Flush()
+}
+
+// Flush applies sent writes in a tagged transaction
+func (ot *originTagger) Flush() error {
+	if len(ot.cmds) == 0 {
+		return ot.Redis.Flush()
+	}
+	cmds := ot.cmds
+	ot.cmds = nil
+
+	batcher := ot.Redis.NewBatcher()
+	batcher.Put("multi")
+	batcher.Put("hset", ot.key, originMarkerField, ot.originId)
+	for _, cmd := range cmds {
+		batcher.Put(cmd[0].(string), cmd[1:]...)
+	}
+	batcher.Put("exec")
+	rets, err := batcher.Exec()
+	if err == nil {
+		err = checkExecReplies(rets, len(cmds)+1)
+	}
+	if err != nil {
+		for range cmds {
+			ot.replies = append(ot.replies, taggedReply{err: err})
+		}
+		return err
+	}
+
+	// replies of exec : the marker, and then writes
+	execReplies := rets[len(rets)-1].([]interface{})
+	for _, reply := range execReplies[1:] {
+		if rerr, ok := reply.(error); ok {
+			ot.replies = append(ot.replies, taggedReply{err: rerr})
+		} else {
+			ot.replies = append(ot.replies, taggedReply{reply: reply})
+		}
+	}
+	return nil
+}
+
+// checkExecReplies checks that the transaction is executed, and exec replies a reply for each command
+func checkExecReplies(rets []interface{}, size int) error {
+	if len(rets) == 0 {
+		return fmt.Errorf("transaction has no replies")
+	}
+	execReplies, ok := rets[len(rets)-1].([]interface{})
+	if !ok {
+		return fmt.Errorf("transaction is aborted : %v", rets[len(rets)-1])
+	}
+	if len(execReplies) != size {
+		return fmt.Errorf("transaction has %d replies, expects %d", len(execReplies), size)
+	}
+	return nil
+}
+
+// Receive returns the reply of a write, or of an untagged command
+func (ot *originTagger) Receive() (interface{}, error) {
+	if len(ot.replies) == 0 {
+		return ot.Redis.Receive()
+	}
+	r := ot.replies[0]
+	ot.replies = ot.replies[1:]
+	return r.reply, r.err
+}
+
+func (ot *originTagger) ReceiveString() (string, error) {
+	return common.String(ot.Receive())
+}
+
+func (ot *originTagger) ReceiveBool() (bool, error) {
+	return common.Bool(ot.Receive())
+}
+
+// lwwScript applies the write if its version is not older than the record of the key,
+// versions are compared by time and then origin id.
+// KEYS : record key, key; ARGV : time, origin id, ttl of record, command and arguments
+var lwwScript = []byte(strings.TrimSpace(`
+if redis.replicate_commands then redis.replicate_commands() end
+local cur = redis.call('get', KEYS[1])
+if cur then
+	local ts, origin = string.match(cur, '^(%d+):(.*)$')
+	if ts and (tonumber(ts) > tonumber(ARGV[1]) or (tonumber(ts) == tonumber(ARGV[1]) and origin > ARGV[2])) then
+		return 0
+	end
+end
+redis.call('set', KEYS[1], ARGV[1] .. ':' .. ARGV[2], 'px', ARGV[3])
+redis.call(ARGV[4], unpack(ARGV, 5))
+return 1
+`))
+
+// lwwOriginScript versions the write of input, the version is newer than the record of the key.
+// it returns 0 if the record is set by the opposite direction and not seen, the write is superseded.
+// KEYS : record key; ARGV : time, origin id, the seen record, ttl of record
+var lwwOriginScript = []byte(strings.TrimSpace(`
+if redis.replicate_commands then redis.replicate_commands() end
+local ts = tonumber(ARGV[1])
+local cur = redis.call('get', KEYS[1])
+if cur then
+	local cts, origin = string.match(cur, '^(%d+):(.*)$')
+	if cts then
+		if origin ~= ARGV[2] and cur ~= ARGV[3] then
+			return 0
+		end
+		if tonumber(cts) >= ts then
+			ts = tonumber(cts) + 1
+		end
+	end
+end
+redis.call('set', KEYS[1], string.format('%d', ts) .. ':' .. ARGV[2], 'px', ARGV[4])
+return ts
+`))
+
+const maxLwwSeenRecords = 1024 * 1024
+
+// lwwResolver resolves conflicts of concurrent writes by last-writer-wins,
+// time of a write is the time when the syncer reads it, so clocks of syncers should be synchronized.
+// commands with multiple keys or without keys, e.g. scripts, and delay keys are applied without versions
+type lwwResolver struct {
+	*inputConn
+	logger   log.Logger
+	originId string
+	ttl      int64             // milliseconds
+	seen     map[string]string // record key -> record set by the opposite direction, seen in tagged transactions
+}
+
+func newLwwResolver(ic *inputConn, logger log.Logger, cfg *config.BidirectionalConfig) *lwwResolver {
+	return &lwwResolver{
+		inputConn: ic,
+		logger:    logger,
+		originId:  cfg.OriginId,
+		ttl:       cfg.LwwRecordTtl.Milliseconds(),
+		seen:      make(map[string]string),
+	}
+}
+
+// observe keeps the record set by a command of the tagged transaction
+func (lr *lwwResolver) observe(cmd string, argv [][]byte) {
+	if cmd != "set" || len(argv) < 2 || !bytes.HasPrefix(argv[0], []byte(lwwKeyPrefix)) {
+		return
+	}
+	if len(lr.seen) >= maxLwwSeenRecords {
+		// writes of input whose records are dropped are regarded as superseded
+		lr.logger.Warnf("too many lww records of the opposite direction, drop them : count(%d)", len(lr.seen))
+		lr.seen = make(map[string]string)
+	}
+	lr.seen[string(argv[0])] = string(argv[1])
+}
+
+// resolve versions the write of input, and returns the command of output which applies it by the version,
+// it returns false if the write is superseded by a write of the opposite region
+func (lr *lwwResolver) resolve(ce cmdExecution) (cmdExecution, bool, error) {
+	idxes := common.CommandKeyIndexes(ce.Cmd, ce.Args)
+	if len(idxes) != 1 || ce.syncDelayNs > 0 {
+		return ce, true, nil
+	}
+	key, ok := ce.Args[idxes[0]].([]byte)
+	if !ok {
+		return ce, true, nil
+	}
+	recordKey := slotRecordKey(lwwKeyPrefix, key)
+
+	args := bytesArgs([]interface{}{lwwOriginScript, int64(1), recordKey, time.Now().UnixMilli(), lr.originId, lr.seen[recordKey], lr.ttl})
+	version, err := common.Int64(lr.cli.Do("eval", args...))
+	if err != nil {
+		return ce, false, fmt.Errorf("version write of input error : key(%s), err(%w)", key, err)
+	}
+	delete(lr.seen, recordKey)
+	if version == 0 {
+		return ce, false, nil
+	}
+
+	args = bytesArgs([]interface{}{lwwScript, int64(2), recordKey, key, version, lr.originId, lr.ttl, ce.Cmd})
+	args = append(args, ce.Args...)
+	return cmdExecution{
+		Cmd:           "eval",
+		Args:          args,
+		Offset:        ce.Offset,
+		Db:            ce.Db,
+		syncDelayNs:   ce.syncDelayNs,
+		syncDelayHost: ce.syncDelayHost,
+		syncDelayNode: ce.syncDelayNode,
+	}, true, nil
+}
@@ -0,0 +1,141 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+)
+
+func TestIsOriginMarker(t *testing.T) {
+	key := originKey(config.RedisConfig{Addresses: []string{"127.0.0.1:6379"}}, true)
+	assert.True(t, isOriginMarker("hset", [][]byte{[]byte(key), []byte("origin"), []byte("region-a")}))
+	assert.False(t, isOriginMarker("hset", [][]byte{[]byte(key), []byte("field"), []byte("region-a")}))
+	assert.False(t, isOriginMarker("hset", [][]byte{[]byte("key"), []byte("origin"), []byte("region-a")}))
+	assert.False(t, isOriginMarker("set", [][]byte{[]byte(key), []byte("origin")}))
+}
+
+func TestOriginTagger(t *testing.T) {
+	cli := newFakeRedis(func(cmd string, args []interface{}) interface{} {
+		switch cmd {
+		case "exists":
+			return int64(0)
+		case "restore":
+			if fmt.Sprintf("%s", args[0]) == "bad" {
+				return errors.New("ERR DUMP payload version or checksum are wrong")
+			}
+			return "OK"
+		}
+		return int64(1)
+	})
+	ot := newOriginTagger(cli, "marker", "region-a")
+
+	// reads are not tagged
+	ret, err := ot.Do("exists", "k1")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), ret)
+
+	ret, err = ot.Do("restore", "k1", int64(0), "payload")
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", ret)
+
+	// writes before flush are in one transaction
+	assert.Nil(t, ot.Send("rpush", "k2", "a"))
+	assert.Nil(t, ot.Send("rpush", "k2", "b"))
+	assert.Nil(t, ot.Flush())
+	for i := 0; i < 2; i++ {
+		ret, err = ot.Receive()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), ret)
+	}
+
+	_, err = ot.Do("restore", "bad", int64(0), "payload")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, []string{
+		"exists k1",
+		"hset marker origin region-a", "restore k1 0 payload",
+		"hset marker origin region-a", "rpush k2 a", "rpush k2 b",
+		"hset marker origin region-a", "restore bad 0 payload",
+	}, executedStrings(cli))
+}
+
+func executedStrings(cli *fakeRedis) []string {
+	ret := []string{}
+	for _, cmd := range cli.executed() {
+		parts := []string{}
+		for _, arg := range cmd {
+			parts = append(parts, fmt.Sprintf("%v", arg))
+		}
+		ret = append(ret, strings.Join(parts, " "))
+	}
+	return ret
+}
+
+func TestCheckExecReplies(t *testing.T) {
+	assert.Nil(t, checkExecReplies([]interface{}{"OK", "QUEUED", "QUEUED", []interface{}{int64(1), "OK"}}, 2))
+	assert.NotNil(t, checkExecReplies([]interface{}{"OK", "QUEUED", nil}, 1))
+	assert.NotNil(t, checkExecReplies([]interface{}{"OK", "QUEUED", []interface{}{}}, 1))
+	assert.NotNil(t, checkExecReplies(nil, 1))
+}
+
+func TestLwwResolver(t *testing.T) {
+	var evals [][]interface{}
+	version := int64(1700000000000)
+	cli := newFakeRedis(func(cmd string, args []interface{}) interface{} {
+		evals = append(evals, args)
+		return version
+	})
+	lr := newLwwResolver(&inputConn{cli: cli}, log.WithLogger(""),
+		&config.BidirectionalConfig{OriginId: "region-a", LwwRecordTtl: time.Hour})
+	record := lwwKeyPrefix + "{k}"
+
+	// a record set by the tagged transaction of the opposite direction
+	lr.observe("set", [][]byte{[]byte(record), []byte("1699999999999:region-b"), []byte("px"), []byte("3600000")})
+	lr.observe("set", [][]byte{[]byte("k"), []byte("v")})
+	assert.Equal(t, map[string]string{record: "1699999999999:region-b"}, lr.seen)
+
+	ce, apply, err := lr.resolve(cmdExecution{Cmd: "incr", Args: []interface{}{[]byte("k")}, Offset: 10, Db: 1})
+	assert.Nil(t, err)
+	assert.True(t, apply)
+	assert.Equal(t, "eval", ce.Cmd)
+	assert.Equal(t, int64(10), ce.Offset)
+	assert.Equal(t, 1, ce.Db)
+	assert.Equal(t, lwwScript, ce.Args[0])
+	assert.Equal(t, "2 redis-gunyu-checkpoint-lww-{k} k 1700000000000 region-a 3600000 incr k", joinArgs(ce.Args[1:]))
+	assert.Len(t, evals, 1)
+	assert.Equal(t, lwwOriginScript, evals[0][0])
+	argv := strings.Split(joinArgs(evals[0][1:]), " ")
+	assert.Equal(t, []string{"1", record}, argv[:2])
+	assert.Equal(t, []string{"region-a", "1699999999999:region-b", "3600000"}, argv[3:])
+	assert.Empty(t, lr.seen)
+
+	// superseded by a write of the opposite direction
+	version = 0
+	_, apply, err = lr.resolve(cmdExecution{Cmd: "set", Args: []interface{}{[]byte("k"), []byte("v")}})
+	assert.Nil(t, err)
+	assert.False(t, apply)
+	assert.Equal(t, "", strings.Split(joinArgs(evals[1][1:]), " ")[4]) // not seen
+
+	// commands of multiple keys are not versioned
+	mset := cmdExecution{Cmd: "mset", Args: []interface{}{[]byte("a"), []byte("1"), []byte("b"), []byte("2")}}
+	ce, apply, err = lr.resolve(mset)
+	assert.Nil(t, err)
+	assert.True(t, apply)
+	assert.Equal(t, mset, ce)
+	assert.Len(t, evals, 2)
+}
+
+func joinArgs(args []interface{}) string {
+	parts := []string{}
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprintf("%s", arg))
+	}
+	return strings.Join(parts, " ")
+}
@@ -34,8 +34,9 @@ type StoreChannel struct {
 func NewStoreChannel(cfg StorerConf) *StoreChannel {
 	storer := store.NewStorer(cfg.InputId, cfg.Dir, cfg.MaxSize, cfg.LogSize, cfg.flush)
 	return &StoreChannel{
-		storer: storer,
-		logger: log.WithLogger(config.LogModuleName(fmt.Sprintf("[StoreChannel(%s)] ", cfg.InputId))),
+		storer:    storer,
+		StorerCfg: cfg,
+		logger:    log.WithLogger(config.LogModuleName(fmt.Sprintf("[StoreChannel(%s)] ", cfg.InputId))),
 	}
 }
 
@@ -95,7 +96,7 @@ func (sc *StoreChannel) NewReader(offset Offset) (*store.Reader, error) {
 	// 		return nil, err
 	// 	}
 	// }
-	r, err := sc.storer.GetReader(offset.Offset, config.Get().Channel.VerifyCrc)
+	r, err := sc.storer.GetReader(offset.Offset, sc.StorerCfg.verifyCrc)
 	if err != nil {
 		sc.logger.Errorf("storer.GetReader error : offset(%v), err(%v)", offset, err)
 	}
@@ -0,0 +1,143 @@
+package syncer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
+	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+)
+
+var (
+	delayGauge = metric.NewGaugeVec(metric.GaugeVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "output",
+		Name:      "delay_seconds",
+		Labels:    []string{"input"},
+	})
+)
+
+// DelayControl pauses, resumes and fast-forwards the delayed replay,
+// it's owned by the syncer, so the state is kept after the output is restarted
+type DelayControl struct {
+	mux         sync.Mutex
+	paused      bool
+	fastForward time.Time // commands ingested before it are replayed without delay
+	changed     chan struct{}
+}
+
+type DelayStatus struct {
+	Paused      bool
+	FastForward time.Time
+}
+
+func NewDelayControl() *DelayControl {
+	return &DelayControl{changed: make(chan struct{})}
+}
+
+// Pause holds all commands in the storer, the input keeps syncing
+func (dc *DelayControl) Pause() {
+	dc.update(func() { dc.paused = true })
+}
+
+func (dc *DelayControl) Resume() {
+	dc.update(func() { dc.paused = false })
+}
+
+// FastForward replays commands ingested before now without delay
+func (dc *DelayControl) FastForward() {
+	dc.update(func() { dc.fastForward = time.Now() })
+}
+
+func (dc *DelayControl) Status() DelayStatus {
+	dc.mux.Lock()
+	defer dc.mux.Unlock()
+	return DelayStatus{Paused: dc.paused, FastForward: dc.fastForward}
+}
+
+func (dc *DelayControl) update(fn func()) {
+	dc.mux.Lock()
+	defer dc.mux.Unlock()
+	fn()
+	close(dc.changed)
+	dc.changed = make(chan struct{})
+}
+
+func (dc *DelayControl) state() (bool, time.Time, <-chan struct{}) {
+	dc.mux.Lock()
+	defer dc.mux.Unlock()
+	return dc.paused, dc.fastForward, dc.changed
+}
+
+// ingestClock returns the time when commands are ingested by the storer, the last record is cached
+type ingestClock struct {
+	ingest func(offset int64) (time.Time, int64, bool)
+	right  int64     // offset of the last ingestion record
+	t      time.Time // time of the last ingestion record
+}
+
+func newIngestClock(ingest func(offset int64) (time.Time, int64, bool)) *ingestClock {
+	return &ingestClock{ingest: ingest, right: -1}
+}
+
+// at returns the ingestion time of the command ending at offset
+func (ic *ingestClock) at(offset int64) time.Time {
+	if offset > ic.right {
+		if t, right, ok := ic.ingest(offset); ok {
+			ic.t, ic.right = t, right
+		} else { // unknown, e.g. records are lost after crash, treats it as now
+			ic.t, ic.right = time.Now(), offset
+		}
+	}
+	return ic.t
+}
+
+// delayWaiter holds commands until they have been ingested for the delay of output
+type delayWaiter struct {
+	ro    *RedisOutput
+	ctrl  *DelayControl
+	clock *ingestClock
+}
+
+// wait blocks until the command ending at offset can be replayed
+func (dw *delayWaiter) wait(quit usync.WaitCloser, offset int64) error {
+	const maxWait = time.Second // picks up the reloaded delay
+	for {
+		paused, ff, changed := dw.ctrl.state()
+		delay := dw.ro.outputCfg().Delay
+		wait := maxWait
+		if !paused {
+			if delay <= 0 {
+				delayGauge.Set(0, dw.ro.cfg.InputName)
+				return nil
+			}
+			ingestT := dw.clock.at(offset)
+			if !ingestT.After(ff) {
+				return nil
+			}
+			remain := time.Until(ingestT.Add(delay))
+			if remain <= 0 {
+				delayGauge.Set(time.Since(ingestT).Seconds(), dw.ro.cfg.InputName)
+				return nil
+			}
+			if remain < wait {
+				wait = remain
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-quit.Context().Done():
+			timer.Stop()
+			if err := quit.Error(); err != nil {
+				return err
+			}
+			return context.Canceled
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
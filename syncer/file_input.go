@@ -0,0 +1,443 @@
+package syncer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+	"github.com/mgtv-tech/redis-GunYu/pkg/rdb"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/proto"
+	"github.com/mgtv-tech/redis-GunYu/pkg/store"
+	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+)
+
+/*
+file :
+	replays a rdb file and(or) aof files, e.g. backups of redis, into output.
+	offsets of the channel :
+		the rdb ends at rdbSize+1, output starts from 1,
+		commands of aof files are re-encoded without annotations(e.g. #TS:), and start from rdbSize+1.
+	the run id is generated by paths, sizes and modification times of files,
+	so output resumes from its checkpoint after restarting, unless files are modified.
+	the rdb preamble of the first aof file is replayed as the rdb.
+	corrupted files stop the input, a truncated command at the end of the last aof file is ignored.
+*/
+
+type FileInput struct {
+	*RedisInput
+	fileCfg config.InputFileConfig
+}
+
+func NewFileInput(redisCfg config.RedisConfig, fileCfg config.InputFileConfig) *FileInput {
+	fi := &FileInput{
+		RedisInput: NewRedisInput(redisCfg),
+		fileCfg:    fileCfg,
+	}
+	fi.logger = log.WithLogger(config.LogModuleName(fmt.Sprintf("[FileInput(%s)] ", redisCfg.Address())))
+	return fi
+}
+
+type inputFile struct {
+	path  string
+	start int64 // the end of rdb preamble
+	size  int64
+}
+
+type inputFiles struct {
+	runId   string
+	rdb     *inputFile // nil if there is no rdb
+	aofs    []inputFile
+	aofSize int64 // annotations are dropped, it's the upper bound of re-encoded commands
+}
+
+func (fs *inputFiles) rdbSize() int64 {
+	if fs.rdb == nil {
+		return 0
+	}
+	return fs.rdb.size
+}
+
+func (fi *FileInput) Run() error {
+	fi.logger.Debugf("Run")
+
+	fi.wait.WgAdd(1)
+	usync.SafeGo(func() {
+		defer fi.wait.WgDone()
+		for !fi.wait.IsClosed() {
+			err := fi.run()
+			if err != nil {
+				fi.logger.Errorf("run error : %v", err)
+				if errors.Is(err, ErrCorrupted) {
+					fi.channel.DelRunId(fi.channel.RunId())
+				}
+				if errors.Is(err, ErrBreak) {
+					fi.wait.Close(err)
+					break
+				}
+			}
+			fi.wait.Sleep(2 * time.Second)
+		}
+	}, func(i interface{}) {
+		fi.wait.Close(fmt.Errorf("panic : %v", i))
+	})
+
+	fi.wait.WgWait()
+	return fi.wait.Error()
+}
+
+func (fi *FileInput) run() error {
+	fi.fsm.Reset()
+
+	inputStateGauge.Set(0, fi.inputAddr)
+	defer inputStateGauge.Set(0, fi.inputAddr)
+
+	files, err := fi.statFiles()
+	if err != nil {
+		return errors.Join(ErrBreak, err)
+	}
+	fi.setRunIds([]string{files.runId})
+
+	runScope := usync.NewWaitCloserFromParent(fi.wait, nil)
+	startPoint, err := fi.ingest(runScope, files)
+	if err != nil {
+		runScope.Close(err)
+	}
+	reader := fi.readChannel(runScope, startPoint)
+	fi.sendOutput(runScope.Context(), runScope, reader)
+
+	runScope.WgWait()
+	return runScope.Error()
+}
+
+// statFiles returns the rdb and aof files of config
+func (fi *FileInput) statFiles() (*inputFiles, error) {
+	rdbPath := fi.fileCfg.Rdb
+	aofPaths := []string{}
+	if fi.fileCfg.Aof != "" {
+		if store.IsAofManifest(fi.fileCfg.Aof) {
+			manifest, err := store.ParseAofManifest(fi.fileCfg.Aof)
+			if err != nil {
+				return nil, err
+			}
+			files := manifest.Files()
+			if manifest.Base != nil && manifest.Base.IsRdb() {
+				if rdbPath != "" {
+					return nil, fmt.Errorf("both rdb and base rdb of aof manifest are specified : rdb(%s), base(%s)", rdbPath, files[0])
+				}
+				rdbPath = files[0]
+				files = files[1:]
+			}
+			aofPaths = files
+		} else {
+			aofPaths = append(aofPaths, fi.fileCfg.Aof)
+		}
+	}
+
+	files := &inputFiles{}
+	hash := sha1.New()
+	stat := func(path string) (inputFile, error) {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return inputFile{}, err
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return inputFile{}, err
+		}
+		if info.IsDir() {
+			return inputFile{}, fmt.Errorf("not a file : %s", abs)
+		}
+		fmt.Fprintf(hash, "%s:%d:%d\n", abs, info.Size(), info.ModTime().UnixNano())
+		return inputFile{path: abs, size: info.Size()}, nil
+	}
+
+	if rdbPath != "" {
+		f, err := stat(rdbPath)
+		if err != nil {
+			return nil, err
+		}
+		files.rdb = &f
+	}
+	for _, path := range aofPaths {
+		f, err := stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files.aofs = append(files.aofs, f)
+		files.aofSize += f.size
+	}
+	if len(files.aofs) > 0 {
+		size, err := rdbPreambleSize(files.aofs[0].path)
+		if err != nil {
+			return nil, err
+		}
+		if size > 0 {
+			if files.rdb != nil {
+				return nil, fmt.Errorf("both rdb and rdb preamble of aof are specified : rdb(%s), aof(%s)", files.rdb.path, files.aofs[0].path)
+			}
+			files.rdb = &inputFile{path: files.aofs[0].path, size: size}
+			files.aofs[0].start = size
+			files.aofs[0].size -= size
+			files.aofSize -= size
+		}
+	}
+	files.runId = hex.EncodeToString(hash.Sum(nil))
+	return files, nil
+}
+
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// rdbPreambleSize returns the size of rdb preamble of the aof file, it's 0 if there is no preamble
+func rdbPreambleSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	br := bufio.NewReaderSize(f, 64*1024)
+	if magic, _ := br.Peek(5); !bytes.Equal(magic, []byte("REDIS")) {
+		return 0, nil
+	}
+
+	// the loader reads exactly the rdb
+	cr := &countingReader{reader: br}
+	loader := rdb.NewLoader(cr, "")
+	if err = loader.Header(); err != nil {
+		return 0, fmt.Errorf("rdb preamble : file(%s), error(%w)", path, err)
+	}
+	for {
+		e, err := loader.Next()
+		if err != nil {
+			return 0, fmt.Errorf("rdb preamble : file(%s), error(%w)", path, err)
+		}
+		if e == nil {
+			break
+		}
+	}
+	if err = loader.Footer(); err != nil {
+		return 0, fmt.Errorf("rdb preamble : file(%s), error(%w)", path, err)
+	}
+	return cr.n, nil
+}
+
+// ingest writes files into the channel from where output or channel stops, and returns the start point of output
+func (fi *FileInput) ingest(wait usync.WaitCloser, files *inputFiles) (StartPoint, error) {
+	runId := files.runId
+	aofLeft := files.rdbSize() + 1
+	aofRight := aofLeft + files.aofSize
+
+	sp, err := fi.getOutputStartPoint(wait.Context(), []string{runId})
+	if err != nil {
+		return sp, err
+	}
+	if sp.RunId != runId {
+		sp = StartPoint{RunId: runId, Offset: aofLeft - files.rdbSize()}
+	}
+	if sp.Offset > aofRight {
+		return sp, errors.Join(ErrBreak, fmt.Errorf("checkpoint of output is beyond files : %v, right(%d)", sp, aofRight))
+	}
+
+	// channel has the rdb and a part of aof
+	if fi.channel.RunId() == runId && fi.channel.IsValidOffset(sp.ToOffset()) {
+		if _, right := fi.channel.GetOffsetRange(runId); right >= aofLeft {
+			fi.fsm.SetState(SyncStateFullSynced)
+			return sp, fi.ingestAof(wait, files, right, aofLeft)
+		}
+	}
+
+	if err = fi.resetChannel(wait, runId); err != nil {
+		return sp, err
+	}
+
+	if sp.Offset >= aofLeft {
+		fi.fsm.SetState(SyncStateFullSynced)
+		return sp, fi.ingestAof(wait, files, sp.Offset, aofLeft)
+	}
+
+	if !rdbLimiterAcquire(wait.Done()) {
+		return sp, wait.Error()
+	}
+	rdbFile, err := os.Open(files.rdb.path)
+	if err != nil {
+		rdbLimiterRelease()
+		return sp, err
+	}
+	rdbWriter, err := fi.channel.NewRdbWriter(rdbFile, aofLeft, files.rdbSize())
+	if err != nil {
+		rdbFile.Close()
+		rdbLimiterRelease()
+		return sp, err
+	}
+	metricSyncType.Inc(fi.inputAddr, "full")
+	inputStateGauge.Set(1, fi.inputAddr)
+	fi.logger.Infof("rdb : runId(%s), file(%s), size(%d)", runId, files.rdb.path, files.rdbSize())
+
+	wait.WgAdd(1)
+	usync.SafeGo(func() {
+		defer wait.WgDone()
+		fi.fsm.SetState(SyncStateFullSyncing)
+		rdbWriter.Start()
+		err := rdbWriter.Wait(wait.Context())
+		rdbWriter.Close()
+		rdbFile.Close()
+		rdbLimiterRelease()
+		if err != nil {
+			fi.logger.Errorf("rdb writer error : err(%v)", err)
+			wait.Close(err)
+			return
+		}
+		fi.fsm.SetState(SyncStateFullSynced)
+		if err = fi.ingestAof(wait, files, aofLeft, aofLeft); err != nil {
+			wait.Close(err)
+		}
+	}, func(i interface{}) { wait.Close(fmt.Errorf("panic : %v", i)) })
+
+	return sp, nil
+}
+
+// ingestAof writes aof files into the channel from offset
+func (fi *FileInput) ingestAof(wait usync.WaitCloser, files *inputFiles, offset int64, aofLeft int64) error {
+	if wait.IsClosed() {
+		return nil
+	}
+	reader, closer, err := fi.openAofs(wait, files, offset-aofLeft)
+	if err != nil {
+		return err
+	}
+	aofWriter, err := fi.channel.NewAofWritter(reader, offset)
+	if err != nil {
+		closer()
+		return err
+	}
+	metricSyncType.Inc(fi.inputAddr, "incr")
+	inputStateGauge.Set(2, fi.inputAddr)
+	fi.logger.Infof("aof : runId(%s), files(%d), offset(%d), right(%d)", files.runId, len(files.aofs), offset, aofLeft+files.aofSize)
+
+	wait.WgAdd(1)
+	usync.SafeGo(func() {
+		defer wait.WgDone()
+		fi.fsm.SetState(SyncStateIncrSyncing)
+		aofWriter.Start()
+		err := aofWriter.Wait(wait.Context())
+		aofWriter.Close()
+		closer()
+		fi.fsm.SetState(SyncStateIncrSynced)
+		wait.Close(err)
+	}, func(i interface{}) { wait.Close(fmt.Errorf("panic : %v", i)) })
+	return nil
+}
+
+// openAofs returns a reader of commands of aof files from pos,
+// the reader blocks at the end of files until wait is closed, so output keeps sending the channel
+func (fi *FileInput) openAofs(wait usync.WaitCloser, files *inputFiles, pos int64) (io.Reader, func(), error) {
+	paths := make([]string, 0, len(files.aofs))
+	for _, af := range files.aofs {
+		paths = append(paths, af.path)
+	}
+	var start int64
+	if len(files.aofs) > 0 {
+		start = files.aofs[0].start
+	}
+	reader := newFileAofReader(store.NewRedisAofReaderFrom(paths, start), pos, fi.logger)
+	if len(paths) > 0 {
+		reader.last = paths[len(paths)-1]
+	}
+	return io.MultiReader(reader, &fileTailReader{wait: wait, logger: fi.logger}), func() { reader.Close() }, nil
+}
+
+// fileAofReader re-encodes commands of aof files, the re-encoded stream is the same for files of a run id,
+// so it resumes from a position of the stream by skipping commands before it
+type fileAofReader struct {
+	mux    sync.Mutex // Close is called by another goroutine
+	reader *store.RedisAofReader
+	last   string // the last file
+	skip   int64
+	buf    bytes.Buffer
+	writer *proto.Writer
+	logger log.Logger
+}
+
+func newFileAofReader(reader *store.RedisAofReader, pos int64, logger log.Logger) *fileAofReader {
+	r := &fileAofReader{reader: reader, skip: pos, logger: logger}
+	r.writer = proto.NewWriter(&r.buf, 64*1024)
+	return r
+}
+
+func (r *fileAofReader) Read(p []byte) (int, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for r.buf.Len() == 0 {
+		cmd, err := r.reader.Next()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			file, offset := r.reader.File()
+			if errors.Is(err, io.ErrUnexpectedEOF) && file == r.last {
+				r.logger.Warnf("the last aof is truncated : file(%s), offset(%d)", file, offset)
+				return 0, io.EOF
+			}
+			// files are corrupted, it's not retried
+			return 0, errors.Join(ErrBreak, err)
+		}
+		args := make([]interface{}, 0, len(cmd.Args))
+		for _, arg := range cmd.Args {
+			args = append(args, arg)
+		}
+		if err = r.writer.WriteArgs(args); err == nil {
+			err = r.writer.Flush()
+		}
+		if err != nil {
+			return 0, err
+		}
+		if r.skip > 0 {
+			n := int64(r.buf.Len())
+			if n > r.skip {
+				n = r.skip
+			}
+			r.buf.Next(int(n))
+			r.skip -= n
+		}
+	}
+	return r.buf.Read(p)
+}
+
+func (r *fileAofReader) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.reader.Close()
+}
+
+type fileTailReader struct {
+	wait   usync.WaitCloser
+	logger log.Logger
+	done   bool
+}
+
+func (r *fileTailReader) Read(p []byte) (int, error) {
+	if !r.done {
+		r.done = true
+		r.logger.Infof("files are ingested")
+	}
+	<-r.wait.Done()
+	return 0, io.EOF
+}
@@ -0,0 +1,112 @@
+package syncer
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+	"github.com/mgtv-tech/redis-GunYu/pkg/store"
+	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+)
+
+const (
+	testAofSelect = "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n"
+	testAofSet    = "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$1\r\nv\r\n"
+	testAofDel    = "*2\r\n$3\r\ndel\r\n$1\r\nk\r\n"
+	// a string k=v in db 0 without checksum
+	testRdbPreamble = "REDIS0011\xfe\x00\x00\x01k\x01v\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+)
+
+func writeTestFiles(t *testing.T, contents ...string) []string {
+	dir := t.TempDir()
+	paths := []string{}
+	for i, content := range contents {
+		path := filepath.Join(dir, "appendonly.aof."+string(rune('1'+i))+".incr.aof")
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0666))
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func readFileAof(paths []string, start int64, pos int64) (string, error) {
+	r := newFileAofReader(store.NewRedisAofReaderFrom(paths, start), pos, log.WithLogger(""))
+	r.last = paths[len(paths)-1]
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+func TestFileAofReader(t *testing.T) {
+	paths := writeTestFiles(t,
+		"#TS:1700000000\r\n"+testAofSelect+"#TS:1700000001\r\n"+testAofSet,
+		testAofDel+"*2\r\n$3\r\ndel\r\n$1", // the last command is truncated
+	)
+	stream := testAofSelect + testAofSet + testAofDel
+
+	data, err := readFileAof(paths, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, stream, data)
+
+	// resumes from the middle of a command
+	pos := int64(len(testAofSelect) + 5)
+	data, err = readFileAof(paths, 0, pos)
+	assert.Nil(t, err)
+	assert.Equal(t, stream[pos:], data)
+
+	// the truncated file is not the last one
+	_, err = readFileAof([]string{paths[1], paths[0]}, 0, 0)
+	assert.True(t, errors.Is(err, ErrBreak))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	paths = writeTestFiles(t, testAofSelect+"+OK\r\n")
+	_, err = readFileAof(paths, 0, 0)
+	assert.True(t, errors.Is(err, ErrBreak))
+}
+
+func TestFileInputRdbPreamble(t *testing.T) {
+	paths := writeTestFiles(t, testRdbPreamble+"#TS:1700000000\r\n"+testAofSet, testAofDel)
+	size, err := rdbPreambleSize(paths[1])
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+
+	manifest := filepath.Join(filepath.Dir(paths[0]), "appendonly.aof.manifest")
+	assert.Nil(t, os.WriteFile(manifest, []byte(
+		"file "+filepath.Base(paths[0])+" seq 1 type b\nfile "+filepath.Base(paths[1])+" seq 2 type i\n"), 0666))
+
+	for _, aof := range []string{manifest, paths[0]} {
+		fi := NewFileInput(config.RedisConfig{Addresses: []string{"file"}}, config.InputFileConfig{Aof: aof})
+		files, err := fi.statFiles()
+		assert.Nil(t, err)
+		assert.Equal(t, &inputFile{path: paths[0], size: int64(len(testRdbPreamble))}, files.rdb)
+		assert.Equal(t, int64(len(testRdbPreamble)), files.aofs[0].start)
+		assert.Equal(t, files.aofs[0].size+int64(len(testRdbPreamble)), int64(len(testRdbPreamble+"#TS:1700000000\r\n"+testAofSet)))
+
+		wait := usync.NewWaitCloser(nil)
+		wait.Close(nil)
+		reader, closer, err := fi.openAofs(wait, files, 0)
+		assert.Nil(t, err)
+		data, err := io.ReadAll(reader)
+		closer()
+		assert.Nil(t, err)
+		if aof == manifest {
+			assert.Equal(t, testAofSet+testAofDel, string(data))
+		} else {
+			assert.Equal(t, testAofSet, string(data))
+		}
+	}
+
+	fi := NewFileInput(config.RedisConfig{Addresses: []string{"file"}}, config.InputFileConfig{Rdb: paths[1], Aof: paths[0]})
+	_, err = fi.statFiles()
+	assert.NotNil(t, err)
+
+	// the preamble is corrupted
+	paths = writeTestFiles(t, testRdbPreamble[:12])
+	_, err = rdbPreambleSize(paths[0])
+	assert.NotNil(t, err)
+}
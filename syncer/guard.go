@@ -0,0 +1,341 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
+	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+)
+
+/*
+dangerous command guard :
+	commands of output.guard.commands are quarantined one by one,
+	delete commands are counted by the second when they are ingested, the rest of the second is quarantined
+	if deleted keys exceed output.guard.maxDeletedKeysPerSecond.
+	the syncer is paused when a window is tripped, and resumed when it's approved or skipped by the API.
+	a skipped deletion window keeps the guard tripped, later deletions are skipped too until the window is approved.
+*/
+
+const (
+	GuardPolicyCommand     = "command"
+	GuardPolicyDeletedKeys = "deletedKeysPerSecond"
+)
+
+type GuardDecision string
+
+const (
+	GuardPending GuardDecision = "pending"
+	GuardApprove GuardDecision = "approve"
+	GuardSkip    GuardDecision = "skip"
+)
+
+const maxGuardCommands = 100 // held commands listed in a window
+
+var (
+	guardTripCounter = metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "output",
+		Name:      "guard_trip",
+		Labels:    []string{"input", "policy"},
+	})
+	guardPendingGauge = metric.NewGaugeVec(metric.GaugeVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "output",
+		Name:      "guard_pending",
+		Labels:    []string{"input"},
+	})
+)
+
+// GuardWindow is a window of quarantined commands
+type GuardWindow struct {
+	Policy   string
+	Reason   string
+	Offset   int64     // offset of the first held command
+	Start    time.Time // ingestion time of the window
+	Commands []string  // held commands, arguments are truncated, at most maxGuardCommands
+	Held     int       // number of held commands
+	Tripped  time.Time
+	Decision GuardDecision
+
+	last int64 // offset of the last held command
+}
+
+// same returns true if both are the same window, the output may quarantine it again after restarting
+func (gw *GuardWindow) same(o *GuardWindow) bool {
+	if gw.Policy != o.Policy {
+		return false
+	}
+	if gw.Policy == GuardPolicyDeletedKeys {
+		return gw.Start.Equal(o.Start)
+	}
+	return gw.Offset == o.Offset
+}
+
+// addHeld lists the held command, commands replayed again after restarting are not listed twice
+func (gw *GuardWindow) addHeld(offset int64, cmd string) {
+	if gw.Held > 0 && offset <= gw.last {
+		return
+	}
+	gw.last = offset
+	gw.Held++
+	if len(gw.Commands) < maxGuardCommands {
+		gw.Commands = append(gw.Commands, cmd)
+	}
+}
+
+// GuardControl keeps the quarantined window, it's owned by the syncer,
+// so the decision is kept after the output is restarted
+type GuardControl struct {
+	mux     sync.Mutex
+	window  *GuardWindow
+	changed chan struct{}
+	pause   func() // pauses the syncer when a window is tripped, it doesn't block
+	resume  func() // resumes the syncer when the window is decided
+}
+
+func NewGuardControl() *GuardControl {
+	return &GuardControl{changed: make(chan struct{})}
+}
+
+func (gc *GuardControl) setPauser(pause func(), resume func()) {
+	gc.mux.Lock()
+	defer gc.mux.Unlock()
+	gc.pause, gc.resume = pause, resume
+}
+
+// Window returns a copy of the quarantined window, nil if there is none
+func (gc *GuardControl) Window() *GuardWindow {
+	gc.mux.Lock()
+	defer gc.mux.Unlock()
+	if gc.window == nil {
+		return nil
+	}
+	w := *gc.window
+	w.Commands = append([]string{}, gc.window.Commands...)
+	return &w
+}
+
+// Approve replays the quarantined window, or untrips the guard of a skipped deletion window,
+// false if there is no window to approve
+func (gc *GuardControl) Approve() bool {
+	return gc.decide(GuardApprove)
+}
+
+// Skip drops the quarantined window, false if there is no pending window
+func (gc *GuardControl) Skip() bool {
+	return gc.decide(GuardSkip)
+}
+
+func (gc *GuardControl) decide(d GuardDecision) bool {
+	gc.mux.Lock()
+	w := gc.window
+	switch {
+	case w == nil:
+		gc.mux.Unlock()
+		return false
+	case w.Decision == GuardPending:
+		w.Decision = d
+	case w.Decision == GuardSkip && d == GuardApprove:
+		gc.window = nil
+	default:
+		gc.mux.Unlock()
+		return false
+	}
+	close(gc.changed)
+	gc.changed = make(chan struct{})
+	resume := gc.resume
+	gc.mux.Unlock()
+
+	if resume != nil {
+		resume()
+	}
+	return true
+}
+
+// tripped returns the window which the command at offset belongs to, nil if the guard isn't tripped
+func (gc *GuardControl) tripped(policy string, offset int64) *GuardWindow {
+	gc.mux.Lock()
+	defer gc.mux.Unlock()
+	if gc.window == nil || gc.window.Policy != policy || offset < gc.window.Offset {
+		return nil
+	}
+	return gc.window
+}
+
+// skipHeld lists the command skipped by the window, false if the window is not skipping
+func (gc *GuardControl) skipHeld(w *GuardWindow, offset int64, cmd string) bool {
+	gc.mux.Lock()
+	defer gc.mux.Unlock()
+	if gc.window != w || w.Decision != GuardSkip {
+		return false
+	}
+	w.addHeld(offset, cmd)
+	return true
+}
+
+// hold quarantines the window and waits for the decision, the syncer is paused if the window is tripped
+func (gc *GuardControl) hold(quit usync.WaitCloser, w *GuardWindow, offset int64, cmd string) (GuardDecision, error) {
+	gc.mux.Lock()
+	if gc.window == nil || !gc.window.same(w) {
+		gc.window = w
+	}
+	win := gc.window
+	pending := win.Decision == GuardPending
+	if pending {
+		win.addHeld(offset, cmd)
+	}
+	pause := gc.pause
+	gc.mux.Unlock()
+	if pending && pause != nil {
+		pause()
+	}
+
+	for {
+		gc.mux.Lock()
+		d, changed := win.Decision, gc.changed
+		// a skipped deletion window keeps the guard tripped
+		if d != GuardPending && gc.window == win && (d == GuardApprove || win.Policy == GuardPolicyCommand) {
+			gc.window = nil
+		}
+		gc.mux.Unlock()
+		if d != GuardPending {
+			return d, nil
+		}
+
+		select {
+		case <-quit.Context().Done():
+			if err := quit.Error(); err != nil {
+				return d, err
+			}
+			return d, context.Canceled
+		case <-changed:
+		}
+	}
+}
+
+// cmdGuard checks replayed commands with policies of output.guard
+type cmdGuard struct {
+	ro      *RedisOutput
+	ctrl    *GuardControl
+	clock   *ingestClock
+	second  int64         // ingestion second of deleted keys
+	deleted int           // deleted keys in the second
+	verdict GuardDecision // decision of the rest of the second
+}
+
+// check returns false if the command is skipped, it blocks until the quarantined command is approved or skipped
+func (cg *cmdGuard) check(quit usync.WaitCloser, cmd string, argv [][]byte, offset int64) (bool, error) {
+	gcfg := cg.ro.outputCfg().Guard
+	if gcfg == nil {
+		return true, nil
+	}
+
+	if gcfg.Quarantines(cmd) {
+		d, err := cg.hold(quit, &GuardWindow{
+			Policy: GuardPolicyCommand,
+			Reason: fmt.Sprintf("command %s is quarantined", cmd),
+			Offset: offset,
+			Start:  cg.clock.at(offset),
+		}, offset, guardCommandString(cmd, argv))
+		return d == GuardApprove, err
+	}
+
+	n := deletedKeys(cmd, argv)
+	if gcfg.MaxDeletedKeysPerSecond <= 0 || n == 0 {
+		return true, nil
+	}
+	ingestT := cg.clock.at(offset)
+	if w := cg.ctrl.tripped(GuardPolicyDeletedKeys, offset); w != nil {
+		// the window is tripped before the output is restarted
+		if cg.ctrl.skipHeld(w, offset, guardCommandString(cmd, argv)) {
+			return false, nil
+		}
+		return cg.holdDeletion(quit, w, cmd, argv, offset)
+	}
+
+	if sec := ingestT.Unix(); sec != cg.second {
+		cg.second, cg.deleted, cg.verdict = sec, 0, GuardPending
+	}
+	cg.deleted += n
+	if cg.verdict != GuardPending {
+		return cg.verdict == GuardApprove, nil
+	}
+	if cg.deleted <= gcfg.MaxDeletedKeysPerSecond {
+		return true, nil
+	}
+	return cg.holdDeletion(quit, &GuardWindow{
+		Policy: GuardPolicyDeletedKeys,
+		Reason: fmt.Sprintf("%d keys are deleted in a second, exceeds %d", cg.deleted, gcfg.MaxDeletedKeysPerSecond),
+		Offset: offset,
+		Start:  time.Unix(cg.second, 0),
+	}, cmd, argv, offset)
+}
+
+// holdDeletion holds the deletion window, the approval replays the rest of the second of the window
+func (cg *cmdGuard) holdDeletion(quit usync.WaitCloser, w *GuardWindow, cmd string, argv [][]byte, offset int64) (bool, error) {
+	d, err := cg.hold(quit, w, offset, guardCommandString(cmd, argv))
+	if err != nil {
+		return false, err
+	}
+	cg.second, cg.deleted, cg.verdict = w.Start.Unix(), 0, d
+	return d == GuardApprove, nil
+}
+
+func (cg *cmdGuard) hold(quit usync.WaitCloser, w *GuardWindow, offset int64, cmd string) (GuardDecision, error) {
+	if cur := cg.ctrl.Window(); cur == nil || !cur.same(w) {
+		w.Tripped = time.Now()
+		w.Decision = GuardPending
+		guardTripCounter.Inc(cg.ro.cfg.InputName, w.Policy)
+		cg.ro.logger.Errorf("replay is held by guard, the syncer is paused, approve or skip it by API : policy(%s), reason(%s), offset(%d), command(%s)",
+			w.Policy, w.Reason, offset, cmd)
+	}
+	guardPendingGauge.Set(1, cg.ro.cfg.InputName)
+
+	d, err := cg.ctrl.hold(quit, w, offset, cmd)
+	if err == nil {
+		// the gauge is kept if the output is stopped by the pause
+		guardPendingGauge.Set(0, cg.ro.cfg.InputName)
+		cg.ro.logger.Infof("guard window is decided : policy(%s), offset(%d), decision(%s)", w.Policy, w.Offset, d)
+	}
+	return d, err
+}
+
+// deletedKeys returns the number of keys deleted by the command
+func deletedKeys(cmd string, argv [][]byte) int {
+	switch cmd {
+	case "del", "unlink":
+		return len(argv)
+	case "getdel":
+		return 1
+	}
+	return 0
+}
+
+// guardCommandString returns the command with a few truncated arguments
+func guardCommandString(cmd string, argv [][]byte) string {
+	const (
+		maxArgs   = 3
+		maxArgLen = 64
+	)
+	var sb strings.Builder
+	sb.WriteString(cmd)
+	for i, arg := range argv {
+		if i == maxArgs {
+			fmt.Fprintf(&sb, " ...(%d more)", len(argv)-maxArgs)
+			break
+		}
+		sb.WriteByte(' ')
+		if len(arg) > maxArgLen {
+			sb.Write(arg[:maxArgLen])
+			sb.WriteString("...")
+		} else {
+			sb.Write(arg)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,158 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+)
+
+// newTestGuard returns a guard whose commands are ingested in the second of offset/100
+func newTestGuard(ctrl *GuardControl, gcfg *config.GuardConfig) *cmdGuard {
+	ro := newTestOutput(&config.OutputConfig{Guard: gcfg})
+	clock := newIngestClock(func(offset int64) (time.Time, int64, bool) {
+		return time.Unix(offset/100, 0), offset, true
+	})
+	return &cmdGuard{ro: ro, ctrl: ctrl, clock: clock}
+}
+
+type guardResult struct {
+	pass bool
+	err  error
+}
+
+// checkAsync checks the command, the result is sent after it's decided
+func checkAsync(cg *cmdGuard, quit usync.WaitCloser, cmd string, offset int64, args ...string) chan guardResult {
+	argv := [][]byte{}
+	for _, arg := range args {
+		argv = append(argv, []byte(arg))
+	}
+	ret := make(chan guardResult, 1)
+	go func() {
+		pass, err := cg.check(quit, cmd, argv, offset)
+		ret <- guardResult{pass, err}
+	}()
+	return ret
+}
+
+func waitPending(t *testing.T, ctrl *GuardControl) *GuardWindow {
+	assert.Eventually(t, func() bool {
+		w := ctrl.Window()
+		return w != nil && w.Decision == GuardPending
+	}, time.Second, time.Millisecond)
+	return ctrl.Window()
+}
+
+func newCountedControl() (*GuardControl, *atomic.Int32, *atomic.Int32) {
+	var pauses, resumes atomic.Int32
+	ctrl := NewGuardControl()
+	ctrl.setPauser(func() { pauses.Add(1) }, func() { resumes.Add(1) })
+	return ctrl, &pauses, &resumes
+}
+
+func TestGuardCommand(t *testing.T) {
+	ctrl, pauses, resumes := newCountedControl()
+	cg := newTestGuard(ctrl, &config.GuardConfig{Commands: []string{"flushall"}})
+	quit := usync.NewWaitCloser(nil)
+
+	ret := checkAsync(cg, quit, "set", 100, "k", "v")
+	assert.Equal(t, guardResult{pass: true}, <-ret)
+
+	ret = checkAsync(cg, quit, "flushall", 200)
+	w := waitPending(t, ctrl)
+	assert.Equal(t, GuardPolicyCommand, w.Policy)
+	assert.Equal(t, []string{"flushall"}, w.Commands)
+	assert.Equal(t, int32(1), pauses.Load())
+	assert.True(t, ctrl.Approve())
+	assert.Equal(t, guardResult{pass: true}, <-ret)
+	assert.Equal(t, int32(1), resumes.Load())
+	assert.Nil(t, ctrl.Window())
+	assert.False(t, ctrl.Approve())
+
+	ret = checkAsync(cg, quit, "flushall", 300, "async")
+	waitPending(t, ctrl)
+	assert.True(t, ctrl.Skip())
+	assert.Equal(t, guardResult{pass: false}, <-ret)
+	assert.Nil(t, ctrl.Window())
+}
+
+func TestGuardDeletedKeys(t *testing.T) {
+	ctrl, _, _ := newCountedControl()
+	cg := newTestGuard(ctrl, &config.GuardConfig{MaxDeletedKeysPerSecond: 2})
+	quit := usync.NewWaitCloser(nil)
+
+	assert.Equal(t, guardResult{pass: true}, <-checkAsync(cg, quit, "del", 100, "a", "b"))
+	ret := checkAsync(cg, quit, "unlink", 110, "c")
+	w := waitPending(t, ctrl)
+	assert.Equal(t, GuardPolicyDeletedKeys, w.Policy)
+	assert.Equal(t, time.Unix(1, 0), w.Start)
+	assert.Equal(t, []string{"unlink c"}, w.Commands)
+	assert.True(t, ctrl.Skip())
+	assert.Equal(t, guardResult{pass: false}, <-ret)
+
+	// the guard is tripped until it's approved, skipped deletions of later seconds are listed once
+	assert.Equal(t, guardResult{pass: true}, <-checkAsync(cg, quit, "set", 250, "d", "v"))
+	assert.Equal(t, guardResult{pass: false}, <-checkAsync(cg, quit, "del", 250, "d"))
+	assert.Equal(t, guardResult{pass: false}, <-checkAsync(cg, quit, "del", 250, "d"))
+	assert.Equal(t, guardResult{pass: false}, <-checkAsync(cg, quit, "getdel", 500, "e"))
+	w = ctrl.Window()
+	assert.Equal(t, GuardSkip, w.Decision)
+	assert.Equal(t, 3, w.Held)
+	assert.Equal(t, []string{"unlink c", "del d", "getdel e"}, w.Commands)
+	assert.False(t, ctrl.Skip())
+
+	assert.True(t, ctrl.Approve())
+	assert.Nil(t, ctrl.Window())
+	assert.Equal(t, guardResult{pass: true}, <-checkAsync(cg, quit, "del", 600, "f"))
+}
+
+func TestGuardPausedAndRestarted(t *testing.T) {
+	ctrl := NewGuardControl()
+	gcfg := &config.GuardConfig{MaxDeletedKeysPerSecond: 2}
+	quit := usync.NewWaitCloser(nil)
+	// the pause stops the output
+	ctrl.setPauser(func() { quit.Close(nil) }, nil)
+
+	cg := newTestGuard(ctrl, gcfg)
+	assert.Equal(t, guardResult{pass: true}, <-checkAsync(cg, quit, "del", 100, "a", "b"))
+	ret := <-checkAsync(cg, quit, "del", 110, "c")
+	assert.False(t, ret.pass)
+	assert.ErrorIs(t, ret.err, context.Canceled)
+	assert.Equal(t, GuardPending, ctrl.Window().Decision)
+	assert.True(t, ctrl.Approve())
+
+	// the restarted output resumes from the middle of the second, the decided window is applied
+	ctrl.setPauser(nil, nil)
+	cg = newTestGuard(ctrl, gcfg)
+	quit = usync.NewWaitCloser(nil)
+	assert.Equal(t, guardResult{pass: true}, <-checkAsync(cg, quit, "del", 105, "b"))
+	assert.Equal(t, guardResult{pass: true}, <-checkAsync(cg, quit, "del", 110, "c"))
+	assert.Nil(t, ctrl.Window())
+	assert.Equal(t, guardResult{pass: true}, <-checkAsync(cg, quit, "del", 120, "x", "y", "z"))
+}
+
+func TestGuardWindowHeld(t *testing.T) {
+	w := &GuardWindow{}
+	for i := 0; i < maxGuardCommands+10; i++ {
+		w.addHeld(int64(i+1), fmt.Sprintf("del k%d", i))
+	}
+	w.addHeld(5, "del k4")
+	assert.Equal(t, maxGuardCommands+10, w.Held)
+	assert.Equal(t, maxGuardCommands, len(w.Commands))
+	assert.Equal(t, "del k0", w.Commands[0])
+}
+
+func TestGuardCommandString(t *testing.T) {
+	long := make([]byte, 100)
+	for i := range long {
+		long[i] = 'a'
+	}
+	assert.Equal(t, "del a b c ...(2 more)", guardCommandString("del", [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e")}))
+	assert.Equal(t, "set "+string(long[:64])+"...", guardCommandString("set", [][]byte{long}))
+}
@@ -5,9 +5,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/exp/slices"
 
 	"github.com/mgtv-tech/redis-GunYu/config"
@@ -15,8 +17,10 @@ import (
 	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
 	"github.com/mgtv-tech/redis-GunYu/pkg/store"
 	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+	"github.com/mgtv-tech/redis-GunYu/pkg/telemetry"
 	"github.com/mgtv-tech/redis-GunYu/pkg/util"
 )
 
@@ -62,6 +66,7 @@ type Input interface {
 	Stop() error
 	SetOutput(output Output) // @TODO multi outputs
 	SetChannel(ch Channel)
+	SetLag(lag *SyncLag)
 	StateNotify(SyncState) usync.WaitChannel
 	RunIds() []string
 }
@@ -72,6 +77,7 @@ type RedisInput struct {
 	wait      usync.WaitCloser
 	channel   Channel
 	output    Output
+	lag       *SyncLag
 	fsm       *SyncFiniteStateMachine
 	logger    log.Logger
 	runIds    []string
@@ -80,21 +86,24 @@ type RedisInput struct {
 }
 
 type StorerConf struct {
-	InputId string
-	Dir     string
-	MaxSize int64
-	LogSize int64
-	flush   config.FlushPolicy
+	InputId   string
+	Dir       string
+	MaxSize   int64
+	LogSize   int64
+	flush     config.FlushPolicy
+	verifyCrc bool
 }
 
 func NewRedisInput(redisCfg config.RedisConfig) *RedisInput {
-	return &RedisInput{
+	ri := &RedisInput{
 		inputAddr: redisCfg.Address(),
 		wait:      usync.NewWaitCloser(nil),
 		fsm:       NewSyncFiniteStateMachine(),
 		cfg:       redisCfg,
 		logger:    log.WithLogger(config.LogModuleName(fmt.Sprintf("[RedisInput(%s)] ", redisCfg.Address()))),
 	}
+	ri.fsm.AddObserver(&stateJournal{input: ri.inputAddr, state: ri.fsm.State()})
+	return ri
 }
 
 var (
@@ -124,16 +133,22 @@ func (ri *RedisInput) SetChannel(ch Channel) {
 	ri.channel = ch
 }
 
-func (ri *RedisInput) rdbLimiterAcquire(wait usync.WaitChannel) {
+func (ri *RedisInput) SetLag(lag *SyncLag) {
+	ri.lag = lag
+}
+
+// rdbLimiterAcquire returns false if it's not acquired
+func rdbLimiterAcquire(wait usync.WaitChannel) bool {
 	limiter := config.Get().Input.RdbLimiter()
 	select {
 	case <-wait:
-		return
+		return false
 	case limiter <- struct{}{}:
+		return true
 	}
 }
 
-func (ri *RedisInput) rdbLimiterRelease() {
+func rdbLimiterRelease() {
 	<-config.Get().Input.RdbLimiter()
 }
 
@@ -149,29 +164,29 @@ func (ri *RedisInput) setRunIds(ids []string) {
 	ri.runIds = ids
 }
 
-func (ri *RedisInput) fetchInput(wait usync.WaitCloser) (outSp StartPoint) {
+func (ri *RedisInput) fetchInput(ctx context.Context, wait usync.WaitCloser) (outSp StartPoint) {
 	// RDB concurrency limit
-	ri.rdbLimiterAcquire(wait.Done())
+	rdbLimiterAcquire(wait.Done())
 
 	// for input redis, shouldn't reconnect to redis if encounters error or connection is broken
 	redisCli, err := ri.newRedisConn(wait.Context())
 	if err != nil {
 		wait.Close(errors.Join(ErrRestart, err)) // check typology
-		ri.rdbLimiterRelease()
+		rdbLimiterRelease()
 		return
 	}
 
 	// meta
-	isFullSync, rdbSize, locSp, outSp, err := ri.syncMeta(wait.Context(), redisCli)
+	isFullSync, rdbSize, locSp, outSp, err := ri.syncMeta(ctx, redisCli)
 	if err != nil {
 		wait.Close(err)
-		ri.rdbLimiterRelease()
+		rdbLimiterRelease()
 		redisCli.Close()
 		return
 	}
 
 	// data
-	ri.syncData(wait, redisCli, isFullSync, rdbSize, locSp.Offset)
+	ri.syncData(ctx, wait, redisCli, isFullSync, rdbSize, locSp.Offset)
 	return
 }
 
@@ -221,14 +236,14 @@ func (ri *RedisInput) syncMeta(ctx context.Context, redisCli *redis.StandaloneRe
 		// outSp not in locSp :
 		// 3. channel.right < output.offset :
 		if ri.channel.IsValidOffset(Offset{RunId: locSp.RunId, Offset: outSp.Offset}) {
-			sOffset, isFullSync, rdbSize, err = ri.pSync(redisCli, locSp.ToOffset())
+			sOffset, isFullSync, rdbSize, err = ri.pSync(ctx, redisCli, locSp.ToOffset())
 			if err != nil {
 				return
 			}
 		} else {
 			// there is a gap between output and channel [@TODO, @OPTIMIZE : check distance of gap]
 			// channel.Clear(); locSp = outSp
-			sOffset, isFullSync, rdbSize, err = ri.pSync(redisCli, outSp.ToOffset())
+			sOffset, isFullSync, rdbSize, err = ri.pSync(ctx, redisCli, outSp.ToOffset())
 			if err != nil {
 				return
 			}
@@ -239,7 +254,7 @@ func (ri *RedisInput) syncMeta(ctx context.Context, redisCli *redis.StandaloneRe
 		}
 	} else if slices.Contains(inputIds, outSp.RunId) {
 		// local is stale, set locSp to outSp
-		sOffset, isFullSync, rdbSize, err = ri.pSync(redisCli, outSp.ToOffset())
+		sOffset, isFullSync, rdbSize, err = ri.pSync(ctx, redisCli, outSp.ToOffset())
 		if err != nil {
 			return
 		}
@@ -252,7 +267,7 @@ func (ri *RedisInput) syncMeta(ctx context.Context, redisCli *redis.StandaloneRe
 		// channel has a RDB file, so set offset to zero
 		locRdbLeft, locRdbSize := ri.channel.GetRdb(locSp.RunId)
 		if locRdbLeft != -1 && locRdbSize != -1 { // a valid RDB
-			sOffset, isFullSync, rdbSize, err = ri.pSync(redisCli, locSp.ToOffset())
+			sOffset, isFullSync, rdbSize, err = ri.pSync(ctx, redisCli, locSp.ToOffset())
 			if err != nil {
 				return
 			}
@@ -264,14 +279,14 @@ func (ri *RedisInput) syncMeta(ctx context.Context, redisCli *redis.StandaloneRe
 			}
 		} else {
 			synSp.Initialize()
-			sOffset, isFullSync, rdbSize, err = ri.pSync(redisCli, synSp.ToOffset())
+			sOffset, isFullSync, rdbSize, err = ri.pSync(ctx, redisCli, synSp.ToOffset())
 			if err != nil {
 				return
 			}
 		}
 	} else { // full sync
 		synSp.Initialize()
-		sOffset, isFullSync, rdbSize, err = ri.pSync(redisCli, synSp.ToOffset())
+		sOffset, isFullSync, rdbSize, err = ri.pSync(ctx, redisCli, synSp.ToOffset())
 		if err != nil {
 			return
 		}
@@ -325,7 +340,7 @@ func (ri *RedisInput) syncMeta(ctx context.Context, redisCli *redis.StandaloneRe
 	return
 }
 
-func (ri *RedisInput) syncData(wait usync.WaitCloser, redisCli *redis.StandaloneRedis, isFullSync bool, rdbSize int64, offset int64) {
+func (ri *RedisInput) syncData(ctx context.Context, wait usync.WaitCloser, redisCli *redis.StandaloneRedis, isFullSync bool, rdbSize int64, offset int64) {
 	var rdbWriter *store.RdbWriter
 	var aofWriter *store.AofWriter
 	var err error
@@ -337,7 +352,7 @@ func (ri *RedisInput) syncData(wait usync.WaitCloser, redisCli *redis.Standalone
 		aofWriter, err = ri.channel.NewAofWritter(redisCli.Client().BufioReader(), offset)
 	}
 	if err != nil {
-		ri.rdbLimiterRelease()
+		rdbLimiterRelease()
 		wait.Close(err)
 		return
 	}
@@ -349,18 +364,18 @@ func (ri *RedisInput) syncData(wait usync.WaitCloser, redisCli *redis.Standalone
 			}
 		}()
 		if wait.IsClosed() {
-			ri.rdbLimiterRelease()
+			rdbLimiterRelease()
 			return nil
 		}
 		if isFullSync {
 			ri.logger.Debugf("rdb sync : input(%s), offset(%d), rdbSize(%d)", ri.inputAddr, offset, rdbSize)
-			err = ri.syncRdb(wait.Context(), redisCli.Client().BufioReader(), rdbWriter)
+			err = ri.syncRdb(ctx, redisCli.Client().BufioReader(), rdbWriter)
 			if err != nil {
-				ri.rdbLimiterRelease()
+				rdbLimiterRelease()
 				return err
 			}
 		}
-		ri.rdbLimiterRelease()
+		rdbLimiterRelease()
 
 		if aofWriter == nil {
 			aofWriter, err = ri.channel.NewAofWritter(redisCli.Client().BufioReader(), offset)
@@ -384,9 +399,11 @@ func (ri *RedisInput) syncData(wait usync.WaitCloser, redisCli *redis.Standalone
 }
 
 func (ri *RedisInput) syncRdb(ctx context.Context, reader *bufio.Reader, writer *store.RdbWriter) error {
+	ctx, span := telemetry.StartSpan(ctx, "input.rdb_ingest", attribute.String("input", ri.inputAddr))
 	ri.fsm.SetState(SyncStateFullSyncing)
 	writer.Start()
 	err := writer.Wait(ctx)
+	telemetry.EndSpan(span, err)
 	if err != nil {
 		ri.logger.Errorf("rdb writer error : err(%v)", err)
 	} else {
@@ -469,37 +486,75 @@ func (ri *RedisInput) Run() (err error) {
 	return ri.wait.Error()
 }
 
+// checkSyncDelay writes delay keys to the input node, and polls master_repl_offset of the node every second
 func (ri *RedisInput) checkSyncDelay(wait usync.WaitCloser, cfg config.RedisConfig) {
-	testKey := config.Get().Input.SyncDelayTestKey
-	if testKey == "" {
+	if ri.lag == nil {
 		return
 	}
 	timeout := config.Get().Server.GracefullStopTimeout
+	nodeCfg := cfg
+	nodeCfg.Type = config.RedisTypeStandalone
 	cfg.Type = cfg.Otype
 
 	usync.SafeGo(func() {
 		for !wait.IsClosed() {
-			// @TODO
-			// leader should check every node
-			cli, err := client.NewRedis(cfg)
+			// delay keys are hashed into slots of the input node, the cluster client routes them to its master
+			var cli client.Redis
+			keys := ri.lag.delayKeys()
+			if len(keys) > 0 {
+				var err error
+				cli, err = client.NewRedis(cfg)
+				if err != nil {
+					ri.logger.Errorf("checkSyncDelay, new redis : addr(%s), error(%v)", ri.cfg.Address(), err)
+					wait.Sleep(timeout)
+					continue
+				}
+			}
+			nodeCli, err := client.NewRedis(nodeCfg)
 			if err != nil {
 				ri.logger.Errorf("checkSyncDelay, new redis : addr(%s), error(%v)", ri.cfg.Address(), err)
+				if cli != nil {
+					cli.Close()
+				}
 				wait.Sleep(timeout)
 				continue
 			}
 			for !wait.IsClosed() {
 				val := fmt.Sprintf("%s_%d", cfg.Address(), time.Now().UnixNano())
-				_, err := cli.Do("SET", testKey, val)
+				for _, key := range keys {
+					if _, err := cli.Do("SET", key, val); err != nil {
+						ri.logger.Warnf("checkSyncDelay, set testkey : addr(%s), key(%s), error(%v)", ri.cfg.Address(), key, err)
+					}
+				}
+				offset, err := masterReplOffset(nodeCli)
 				if err != nil {
-					ri.logger.Warnf("checkSyncDelay, set testkey : addr(%s), error(%v)", ri.cfg.Address(), err)
+					ri.logger.Warnf("checkSyncDelay, get master_repl_offset : addr(%s), error(%v)", ri.cfg.Address(), err)
+					break
 				}
+				ri.lag.source(offset)
 				wait.Sleep(1 * time.Second)
 			}
-			cli.Close()
+			if cli != nil {
+				cli.Close()
+			}
+			nodeCli.Close()
+			wait.Sleep(1 * time.Second)
 		}
 	}, nil)
 }
 
+func masterReplOffset(cli client.Redis) (int64, error) {
+	ret, err := common.Bytes(cli.Do("info", "replication"))
+	if err != nil {
+		return 0, err
+	}
+	val, ok := redis.ParseRedisInfo(ret)["master_repl_offset"]
+	if !ok {
+		return 0, errors.New("no master_repl_offset")
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
 var (
 	// 0 is abort; 1 is full sync; 2 is incr sync
 	inputStateGauge = metric.NewGaugeVec(metric.GaugeVecOpts{
@@ -520,14 +575,35 @@ func (ri *RedisInput) run() error {
 	runScope := usync.NewWaitCloserFromParent(ri.wait, nil)
 
 	// input -> channel -> output
-	startPoint := ri.fetchInput(runScope)
+	// the span of a run lasts until the RDB is replayed, batches of incremental sync are traced separately
+	ctx, span := telemetry.StartSpan(runScope.Context(), "input.sync", attribute.String("input", ri.inputAddr))
+	startPoint := ri.fetchInput(ctx, runScope)
 	reader := ri.readChannel(runScope, startPoint)
-	ri.sendOutput(runScope, reader)
+	if reader == nil || reader.IsAof() {
+		telemetry.EndSpan(span, runScope.Error())
+		ri.sendOutput(runScope.Context(), runScope, reader)
+	} else {
+		ri.sendOutput(ctx, runScope, reader)
+		telemetry.EndSpan(span, runScope.Error())
+	}
 
 	runScope.WgWait()
 	return runScope.Error()
 }
 
+// resetChannel discards data of the channel, and starts the run id in channel and output
+func (ri *RedisInput) resetChannel(wait usync.WaitCloser, runId string) error {
+	err := ri.channel.DelRunId(ri.channel.RunId())
+	if err != nil {
+		return err
+	}
+	err = ri.channel.SetRunId(runId)
+	if err != nil {
+		return err
+	}
+	return ri.output.SetRunId(wait.Context(), runId)
+}
+
 func (ri *RedisInput) readChannel(wait usync.WaitCloser, readerOffset StartPoint) *store.Reader {
 	if wait.IsClosed() {
 		return nil
@@ -542,11 +618,11 @@ func (ri *RedisInput) readChannel(wait usync.WaitCloser, readerOffset StartPoint
 	return reader
 }
 
-func (ri *RedisInput) sendOutput(wait usync.WaitCloser, reader *store.Reader) {
+func (ri *RedisInput) sendOutput(ctx context.Context, wait usync.WaitCloser, reader *store.Reader) {
 	if wait.IsClosed() {
 		return
 	}
-	err := ri.output.Send(wait.Context(), reader)
+	err := ri.output.Send(ctx, reader)
 	wait.Close(err)
 }
 
@@ -569,9 +645,16 @@ func (ri *RedisInput) newRedisConn(ctx context.Context) (cli *redis.StandaloneRe
 }
 
 // continue psync ?
-func (ri *RedisInput) pSync(cli *redis.StandaloneRedis, offset Offset) (
+func (ri *RedisInput) pSync(ctx context.Context, cli *redis.StandaloneRedis, offset Offset) (
 	off Offset, fullSync bool, rdbSize int64, err error) {
 
+	_, span := telemetry.StartSpan(ctx, "input.psync", attribute.String("input", ri.inputAddr),
+		attribute.String("run_id", offset.RunId), attribute.Int64("offset", offset.Offset))
+	defer func() {
+		span.SetAttributes(attribute.Bool("full_sync", fullSync), attribute.Int64("rdb_size", rdbSize))
+		telemetry.EndSpan(span, err)
+	}()
+
 	err = cli.SendPSyncListeningPort(config.Get().Server.ListenPort)
 	if err != nil {
 		ri.logger.Errorf("psync error : offset(%v), err(%v)", offset, err)
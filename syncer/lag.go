@@ -0,0 +1,269 @@
+package syncer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
+)
+
+/*
+sync lag :
+	bytes : master_repl_offset of the input node minus the offset acked by output, it's polled every second.
+	time : the input writes input.syncDelayTestKey every second, a key for each target node,
+		keys are hashed into slots of the input node and the target node, so every source shard and every target node is covered.
+		the lag of a target node is the time since the latest applied key was written to the input,
+		it's unknown(-1) until a key is applied.
+*/
+
+var (
+	lagBytesGauge = metric.NewGaugeVec(metric.GaugeVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "lag",
+		Name:      "bytes",
+		Labels:    []string{"input", "shard"},
+	})
+	lagSecondsGauge = metric.NewGaugeVec(metric.GaugeVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "lag",
+		Name:      "seconds",
+		Labels:    []string{"input", "shard", "node"},
+	})
+)
+
+// NodeLag is the time lag of a target node
+type NodeLag struct {
+	Node    string
+	Key     string
+	Seconds float64   // -1 if no key is applied
+	Applied time.Time // write time of the latest applied key, zero if no key is applied
+}
+
+// LagStatus is the lag of an input node
+type LagStatus struct {
+	Shard        string // master address of the source shard
+	SourceOffset int64  // master_repl_offset of the input node
+	AckOffset    int64
+	Bytes        int64 // -1 if offsets of input or output are unknown
+	Nodes        []NodeLag
+	Updated      time.Time // the time when the source offset was polled
+}
+
+type nodeLag struct {
+	key     string
+	applied int64 // nanoseconds
+}
+
+// SyncLag tracks the lag of an input, it's owned by the syncer,
+// the input updates the source offset and writes delay keys, the output records acked offsets and applied keys
+type SyncLag struct {
+	mux          sync.Mutex
+	input        string
+	shard        string
+	sourceOffset int64
+	ackOffset    int64
+	updated      time.Time
+	nodes        map[string]*nodeLag // target node : lag
+	keys         map[string]string   // delay key : target node
+}
+
+func NewSyncLag(input string) *SyncLag {
+	return &SyncLag{
+		input: input,
+		shard: input,
+		nodes: make(map[string]*nodeLag),
+		keys:  make(map[string]string),
+	}
+}
+
+// reset calculates delay keys of target nodes, lags of nodes are unknown until their keys are applied
+func (sl *SyncLag) reset(input config.RedisConfig, output config.RedisConfig) {
+	testKey := config.Get().Input.SyncDelayTestKey
+
+	sl.mux.Lock()
+	defer sl.mux.Unlock()
+	for node := range sl.nodes {
+		lagSecondsGauge.Delete(sl.input, sl.shard, node)
+	}
+	lagBytesGauge.Delete(sl.input, sl.shard)
+
+	sl.shard = sl.input
+	if shard := config.Get().Input.Redis.GetClusterShard(sl.input); shard != nil {
+		sl.shard = shard.Master.Address
+	}
+	sl.sourceOffset = 0
+	sl.ackOffset = 0
+	sl.updated = time.Time{}
+	sl.nodes = make(map[string]*nodeLag)
+	sl.keys = make(map[string]string)
+	// delay keys are written to a redis input
+	if testKey == "" || config.Get().Input.SyncType != config.TypeSync {
+		return
+	}
+	for node, key := range syncDelayKeys(testKey, &input, &output) {
+		sl.nodes[node] = &nodeLag{key: key}
+		sl.keys[key] = node
+	}
+}
+
+// syncDelayKeys returns a delay key of each target node.
+// keys of a cluster input are hashed into slots of the input node, so they are written to the node,
+// and keys are hashed into slots of the target node if the output is a cluster
+func syncDelayKeys(testKey string, input *config.RedisConfig, output *config.RedisConfig) map[string]string {
+	inSlots := &config.RedisSlots{Ranges: []config.RedisSlotRange{{Left: 0, Right: 16383}}}
+	if input.IsCluster() {
+		inSlots = input.GetAllSlots()
+	}
+
+	keys := make(map[string]string)
+	if !output.IsCluster() {
+		key := testKey
+		if input.IsCluster() {
+			key = choseKeyInSlots(testKey, inSlots)
+		}
+		if key != "" {
+			keys[output.Address()] = key
+		}
+		return keys
+	}
+	for _, shard := range output.GetClusterShards() {
+		slots := intersectSlots(inSlots, &shard.Slots)
+		if len(slots.Ranges) == 0 {
+			continue
+		}
+		if key := choseKeyInSlots(testKey, slots); key != "" {
+			keys[shard.Master.Address] = key
+		}
+	}
+	return keys
+}
+
+// intersectSlots returns the intersection of sorted slots
+func intersectSlots(a, b *config.RedisSlots) *config.RedisSlots {
+	ret := &config.RedisSlots{}
+	i, j := 0, 0
+	for i < len(a.Ranges) && j < len(b.Ranges) {
+		ra, rb := a.Ranges[i], b.Ranges[j]
+		left, right := ra.Left, ra.Right
+		if rb.Left > left {
+			left = rb.Left
+		}
+		if rb.Right < right {
+			right = rb.Right
+		}
+		if left <= right {
+			ret.Ranges = append(ret.Ranges, config.RedisSlotRange{Left: left, Right: right})
+		}
+		if ra.Right < rb.Right {
+			i++
+		} else {
+			j++
+		}
+	}
+	return ret
+}
+
+// delayKeys returns delay keys which are written to the input
+func (sl *SyncLag) delayKeys() []string {
+	if sl == nil {
+		return nil
+	}
+	sl.mux.Lock()
+	defer sl.mux.Unlock()
+	keys := make([]string, 0, len(sl.keys))
+	for key := range sl.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// delayNode returns the target node of a delay key
+func (sl *SyncLag) delayNode(key string) (string, bool) {
+	if sl == nil {
+		return "", false
+	}
+	sl.mux.Lock()
+	defer sl.mux.Unlock()
+	node, ok := sl.keys[key]
+	return node, ok
+}
+
+// applied records a delay key which is applied to the target node, ns is the write time of the key
+func (sl *SyncLag) applied(node string, ns int64) {
+	if sl == nil {
+		return
+	}
+	sl.mux.Lock()
+	defer sl.mux.Unlock()
+	if nl, ok := sl.nodes[node]; ok && ns > nl.applied {
+		nl.applied = ns
+	}
+}
+
+func (sl *SyncLag) ack(offset int64) {
+	if sl == nil {
+		return
+	}
+	sl.mux.Lock()
+	sl.ackOffset = offset
+	sl.mux.Unlock()
+}
+
+// source updates master_repl_offset of the input node, and refreshes metrics
+func (sl *SyncLag) source(offset int64) {
+	if sl == nil {
+		return
+	}
+	sl.mux.Lock()
+	sl.sourceOffset = offset
+	sl.updated = time.Now()
+	sl.mux.Unlock()
+	sl.refresh()
+}
+
+// refresh updates metrics of lag
+func (sl *SyncLag) refresh() {
+	st := sl.Status()
+	if st.Bytes >= 0 {
+		lagBytesGauge.Set(float64(st.Bytes), sl.input, st.Shard)
+	}
+	for _, nl := range st.Nodes {
+		if nl.Seconds >= 0 {
+			lagSecondsGauge.Set(nl.Seconds, sl.input, st.Shard, nl.Node)
+		}
+	}
+}
+
+func (sl *SyncLag) Status() LagStatus {
+	sl.mux.Lock()
+	defer sl.mux.Unlock()
+	st := LagStatus{
+		Shard:        sl.shard,
+		SourceOffset: sl.sourceOffset,
+		AckOffset:    sl.ackOffset,
+		Bytes:        -1,
+		Updated:      sl.updated,
+	}
+	if sl.sourceOffset > 0 && sl.ackOffset > 0 {
+		st.Bytes = sl.sourceOffset - sl.ackOffset
+		if st.Bytes < 0 {
+			st.Bytes = 0
+		}
+	}
+	now := time.Now()
+	for node, nl := range sl.nodes {
+		lag := NodeLag{Node: node, Key: nl.key, Seconds: -1}
+		if nl.applied > 0 {
+			lag.Applied = time.Unix(0, nl.applied)
+			lag.Seconds = now.Sub(lag.Applied).Seconds()
+			if lag.Seconds < 0 {
+				lag.Seconds = 0
+			}
+		}
+		st.Nodes = append(st.Nodes, lag)
+	}
+	sort.Slice(st.Nodes, func(i, j int) bool { return st.Nodes[i].Node < st.Nodes[j].Node })
+	return st
+}
@@ -0,0 +1,131 @@
+package syncer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
+)
+
+func slotRanges(ranges ...int) *config.RedisSlots {
+	slots := &config.RedisSlots{}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		slots.Ranges = append(slots.Ranges, config.RedisSlotRange{Left: ranges[i], Right: ranges[i+1]})
+	}
+	return slots
+}
+
+func TestIntersectSlots(t *testing.T) {
+	cases := []struct {
+		a, b *config.RedisSlots
+		exp  *config.RedisSlots
+	}{
+		{slotRanges(0, 16383), slotRanges(100, 200), slotRanges(100, 200)},
+		{slotRanges(0, 100), slotRanges(101, 200), slotRanges()},
+		{slotRanges(0, 100), slotRanges(100, 200), slotRanges(100, 100)},
+		{slotRanges(0, 10, 20, 30, 40, 50), slotRanges(5, 25, 45, 60), slotRanges(5, 10, 20, 25, 45, 50)},
+		{slotRanges(5, 25, 45, 60), slotRanges(0, 10, 20, 30, 40, 50), slotRanges(5, 10, 20, 25, 45, 50)},
+		{slotRanges(), slotRanges(0, 16383), slotRanges()},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.exp.Ranges, intersectSlots(c.a, c.b).Ranges, i)
+	}
+}
+
+func testClusterConfig(shards ...*config.RedisClusterShard) *config.RedisConfig {
+	cfg := &config.RedisConfig{Addresses: []string{shards[0].Master.Address}, Type: config.RedisTypeCluster}
+	cfg.SetClusterShards(shards)
+	return cfg
+}
+
+func testShard(addr string, ranges ...int) *config.RedisClusterShard {
+	return &config.RedisClusterShard{Master: config.RedisNode{Address: addr}, Slots: *slotRanges(ranges...)}
+}
+
+func inSlots(key string, slots *config.RedisSlots) bool {
+	slot := int(redis.KeyToSlot(key))
+	for _, r := range slots.Ranges {
+		if slot >= r.Left && slot <= r.Right {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSyncDelayKeys(t *testing.T) {
+	prefix := "redis-GunYu-syncDelay-testKey"
+	standalone := &config.RedisConfig{Addresses: []string{"127.0.0.1:6379"}, Type: config.RedisTypeStandalone}
+	input := testClusterConfig(testShard("127.0.0.1:7000", 0, 8191))
+	output := testClusterConfig(
+		testShard("127.0.0.1:8000", 0, 5460),
+		testShard("127.0.0.1:8001", 5461, 10922),
+		testShard("127.0.0.1:8002", 10923, 16383),
+	)
+
+	// standalone to standalone
+	assert.Equal(t, map[string]string{"127.0.0.1:6379": prefix}, syncDelayKeys(prefix, standalone, standalone))
+
+	// the key is hashed into slots of the input node
+	keys := syncDelayKeys(prefix, input, standalone)
+	assert.Len(t, keys, 1)
+	assert.True(t, inSlots(keys["127.0.0.1:6379"], slotRanges(0, 8191)))
+
+	// a key for each target node whose slots overlap the input node
+	keys = syncDelayKeys(prefix, input, output)
+	assert.Len(t, keys, 2)
+	assert.True(t, inSlots(keys["127.0.0.1:8000"], slotRanges(0, 5460)))
+	assert.True(t, inSlots(keys["127.0.0.1:8001"], slotRanges(5461, 8191)))
+
+	keys = syncDelayKeys(prefix, standalone, output)
+	assert.Len(t, keys, 3)
+	for node, key := range keys {
+		assert.True(t, strings.HasPrefix(key, prefix))
+		assert.True(t, inSlots(key, output.GetClusterShard(node).Slots.Clone()), node)
+	}
+}
+
+func TestSyncLagStatus(t *testing.T) {
+	sl := NewSyncLag("127.0.0.1:6379")
+	sl.nodes["b"] = &nodeLag{key: "kb"}
+	sl.nodes["a"] = &nodeLag{key: "ka"}
+	sl.keys["ka"], sl.keys["kb"] = "a", "b"
+
+	st := sl.Status()
+	assert.Equal(t, int64(-1), st.Bytes)
+	assert.Equal(t, []NodeLag{{Node: "a", Key: "ka", Seconds: -1}, {Node: "b", Key: "kb", Seconds: -1}}, st.Nodes)
+
+	// the source offset is unknown
+	sl.ack(100)
+	assert.Equal(t, int64(-1), sl.Status().Bytes)
+	sl.source(150)
+	st = sl.Status()
+	assert.Equal(t, int64(50), st.Bytes)
+	assert.False(t, st.Updated.IsZero())
+	// output acks an offset newer than the polled one
+	sl.ack(200)
+	assert.Equal(t, int64(0), sl.Status().Bytes)
+
+	written := time.Now().Add(-3 * time.Second)
+	node, ok := sl.delayNode("ka")
+	assert.True(t, ok)
+	sl.applied(node, written.UnixNano())
+	sl.applied(node, written.Add(-time.Second).UnixNano()) // an older key
+	sl.applied("c", written.UnixNano())                    // unknown node
+	st = sl.Status()
+	assert.Len(t, st.Nodes, 2)
+	assert.Equal(t, written.UnixNano(), st.Nodes[0].Applied.UnixNano())
+	assert.InDelta(t, 3, st.Nodes[0].Seconds, 0.5)
+	assert.Equal(t, float64(-1), st.Nodes[1].Seconds)
+
+	// a key written in the future by a skewed clock
+	sl.applied("b", time.Now().Add(time.Minute).UnixNano())
+	assert.Equal(t, float64(0), sl.Status().Nodes[1].Seconds)
+
+	var nilLag *SyncLag
+	nilLag.applied("a", 1)
+	assert.Nil(t, nilLag.delayKeys())
+}
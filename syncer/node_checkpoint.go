@@ -0,0 +1,200 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"sync"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/checkpoint"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
+)
+
+// nodeCheckpoint replays commands to a cluster which can't replay transactions of input, e.g. slots are mismatched.
+// commands of each target node are executed with the checkpoint of the node in a transaction,
+// so commands whose offsets are not greater than the checkpoint of their node have been applied, and are skipped after resuming.
+//
+// the checkpoint key of a node is hashed to the node and depends on its slots,
+// checkpoints are ignored if slots of the node are changed
+type nodeCheckpoint struct {
+	cli     *client.ClusterRedis
+	keys    map[string]string // node address : checkpoint key
+	offsets map[string]int64  // node address : applied offset
+}
+
+func newNodeCheckpoint(cli *client.ClusterRedis, offsets map[string]int64) *nodeCheckpoint {
+	nc := &nodeCheckpoint{
+		cli:     cli,
+		offsets: make(map[string]int64, len(offsets)),
+	}
+	for addr, offset := range offsets {
+		nc.offsets[addr] = offset
+	}
+	return nc
+}
+
+func nodeCheckpointKey(slots *config.RedisSlots) string {
+	layout := strings.Builder{}
+	for _, r := range slots.Ranges {
+		layout.WriteString(fmt.Sprintf("%d-%d,", r.Left, r.Right))
+	}
+	return choseKeyInSlots(fmt.Sprintf("%s-node-%08x", config.CheckpointKey, crc32.ChecksumIEEE([]byte(layout.String()))), slots)
+}
+
+// refresh calculates checkpoint keys of master nodes
+func (nc *nodeCheckpoint) refresh() {
+	nc.keys = make(map[string]string)
+	for addr, slots := range nc.cli.MasterSlots() {
+		nc.keys[addr] = nodeCheckpointKey(slots)
+	}
+}
+
+func (nc *nodeCheckpoint) key(addr string) (string, error) {
+	if _, ok := nc.keys[addr]; !ok {
+		nc.refresh()
+	}
+	key := nc.keys[addr]
+	if key == "" {
+		return "", fmt.Errorf("no checkpoint key of node : %s", addr)
+	}
+	return key, nil
+}
+
+// load reads checkpoints of master nodes, the larger offset of run ids is used
+func (nc *nodeCheckpoint) load(runIds []string) error {
+	nc.refresh()
+	nc.offsets = make(map[string]int64)
+	for addr, key := range nc.keys {
+		if key == "" {
+			continue
+		}
+		args := []interface{}{key}
+		for _, id := range runIds {
+			args = append(args, (&checkpoint.CheckpointInfo{RunId: id}).OffsetKey())
+		}
+		ret, err := common.Values(nc.cli.Do("hmget", args...))
+		if err != nil {
+			return fmt.Errorf("get checkpoint of node(%s) error : %w", addr, err)
+		}
+		for _, v := range ret {
+			if v == nil {
+				continue
+			}
+			offset, err := common.Int64(v, nil)
+			if err != nil {
+				return fmt.Errorf("parse checkpoint(%v) of node(%s) error : %w", v, addr, err)
+			}
+			if cur, ok := nc.offsets[addr]; !ok || offset > cur {
+				nc.offsets[addr] = offset
+			}
+		}
+	}
+	return nil
+}
+
+// startOffset returns the minimum offset that all master nodes have applied, offset is the checkpoint of input
+func (nc *nodeCheckpoint) startOffset(offset int64) int64 {
+	start := int64(-1)
+	for addr := range nc.keys {
+		applied := offset
+		if nodeOffset, ok := nc.offsets[addr]; ok && nodeOffset > applied {
+			applied = nodeOffset
+		}
+		if start == -1 || applied < start {
+			start = applied
+		}
+	}
+	if start == -1 {
+		return offset
+	}
+	return start
+}
+
+// reset deletes checkpoints of run ids from master nodes
+func (nc *nodeCheckpoint) reset(runIds []string) error {
+	nc.refresh()
+	nc.offsets = make(map[string]int64)
+	for addr, key := range nc.keys {
+		if key == "" {
+			continue
+		}
+		args := []interface{}{key}
+		for _, id := range runIds {
+			args = append(args, (&checkpoint.CheckpointInfo{RunId: id}).OffsetKey())
+		}
+		if _, err := nc.cli.Do("hdel", args...); err != nil {
+			return fmt.Errorf("delete checkpoint of node(%s) error : %w", addr, err)
+		}
+	}
+	return nil
+}
+
+// exec sends commands of each node and its checkpoint in a transaction, returns the number of sent commands.
+// nodes are executed in parallel, the checkpoint of a node is updated in memory if its transaction succeeds
+func (nc *nodeCheckpoint) exec(cmds []cmdExecution, runId string, lastOffset int64) (uint, error) {
+	nodes := []string{}
+	nodeCmds := make(map[string][]cmdExecution)
+	for _, ce := range cmds {
+		addr, err := nc.cli.NodeOfCmd(ce.Cmd, ce.Args...)
+		if err != nil {
+			return 0, err
+		}
+		if addr == "" { // e.g. select
+			continue
+		}
+		if offset, ok := nc.offsets[addr]; ok && ce.Offset <= offset { // applied
+			continue
+		}
+		if _, ok := nodeCmds[addr]; !ok {
+			nodes = append(nodes, addr)
+		}
+		nodeCmds[addr] = append(nodeCmds[addr], ce)
+	}
+
+	cpField := (&checkpoint.CheckpointInfo{RunId: runId}).OffsetKey()
+	errs := make([]error, len(nodes))
+	wg := sync.WaitGroup{}
+	var cmdCounter uint
+	for i, addr := range nodes {
+		key, err := nc.key(addr)
+		if err != nil {
+			return 0, err
+		}
+		batcher := nc.cli.NewTxnBatcher()
+		for _, ce := range nodeCmds[addr] {
+			batcher.Put(ce.Cmd, ce.Args...)
+		}
+		batcher.Put("hset", key, cpField, lastOffset)
+		cmdCounter += uint(len(nodeCmds[addr]))
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			replies, err := batcher.Exec()
+			if err == nil {
+				// the reply of exec is an array, or an error if the transaction is aborted
+				if _, ok := replies[len(replies)-1].([]interface{}); !ok {
+					err = fmt.Errorf("transaction is aborted : %v", replies[len(replies)-1])
+				}
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+
+	for i, addr := range nodes {
+		if errs[i] == nil {
+			nc.offsets[addr] = lastOffset
+		} else {
+			errs[i] = fmt.Errorf("node(%s) : %w", addr, errs[i])
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		nc.keys = nil // slots may be changed
+	}
+	return cmdCounter, err
+}
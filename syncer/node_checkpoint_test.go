@@ -0,0 +1,45 @@
+package syncer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
+)
+
+func TestNodeCheckpointKey(t *testing.T) {
+	slots := &config.RedisSlots{Ranges: []config.RedisSlotRange{{Left: 0, Right: 5460}, {Left: 10923, Right: 10923}}}
+	key := nodeCheckpointKey(slots)
+	assert.NotEmpty(t, key)
+	assert.LessOrEqual(t, int(redis.KeyToSlot(key)), 5460)
+	assert.Equal(t, key, nodeCheckpointKey(slots))
+
+	// checkpoints are ignored if slots are changed
+	moved := &config.RedisSlots{Ranges: []config.RedisSlotRange{{Left: 0, Right: 5461}}}
+	assert.NotEqual(t, key, nodeCheckpointKey(moved))
+}
+
+func TestNodeCheckpointStartOffset(t *testing.T) {
+	cases := []struct {
+		name    string
+		keys    []string
+		offsets map[string]int64
+		offset  int64
+		exp     int64
+	}{
+		{"no node", nil, nil, 100, 100},
+		{"no checkpoint of nodes", []string{"a", "b"}, nil, 100, 100},
+		{"all nodes applied more", []string{"a", "b"}, map[string]int64{"a": 200, "b": 150}, 100, 150},
+		{"a node without checkpoint", []string{"a", "b"}, map[string]int64{"a": 200}, 100, 100},
+		{"checkpoint of node is stale", []string{"a", "b"}, map[string]int64{"a": 50, "b": 150}, 100, 100},
+	}
+	for _, c := range cases {
+		nc := &nodeCheckpoint{keys: make(map[string]string), offsets: c.offsets}
+		for _, k := range c.keys {
+			nc.keys[k] = "key-" + k
+		}
+		assert.Equal(t, c.exp, nc.startOffset(c.offset), c.name)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"github.com/mgtv-tech/redis-GunYu/config"
 	pkgCommon "github.com/mgtv-tech/redis-GunYu/pkg/common"
 	"github.com/mgtv-tech/redis-GunYu/pkg/filter"
@@ -27,6 +29,7 @@ import (
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
 	"github.com/mgtv-tech/redis-GunYu/pkg/store"
 	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+	"github.com/mgtv-tech/redis-GunYu/pkg/telemetry"
 	"github.com/mgtv-tech/redis-GunYu/pkg/util"
 )
 
@@ -48,8 +51,18 @@ type RedisOutput struct {
 
 	cpGuard         sync.RWMutex
 	checkpointInMem checkpoint.CheckpointInfo
+	nodeOffsets     map[string]int64 // checkpoints of target nodes, see nodeCheckpoint
+	extStoreMux     sync.Mutex
+	extStore        checkpoint.Store // shared checkpoint store outside of redis
+
+	outSettings atomic.Pointer[outputSettings]
+}
 
-	outFilter *filter.RedisCmdFilter
+// outputSettings are the output and filter of the input
+type outputSettings struct {
+	gen    int64 // generation of configuration
+	output *config.OutputConfig
+	filter *filter.RedisCmdFilter
 }
 
 var (
@@ -66,6 +79,31 @@ var (
 		Labels:    []string{"input"},
 	})
 
+	slotKeySendCounter = metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "output",
+		Name:      "slot_key_send",
+		Labels:    []string{"input", "slots"},
+	})
+	slotKeyFilterCounter = metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "output",
+		Name:      "slot_key_filter",
+		Labels:    []string{"input", "slots"},
+	})
+	perSlotKeySendCounter = metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "output",
+		Name:      "per_slot_key_send",
+		Labels:    []string{"input", "slot"},
+	})
+	perSlotKeyFilterCounter = metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "output",
+		Name:      "per_slot_key_filter",
+		Labels:    []string{"input", "slot"},
+	})
+
 	aofCmdCounter = metric.NewCounterVec(metric.CounterVecOpts{
 		Namespace: config.AppName,
 		Subsystem: "output",
@@ -135,6 +173,13 @@ var (
 )
 
 func (ro *RedisOutput) Close() {
+	ro.extStoreMux.Lock()
+	defer ro.extStoreMux.Unlock()
+	if ro.extStore != nil {
+		err := ro.extStore.Close()
+		ro.logger.Log(err, "close checkpoint store : store(%s), err(%v)", ro.extStore.Name(), err)
+		ro.extStore = nil
+	}
 }
 
 func NewRedisOutput(cfg RedisOutputConfig) *RedisOutput {
@@ -147,28 +192,108 @@ func NewRedisOutput(cfg RedisOutputConfig) *RedisOutput {
 		ro.cfg.Redis.GetClusterOptions().HandleMoveErr = false
 		ro.cfg.Redis.GetClusterOptions().HandleAskErr = false
 	}
-	ro.outFilter = &filter.RedisCmdFilter{}
-	ro.outFilter.InsertCmdBlackList(filter.NoRouteCmds, true)
-	ro.outFilter.InsertCmdBlackList(config.Get().Filter.CmdBlacklist, true)
+	ro.outSettings.Store(ro.newOutputSettings(config.Generation()))
+
+	return ro
+}
+
+func (ro *RedisOutput) newOutputSettings(gen int64) *outputSettings {
+	st := config.Get().InputSettings(ro.cfg.InputAddresses...)
+	outFilter := newOutputFilter(st.Filter, st.Output.Guard)
+	var ranges []config.RedisSlotRange
+	perSlot := false
+	if st.Filter.KeyFilter != nil {
+		ranges = st.Filter.KeyFilter.GetSlotRanges()
+		perSlot = st.Filter.KeyFilter.SlotMetrics
+	}
+	labels := slotRangeLabels(ranges)
+	outFilter.SetSlotObserver(func(slot uint16, filtered bool) {
+		ro.slotCounterAdd(labels[slot], filtered)
+		if perSlot {
+			ro.perSlotCounterAdd(slot, filtered)
+		}
+	})
+	return &outputSettings{
+		gen:    gen,
+		output: st.Output,
+		filter: outFilter,
+	}
+}
+
+// newOutputFilter returns the filter of output, commands quarantined by guard are not filtered by default
+func newOutputFilter(fc *config.FilterConfig, gc *config.GuardConfig) *filter.RedisCmdFilter {
+	outFilter := &filter.RedisCmdFilter{}
+	noRouteCmds := filter.NoRouteCmds
+	if gc != nil {
+		noRouteCmds = make([]string, 0, len(filter.NoRouteCmds))
+		for _, cmd := range filter.NoRouteCmds {
+			if !gc.Quarantines(cmd) {
+				noRouteCmds = append(noRouteCmds, cmd)
+			}
+		}
+	}
+	outFilter.InsertCmdBlackList(noRouteCmds, true)
+	outFilter.InsertCmdBlackList(fc.CmdBlacklist, true)
+	outFilter.InsertDbBlackList(fc.DbBlacklist)
 
-	ro.outFilter.InsertPrefixKeyBlackList([]string{config.CheckpointKey})
-	keyFilter := config.Get().Filter.KeyFilter
+	outFilter.InsertPrefixKeyBlackList([]string{config.CheckpointKey})
+	keyFilter := fc.KeyFilter
 	if keyFilter != nil {
-		ro.outFilter.InsertPrefixKeyBlackList(keyFilter.PrefixKeyBlacklist)
-		ro.outFilter.InsertPrefixKeyWhiteList(keyFilter.PrefixKeyWhitelist)
+		outFilter.InsertPrefixKeyBlackList(keyFilter.PrefixKeyBlacklist)
+		outFilter.InsertPrefixKeyWhiteList(keyFilter.PrefixKeyWhitelist)
+		for _, sr := range keyFilter.GetSlotRanges() {
+			outFilter.InsertSlotWhiteList(sr.Left, sr.Right)
+		}
+		outFilter.InsertHashTagWhiteList(keyFilter.HashTags)
+	}
+	return outFilter
+}
+
+// settings rebuilds the output and filter of the input if the configuration is reloaded
+func (ro *RedisOutput) settings() *outputSettings {
+	st := ro.outSettings.Load()
+	if gen := config.Generation(); st.gen != gen {
+		st = ro.newOutputSettings(gen)
+		ro.outSettings.Store(st)
+		ro.logger.Infof("settings are reloaded : generation(%d)", gen)
 	}
+	return st
+}
 
-	return ro
+func (ro *RedisOutput) outputCfg() *config.OutputConfig {
+	return ro.settings().output
+}
+
+func (ro *RedisOutput) cmdFilter() *filter.RedisCmdFilter {
+	return ro.settings().filter
+}
+
+// resetBatchTickerIfReloaded picks up the reloaded batchTicker
+func (ro *RedisOutput) resetBatchTickerIfReloaded(ticker *time.Ticker, cfgGen *int64) {
+	if gen := config.Generation(); gen != *cfgGen {
+		*cfgGen = gen
+		ticker.Reset(ro.outputCfg().BatchTicker)
+	}
 }
 
 type RedisOutputConfig struct {
 	InputName                  string
+	Input                      config.RedisConfig // observes the state of input to rewrite non-idempotent commands
+	InputAddresses             []string           // the input and its shard, select the overridden settings
 	Redis                      config.RedisConfig
 	Parallel                   int
 	EnableResumeFromBreakPoint bool
 	CheckpointName             string
+	CheckpointRedis            *config.RedisConfig           // stores checkpoints instead of output redis
+	CheckpointStore            *config.CheckpointStoreConfig // stores checkpoints outside of redis
 	RunId                      string
 	CanTransaction             bool
+	Bidirectional              *config.BidirectionalConfig
+	OriginKey                  string        // tags transactions applied to output
+	Delay                      *DelayControl // controls the delayed replay
+	Guard                      *GuardControl // keeps the window quarantined by output.guard
+	Lag                        *SyncLag      // records acked offsets and applied delay keys
+	Scripts                    *ScriptCache  // scripts seen in the input
 }
 
 type cmdExecution struct {
@@ -178,6 +303,8 @@ type cmdExecution struct {
 	Db            int
 	syncDelayNs   int64
 	syncDelayHost string
+	syncDelayNode string // target node of the delay key
+	allNodes      bool   // executed on all nodes of output cluster after pending commands are replied
 }
 
 func (ro *RedisOutput) SetRunId(ctx context.Context, id string) error {
@@ -186,12 +313,12 @@ func (ro *RedisOutput) SetRunId(ctx context.Context, id string) error {
 	}
 
 	return util.RetryLinearJitter(ctx, func() error {
-		cli, err := ro.NewRedisConn(ctx)
+		store, release, err := ro.newCheckpointStore(ctx)
 		if err != nil {
 			return err
 		}
-		defer cli.Close()
-		err = checkpoint.UpdateCheckpoint(cli, ro.cfg.CheckpointName, []string{id, ro.cfg.RunId})
+		defer release()
+		err = checkpoint.UpdateCheckpoint(store, ro.cfg.CheckpointName, []string{id, ro.cfg.RunId})
 		if err != nil {
 			ro.logger.Errorf("update checkpoint error : cp(%s), runId(%s,%s), err(%v)", ro.cfg.CheckpointName, id, ro.cfg.RunId, err)
 		}
@@ -213,21 +340,25 @@ func (ro *RedisOutput) Send(ctx context.Context, reader *store.Reader) error {
 }
 
 func (ro *RedisOutput) stats(ctx context.Context) {
-	if config.Get().Output.Stats.DisableLog {
-		return
-	}
-	interval := config.Get().Output.Stats.LogInterval
+	interval := ro.outputCfg().Stats.LogInterval
 	usync.SafeGo(func() {
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
+		cfgGen := config.Generation()
 		lFilter := ro.filterCounterRt.Load()
 		lSend := ro.sendCounterRt.Load()
 		for {
 			select {
 			case <-ticker.C:
+				if gen := config.Generation(); gen != cfgGen {
+					cfgGen = gen
+					ticker.Reset(ro.outputCfg().Stats.LogInterval)
+				}
 				filter := ro.filterCounterRt.Load()
 				send := ro.sendCounterRt.Load()
-				ro.logger.Infof("stats : filterCmd(%d), sendCmd(%d)", filter-lFilter, send-lSend)
+				if !ro.outputCfg().Stats.DisableLog {
+					ro.logger.Infof("stats : filterCmd(%d), sendCmd(%d)", filter-lFilter, send-lSend)
+				}
 				lFilter = filter
 				lSend = send
 			case <-ctx.Done():
@@ -238,7 +369,10 @@ func (ro *RedisOutput) stats(ctx context.Context) {
 }
 
 func (ro *RedisOutput) SendRdb(ctx context.Context, reader *store.Reader) error {
+	ctx, span := telemetry.StartSpan(ctx, "output.rdb_replay", attribute.String("input", ro.cfg.InputName),
+		attribute.String("run_id", reader.RunId()), attribute.Int64("offset", reader.Left()), attribute.Int64("rdb_size", reader.Size()))
 	err := ro.sendRdb(ctx, reader)
+	telemetry.EndSpan(span, err)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			err = errors.Join(err, ErrRestart) // @TODO handle it
@@ -253,7 +387,7 @@ func (ro *RedisOutput) SendRdb(ctx context.Context, reader *store.Reader) error
 }
 
 func (ro *RedisOutput) SendAof(ctx context.Context, reader *store.Reader) error {
-	err := ro.sendAof(ctx, reader.RunId(), reader.IoReader(), reader.Left(), reader.Size())
+	err := ro.sendAof(ctx, reader.RunId(), reader.IoReader(), reader.Left(), reader.Size(), reader.IngestTime)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			err = errors.Join(err, ErrRestart)
@@ -287,6 +421,44 @@ func (ro *RedisOutput) rdbFilterCounterAdd(v uint) {
 	ro.rdbFilterCounterRt.Add(int64(v))
 }
 
+const slotsOthers = "others"
+
+// slotRangeLabels labels each slot with its configured range, e.g. "0-5460",
+// slots out of ranges are labeled as others, so the cardinality of counters is bounded by ranges
+func slotRangeLabels(ranges []config.RedisSlotRange) []string {
+	labels := make([]string, 16384) // slots of redis cluster
+	for i := range labels {
+		labels[i] = slotsOthers
+	}
+	for _, sr := range ranges {
+		label := fmt.Sprintf("%d-%d", sr.Left, sr.Right)
+		for i := sr.Left; i <= sr.Right && i < len(labels); i++ {
+			if labels[i] == slotsOthers {
+				labels[i] = label
+			}
+		}
+	}
+	return labels
+}
+
+// slotCounterAdd counts keys of the slot and hash tag filter by slot ranges
+func (ro *RedisOutput) slotCounterAdd(slots string, filtered bool) {
+	if filtered {
+		slotKeyFilterCounter.Inc(ro.cfg.InputName, slots)
+	} else {
+		slotKeySendCounter.Inc(ro.cfg.InputName, slots)
+	}
+}
+
+// perSlotCounterAdd counts keys of the slot and hash tag filter by slots, it's enabled by keyFilter.slotMetrics
+func (ro *RedisOutput) perSlotCounterAdd(slot uint16, filtered bool) {
+	if filtered {
+		perSlotKeyFilterCounter.Inc(ro.cfg.InputName, strconv.Itoa(int(slot)))
+	} else {
+		perSlotKeySendCounter.Inc(ro.cfg.InputName, strconv.Itoa(int(slot)))
+	}
+}
+
 func (ro *RedisOutput) sendRdb(pctx context.Context, reader *store.Reader) error {
 	ro.logger.Infof("send rdb : runId(%s), offset(%d), size(%d)", reader.RunId(), reader.Left(), reader.Size())
 
@@ -323,6 +495,11 @@ func (ro *RedisOutput) sendRdb(pctx context.Context, reader *store.Reader) error
 		}
 	}
 
+	if ro.cfg.Bidirectional != nil && !ro.cfg.CanTransaction {
+		// restored keys can't be tagged without transactions
+		return errors.Join(ErrQuit, fmt.Errorf("bidirectional sync requires transactions : output(%v)", ro.cfg.Redis.Addresses))
+	}
+
 	pipe := redis.ParseRdb(ioReader, &readBytes, config.RDBPipeSize, ro.cfg.Redis.Version)
 	errChan := make(chan error, ro.cfg.Parallel)
 
@@ -334,6 +511,10 @@ func (ro *RedisOutput) sendRdb(pctx context.Context, reader *store.Reader) error
 			return err
 		}
 		defer cli.Close()
+		if ro.cfg.Bidirectional != nil {
+			// the opposite direction drops restored keys as well
+			cli = newOriginTagger(cli, ro.cfg.OriginKey, ro.cfg.Bidirectional.OriginId)
+		}
 
 		var ticker = time.Now()
 		pingC := 0
@@ -375,7 +556,7 @@ func (ro *RedisOutput) sendRdb(pctx context.Context, reader *store.Reader) error
 			}
 
 			filterOut := false
-			if filter.FilterDB(int(e.DB)) {
+			if ro.cmdFilter().FilterDB(int(e.DB)) {
 				filterOut = true
 			} else {
 				if tdb, ok := ro.selectDB(currentDB, int(e.DB)); ok {
@@ -387,7 +568,7 @@ func (ro *RedisOutput) sendRdb(pctx context.Context, reader *store.Reader) error
 					}
 				}
 
-				if ro.outFilter.FilterKey(util.BytesToString(e.Key)) {
+				if ro.cmdFilter().FilterKey(util.BytesToString(e.Key)) {
 					filterOut = true
 				}
 			}
@@ -396,7 +577,11 @@ func (ro *RedisOutput) sendRdb(pctx context.Context, reader *store.Reader) error
 				ro.rdbFilterCounterAdd(1)
 			} else {
 				ro.rdbSendCounterAdd(1)
-				err := rdbrestore.RestoreRdbEntry(cli, e) // @TODO retry
+				if ro.cfg.Scripts != nil && e.ObjectParser != nil && e.ObjectParser.Type() == rdb.RdbObjectAux &&
+					bytes.Equal(e.ObjectParser.Key(), []byte("lua")) {
+					ro.cfg.Scripts.add(e.ObjectParser.Value())
+				}
+				err := rdbrestore.RestoreRdbEntry(cli, e, ro.outputCfg()) // @TODO retry
 				if err != nil {
 					ro.logger.Errorf("restore rdb error : entry(%v), err(%v)", e, err)
 					return err
@@ -429,9 +614,70 @@ func (ro *RedisOutput) sendRdb(pctx context.Context, reader *store.Reader) error
 	}
 	ro.logger.Debugf("send rdb OK : runId(%s), offset(%d), size(%d)", reader.RunId(), reader.Left(), reader.Size())
 
+	if err := ro.resetNodeCheckpoints(ctx, reader.RunId()); err != nil {
+		return err
+	}
 	return ro.setCheckpoint(ctx, reader.RunId(), reader.Left(), config.Version)
 }
 
+// nodeCheckpointEnabled returns true if commands are replayed with checkpoints of target nodes
+func (ro *RedisOutput) nodeCheckpointEnabled() bool {
+	return ro.cfg.EnableResumeFromBreakPoint && ro.cfg.Redis.IsCluster() && !ro.cfg.CanTransaction
+}
+
+// resetNodeCheckpoints deletes checkpoints of target nodes after full synchronization, they are older than the rdb
+func (ro *RedisOutput) resetNodeCheckpoints(ctx context.Context, runId string) error {
+	if !ro.nodeCheckpointEnabled() {
+		return nil
+	}
+	ro.cpGuard.Lock()
+	ro.nodeOffsets = nil
+	ro.cpGuard.Unlock()
+
+	err := util.RetryLinearJitter(ctx, func() error {
+		conn, err := ro.NewRedisConn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		cc, ok := conn.(*client.ClusterRedis)
+		if !ok {
+			return nil
+		}
+		runIds := []string{runId}
+		if ro.cfg.RunId != "" && ro.cfg.RunId != runId {
+			runIds = append(runIds, ro.cfg.RunId)
+		}
+		return newNodeCheckpoint(cc, nil).reset(runIds)
+	}, 5, time.Second*2, 0.3)
+	ro.logger.Log(err, "reset checkpoints of nodes : runId(%s), err(%v)", runId, err)
+	return err
+}
+
+// loadNodeCheckpoints loads checkpoints of target nodes, and returns the minimum offset that all nodes have applied
+func (ro *RedisOutput) loadNodeCheckpoints(ctx context.Context, runIds []string, offset int64) (int64, error) {
+	conn, err := ro.NewRedisConn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	cc, ok := conn.(*client.ClusterRedis)
+	if !ok {
+		return offset, nil
+	}
+	nc := newNodeCheckpoint(cc, nil)
+	if err = nc.load(runIds); err != nil {
+		return 0, err
+	}
+	ro.cpGuard.Lock()
+	ro.nodeOffsets = nc.offsets
+	ro.cpGuard.Unlock()
+
+	start := nc.startOffset(offset)
+	ro.logger.Infof("load checkpoints of nodes : offset(%d), start(%d), nodes(%v)", offset, start, nc.offsets)
+	return start, nil
+}
+
 func (ro *RedisOutput) setCheckpoint(ctx context.Context, runId string, offset int64, version string) error {
 	checkpointKv := &checkpoint.CheckpointInfo{
 		Key:     ro.cfg.CheckpointName,
@@ -447,14 +693,17 @@ func (ro *RedisOutput) setCheckpoint(ctx context.Context, runId string, offset i
 		return nil
 	}
 
+	ctx, span := telemetry.StartSpan(ctx, "output.checkpoint", attribute.String("input", ro.cfg.InputName),
+		attribute.String("run_id", runId), attribute.Int64("offset", offset))
 	err := util.RetryLinearJitter(ctx, func() error {
-		cli, err := ro.NewRedisConn(ctx)
+		store, release, err := ro.newCheckpointStore(ctx)
 		if err != nil {
 			return err
 		}
-		defer cli.Close()
-		return checkpoint.SetCheckpoint(cli, checkpointKv)
+		defer release()
+		return store.SetCheckpoint(checkpointKv)
 	}, 5, time.Second*2, 0.3)
+	telemetry.EndSpan(span, err)
 	ro.logger.Log(err, "set checkpoint : checkpoint(%v), err(%v)", checkpointKv, err)
 	return err
 }
@@ -467,16 +716,61 @@ func (ro *RedisOutput) NewRedisConn(ctx context.Context) (conn client.Redis, err
 	return conn, err
 }
 
-func (ro *RedisOutput) sendAof(ctx context.Context, runId string, reader *bufio.Reader, offset int64, nsize int64) (err error) {
+// newCheckpointStore returns the store of checkpoints, release should be called after using it.
+// the store outside of redis is shared by the output, otherwise it's a connection of output redis or checkpoint redis
+func (ro *RedisOutput) newCheckpointStore(ctx context.Context) (store checkpoint.Store, release func(), err error) {
+	if ro.cfg.CheckpointStore != nil && ro.cfg.CheckpointStore.IsExternal() {
+		ro.extStoreMux.Lock()
+		defer ro.extStoreMux.Unlock()
+		if ro.extStore == nil {
+			// the store lives as long as the output
+			ro.extStore, err = checkpoint.NewStore(context.Background(), ro.cfg.CheckpointStore, ro.cfg.Redis)
+			if err != nil {
+				ro.logger.Errorf("new checkpoint store error : store(%s), err(%v)", ro.cfg.CheckpointStore.Id(), err)
+				return nil, nil, err
+			}
+		}
+		return ro.extStore, func() {}, nil
+	}
+
+	redisCfg := ro.cfg.Redis
+	if ro.cfg.CheckpointRedis != nil {
+		redisCfg = *ro.cfg.CheckpointRedis
+	}
+	store, err = checkpoint.NewStore(ctx, nil, redisCfg)
+	if err != nil {
+		ro.logger.Errorf("new checkpoint redis error : redis(%v), err(%v)", redisCfg.Addresses, err)
+		return nil, nil, err
+	}
+	return store, func() { store.Close() }, nil
+}
+
+// separateCheckpoint returns true if checkpoints are not stored in output redis,
+// they can't be updated with commands in a batch
+func (ro *RedisOutput) separateCheckpoint() bool {
+	return ro.cfg.CheckpointRedis != nil || (ro.cfg.CheckpointStore != nil && ro.cfg.CheckpointStore.IsExternal())
+}
+
+func (ro *RedisOutput) sendAof(ctx context.Context, runId string, reader *bufio.Reader, offset int64, nsize int64,
+	ingest func(int64) (time.Time, int64, bool)) (err error) {
 	ro.logger.Infof("send aof : runId(%s), offset(%d), size(%d)", runId, offset, nsize)
 
-	sendBuf := make(chan cmdExecution, config.Get().Output.BatchCmdCount*10)
+	sendBuf := make(chan cmdExecution, ro.outputCfg().BatchCmdCount*10)
 	replayQuit := usync.NewWaitCloserFromContext(ctx, nil)
-	// @TODO fetch source offset, calculate gap between source and output
-	//go ro.fetchOffset()
+	// commands before offset are applied, the gap between input and output is tracked by Lag
+	ro.cfg.Lag.ack(offset)
 
 	usync.SafeGo(func() {
-		err := ro.parseAofCommand(replayQuit, reader, offset, sendBuf)
+		clock := newIngestClock(ingest)
+		var delay *delayWaiter
+		if ro.cfg.Delay != nil {
+			delay = &delayWaiter{ro: ro, ctrl: ro.cfg.Delay, clock: clock}
+		}
+		var guard *cmdGuard
+		if ro.cfg.Guard != nil {
+			guard = &cmdGuard{ro: ro, ctrl: ro.cfg.Guard, clock: clock}
+		}
+		err := ro.parseAofCommand(replayQuit, reader, runId, offset, sendBuf, delay, guard)
 		if err != nil {
 			replayQuit.Close(err)
 		}
@@ -532,7 +826,8 @@ func (ro *RedisOutput) receiveReply(cli client.Redis) error {
 	return nil
 }
 
-func (ro *RedisOutput) parseAofCommand(replayQuit usync.WaitCloser, reader *bufio.Reader, startOffset int64, sendBuf chan cmdExecution) error {
+func (ro *RedisOutput) parseAofCommand(replayQuit usync.WaitCloser, reader *bufio.Reader, runId string, startOffset int64, sendBuf chan cmdExecution,
+	delay *delayWaiter, guard *cmdGuard) error {
 	var (
 		currentDB = -1
 		bypass    = false
@@ -550,6 +845,41 @@ func (ro *RedisOutput) parseAofCommand(replayQuit usync.WaitCloser, reader *bufi
 		}
 	}
 
+	// rewriter observes the state of input, and lww records versions of writes in input
+	var (
+		inConn   *inputConn
+		rewriter *cmdRewriter
+		lww      *lwwResolver
+	)
+	bidirectional := ro.cfg.Bidirectional
+	useLww := bidirectional != nil && bidirectional.Conflict == config.ConflictLww
+	if ro.outputCfg().RewriteNonIdempotent || useLww {
+		var err error
+		inConn, err = newInputConn(ro.cfg.Input, ro.startDbId)
+		if err != nil {
+			ro.logger.Errorf("new input connection error : input(%s), err(%v)", ro.cfg.InputName, err)
+			return err
+		}
+		defer inConn.Close()
+		if ro.outputCfg().RewriteNonIdempotent {
+			// commands can't be replayed from checkpoints older than staleCheckpointDuration
+			rewriter = newCmdRewriter(inConn, ro.logger, runId, config.Get().InputSettings(ro.cfg.InputAddresses...).Channel.StaleCheckpointDuration)
+		}
+		if useLww {
+			lww = newLwwResolver(inConn, ro.logger, bidirectional)
+		}
+	}
+	var scripts *scriptReplayer
+	if ro.cfg.Scripts != nil {
+		scripts = newScriptReplayer(ro, ro.cfg.Scripts)
+	}
+
+	// commands of a transaction tagged by the output of opposite direction are dropped
+	var (
+		pendingMulti *cmdExecution
+		dropTxn      bool
+	)
+
 	syncDelayTestkey := []byte(config.Get().Input.SyncDelayTestKey)
 
 	decoder := client.NewDecoder(reader)
@@ -571,6 +901,13 @@ func (ro *RedisOutput) parseAofCommand(replayQuit usync.WaitCloser, reader *bufi
 			return errors.Join(ErrCorrupted, err)
 		}
 
+		// delayed replica, commands are held in the storer
+		if delay != nil {
+			if err = delay.wait(replayQuit, startOffset+incrOffset); err != nil {
+				return err
+			}
+		}
+
 		sCmd, argv, err := client.ParseArgs(resp) // lower case
 		if err != nil {
 			err = fmt.Errorf("parse error : input(%s), err(%w)", ro.cfg.InputName, err)
@@ -579,6 +916,35 @@ func (ro *RedisOutput) parseAofCommand(replayQuit usync.WaitCloser, reader *bufi
 		}
 		aofCmdCounter.Inc(ro.cfg.InputName)
 
+		if bidirectional != nil {
+			if pendingMulti != nil {
+				if isOriginMarker(sCmd, argv) {
+					dropTxn = true
+				} else {
+					sendBuf <- *pendingMulti
+				}
+				pendingMulti = nil
+			}
+			if dropTxn {
+				if sCmd == "exec" {
+					dropTxn = false
+				} else if lww != nil {
+					lww.observe(sCmd, argv)
+				}
+				loopCounter.Inc(ro.cfg.InputName)
+				continue
+			}
+			if sCmd == "multi" && !bypass && !ro.cmdFilter().FilterCmd(sCmd) { // wait for the marker
+				pendingMulti = &cmdExecution{
+					Cmd:    sCmd,
+					Args:   []interface{}{},
+					Offset: startOffset + incrOffset,
+					Db:     currentDB,
+				}
+				continue
+			}
+		}
+
 		// filter db, filter command, filter key
 		if sCmd != "ping" {
 			if strings.EqualFold(sCmd, "select") {
@@ -593,9 +959,14 @@ func (ro *RedisOutput) parseAofCommand(replayQuit usync.WaitCloser, reader *bufi
 					ro.logger.Errorf("%s", err.Error())
 					return err
 				}
-				bypass = filter.FilterDB(n) // filter following commands
+				bypass = ro.cmdFilter().FilterDB(n) // filter following commands
 				selectDB = n
-			} else if ro.outFilter.FilterCmd(sCmd) {
+				if inConn != nil {
+					if err = inConn.selectDB(n); err != nil {
+						return err
+					}
+				}
+			} else if ro.cmdFilter().FilterCmd(sCmd) {
 				ignoreCmd = true
 			} else if strings.EqualFold(sCmd, "publish") && strings.EqualFold(string(argv[0]), "__sentinel__:hello") {
 				ignoresentinel = true
@@ -607,7 +978,7 @@ func (ro *RedisOutput) parseAofCommand(replayQuit usync.WaitCloser, reader *bufi
 			}
 		}
 
-		newArgv, reject = ro.outFilter.FilterCmdKey(sCmd, argv)
+		newArgv, reject = ro.cmdFilter().FilterCmdKey(sCmd, argv)
 		if bypass || reject {
 			ro.filterCounterAdd(1)
 			continue
@@ -628,6 +999,18 @@ func (ro *RedisOutput) parseAofCommand(replayQuit usync.WaitCloser, reader *bufi
 			continue
 		}
 
+		// dangerous commands are held until they are approved or skipped
+		if guard != nil {
+			pass, err := guard.check(replayQuit, sCmd, newArgv, startOffset+incrOffset)
+			if err != nil {
+				return err
+			}
+			if !pass {
+				ro.filterCounterAdd(1)
+				continue
+			}
+		}
+
 		data := make([]interface{}, 0, len(newArgv))
 		for _, item := range newArgv {
 			data = append(data, item)
@@ -639,8 +1022,9 @@ func (ro *RedisOutput) parseAofCommand(replayQuit usync.WaitCloser, reader *bufi
 			Db:     currentDB,
 		}
 		if len(syncDelayTestkey) > 0 {
-			if sCmd == "set" && len(argv) > 0 {
-				if bytes.Equal(argv[0], syncDelayTestkey) {
+			if sCmd == "set" && len(argv) > 1 {
+				node, isDelayKey := ro.cfg.Lag.delayNode(util.BytesToString(argv[0]))
+				if isDelayKey || bytes.Equal(argv[0], syncDelayTestkey) {
 					vals := strings.Split(util.BytesToString(argv[1]), "_")
 					if len(vals) == 2 {
 						ns, err := strconv.ParseInt((vals[1]), 10, 64)
@@ -649,13 +1033,42 @@ func (ro *RedisOutput) parseAofCommand(replayQuit usync.WaitCloser, reader *bufi
 						} else {
 							cmdExec.syncDelayNs = ns
 							cmdExec.syncDelayHost = vals[0]
+							cmdExec.syncDelayNode = node
 						}
 					}
 				}
 			}
 		}
 
-		sendBuf <- cmdExec
+		if scripts != nil {
+			cmdExec = scripts.replay(cmdExec)
+		}
+
+		if lww != nil {
+			var apply bool
+			cmdExec, apply, err = lww.resolve(cmdExec)
+			if err != nil {
+				ro.logger.Errorf("%s", err.Error())
+				return err
+			}
+			if !apply {
+				lwwSupersededCounter.Inc(ro.cfg.InputName)
+				continue
+			}
+		}
+
+		if rewriter == nil {
+			sendBuf <- cmdExec
+			continue
+		}
+		cmdExecs, err := rewriter.rewrite(cmdExec)
+		if err != nil {
+			ro.logger.Errorf("%s", err.Error())
+			return err
+		}
+		for _, ce := range cmdExecs {
+			sendBuf <- ce
+		}
 	}
 
 	return nil
@@ -685,16 +1098,23 @@ func (ro *RedisOutput) checkpoint(ctx context.Context, runIds []string) (cpi *ch
 		return &ro.checkpointInMem, 0, nil
 	}
 
-	cli, err := ro.NewRedisConn(ctx)
+	store, release, err := ro.newCheckpointStore(ctx)
 	if err != nil {
 		return nil, 0, err
 	}
-	defer cli.Close()
-	cpKv, _dbid, err := checkpoint.GetCheckpoint(cli, ro.cfg.CheckpointName, runIds)
+	defer release()
+	cpKv, _dbid, err := store.GetCheckpoint(ro.cfg.CheckpointName, runIds)
 	if err != nil {
 		ro.logger.Errorf("get checkpoint error : name(%s), runIds(%v), err(%v)", ro.cfg.CheckpointName, runIds, err)
 		return
 	}
+	if ro.nodeCheckpointEnabled() && cpKv.RunId != "?" {
+		cpKv.Offset, err = ro.loadNodeCheckpoints(ctx, runIds, cpKv.Offset)
+		if err != nil {
+			ro.logger.Errorf("load checkpoints of nodes error : runIds(%v), err(%v)", runIds, err)
+			return
+		}
+	}
 	cpi = cpKv
 	dbid = _dbid
 
@@ -725,7 +1145,7 @@ func (ro *RedisOutput) sendCmds(replayWait usync.WaitCloser, conn client.Redis,
 	defer updateCp()
 
 	usync.SafeGo(func() {
-		updateCpTicker := time.NewTicker(config.Get().Output.UpdateCheckpointTicker)
+		updateCpTicker := time.NewTicker(ro.outputCfg().UpdateCheckpointTicker)
 		defer updateCpTicker.Stop()
 		var err error
 		for {
@@ -739,6 +1159,7 @@ func (ro *RedisOutput) sendCmds(replayWait usync.WaitCloser, conn client.Redis,
 					return
 				}
 				ackOffsetGauge.Set(float64(offset), ro.cfg.InputName)
+				ro.cfg.Lag.ack(offset)
 				repliedOffset.Store(offset)
 			case <-updateCpTicker.C:
 				err = updateCp()
@@ -777,6 +1198,7 @@ func (ro *RedisOutput) sendCmds(replayWait usync.WaitCloser, conn client.Redis,
 			if item.syncDelayNs > 0 {
 				delay := time.Now().UnixNano() - item.syncDelayNs
 				syncDelayGauge.Set(float64(delay), item.syncDelayHost)
+				ro.cfg.Lag.applied(item.syncDelayNode, item.syncDelayNs)
 			}
 		case <-replayWait.Done():
 			return nil
@@ -805,16 +1227,17 @@ func (ro *RedisOutput) sendCmdsInTransaction(replayWait usync.WaitCloser, conn c
 	var txnStatus txnStatus // transaction status
 	var needFlush bool
 
-	cmdQueue := make([]cmdExecution, 0, config.Get().Output.BatchCmdCount+1)
+	cmdQueue := make([]cmdExecution, 0, ro.outputCfg().BatchCmdCount+1)
 	checkpointKv := checkpoint.CheckpointInfo{
 		Key:     ro.cfg.CheckpointName,
 		RunId:   runId,
 		Version: config.Version,
 	}
-	ticker := time.NewTicker(time.Duration(config.Get().Output.BatchTicker))
+	ticker := time.NewTicker(time.Duration(ro.outputCfg().BatchTicker))
 	defer ticker.Stop()
+	cfgGen := config.Generation()
 
-	keepaliveTicker := time.NewTicker(time.Duration(config.Get().Output.KeepaliveTicker))
+	keepaliveTicker := time.NewTicker(time.Duration(ro.outputCfg().KeepaliveTicker))
 	defer keepaliveTicker.Stop()
 
 	cpInDbs := make(map[int]struct{})
@@ -907,8 +1330,8 @@ func (ro *RedisOutput) sendCmdsInTransaction(replayWait usync.WaitCloser, conn c
 		batchSendCounter.Add(1, ro.cfg.InputName, "yes", "ok")
 		ackOffsetGauge.Set(float64(cmdQueue[len(cmdQueue)-1].Offset), ro.cfg.InputName)
 
-		if uint(len(cmdQueue)) > config.Get().Output.BatchCmdCount*2 { // avoid occuping huge memory
-			cmdQueue = make([]cmdExecution, 0, config.Get().Output.BatchCmdCount+1)
+		if uint(len(cmdQueue)) > ro.outputCfg().BatchCmdCount*2 { // avoid occuping huge memory
+			cmdQueue = make([]cmdExecution, 0, ro.outputCfg().BatchCmdCount+1)
 		} else {
 			cmdQueue = cmdQueue[:0]
 		}
@@ -932,6 +1355,20 @@ func (ro *RedisOutput) sendCmdsInTransaction(replayWait usync.WaitCloser, conn c
 				length += len(item.Args[i].([]byte))
 			}
 
+			if item.allNodes && !isTransaction {
+				// e.g. script flush, commands calling scripts before it are replied first
+				if len(cmdQueue) > 0 {
+					if err := sendFunc(isTransaction, shouldUpdateCP); err != nil {
+						return err
+					}
+				}
+				if err := ro.doOnAllNodes(conn, item); err != nil {
+					return err
+				}
+				lastOffset = item.Offset
+				continue
+			}
+
 			lastOffset = item.Offset
 			if item.Cmd == "ping" { // skip ping command, keepaliveTicker handle it[multi/exec, ping issue for cluster]
 				continue
@@ -956,6 +1393,7 @@ func (ro *RedisOutput) sendCmdsInTransaction(replayWait usync.WaitCloser, conn c
 				isTransaction = true
 			}
 		case <-ticker.C:
+			ro.resetBatchTickerIfReloaded(ticker, &cfgGen)
 			if !isTransaction && (len(cmdQueue) > 0) {
 				needFlush = true
 			}
@@ -976,8 +1414,8 @@ func (ro *RedisOutput) sendCmdsInTransaction(replayWait usync.WaitCloser, conn c
 		}
 
 		if !needFlush && !isTransaction &&
-			(queuedCmdCount >= config.Get().Output.BatchCmdCount ||
-				queuedByteSize >= config.Get().Output.BatchBufferSize) {
+			(queuedCmdCount >= ro.outputCfg().BatchCmdCount ||
+				queuedByteSize >= ro.outputCfg().BatchBufferSize) {
 			needFlush = true
 		}
 
@@ -992,6 +1430,15 @@ func (ro *RedisOutput) sendCmdsInTransaction(replayWait usync.WaitCloser, conn c
 	}
 }
 
+// lagApplied records delay keys of applied commands
+func (ro *RedisOutput) lagApplied(cmds []cmdExecution) {
+	for i := range cmds {
+		if cmds[i].syncDelayNs > 0 {
+			ro.cfg.Lag.applied(cmds[i].syncDelayNode, cmds[i].syncDelayNs)
+		}
+	}
+}
+
 func (ro *RedisOutput) checkReplies(replies []interface{}) error {
 	if len(replies) == 0 {
 		return fmt.Errorf("replies is empmty")
@@ -1017,19 +1464,20 @@ func (ro *RedisOutput) sendCmdsBatch(replayWait usync.WaitCloser, conn client.Re
 	var txnStatus txnStatus // transaction status
 	var needFlush bool
 
-	cmdQueue := make([]cmdExecution, 0, config.Get().Output.BatchCmdCount+1)
+	cmdQueue := make([]cmdExecution, 0, ro.outputCfg().BatchCmdCount+1)
 	checkpointKv := checkpoint.CheckpointInfo{
 		Key:     ro.cfg.CheckpointName,
 		RunId:   runId,
 		Version: config.Version,
 	}
-	batchTicker := time.NewTicker(time.Duration(config.Get().Output.BatchTicker))
+	batchTicker := time.NewTicker(time.Duration(ro.outputCfg().BatchTicker))
 	defer batchTicker.Stop()
+	cfgGen := config.Generation()
 
-	keepaliveTicker := time.NewTicker(time.Duration(config.Get().Output.KeepaliveTicker))
+	keepaliveTicker := time.NewTicker(time.Duration(ro.outputCfg().KeepaliveTicker))
 	defer keepaliveTicker.Stop()
 
-	cpTicker := config.Get().Output.UpdateCheckpointTicker
+	cpTicker := ro.outputCfg().UpdateCheckpointTicker
 	if transactionMode {
 		cpTicker = time.Hour * 24 * 365 * 100
 	}
@@ -1038,6 +1486,45 @@ func (ro *RedisOutput) sendCmdsBatch(replayWait usync.WaitCloser, conn client.Re
 
 	cpInDbs := make(map[int]struct{})
 
+	if ro.cfg.Bidirectional != nil && !transactionMode {
+		// writes can't be tagged without transactions
+		return errors.Join(ErrQuit, fmt.Errorf("bidirectional sync requires transactions : output(%v)", ro.cfg.Redis.Addresses))
+	}
+
+	// commands of each node are executed with the checkpoint of the node in a transaction
+	var nodeCp *nodeCheckpoint
+	if !transactionMode && ro.nodeCheckpointEnabled() {
+		if cc, ok := conn.(*client.ClusterRedis); ok {
+			ro.cpGuard.RLock()
+			nodeCp = newNodeCheckpoint(cc, ro.nodeOffsets)
+			ro.cpGuard.RUnlock()
+		}
+	}
+
+	// checkpoints are updated after commands are replied, if they are not stored in output redis,
+	// or checkpoints of nodes are used
+	var cpStore checkpoint.Store
+	cpDb := ro.startDbId
+	if (ro.separateCheckpoint() || nodeCp != nil) && ro.cfg.EnableResumeFromBreakPoint {
+		store, release, err := ro.newCheckpointStore(replayWait.Context())
+		if err != nil {
+			return err
+		}
+		defer release()
+		cpStore = store
+	}
+	updateCpStore := func(ctx context.Context, lastOffset int64) error {
+		cp := &checkpoint.CheckpointInfo{Key: checkpointKv.Key, RunId: runId, Version: config.Version, Offset: lastOffset, Db: cpDb}
+		_, span := telemetry.StartSpan(ctx, "output.checkpoint", attribute.String("input", ro.cfg.InputName),
+			attribute.String("run_id", runId), attribute.Int64("offset", lastOffset), attribute.String("store", cpStore.Name()))
+		err := cpStore.SetCheckpoint(cp)
+		telemetry.EndSpan(span, err)
+		if err != nil {
+			ro.logger.Errorf("update checkpoint error : store(%s), checkpoint(%v), error(%v)", cpStore.Name(), cp, err)
+		}
+		return err
+	}
+
 	// transaction : call sendFunc when command is "exec", never break down a transaction
 	// non-transaction : call sendFunc when queue is full or ticker is delivered
 
@@ -1046,13 +1533,69 @@ func (ro *RedisOutput) sendCmdsBatch(replayWait usync.WaitCloser, conn client.Re
 		transactionLabel = "yes"
 	}
 
-	sendFuncOnce := func(shouldInTransaction, shouldUpdateCP bool, lastOffset int64) error {
+	resetCmdQueue := func() {
+		if uint(len(cmdQueue)) > ro.outputCfg().BatchCmdCount*2 { // avoid occuping huge memory
+			cmdQueue = make([]cmdExecution, 0, ro.outputCfg().BatchCmdCount+1)
+		} else {
+			cmdQueue = cmdQueue[:0]
+		}
+		queuedByteSize = 0
+	}
+
+	// the checkpoint of input is updated if all nodes succeed
+	sendNodeTxnsOnce := func(ctx context.Context, shouldUpdateCP bool, lastOffset int64) error {
+		delayNs := int64(0)
+		for _, ce := range cmdQueue {
+			if ce.syncDelayNs > 0 && (delayNs == 0 || delayNs > ce.syncDelayNs) {
+				delayNs = ce.syncDelayNs
+			}
+		}
+
+		cmdCounter, err := nodeCp.exec(cmdQueue, runId, lastOffset)
+		if delayNs > 0 {
+			syncDelayGauge.Set(float64(time.Now().UnixNano()-delayNs), ro.cfg.InputName)
+		}
+		if err != nil {
+			ro.logger.Errorf("exec error %v", err)
+			failCounter.Add(float64(cmdCounter), ro.cfg.InputName)
+			batchSendCounter.Add(1, ro.cfg.InputName, transactionLabel, "error")
+			return err
+		}
+		if shouldUpdateCP {
+			if len(cmdQueue) > 0 {
+				cpDb = cmdQueue[len(cmdQueue)-1].Db
+			}
+			if err = updateCpStore(ctx, lastOffset); err != nil {
+				return err
+			}
+		}
+
+		sendOffsetGauge.Set(float64(lastOffset), ro.cfg.InputName)
+		sendSizeCounter.Add(float64(queuedByteSize), ro.cfg.InputName)
+		ro.sendCounterAdd(cmdCounter)
+		succCounter.Add(float64(cmdCounter), ro.cfg.InputName)
+		batchSendCounter.Add(1, ro.cfg.InputName, transactionLabel, "ok")
+		ackOffsetGauge.Set(float64(lastOffset), ro.cfg.InputName)
+		ro.cfg.Lag.ack(lastOffset)
+		ro.lagApplied(cmdQueue)
+		resetCmdQueue()
+		return nil
+	}
+
+	sendFuncOnce := func(ctx context.Context, shouldInTransaction, shouldUpdateCP bool, lastOffset int64) error {
+		if nodeCp != nil {
+			return sendNodeTxnsOnce(ctx, shouldUpdateCP, lastOffset)
+		}
 
 		batcher := conn.NewBatcher()
 		cmdCounter := uint(0)
 
 		if shouldInTransaction {
 			batcher.Put("multi")
+			if ro.cfg.Bidirectional != nil && len(cmdQueue) > 0 {
+				// tags the transaction, the opposite direction drops it
+				batcher.Put("hset", ro.cfg.OriginKey, originMarkerField, ro.cfg.Bidirectional.OriginId)
+			}
 		}
 
 		delayNs := int64(0)
@@ -1066,16 +1609,23 @@ func (ro *RedisOutput) sendCmdsBatch(replayWait usync.WaitCloser, conn client.Re
 			}
 		}
 
+		updateCpLater := false
 		if shouldUpdateCP {
 			if ro.cfg.EnableResumeFromBreakPoint {
 				if len(cmdQueue) > 0 {
-					lastCmd := cmdQueue[len(cmdQueue)-1]
-					if _, ok := cpInDbs[lastCmd.Db]; !ok {
-						cpInDbs[lastCmd.Db] = struct{}{}
-						batcher.Put("hset", checkpointKv.Key, checkpointKv.RunIdKey(), runId, checkpointKv.VersionKey(), config.Version)
+					cpDb = cmdQueue[len(cmdQueue)-1].Db
+				}
+				if cpStore != nil {
+					updateCpLater = true
+				} else {
+					if len(cmdQueue) > 0 {
+						if _, ok := cpInDbs[cpDb]; !ok {
+							cpInDbs[cpDb] = struct{}{}
+							batcher.Put("hset", checkpointKv.Key, checkpointKv.RunIdKey(), runId, checkpointKv.VersionKey(), config.Version)
+						}
 					}
+					batcher.Put("hset", checkpointKv.Key, checkpointKv.OffsetKey(), lastOffset)
 				}
-				batcher.Put("hset", checkpointKv.Key, checkpointKv.OffsetKey(), lastOffset)
 			} else {
 				ro.cpGuard.Lock()
 				ro.checkpointInMem.Offset = lastOffset
@@ -1087,6 +1637,9 @@ func (ro *RedisOutput) sendCmdsBatch(replayWait usync.WaitCloser, conn client.Re
 			batcher.Put("exec")
 		}
 		if batcher.Len() == 0 {
+			if updateCpLater {
+				return updateCpStore(ctx, lastOffset)
+			}
 			return nil
 		}
 
@@ -1096,6 +1649,7 @@ func (ro *RedisOutput) sendCmdsBatch(replayWait usync.WaitCloser, conn client.Re
 		}
 
 		if err != nil {
+			ro.countNoScript(err)
 			ro.logger.Errorf("exec error %v", err)
 			failCounter.Inc(ro.cfg.InputName)
 			batchSendCounter.Add(1, ro.cfg.InputName, transactionLabel, "error")
@@ -1106,31 +1660,36 @@ func (ro *RedisOutput) sendCmdsBatch(replayWait usync.WaitCloser, conn client.Re
 		sendSizeCounter.Add(float64(queuedByteSize), ro.cfg.InputName)
 		ro.sendCounterAdd(uint(cmdCounter))
 
+		ro.countNoScript(rets...)
 		err = ro.checkReplies(rets)
 		if err != nil {
 			failCounter.Add(float64(cmdCounter), ro.cfg.InputName)
 			batchSendCounter.Add(1, ro.cfg.InputName, transactionLabel, "error")
 			return err
 		}
+		if updateCpLater {
+			if err = updateCpStore(ctx, lastOffset); err != nil {
+				return err
+			}
+		}
 
 		succCounter.Add(float64(cmdCounter), ro.cfg.InputName)
 		batchSendCounter.Add(1, ro.cfg.InputName, transactionLabel, "ok")
 		ackOffsetGauge.Set(float64(lastOffset), ro.cfg.InputName)
-
-		if uint(len(cmdQueue)) > config.Get().Output.BatchCmdCount*2 { // avoid occuping huge memory
-			cmdQueue = make([]cmdExecution, 0, config.Get().Output.BatchCmdCount+1)
-		} else {
-			cmdQueue = cmdQueue[:0]
-		}
-
-		queuedByteSize = 0
+		ro.cfg.Lag.ack(lastOffset)
+		ro.lagApplied(cmdQueue)
+		resetCmdQueue()
 		return nil
 	}
 
 	sendFunc := func(shouldInTransaction, shouldUpdateCP bool, lastOffset int64) error {
 		maxRetries := 0
 		for {
-			err := sendFuncOnce(shouldInTransaction, shouldUpdateCP, lastOffset)
+			ctx, span := telemetry.StartSpan(replayWait.Context(), "output.batch_flush", attribute.String("input", ro.cfg.InputName),
+				attribute.Int("cmds", len(cmdQueue)), attribute.Int64("bytes", int64(queuedByteSize)), attribute.Int64("offset", lastOffset),
+				attribute.Bool("transaction", shouldInTransaction), attribute.Bool("checkpoint", shouldUpdateCP))
+			err := sendFuncOnce(ctx, shouldInTransaction, shouldUpdateCP, lastOffset)
+			telemetry.EndSpan(span, err)
 			if err == nil {
 				return err
 			}
@@ -1168,6 +1727,20 @@ func (ro *RedisOutput) sendCmdsBatch(replayWait usync.WaitCloser, conn client.Re
 				length += len(item.Args[i].([]byte))
 			}
 
+			if item.allNodes && !inTransaction {
+				// e.g. script flush, commands calling scripts before it are replied first
+				if len(cmdQueue) > 0 {
+					if err := sendFunc(transactionBatch, shouldUpdateCP, lastOffset); err != nil {
+						return err
+					}
+				}
+				if err := ro.doOnAllNodes(conn, item); err != nil {
+					return err
+				}
+				lastOffset = item.Offset
+				continue
+			}
+
 			lastOffset = item.Offset
 			if item.Cmd == "ping" { // skip ping command, keepaliveTicker handle it[multi/exec, ping issue for cluster]
 				continue
@@ -1207,6 +1780,7 @@ func (ro *RedisOutput) sendCmdsBatch(replayWait usync.WaitCloser, conn client.Re
 				}
 			}
 		case <-batchTicker.C:
+			ro.resetBatchTickerIfReloaded(batchTicker, &cfgGen)
 			if !needFlush && !inTransaction && (len(cmdQueue) > 0) {
 				needFlush = true
 			}
@@ -1237,8 +1811,8 @@ func (ro *RedisOutput) sendCmdsBatch(replayWait usync.WaitCloser, conn client.Re
 		}
 
 		if !needFlush && !inTransaction &&
-			(uint(len(cmdQueue)) >= config.Get().Output.BatchCmdCount ||
-				queuedByteSize >= config.Get().Output.BatchBufferSize) {
+			(uint(len(cmdQueue)) >= ro.outputCfg().BatchCmdCount ||
+				queuedByteSize >= ro.outputCfg().BatchBufferSize) {
 			needFlush = true
 		}
 
@@ -1262,9 +1836,9 @@ func (ro *RedisOutput) selectDB(currentDB int, originDB int) (int, bool) {
 		return currentDB, false
 	}
 	targetDB := originDB
-	if config.Get().Output.TargetDb != -1 { // highest priority
-		targetDB = config.Get().Output.TargetDb
-	} else if tdb, ok := config.Get().Output.TargetDbMap[originDB]; ok {
+	if ro.outputCfg().TargetDb != -1 { // highest priority
+		targetDB = ro.outputCfg().TargetDb
+	} else if tdb, ok := ro.outputCfg().TargetDbMap[originDB]; ok {
 		targetDB = tdb
 	}
 
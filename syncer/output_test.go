@@ -0,0 +1,33 @@
+package syncer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+)
+
+// newTestOutput returns an output with the configuration, it doesn't connect to redis
+func newTestOutput(of *config.OutputConfig) *RedisOutput {
+	ro := &RedisOutput{logger: log.WithLogger("[output test] ")}
+	if of.Redis != nil {
+		ro.cfg.Redis = *of.Redis
+	}
+	ro.outSettings.Store(&outputSettings{gen: config.Generation(), output: of})
+	return ro
+}
+
+func TestSlotRangeLabels(t *testing.T) {
+	labels := slotRangeLabels([]config.RedisSlotRange{{Left: 0, Right: 100}, {Left: 8000, Right: 8000}, {Left: 50, Right: 200}})
+	assert.Equal(t, 16384, len(labels))
+	assert.Equal(t, "0-100", labels[0])
+	assert.Equal(t, "0-100", labels[100])
+	assert.Equal(t, "50-200", labels[101]) // overlapped slots belong to the first range
+	assert.Equal(t, "8000-8000", labels[8000])
+	assert.Equal(t, slotsOthers, labels[8001])
+
+	labels = slotRangeLabels(nil) // hash tags only
+	assert.Equal(t, slotsOthers, labels[16383])
+}
@@ -0,0 +1,358 @@
+package syncer
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/proto"
+	"github.com/mgtv-tech/redis-GunYu/pkg/store"
+	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+)
+
+/*
+psync server :
+	serves the channel of an input node as a redis master, so redis replicas or other syncers replicate the input from syncer,
+	it moves the load of full resyncs off the input, and relays the replication.
+	offsets are offsets of the input redis :
+		a partial resync continues from any offset of aof in the channel,
+		a full resync sends the rdb of the channel, then aof from the end of the rdb.
+	+CONTINUE has the run id only if the replica sends REPLCONF capa psync2, older replicas expect a bare +CONTINUE.
+	replication data is sent as it is, there are no heartbeats except those of the input.
+*/
+
+var (
+	metricPsyncServerSync = metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "psync_server",
+		Name:      "sync_type",
+		Labels:    []string{"input", "sync_type"},
+	})
+	metricPsyncServerSend = metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "psync_server",
+		Name:      "send",
+		Labels:    []string{"input"},
+	})
+)
+
+var errPsyncNoData = errors.New("no data in channel")
+
+type PsyncServer struct {
+	inputId  string
+	addr     string
+	password string
+	channel  Channel
+	listener net.Listener
+	wait     usync.WaitCloser
+	logger   log.Logger
+}
+
+func NewPsyncServer(inputId string, addr string, password string, channel Channel) *PsyncServer {
+	return &PsyncServer{
+		inputId:  inputId,
+		addr:     addr,
+		password: password,
+		channel:  channel,
+		wait:     usync.NewWaitCloser(nil),
+		logger:   log.WithLogger(config.LogModuleName(fmt.Sprintf("[PsyncServer(%s)] ", inputId))),
+	}
+}
+
+func (ps *PsyncServer) Start() error {
+	listener, err := net.Listen("tcp", ps.addr)
+	if err != nil {
+		return err
+	}
+	ps.listener = listener
+	ps.logger.Infof("listen : %s", ps.addr)
+
+	ps.wait.WgAdd(1)
+	usync.SafeGo(func() {
+		defer ps.wait.WgDone()
+		for !ps.wait.IsClosed() {
+			conn, err := listener.Accept()
+			if err != nil {
+				if !ps.wait.IsClosed() {
+					ps.logger.Errorf("accept error : %v", err)
+				}
+				ps.wait.Close(err)
+				return
+			}
+			ps.serve(conn)
+		}
+	}, func(i interface{}) { ps.wait.Close(fmt.Errorf("panic : %v", i)) })
+	return nil
+}
+
+func (ps *PsyncServer) Stop() {
+	ps.wait.Close(nil)
+	if ps.listener != nil {
+		ps.listener.Close()
+	}
+	ps.wait.WgWait()
+}
+
+func (ps *PsyncServer) serve(conn net.Conn) {
+	connWait := usync.NewWaitCloserFromParent(ps.wait, nil)
+	sess := &psyncSession{
+		server: ps,
+		conn:   conn,
+		reader: proto.NewReader(conn, 16*1024),
+		writer: proto.NewWriter(conn, 16*1024),
+		wait:   connWait,
+		authed: ps.password == "",
+		logger: log.WithLogger(config.LogModuleName(fmt.Sprintf("[PsyncServer(%s)] replica(%s) ", ps.inputId, conn.RemoteAddr()))),
+	}
+
+	connWait.WgAdd(2)
+	usync.SafeGo(func() {
+		defer connWait.WgDone()
+		<-connWait.Done()
+		conn.Close()
+	}, nil)
+	usync.SafeGo(func() {
+		defer connWait.WgDone()
+		err := sess.run()
+		if err != nil && !errors.Is(err, io.EOF) && !connWait.IsClosed() {
+			sess.logger.Errorf("session error : %v", err)
+		}
+		sess.logger.Infof("disconnected : ack(%d)", sess.ack.Load())
+		connWait.Close(nil)
+	}, func(i interface{}) { connWait.Close(fmt.Errorf("panic : %v", i)) })
+}
+
+type psyncSession struct {
+	server *PsyncServer
+	conn   net.Conn
+	reader *proto.Reader
+	writer *proto.Writer
+	wait   usync.WaitCloser
+	authed bool
+	psync2 bool // the replica accepts +CONTINUE <replid>
+	ack    atomic.Int64
+	logger log.Logger
+}
+
+// readCommand reads a command of multibulk or inline protocol
+func (ss *psyncSession) readCommand() ([]string, error) {
+	t, err := ss.reader.PeekReplyType()
+	if err != nil {
+		return nil, err
+	}
+	if t != proto.RespArray {
+		line, err := ss.reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		return strings.Fields(string(line)), nil
+	}
+	reply, err := ss.reader.ReadReply()
+	if err != nil {
+		return nil, err
+	}
+	vals, _ := reply.([]interface{})
+	args := make([]string, 0, len(vals))
+	for _, v := range vals {
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid command : %v", reply)
+		}
+		args = append(args, str)
+	}
+	return args, nil
+}
+
+func (ss *psyncSession) reply(line string) error {
+	w := ss.writer.BufioWriter()
+	if _, err := w.WriteString(line); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return ss.writer.Flush()
+}
+
+func (ss *psyncSession) run() error {
+	for !ss.wait.IsClosed() {
+		args, err := ss.readCommand()
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+		cmd := strings.ToLower(args[0])
+		if !ss.authed && cmd != "auth" {
+			if err = ss.reply("-NOAUTH Authentication required."); err != nil {
+				return err
+			}
+			continue
+		}
+		switch cmd {
+		case "ping":
+			err = ss.reply("+PONG")
+		case "auth":
+			// AUTH [username] password
+			if ss.server.password == "" {
+				err = ss.reply("-ERR AUTH called without any password configured for the default user.")
+			} else if len(args) < 2 || len(args) > 3 ||
+				subtle.ConstantTimeCompare([]byte(args[len(args)-1]), []byte(ss.server.password)) != 1 {
+				err = ss.reply("-WRONGPASS invalid username-password pair or user is disabled.")
+			} else {
+				ss.authed = true
+				err = ss.reply("+OK")
+			}
+		case "replconf":
+			// REPLCONF <option> <value> [<option> <value> ...]
+			for i := 1; i+1 < len(args); i += 2 {
+				if strings.ToLower(args[i]) == "capa" && strings.ToLower(args[i+1]) == "psync2" {
+					ss.psync2 = true
+				}
+			}
+			err = ss.reply("+OK")
+		case "psync", "sync":
+			return ss.sync(args)
+		default:
+			err = ss.reply(fmt.Sprintf("-ERR unknown command '%s'", args[0]))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sync serves PSYNC replid offset, or SYNC
+func (ss *psyncSession) sync(args []string) error {
+	channel := ss.server.channel
+	runId := channel.RunId()
+	if runId == "" {
+		return ss.reply("-NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+
+	isPsync := strings.ToLower(args[0]) == "psync"
+	if isPsync && len(args) != 3 {
+		return ss.reply("-ERR wrong number of arguments for 'psync' command")
+	}
+
+	// partial resync, redis sends offset+1 of the replica
+	if isPsync && args[1] == runId {
+		offset, err := strconv.ParseInt(args[2], 10, 64)
+		if err == nil && offset > 0 {
+			reader, err := ss.aofReader(runId, offset-1)
+			if err == nil {
+				ss.logger.Infof("partial resync : runId(%s), offset(%d)", runId, offset-1)
+				cont := "+CONTINUE"
+				if ss.psync2 {
+					cont = fmt.Sprintf("+CONTINUE %s", runId)
+				}
+				if err = ss.reply(cont); err != nil {
+					return err
+				}
+				metricPsyncServerSync.Inc(ss.server.inputId, "incr")
+				return ss.sendAof(reader)
+			}
+			ss.logger.Infof("partial resync is not accepted : runId(%s), offset(%d), error(%v)", runId, offset-1, err)
+		}
+	}
+
+	// full resync
+	left, size := channel.GetRdb(runId)
+	if left < 0 {
+		ss.logger.Warnf("full resync : there is no rdb in channel, runId(%s)", runId)
+		return ss.reply("-ERR no rdb in channel, try again later")
+	}
+	rdbReader, err := channel.NewReader(Offset{RunId: runId, Offset: left - size})
+	if err != nil || rdbReader.IsAof() {
+		ss.logger.Warnf("full resync : new rdb reader, runId(%s), offset(%d), error(%v)", runId, left-size, err)
+		return ss.reply("-ERR no rdb in channel, try again later")
+	}
+	rdbReader.Start(ss.wait)
+	// the aof after the rdb must be in channel
+	aofReader, err := ss.aofReader(runId, left)
+	if err != nil {
+		ss.logger.Warnf("full resync : there is no aof after the rdb, runId(%s), offset(%d), error(%v)", runId, left, err)
+		return ss.reply("-ERR no aof after the rdb in channel, try again later")
+	}
+
+	ss.logger.Infof("full resync : runId(%s), offset(%d), rdb(%d)", runId, left, size)
+	metricPsyncServerSync.Inc(ss.server.inputId, "full")
+	if isPsync {
+		if err = ss.reply(fmt.Sprintf("+FULLRESYNC %s %d", runId, left)); err != nil {
+			return err
+		}
+	}
+	if err = ss.reply(fmt.Sprintf("$%d", size)); err != nil {
+		return err
+	}
+	n, err := io.CopyN(ss.conn, rdbReader.IoReader(), size)
+	metricPsyncServerSend.Add(float64(n), ss.server.inputId)
+	if err != nil {
+		return fmt.Errorf("send rdb : sent(%d), size(%d), error(%w)", n, size, err)
+	}
+	return ss.sendAof(aofReader)
+}
+
+func (ss *psyncSession) aofReader(runId string, offset int64) (*store.Reader, error) {
+	channel := ss.server.channel
+	if !channel.IsValidOffset(Offset{RunId: runId, Offset: offset}) {
+		return nil, errPsyncNoData
+	}
+	reader, err := channel.NewReader(Offset{RunId: runId, Offset: offset})
+	if err != nil {
+		return nil, err
+	}
+	if !reader.IsAof() || reader.Left() != offset {
+		return nil, errPsyncNoData
+	}
+	reader.Start(ss.wait)
+	return reader, nil
+}
+
+// sendAof sends aof to the replica, and reads REPLCONF ACK of the replica
+func (ss *psyncSession) sendAof(reader *store.Reader) error {
+	ss.wait.WgAdd(1)
+	usync.SafeGo(func() {
+		defer ss.wait.WgDone()
+		for {
+			args, err := ss.readCommand()
+			if err != nil {
+				ss.wait.Close(err)
+				return
+			}
+			if len(args) == 3 && strings.ToLower(args[0]) == "replconf" && strings.ToLower(args[1]) == "ack" {
+				if ack, err := strconv.ParseInt(args[2], 10, 64); err == nil {
+					ss.ack.Store(ack)
+				}
+			}
+		}
+	}, func(i interface{}) { ss.wait.Close(fmt.Errorf("panic : %v", i)) })
+
+	ioReader := reader.IoReader()
+	buf := make([]byte, 16*1024)
+	for {
+		n, err := ioReader.Read(buf)
+		if n > 0 {
+			if _, werr := ss.conn.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			metricPsyncServerSend.Add(float64(n), ss.server.inputId)
+		}
+		if err != nil {
+			if ss.wait.IsClosed() {
+				return ss.wait.Error()
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package syncer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/proto"
+)
+
+// newTestPsyncChannel returns a channel with the aof data from offset
+func newTestPsyncChannel(t *testing.T, runId string, offset int64, data []byte) Channel {
+	channel := NewStoreChannel(StorerConf{InputId: "input", Dir: t.TempDir(), MaxSize: 1 << 30, LogSize: 1 << 20})
+	t.Cleanup(func() { channel.Close() })
+	assert.Nil(t, channel.SetRunId(runId))
+	writer, err := channel.NewAofWritter(bytes.NewReader(data), offset)
+	assert.Nil(t, err)
+	writer.Start()
+	assert.Eventually(t, func() bool { return writer.Right() == offset+int64(len(data)) }, time.Second, time.Millisecond)
+	writer.Close()
+	return channel
+}
+
+type psyncTestClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newPsyncTestClient(t *testing.T, ps *PsyncServer) *psyncTestClient {
+	client, server := net.Pipe()
+	ps.serve(server)
+	t.Cleanup(func() { client.Close() })
+	return &psyncTestClient{t: t, conn: client, reader: bufio.NewReader(client)}
+}
+
+// do sends the command and returns the reply line
+func (c *psyncTestClient) do(args ...interface{}) string {
+	w := proto.NewWriter(c.conn, 1024)
+	assert.Nil(c.t, w.WriteArgs(args))
+	assert.Nil(c.t, w.Flush())
+	return c.readLine()
+}
+
+func (c *psyncTestClient) readLine() string {
+	line, err := c.reader.ReadString('\n')
+	assert.Nil(c.t, err)
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+func TestPsyncServerAuth(t *testing.T) {
+	ps := NewPsyncServer("input", "", "secret", newTestPsyncChannel(t, "run1", 100, []byte("x")))
+	defer ps.Stop()
+	c := newPsyncTestClient(t, ps)
+
+	assert.Equal(t, "-NOAUTH Authentication required.", c.do("ping"))
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.", c.do("auth", "secrex"))
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.", c.do("auth", "secret1"))
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.", c.do("auth", "default", "secre"))
+	assert.Equal(t, "+OK", c.do("auth", "default", "secret"))
+	assert.Equal(t, "+PONG", c.do("ping"))
+	assert.Equal(t, "-ERR unknown command 'get'", c.do("get", "k"))
+
+	// inline command
+	_, err := c.conn.Write([]byte("PING\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "+PONG", c.readLine())
+
+	// the session ends after psync
+	assert.Equal(t, "-ERR wrong number of arguments for 'psync' command", c.do("psync", "run1"))
+	_, err = c.reader.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestPsyncServerContinue(t *testing.T) {
+	data := []byte("*1\r\n$4\r\nPING\r\n*3\r\n$3\r\nset\r\n$1\r\nk\r\n$1\r\nv\r\n")
+	channel := newTestPsyncChannel(t, "run1", 100, data)
+
+	cases := []struct {
+		capas []interface{}
+		reply string
+	}{
+		{nil, "+CONTINUE"},                          // e.g. syncers and redis before 4.0
+		{[]interface{}{"capa", "eof"}, "+CONTINUE"}, // redis 2.8 and 3.x
+		{[]interface{}{"capa", "eof", "capa", "psync2"}, "+CONTINUE run1"},
+		{[]interface{}{"CAPA", "PSYNC2"}, "+CONTINUE run1"},
+	}
+	for _, c := range cases {
+		ps := NewPsyncServer("input", "", "", channel)
+		client := newPsyncTestClient(t, ps)
+		assert.Equal(t, "+OK", client.do("replconf", "listening-port", "6380"))
+		if c.capas != nil {
+			assert.Equal(t, "+OK", client.do(append([]interface{}{"replconf"}, c.capas...)...))
+		}
+		// the replica sends its offset+1, the ping is replicated
+		assert.Equal(t, c.reply, client.do("psync", "run1", "115"), c.capas)
+		buf := make([]byte, len(data)-14)
+		_, err := io.ReadFull(client.reader, buf)
+		assert.Nil(t, err)
+		assert.Equal(t, data[14:], buf)
+		client.conn.Close()
+		ps.Stop()
+	}
+}
+
+func TestPsyncServerFullResync(t *testing.T) {
+	channel := newTestPsyncChannel(t, "run1", 100, []byte("x"))
+	ps := NewPsyncServer("input", "", "", channel)
+	defer ps.Stop()
+
+	// unknown run id and offsets out of channel are not continued, there is no rdb in channel
+	for _, args := range [][]interface{}{{"psync", "run2", "101"}, {"psync", "run1", "300"}, {"psync", "?", "-1"}} {
+		c := newPsyncTestClient(t, ps)
+		assert.Equal(t, "-ERR no rdb in channel, try again later", c.do(args...), fmt.Sprint(args))
+	}
+
+	ps = NewPsyncServer("input", "", "", NewStoreChannel(StorerConf{InputId: "input", Dir: t.TempDir(), MaxSize: 1 << 30, LogSize: 1 << 20}))
+	defer ps.Stop()
+	c := newPsyncTestClient(t, ps)
+	assert.Equal(t, "-NOMASTERLINK Can't SYNC while not connected with my master", c.do("sync"))
+}
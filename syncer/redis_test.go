@@ -0,0 +1,146 @@
+package syncer
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
+)
+
+// fakeRedis replies commands by the handler, a nil reply is ErrNil and an error reply is returned as error,
+// methods which are not used by tests are not implemented
+type fakeRedis struct {
+	client.Redis
+	mux     sync.Mutex
+	typ     config.RedisType
+	handler func(cmd string, args []interface{}) interface{}
+	cmds    [][]interface{} // executed commands
+	pending []interface{}   // replies of sent commands
+}
+
+func newFakeRedis(handler func(cmd string, args []interface{}) interface{}) *fakeRedis {
+	return &fakeRedis{typ: config.RedisTypeStandalone, handler: handler}
+}
+
+func (fr *fakeRedis) exec(cmd string, args []interface{}) interface{} {
+	fr.cmds = append(fr.cmds, append([]interface{}{cmd}, args...))
+	return fr.handler(cmd, args)
+}
+
+func reply(ret interface{}) (interface{}, error) {
+	if ret == nil {
+		return nil, common.ErrNil
+	}
+	if err, ok := ret.(error); ok {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (fr *fakeRedis) Do(cmd string, args ...interface{}) (interface{}, error) {
+	fr.mux.Lock()
+	defer fr.mux.Unlock()
+	return reply(fr.exec(cmd, args))
+}
+
+func (fr *fakeRedis) Send(cmd string, args ...interface{}) error {
+	fr.mux.Lock()
+	defer fr.mux.Unlock()
+	fr.pending = append(fr.pending, fr.exec(cmd, args))
+	return nil
+}
+
+func (fr *fakeRedis) SendAndFlush(cmd string, args ...interface{}) error {
+	return fr.Send(cmd, args...)
+}
+
+func (fr *fakeRedis) Receive() (interface{}, error) {
+	fr.mux.Lock()
+	defer fr.mux.Unlock()
+	if len(fr.pending) == 0 {
+		return nil, errors.New("no pending reply")
+	}
+	ret := fr.pending[0]
+	fr.pending = fr.pending[1:]
+	return reply(ret)
+}
+
+func (fr *fakeRedis) ReceiveString() (string, error) {
+	return common.String(fr.Receive())
+}
+
+func (fr *fakeRedis) Flush() error {
+	return nil
+}
+
+func (fr *fakeRedis) NewBatcher() common.CmdBatcher {
+	return &fakeBatcher{fr: fr}
+}
+
+// fakeBatcher executes commands in order, commands between multi and exec are queued,
+// exec replies their replies in an array
+type fakeBatcher struct {
+	fr   *fakeRedis
+	cmds [][]interface{}
+}
+
+func (fb *fakeBatcher) Put(cmd string, args ...interface{}) error {
+	fb.cmds = append(fb.cmds, append([]interface{}{cmd}, args...))
+	return nil
+}
+
+func (fb *fakeBatcher) Len() int {
+	return len(fb.cmds)
+}
+
+func (fb *fakeBatcher) Exec() ([]interface{}, error) {
+	fb.fr.mux.Lock()
+	defer fb.fr.mux.Unlock()
+	var rets, queued []interface{}
+	inTxn := false
+	for _, cmd := range fb.cmds {
+		name := cmd[0].(string)
+		switch {
+		case name == "multi":
+			inTxn = true
+			rets = append(rets, "OK")
+		case name == "exec":
+			inTxn = false
+			rets = append(rets, queued)
+			queued = nil
+		case inTxn:
+			queued = append(queued, fb.fr.exec(name, cmd[1:]))
+			rets = append(rets, "QUEUED")
+		default:
+			ret := fb.fr.exec(name, cmd[1:])
+			if err, ok := ret.(error); ok {
+				return nil, err
+			}
+			rets = append(rets, ret)
+		}
+	}
+	return rets, nil
+}
+
+func (fr *fakeRedis) RedisType() config.RedisType {
+	return fr.typ
+}
+
+func (fr *fakeRedis) IterateNodes(result func(string, interface{}, error), cmd string, args ...interface{}) {
+	for _, addr := range []string{"127.0.0.1:7000", "127.0.0.1:7001"} {
+		ret, err := fr.Do(cmd, args...)
+		result(addr, ret, err)
+	}
+}
+
+func (fr *fakeRedis) Close() error {
+	return nil
+}
+
+func (fr *fakeRedis) executed() [][]interface{} {
+	fr.mux.Lock()
+	defer fr.mux.Unlock()
+	return append([][]interface{}{}, fr.cmds...)
+}
@@ -7,21 +7,27 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/exp/slices"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/api/auth"
 	pb "github.com/mgtv-tech/redis-GunYu/pkg/api/golang"
 	"github.com/mgtv-tech/redis-GunYu/pkg/cluster"
+	unet "github.com/mgtv-tech/redis-GunYu/pkg/io/net"
 	"github.com/mgtv-tech/redis-GunYu/pkg/io/pipe"
 	"github.com/mgtv-tech/redis-GunYu/pkg/log"
 	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
 	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+	"github.com/mgtv-tech/redis-GunYu/pkg/telemetry"
 )
 
 var (
@@ -269,14 +275,29 @@ func (rf *ReplicaFollower) Run() error {
 	rf.wait.WgAdd(1)
 	defer rf.wait.WgDone()
 
+	// handshake, meta sync and rdb sync are traced, aof sync lasts until the follower stops
+	traceStep := func(name string, step func() error) error {
+		_, span := telemetry.StartSpan(rf.wait.Context(), name,
+			attribute.String("input", rf.inputAddress), attribute.String("leader", rf.leader.Address))
+		err := step()
+		telemetry.EndSpan(span, err)
+		return err
+	}
+
 	for !rf.wait.IsClosed() {
 		switch state {
 		case 1: // shake
-			leaderSp, err = rf.protoHandShake(cli)
+			err = traceStep("replica.handshake", func() (err error) {
+				leaderSp, err = rf.protoHandShake(cli)
+				return
+			})
 		case 2: // prepare
 			followerSp, err = rf.preSync(leaderSp)
 		case 3: // meta sync
-			stream, resp, err = rf.metaSync(followerSp, cli)
+			err = traceStep("replica.meta_sync", func() (err error) {
+				stream, resp, err = rf.metaSync(followerSp, cli)
+				return
+			})
 			if err == nil {
 				if resp.GetMeta().GetAof() {
 					state = 5
@@ -286,7 +307,9 @@ func (rf *ReplicaFollower) Run() error {
 				continue
 			}
 		case 4: // rdb
-			err = rf.rdbSync(followerSp, stream, resp)
+			err = traceStep("replica.rdb_sync", func() error {
+				return rf.rdbSync(followerSp, stream, resp)
+			})
 			if err == nil {
 				followerSp, err = rf.channel.StartPoint([]string{leaderSp.RunId})
 				if err != nil {
@@ -558,11 +581,31 @@ func (rf *ReplicaFollower) aofSync(followerSp StartPoint, stream pb.ApiService_S
 
 func (rf *ReplicaFollower) newGrpcConn(leader *cluster.RoleInfo) (*grpc.ClientConn, error) {
 
+	serverCfg := config.Get().Server
+	transportCreds := insecure.NewCredentials()
+	if serverCfg.Tls != nil {
+		opts := serverCfg.Tls.TlsOptions()
+		if opts.ServerName == "" {
+			if host, _, err := net.SplitHostPort(leader.Address); err == nil {
+				opts.ServerName = host
+			}
+		}
+		tlsCfg, err := unet.NewClientTlsConfig(opts)
+		if err != nil {
+			rf.logger.Errorf("tls config error : %v", err)
+			return nil, err
+		}
+		transportCreds = credentials.NewTLS(tlsCfg)
+	}
+
 	var grpcOpts = []grpc.DialOption{
 		grpc.WithChainUnaryInterceptor(ClientUnaryCallInterceptor(grpc.WaitForReady(true))),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(transportCreds),
 		grpc.WithBlock(),
 	}
+	if serverCfg.Auth != nil && serverCfg.Auth.PeerToken != "" {
+		grpcOpts = append(grpcOpts, grpc.WithPerRPCCredentials(auth.NewTokenCredentials(serverCfg.Auth.PeerToken.Value, serverCfg.Tls != nil)))
+	}
 	ctx, cancel := context.WithTimeout(rf.wait.Context(), time.Duration(10*time.Second))
 	defer cancel()
 
@@ -0,0 +1,401 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
+	"github.com/mgtv-tech/redis-GunYu/pkg/util"
+)
+
+type rewriteKind int
+
+const (
+	rewriteString    rewriteKind = iota // set the value of string
+	rewriteHashField                    // set the field of hash
+	rewriteZsetScore                    // set the score of member
+	rewriteExpire                       // set the absolute expiration
+	rewriteOnce                         // applied once for each offset by appliedOnceScript on output
+)
+
+// nonIdempotentCommands are rewritten by cmdRewriter.
+// spop is replicated as srem of the popped members by redis, and smove is idempotent, so they're not rewritten
+var nonIdempotentCommands = map[string]rewriteKind{
+	"incr":         rewriteString,
+	"decr":         rewriteString,
+	"incrby":       rewriteString,
+	"decrby":       rewriteString,
+	"incrbyfloat":  rewriteString,
+	"append":       rewriteString,
+	"bitfield":     rewriteString,
+	"hincrby":      rewriteHashField,
+	"hincrbyfloat": rewriteHashField,
+	"zincrby":      rewriteZsetScore,
+	"expire":       rewriteExpire,
+	"pexpire":      rewriteExpire,
+	"lpush":        rewriteOnce,
+	"rpush":        rewriteOnce,
+	"lpushx":       rewriteOnce,
+	"rpushx":       rewriteOnce,
+	"linsert":      rewriteOnce,
+	"lpop":         rewriteOnce,
+	"rpop":         rewriteOnce,
+	"lrem":         rewriteOnce,
+	"ltrim":        rewriteOnce,
+	"rpoplpush":    rewriteOnce,
+	"lmove":        rewriteOnce,
+	"zpopmin":      rewriteOnce,
+	"zpopmax":      rewriteOnce,
+}
+
+var appliedKeyPrefix = config.CheckpointKey + "-applied-"
+
+// appliedOnceScript applies the command if its offset is newer than the applied record of the key,
+// and records "$runId:$offset" with an expiration. pops are applied as explicit removals of the popped values,
+// ltrim for lists and zrem for sorted sets, so they're deterministic in the replication stream of output.
+// KEYS : applied record, keys of the command; ARGV : run id, offset, ttl of record, command and arguments
+var appliedOnceScript = []byte(strings.TrimSpace(`
+if redis.replicate_commands then redis.replicate_commands() end
+local cur = redis.call('get', KEYS[1])
+if cur then
+	local run, offset = string.match(cur, '^(.*):(%d+)$')
+	if run == ARGV[1] and tonumber(offset) >= tonumber(ARGV[2]) then
+		return 0
+	end
+end
+redis.call('set', KEYS[1], ARGV[1] .. ':' .. ARGV[2], 'px', ARGV[3])
+local cmd = string.lower(ARGV[4])
+local key = ARGV[5]
+if cmd == 'lpop' or cmd == 'rpop' then
+	local n = tonumber(ARGV[6] or '1')
+	if cmd == 'lpop' then
+		redis.call('ltrim', key, n, -1)
+	else
+		redis.call('ltrim', key, 0, -n - 1)
+	end
+elseif cmd == 'zpopmin' or cmd == 'zpopmax' then
+	local n = tonumber(ARGV[6] or '1')
+	if n > 0 then
+		local members
+		if cmd == 'zpopmin' then
+			members = redis.call('zrange', key, 0, n - 1)
+		else
+			members = redis.call('zrange', key, -n, -1)
+		end
+		for i = 1, #members, 1000 do
+			redis.call('zrem', key, unpack(members, i, math.min(i + 999, #members)))
+		end
+	end
+elseif cmd == 'rpoplpush' or cmd == 'lmove' then
+	local from, to = 'right', 'left'
+	if cmd == 'lmove' then
+		from, to = string.lower(ARGV[7]), string.lower(ARGV[8])
+	end
+	local val
+	if from == 'left' then
+		val = redis.call('lindex', key, 0)
+	else
+		val = redis.call('lindex', key, -1)
+	end
+	if val then
+		if from == 'left' then
+			redis.call('ltrim', key, 1, -1)
+		else
+			redis.call('ltrim', key, 0, -2)
+		end
+		if to == 'left' then
+			redis.call('lpush', ARGV[6], val)
+		else
+			redis.call('rpush', ARGV[6], val)
+		end
+	end
+else
+	redis.call(ARGV[4], unpack(ARGV, 5))
+end
+return 1
+`))
+
+var (
+	slotTagsOnce sync.Once
+	slotTags     []string // a hash tag of each slot
+)
+
+// slotTag returns a hash tag of the slot, it has no braces
+func slotTag(slot uint16) string {
+	slotTagsOnce.Do(func() {
+		slotTags = make([]string, 16384)
+		for i, found := int64(0), 0; found < len(slotTags); i++ {
+			tag := strconv.FormatInt(i, 36)
+			if s := redis.KeyToSlot(tag); slotTags[s] == "" {
+				slotTags[s] = tag
+				found++
+			}
+		}
+	})
+	return slotTags[slot]
+}
+
+// slotRecordKey returns the record of key with the prefix, it's in the same slot as key
+func slotRecordKey(prefix string, key []byte) string {
+	skey := util.BytesToString(key)
+	tag := redis.KeyHashTag(skey)
+	if tag == "" {
+		if strings.IndexByte(skey, '}') < 0 {
+			return prefix + "{" + skey + "}"
+		}
+		tag = slotTag(redis.KeyToSlot(skey))
+	}
+	return prefix + "{" + tag + "}" + skey
+}
+
+// inputConn is a connection of input, it follows the db of replayed commands
+type inputConn struct {
+	cli client.Redis
+	db  int
+}
+
+func newInputConn(cfg config.RedisConfig, db int) (*inputConn, error) {
+	cli, err := client.NewRedis(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ic := &inputConn{cli: cli, db: -1}
+	if err = ic.selectDB(db); err != nil {
+		ic.Close()
+		return nil, err
+	}
+	return ic, nil
+}
+
+func (ic *inputConn) Close() error {
+	return ic.cli.Close()
+}
+
+// selectDB selects db of input, cluster only has db 0
+func (ic *inputConn) selectDB(db int) error {
+	if db < 0 || db == ic.db || ic.cli.RedisType() == config.RedisTypeCluster {
+		ic.db = db
+		return nil
+	}
+	if err := common.StringIsOk(ic.cli.Do("select", db)); err != nil {
+		return fmt.Errorf("select db(%d) of input error : %w", db, err)
+	}
+	ic.db = db
+	return nil
+}
+
+// cmdRewriter rewrites non-idempotent commands into deterministic ones,
+// replaying the rewritten commands twice converges to the same state, so at-least-once replay is safe.
+//
+// strings, hash fields, scores and expirations are rewritten with the state observed on input,
+// e.g. incrby is rewritten to set, expire to pexpireat. the state is observed when the command is parsed,
+// it may be newer than the state right after the command, the following commands of the key are rewritten too,
+// so the output converges to the input. the value and the absolute expiration(pexpiretime) are read in one round trip.
+//
+// commands of lists and pops of sorted sets are applied once for each offset by appliedOnceScript on output,
+// the applied offset of each key is recorded in output, input is not read.
+type cmdRewriter struct {
+	*inputConn
+	logger       log.Logger
+	runId        string
+	recordTtl    int64 // milliseconds
+	noExpireTime bool  // input doesn't support pexpiretime, redis < 7.0
+}
+
+func newCmdRewriter(ic *inputConn, logger log.Logger, runId string, recordTtl time.Duration) *cmdRewriter {
+	return &cmdRewriter{
+		inputConn: ic,
+		logger:    logger,
+		runId:     runId,
+		recordTtl: recordTtl.Milliseconds(),
+	}
+}
+
+// rewrite returns the deterministic commands of ce, or ce itself if it's idempotent.
+// the rewritten commands have the same offset and db as ce
+func (rw *cmdRewriter) rewrite(ce cmdExecution) ([]cmdExecution, error) {
+	kind, ok := nonIdempotentCommands[ce.Cmd]
+	if !ok || len(ce.Args) == 0 {
+		return []cmdExecution{ce}, nil
+	}
+
+	var cmds [][]interface{}
+	var err error
+	key := ce.Args[0]
+	switch kind {
+	case rewriteString:
+		cmds, err = rw.rewriteString(key)
+	case rewriteHashField:
+		if len(ce.Args) < 2 {
+			return []cmdExecution{ce}, nil
+		}
+		cmds, err = rw.rewriteMember(key, ce.Args[1], "hget", "hset", "hdel")
+	case rewriteZsetScore:
+		if len(ce.Args) < 3 {
+			return []cmdExecution{ce}, nil
+		}
+		cmds, err = rw.rewriteMember(key, ce.Args[2], "zscore", "zadd", "zrem")
+	case rewriteExpire:
+		cmds, err = rw.rewriteExpire(key)
+	case rewriteOnce:
+		cmds, err = rw.rewriteOnce(ce)
+	}
+	if isWrongType(err) {
+		// the key is replaced by another type after the command, the following commands of the key converge it
+		rw.logger.Debugf("skip command of a replaced key : cmd(%s), key(%s)", ce.Cmd, key)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rewrite command(%s) error : %w", ce.Cmd, err)
+	}
+
+	ces := make([]cmdExecution, 0, len(cmds))
+	for _, cmd := range cmds {
+		ces = append(ces, cmdExecution{
+			Cmd:    cmd[0].(string),
+			Args:   bytesArgs(cmd[1:]),
+			Offset: ce.Offset,
+			Db:     ce.Db,
+		})
+	}
+	return ces, nil
+}
+
+// isWrongType returns true if the error reply is WRONGTYPE
+func isWrongType(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
+
+func isUnknownCommand(err error) bool {
+	return err != nil && strings.HasPrefix(strings.ToLower(err.Error()), "err unknown command")
+}
+
+// bytesArgs converts arguments to bytes, arguments of replayed commands are bytes
+func bytesArgs(args []interface{}) []interface{} {
+	bargs := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			bargs = append(bargs, []byte(v))
+		case int64:
+			bargs = append(bargs, strconv.AppendInt(nil, v, 10))
+		default:
+			bargs = append(bargs, v)
+		}
+	}
+	return bargs
+}
+
+// read reads the key by the command and its absolute expiration in milliseconds in one round trip,
+// the reply is nil if the key doesn't exist, expireAt is -1 if the key has no expiration, -2 if it doesn't exist.
+// if input doesn't support pexpiretime, the expiration is estimated with pttl and the clock of syncer
+func (rw *cmdRewriter) read(key interface{}, cmd string, args ...interface{}) (reply interface{}, expireAt int64, err error) {
+	expireCmd := "pexpiretime"
+	if rw.noExpireTime {
+		expireCmd = "pttl"
+	}
+	if err = rw.cli.Send(cmd, args...); err != nil {
+		return nil, 0, err
+	}
+	if err = rw.cli.SendAndFlush(expireCmd, key); err != nil {
+		return nil, 0, err
+	}
+	// both replies are received to keep the connection in order
+	reply, err = rw.cli.Receive()
+	expireAt, expErr := common.Int64(rw.cli.Receive())
+	if errors.Is(err, common.ErrNil) {
+		reply, err = nil, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if expErr != nil {
+		if !rw.noExpireTime && isUnknownCommand(expErr) {
+			rw.logger.Warnf("input doesn't support pexpiretime, expirations are estimated by pttl")
+			rw.noExpireTime = true
+			return rw.read(key, cmd, args...)
+		}
+		return nil, 0, expErr
+	}
+	if rw.noExpireTime && expireAt >= 0 {
+		expireAt += time.Now().UnixMilli()
+	}
+	return reply, expireAt, nil
+}
+
+func (rw *cmdRewriter) rewriteString(key interface{}) ([][]interface{}, error) {
+	reply, expireAt, err := rw.read(key, "get", key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return [][]interface{}{{"del", key}}, nil
+	}
+	val, err := common.Bytes(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	if expireAt >= 0 {
+		return [][]interface{}{{"set", key, val}, {"pexpireat", key, expireAt}}, nil
+	}
+	return [][]interface{}{{"set", key, val}}, nil
+}
+
+// rewriteMember sets the value of a hash field or the score of a zset member, or removes it
+func (rw *cmdRewriter) rewriteMember(key interface{}, member interface{}, getCmd string, setCmd string, delCmd string) ([][]interface{}, error) {
+	val, err := common.Bytes(rw.cli.Do(getCmd, key, member))
+	if errors.Is(err, common.ErrNil) {
+		return [][]interface{}{{delCmd, key, member}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if setCmd == "zadd" {
+		return [][]interface{}{{setCmd, key, val, member}}, nil
+	}
+	return [][]interface{}{{setCmd, key, member, val}}, nil
+}
+
+func (rw *cmdRewriter) rewriteExpire(key interface{}) ([][]interface{}, error) {
+	_, expireAt, err := rw.read(key, "exists", key)
+	if err != nil {
+		return nil, err
+	}
+	switch expireAt {
+	case -2:
+		return [][]interface{}{{"del", key}}, nil
+	case -1:
+		return [][]interface{}{{"persist", key}}, nil
+	}
+	return [][]interface{}{{"pexpireat", key, expireAt}}, nil
+}
+
+// rewriteOnce guards the command by the applied record of its first key,
+// all keys of the command are in the same slot
+func (rw *cmdRewriter) rewriteOnce(ce cmdExecution) ([][]interface{}, error) {
+	idxes := common.CommandKeyIndexes(ce.Cmd, ce.Args)
+	if len(idxes) == 0 {
+		return [][]interface{}{append([]interface{}{ce.Cmd}, ce.Args...)}, nil
+	}
+	key, ok := ce.Args[idxes[0]].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("key is not bytes : %v", ce.Args[idxes[0]])
+	}
+
+	cmd := make([]interface{}, 0, len(idxes)+len(ce.Args)+8)
+	cmd = append(cmd, "eval", appliedOnceScript, int64(len(idxes)+1), slotRecordKey(appliedKeyPrefix, key))
+	for _, i := range idxes {
+		cmd = append(cmd, ce.Args[i])
+	}
+	cmd = append(cmd, rw.runId, ce.Offset, rw.recordTtl, ce.Cmd)
+	cmd = append(cmd, ce.Args...)
+	return [][]interface{}{cmd}, nil
+}
@@ -0,0 +1,172 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
+)
+
+type fakeKey struct {
+	typ      string
+	val      string
+	fields   map[string]string
+	expireAt int64 // -1 without expiration
+}
+
+// fakeInput replies reads of rewriter by keys
+func fakeInput(keys map[string]*fakeKey, legacy bool) func(cmd string, args []interface{}) interface{} {
+	return func(cmd string, args []interface{}) interface{} {
+		k, ok := keys[fmt.Sprintf("%s", args[0])]
+		switch cmd {
+		case "get":
+			if !ok {
+				return nil
+			}
+			if k.typ != "string" {
+				return errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+			}
+			return []byte(k.val)
+		case "hget", "zscore":
+			if !ok {
+				return nil
+			}
+			if k.typ != "hash" && k.typ != "zset" {
+				return errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+			}
+			if v, ok := k.fields[fmt.Sprintf("%s", args[1])]; ok {
+				return []byte(v)
+			}
+			return nil
+		case "exists":
+			if ok {
+				return int64(1)
+			}
+			return int64(0)
+		case "pexpiretime":
+			if legacy {
+				return errors.New("ERR unknown command 'pexpiretime', with args beginning with: ")
+			}
+			if !ok {
+				return int64(-2)
+			}
+			return k.expireAt
+		case "pttl":
+			if !ok {
+				return int64(-2)
+			}
+			if k.expireAt < 0 {
+				return int64(-1)
+			}
+			return k.expireAt - time.Now().UnixMilli()
+		}
+		return errors.New("ERR unknown command")
+	}
+}
+
+func cmdStrings(ces []cmdExecution) []string {
+	ret := []string{}
+	for _, ce := range ces {
+		parts := []string{ce.Cmd}
+		for _, arg := range ce.Args {
+			parts = append(parts, fmt.Sprintf("%s", arg))
+		}
+		ret = append(ret, strings.Join(parts, " "))
+	}
+	return ret
+}
+
+func TestCmdRewriter(t *testing.T) {
+	at := time.Now().Add(time.Hour).UnixMilli()
+	keys := map[string]*fakeKey{
+		"counter": {typ: "string", val: "10", expireAt: -1},
+		"session": {typ: "string", val: "abc", expireAt: at},
+		"hash":    {typ: "hash", fields: map[string]string{"f": "3"}, expireAt: -1},
+		"zset":    {typ: "zset", fields: map[string]string{"m": "1.5"}, expireAt: -1},
+		"list":    {typ: "list", expireAt: at},
+		"set":     {typ: "set", expireAt: -1},
+	}
+	rw := newCmdRewriter(&inputConn{cli: newFakeRedis(fakeInput(keys, false))}, log.WithLogger(""), "run1", time.Hour)
+
+	cases := []struct {
+		cmd  string
+		args []interface{}
+		exp  []string
+	}{
+		{"set", []interface{}{"counter", "1"}, []string{"set counter 1"}}, // idempotent
+		{"incrby", []interface{}{"counter", "5"}, []string{"set counter 10"}},
+		{"append", []interface{}{"session", "x"}, []string{"set session abc", fmt.Sprintf("pexpireat session %d", at)}},
+		{"incr", []interface{}{"missing"}, []string{"del missing"}},
+		{"hincrby", []interface{}{"hash", "f", "1"}, []string{"hset hash f 3"}},
+		{"hincrby", []interface{}{"hash", "g", "1"}, []string{"hdel hash g"}},
+		{"zincrby", []interface{}{"zset", "1", "m"}, []string{"zadd zset 1.5 m"}},
+		{"pexpire", []interface{}{"session", "100"}, []string{fmt.Sprintf("pexpireat session %d", at)}},
+		{"expire", []interface{}{"counter", "100"}, []string{"persist counter"}},
+		{"expire", []interface{}{"missing", "100"}, []string{"del missing"}},
+		// applied once for each offset, input is not read
+		{"lpush", []interface{}{[]byte("list"), []byte("a")}, []string{"eval 2 redis-gunyu-checkpoint-applied-{list} list run1 100 3600000 lpush list a"}},
+		{"lpop", []interface{}{[]byte("{t}list"), []byte("2")}, []string{"eval 2 redis-gunyu-checkpoint-applied-{t}{t}list {t}list run1 100 3600000 lpop {t}list 2"}},
+		{"lmove", []interface{}{[]byte("{t}a"), []byte("{t}b"), []byte("left"), []byte("right")},
+			[]string{"eval 3 redis-gunyu-checkpoint-applied-{t}{t}a {t}a {t}b run1 100 3600000 lmove {t}a {t}b left right"}},
+		{"spop", []interface{}{"set"}, []string{"spop set"}}, // replicated as srem
+		{"smove", []interface{}{"set", "missing", "m"}, []string{"smove set missing m"}},
+		// the type of key was changed after the command
+		{"incr", []interface{}{"list"}, []string{}},
+		{"hincrby", []interface{}{"counter", "f", "1"}, []string{}},
+	}
+	for _, c := range cases {
+		ces, err := rw.rewrite(cmdExecution{Cmd: c.cmd, Args: c.args, Offset: 100, Db: 2})
+		assert.Nil(t, err, c.cmd)
+		for i, ce := range ces {
+			assert.Equal(t, int64(100), ce.Offset)
+			assert.Equal(t, 2, ce.Db)
+			if ce.Cmd == "eval" {
+				assert.Equal(t, appliedOnceScript, ce.Args[0])
+				ces[i].Args = ce.Args[1:]
+			}
+		}
+		assert.Equal(t, c.exp, cmdStrings(ces), c.cmd)
+	}
+}
+
+func TestCmdRewriterLegacyInput(t *testing.T) {
+	at := time.Now().Add(time.Hour).UnixMilli()
+	keys := map[string]*fakeKey{"session": {typ: "string", val: "abc", expireAt: at}}
+	fr := newFakeRedis(fakeInput(keys, true))
+	rw := newCmdRewriter(&inputConn{cli: fr}, log.WithLogger(""), "run1", time.Hour)
+
+	ces, err := rw.rewrite(cmdExecution{Cmd: "pexpire", Args: []interface{}{"session", "100"}})
+	assert.Nil(t, err)
+	assert.True(t, rw.noExpireTime)
+	assert.Equal(t, 1, len(ces))
+	assert.Equal(t, "pexpireat", ces[0].Cmd)
+	var estimated int64
+	fmt.Sscan(string(ces[0].Args[1].([]byte)), &estimated)
+	assert.InDelta(t, at, estimated, 1000)
+
+	ces, err = rw.rewrite(cmdExecution{Cmd: "incr", Args: []interface{}{"missing"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"del missing"}, cmdStrings(ces))
+	assert.Equal(t, "pttl", fr.executed()[len(fr.executed())-1][0]) // pexpiretime isn't retried
+}
+
+func TestSlotRecordKey(t *testing.T) {
+	for _, key := range []string{"key", "{tag}key", "a{b", "{}key", "a}b", "{a}}b"} {
+		record := slotRecordKey(appliedKeyPrefix, []byte(key))
+		assert.True(t, strings.HasPrefix(record, appliedKeyPrefix), key)
+		assert.Equal(t, redis.KeyToSlot(key), redis.KeyToSlot(record), key)
+	}
+	assert.Equal(t, appliedKeyPrefix+"{key}", slotRecordKey(appliedKeyPrefix, []byte("key")))
+	assert.Equal(t, appliedKeyPrefix+"{tag}{tag}key", slotRecordKey(appliedKeyPrefix, []byte("{tag}key")))
+	assert.NotEqual(t, slotRecordKey(appliedKeyPrefix, []byte("a}b")), slotRecordKey(appliedKeyPrefix, []byte("a}c")))
+
+	for slot := uint16(0); slot < 16384; slot++ {
+		assert.Equal(t, slot, redis.KeyToSlot(slotTag(slot)))
+	}
+}
@@ -0,0 +1,743 @@
+package syncer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/log"
+	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
+	"github.com/mgtv-tech/redis-GunYu/pkg/rdb"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/proto"
+	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+)
+
+/*
+rump :
+	syncs input redis which rejects SYNC and PSYNC, e.g. redis managed by cloud providers.
+	keys of the node are fetched by SCAN, DUMP and PTTL, and saved as a rdb in the channel, so they are replayed as a rdb by output.
+	with keyspace notifications, keys notified during and after the scan are fetched again,
+	and saved as RESTORE or DEL commands in the channel. it's best-effort :
+		notifications are lost if the subscription is broken, then the node is scanned again with a new run id,
+		and keys deleted in the meantime are not deleted from output.
+		the node is scanned at most once per rumpRescanInterval, so a flapping subscription doesn't scan it over and over.
+	a run id is generated for each scan, so there is no breakpoint resumption after restarting.
+*/
+
+type RumpInput struct {
+	*RedisInput
+	storerDir string
+	session   usync.WaitCloser // a scan and the following notifications
+	scannedAt time.Time        // start of the last scan
+	limiter   *rumpLimiter
+}
+
+var (
+	rumpRescanInterval = time.Minute
+)
+
+func NewRumpInput(redisCfg config.RedisConfig, storerDir string) *RumpInput {
+	ri := &RumpInput{
+		RedisInput: NewRedisInput(redisCfg),
+		storerDir:  storerDir,
+	}
+	ri.logger = log.WithLogger(config.LogModuleName(fmt.Sprintf("[RumpInput(%s)] ", redisCfg.Address())))
+	return ri
+}
+
+var (
+	metricRumpKeys = metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "input",
+		Name:      "rump_keys",
+		Labels:    []string{"input", "phase"}, // scan, notify
+	})
+)
+
+func (ri *RumpInput) Run() error {
+	ri.logger.Debugf("Run")
+
+	ri.wait.WgAdd(1)
+	usync.SafeGo(func() {
+		defer ri.wait.WgDone()
+		for !ri.wait.IsClosed() {
+			err := ri.run()
+			if err != nil {
+				ri.logger.Errorf("run error : %v", err)
+				if errors.Is(err, ErrBreak) {
+					ri.wait.Close(err)
+					break
+				}
+			}
+			ri.wait.Sleep(2 * time.Second)
+		}
+		if ri.session != nil {
+			ri.session.WgWait()
+		}
+	}, func(i interface{}) {
+		ri.wait.Close(fmt.Errorf("panic : %v", i))
+	})
+
+	ri.wait.WgWait()
+	return ri.wait.Error()
+}
+
+// run scans the node if there is no session, then sends the channel to output.
+// output is resumed in the session, e.g. it's restarted after the rdb
+func (ri *RumpInput) run() error {
+	if ri.session == nil || ri.session.IsClosed() {
+		if ri.session != nil {
+			ri.session.WgWait()
+			ri.waitRescan(ri.session.Error())
+			if ri.wait.IsClosed() {
+				return nil
+			}
+		}
+		ri.scannedAt = time.Now()
+		ri.session = usync.NewWaitCloserFromParent(ri.wait, nil)
+		if err := ri.rump(ri.session); err != nil {
+			ri.session.Close(err)
+			inputStateGauge.Set(0, ri.inputAddr)
+			return err
+		}
+	}
+	session := ri.session
+
+	runScope := usync.NewWaitCloserFromParent(session, nil)
+	startPoint, err := ri.startPoint(runScope)
+	if err != nil {
+		runScope.Close(err)
+		session.Close(err)
+		return err
+	}
+	reader := ri.readChannel(runScope, startPoint)
+	ri.sendOutput(runScope.Context(), runScope, reader)
+
+	runScope.WgWait()
+	err = runScope.Error()
+	if errors.Is(err, ErrCorrupted) {
+		session.Close(err)
+	}
+	return err
+}
+
+// waitRescan logs why the session is closed, and waits until rumpRescanInterval has passed since the last scan
+func (ri *RumpInput) waitRescan(reason error) {
+	d := rumpRescanInterval - time.Since(ri.scannedAt)
+	if d < 0 {
+		d = 0
+	}
+	ri.logger.Warnf("session is closed, the node is scanned again after %v : error(%v)", d.Round(time.Millisecond), reason)
+	ri.wait.Sleep(d)
+}
+
+// startPoint returns the checkpoint of output, or the start of the rdb if output has no checkpoint of the run id
+func (ri *RumpInput) startPoint(wait usync.WaitCloser) (StartPoint, error) {
+	runIds := ri.RunIds()
+	sp, err := ri.getOutputStartPoint(wait.Context(), runIds)
+	if err != nil {
+		return sp, err
+	}
+	if sp.RunId != runIds[0] {
+		left, size := ri.channel.GetRdb(runIds[0])
+		return StartPoint{RunId: runIds[0], Offset: left - size}, nil
+	}
+	if !ri.channel.IsValidOffset(sp.ToOffset()) {
+		return sp, fmt.Errorf("checkpoint of output is not in channel : %v", sp)
+	}
+	return sp, nil
+}
+
+// rump scans the node into a rdb of the channel, then saves keys of notifications as aof of the channel
+func (ri *RumpInput) rump(wait usync.WaitCloser) error {
+	ri.fsm.Reset()
+	cfg := config.Get().Input.Rump
+	ri.limiter = newRumpLimiter(cfg.KeysPerSecond)
+
+	runId, err := newRumpRunId()
+	if err != nil {
+		return err
+	}
+	ri.setRunIds([]string{runId})
+
+	// subscribe before scanning, keys modified during the scan are fetched again
+	var events *rumpEvents
+	if cfg.KeyspaceNotify {
+		events, err = ri.subscribe(wait)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !rdbLimiterAcquire(wait.Done()) {
+		return wait.Error()
+	}
+	ri.fsm.SetState(SyncStateFullSyncing)
+	inputStateGauge.Set(1, ri.inputAddr)
+	rdbFile, rdbSize, err := ri.scan(wait)
+	if rdbFile != nil {
+		defer func() {
+			rdbFile.Close()
+			os.Remove(rdbFile.Name())
+		}()
+	}
+	if err != nil {
+		rdbLimiterRelease()
+		return err
+	}
+
+	// the rdb ends at offset, output starts from 1
+	offset := rdbSize + 1
+	err = ri.resetChannel(wait, runId)
+	if err == nil {
+		err = ri.saveRdb(wait, rdbFile, offset, rdbSize)
+	}
+	rdbLimiterRelease()
+	if err != nil {
+		return err
+	}
+	metricSyncType.Inc(ri.inputAddr, "full")
+	ri.logger.Infof("rump : runId(%s), rdb(%d)", runId, rdbSize)
+
+	piper, pipew := io.Pipe()
+	aofWriter, err := ri.channel.NewAofWritter(piper, offset)
+	if err != nil {
+		return err
+	}
+	ri.fsm.SetState(SyncStateIncrSyncing)
+	inputStateGauge.Set(2, ri.inputAddr)
+	aofWriter.Start()
+
+	wait.WgAdd(2)
+	usync.SafeGo(func() {
+		defer wait.WgDone()
+		err := aofWriter.Wait(wait.Context())
+		aofWriter.Close()
+		ri.fsm.SetState(SyncStateIncrSynced)
+		inputStateGauge.Set(0, ri.inputAddr)
+		wait.Close(err)
+	}, func(i interface{}) { wait.Close(fmt.Errorf("panic : %v", i)) })
+	usync.SafeGo(func() {
+		defer wait.WgDone()
+		err := ri.syncEvents(wait, events, pipew)
+		pipew.CloseWithError(err)
+		wait.Close(err)
+	}, func(i interface{}) { wait.Close(fmt.Errorf("panic : %v", i)) })
+	return nil
+}
+
+func (ri *RumpInput) saveRdb(wait usync.WaitCloser, reader io.Reader, offset int64, size int64) error {
+	writer, err := ri.channel.NewRdbWriter(reader, offset, size)
+	if err != nil {
+		return err
+	}
+	writer.Start()
+	err = writer.Wait(wait.Context())
+	writer.Close()
+	if err != nil {
+		ri.logger.Errorf("rdb writer error : err(%v)", err)
+		return err
+	}
+	if wait.IsClosed() {
+		return errors.Join(context.Canceled, wait.Error())
+	}
+	ri.fsm.SetState(SyncStateFullSynced)
+	return nil
+}
+
+func (ri *RumpInput) newConn() (client.Redis, error) {
+	cli, err := client.NewRedis(ri.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if ri.cfg.Otype == config.RedisTypeCluster {
+		// replicas of cluster redirect keys to masters without READONLY
+		if err = common.StringIsOk(cli.Do("READONLY")); err != nil {
+			cli.Close()
+			return nil, err
+		}
+	}
+	return cli, nil
+}
+
+func (ri *RumpInput) batchSize() int {
+	cfg := config.Get().Input.Rump
+	if cfg.KeysPerSecond > 0 && cfg.KeysPerSecond < cfg.ScanCount {
+		return cfg.KeysPerSecond
+	}
+	return cfg.ScanCount
+}
+
+type rumpBatch struct {
+	db   int64
+	keys []string
+}
+
+type rumpValue struct {
+	key  string
+	dump []byte // nil if the key doesn't exist
+	pttl int64
+}
+
+// scan saves keys of all dbs into a temporary rdb file
+func (ri *RumpInput) scan(wait usync.WaitCloser) (file *os.File, size int64, err error) {
+	cli, err := ri.newConn()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cli.Close()
+
+	dbs, err := ri.scanDbs(cli)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err = os.CreateTemp(ri.storerDir, "rump_*.rdb.tmp")
+	if err != nil {
+		return nil, 0, err
+	}
+	dw := rdb.NewDumpWriter(file)
+	if err = dw.Header(); err != nil {
+		return file, 0, err
+	}
+
+	scope := usync.NewWaitCloserFromParent(wait, nil)
+	defer scope.Close(nil)
+	var dwMux sync.Mutex
+	save := func(db int64, values []rumpValue) error {
+		dwMux.Lock()
+		defer dwMux.Unlock()
+		now := time.Now().UnixMilli()
+		for _, v := range values {
+			if v.dump == nil {
+				continue
+			}
+			var expireAt int64
+			if v.pttl >= 0 {
+				expireAt = now + v.pttl
+			}
+			if err := dw.Write(db, []byte(v.key), expireAt, v.dump); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	batches := make(chan rumpBatch, config.Get().Input.Rump.Parallel)
+	for i := 0; i < config.Get().Input.Rump.Parallel; i++ {
+		scope.WgAdd(1)
+		usync.SafeGo(func() {
+			defer scope.WgDone()
+			if err := ri.dumpKeys(scope, batches, save); err != nil {
+				scope.Close(err)
+			}
+		}, func(i interface{}) { scope.Close(fmt.Errorf("panic : %v", i)) })
+	}
+
+	err = ri.scanKeys(scope, cli, dbs, batches)
+	close(batches)
+	scope.WgWait()
+	if err == nil && scope.IsClosed() {
+		err = errors.Join(context.Canceled, scope.Error())
+	}
+	if err != nil {
+		return file, 0, err
+	}
+
+	if err = dw.Footer(); err != nil {
+		return file, 0, err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	return file, dw.Size(), err
+}
+
+func (ri *RumpInput) scanDbs(cli client.Redis) ([]int64, error) {
+	if ri.cfg.Otype == config.RedisTypeCluster {
+		return []int64{0}, nil
+	}
+	ret, err := common.Bytes(cli.Do("info", "keyspace"))
+	if err != nil {
+		return nil, err
+	}
+	mp, err := redis.ParseKeyspace(ret)
+	if err != nil {
+		return nil, err
+	}
+	dbs := make([]int64, 0, len(mp))
+	for db := range mp {
+		dbs = append(dbs, int64(db))
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i] < dbs[j] })
+	return dbs, nil
+}
+
+func (ri *RumpInput) scanKeys(wait usync.WaitCloser, cli client.Redis, dbs []int64, batches chan<- rumpBatch) error {
+	batchSize := ri.batchSize()
+	for _, db := range dbs {
+		if err := redis.SelectDB(cli, uint32(db)); err != nil {
+			return err
+		}
+		cursor := "0"
+		count := 0
+		for {
+			reply, err := common.Values(cli.Do("SCAN", cursor, "COUNT", config.Get().Input.Rump.ScanCount))
+			if err != nil {
+				return err
+			}
+			if len(reply) != 2 {
+				return fmt.Errorf("invalid reply of scan : %v", reply)
+			}
+			if cursor, err = common.String(reply[0], nil); err != nil {
+				return err
+			}
+			keys, err := common.Strings(reply[1], nil)
+			if err != nil {
+				return err
+			}
+			count += len(keys)
+			for len(keys) > 0 {
+				n := batchSize
+				if n > len(keys) {
+					n = len(keys)
+				}
+				select {
+				case batches <- rumpBatch{db: db, keys: keys[:n]}:
+				case <-wait.Done():
+					return nil
+				}
+				keys = keys[n:]
+			}
+			if cursor == "0" {
+				break
+			}
+		}
+		ri.logger.Infof("rump scan : db(%d), keys(%d)", db, count)
+	}
+	return nil
+}
+
+// dumpKeys fetches keys of batches until batches is closed
+func (ri *RumpInput) dumpKeys(wait usync.WaitCloser, batches <-chan rumpBatch, save func(int64, []rumpValue) error) error {
+	cli, err := ri.newConn()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	db := int64(-1)
+	for {
+		var batch rumpBatch
+		var ok bool
+		select {
+		case batch, ok = <-batches:
+			if !ok {
+				return nil
+			}
+		case <-wait.Done():
+			return nil
+		}
+		if batch.db != db {
+			if err = redis.SelectDB(cli, uint32(batch.db)); err != nil {
+				return err
+			}
+			db = batch.db
+		}
+		ri.limiter.wait(wait, len(batch.keys))
+		values, err := ri.fetch(cli, batch.keys)
+		if err != nil {
+			return err
+		}
+		if err = save(batch.db, values); err != nil {
+			return err
+		}
+		metricRumpKeys.Add(float64(len(values)), ri.inputAddr, "scan")
+	}
+}
+
+// fetch gets DUMP and PTTL of keys in a pipeline
+func (ri *RumpInput) fetch(cli client.Redis, keys []string) ([]rumpValue, error) {
+	for _, key := range keys {
+		if err := cli.Send("DUMP", key); err != nil {
+			return nil, err
+		}
+		if err := cli.Send("PTTL", key); err != nil {
+			return nil, err
+		}
+	}
+	if err := cli.Flush(); err != nil {
+		return nil, err
+	}
+
+	values := make([]rumpValue, 0, len(keys))
+	for _, key := range keys {
+		dump, err := common.Bytes(cli.Receive())
+		pttl, perr := common.Int64(cli.Receive())
+		if err == nil {
+			err = perr
+		}
+		var rerr proto.RedisError
+		if errors.As(err, &rerr) {
+			ri.logger.Warnf("fetch key error : key(%s), error(%v)", key, err)
+			continue
+		}
+		if err != nil && !errors.Is(err, common.ErrNil) {
+			return nil, err
+		}
+		if pttl == -2 {
+			dump = nil
+		}
+		values = append(values, rumpValue{key: key, dump: dump, pttl: pttl})
+	}
+	return values, nil
+}
+
+func newRumpRunId() (string, error) {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// rumpLimiter limits keys fetched per second
+type rumpLimiter struct {
+	mux    sync.Mutex
+	limit  int
+	window time.Time
+	count  int
+}
+
+func newRumpLimiter(limit int) *rumpLimiter {
+	return &rumpLimiter{limit: limit}
+}
+
+func (rl *rumpLimiter) wait(wait usync.WaitCloser, n int) {
+	if rl.limit <= 0 {
+		return
+	}
+	for !wait.IsClosed() {
+		rl.mux.Lock()
+		now := time.Now()
+		if now.Sub(rl.window) >= time.Second {
+			rl.window = now
+			rl.count = 0
+		}
+		if rl.count == 0 || rl.count+n <= rl.limit {
+			rl.count += n
+			rl.mux.Unlock()
+			return
+		}
+		d := time.Second - now.Sub(rl.window)
+		rl.mux.Unlock()
+		wait.Sleep(d)
+	}
+}
+
+// rumpEvents collects keys of keyspace notifications, keys are deduplicated until they are taken
+type rumpEvents struct {
+	mux    sync.Mutex
+	keys   map[int64]map[string]struct{}
+	notify chan struct{}
+}
+
+func newRumpEvents() *rumpEvents {
+	return &rumpEvents{
+		keys:   make(map[int64]map[string]struct{}),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (re *rumpEvents) add(db int64, key string) {
+	re.mux.Lock()
+	keys, ok := re.keys[db]
+	if !ok {
+		keys = make(map[string]struct{})
+		re.keys[db] = keys
+	}
+	keys[key] = struct{}{}
+	re.mux.Unlock()
+
+	select {
+	case re.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (re *rumpEvents) take() map[int64][]string {
+	re.mux.Lock()
+	taken := re.keys
+	re.keys = make(map[int64]map[string]struct{})
+	re.mux.Unlock()
+
+	ret := make(map[int64][]string, len(taken))
+	for db, keys := range taken {
+		for key := range keys {
+			ret[db] = append(ret[db], key)
+		}
+	}
+	return ret
+}
+
+// parseKeyEvent parses a message of __keyevent@<db>__:<event>, the payload is the key
+func parseKeyEvent(reply []interface{}) (int64, string, bool) {
+	if len(reply) != 4 {
+		return 0, "", false
+	}
+	if kind, _ := common.String(reply[0], nil); kind != "pmessage" {
+		return 0, "", false
+	}
+	channel, _ := common.String(reply[2], nil)
+	key, err := common.String(reply[3], nil)
+	if err != nil || !strings.HasPrefix(channel, "__keyevent@") {
+		return 0, "", false
+	}
+	channel = strings.TrimPrefix(channel, "__keyevent@")
+	pos := strings.Index(channel, "__:")
+	if pos < 0 {
+		return 0, "", false
+	}
+	db, err := strconv.ParseInt(channel[:pos], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return db, key, true
+}
+
+// subscribe subscribes keyevent notifications of all dbs, the session is closed if the subscription is broken
+func (ri *RumpInput) subscribe(wait usync.WaitCloser) (*rumpEvents, error) {
+	cli, err := ri.newConn()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := common.Strings(cli.Do("CONFIG", "GET", "notify-keyspace-events"))
+	if err != nil {
+		ri.logger.Warnf("get notify-keyspace-events : error(%v)", err)
+	} else if len(flags) == 2 && !strings.Contains(flags[1], "E") {
+		ri.logger.Warnf("keyevent notifications are disabled, notify-keyspace-events should be EA : flags(%s)", flags[1])
+	}
+	if _, err = cli.Do("PSUBSCRIBE", "__keyevent@*__:*"); err != nil {
+		cli.Close()
+		return nil, err
+	}
+
+	events := newRumpEvents()
+	wait.WgAdd(2)
+	usync.SafeGo(func() {
+		defer wait.WgDone()
+		<-wait.Done()
+		cli.Close()
+	}, nil)
+	usync.SafeGo(func() {
+		defer wait.WgDone()
+		for {
+			reply, err := common.Values(cli.Receive())
+			if err != nil {
+				if !wait.IsClosed() {
+					ri.logger.Errorf("keyspace notifications error, notifications are lost : error(%v)", err)
+				}
+				wait.Close(err)
+				return
+			}
+			if db, key, ok := parseKeyEvent(reply); ok {
+				events.add(db, key)
+			}
+		}
+	}, func(i interface{}) { wait.Close(fmt.Errorf("panic : %v", i)) })
+	return events, nil
+}
+
+// syncEvents writes keys of notifications into w as RESTORE or DEL, until the session is closed
+func (ri *RumpInput) syncEvents(wait usync.WaitCloser, events *rumpEvents, w io.Writer) error {
+	if events == nil {
+		<-wait.Done()
+		return nil
+	}
+
+	cli, err := ri.newConn()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	return ri.writeEvents(wait, cli, events, w, ri.batchSize())
+}
+
+// writeEvents fetches keys of notifications by cli in batches, and writes them into w
+func (ri *RumpInput) writeEvents(wait usync.WaitCloser, cli client.Redis, events *rumpEvents, w io.Writer, batchSize int) (err error) {
+	writer := proto.NewWriter(w, 64*1024)
+	cliDb, outDb := int64(-1), int64(-1)
+	for {
+		select {
+		case <-wait.Done():
+			return nil
+		case <-events.notify:
+		}
+
+		taken := events.take()
+		dbs := make([]int64, 0, len(taken))
+		for db := range taken {
+			dbs = append(dbs, db)
+		}
+		sort.Slice(dbs, func(i, j int) bool { return dbs[i] < dbs[j] })
+
+		for _, db := range dbs {
+			if db != cliDb {
+				if err = redis.SelectDB(cli, uint32(db)); err != nil {
+					return err
+				}
+				cliDb = db
+			}
+			keys := taken[db]
+			for len(keys) > 0 {
+				n := batchSize
+				if n > len(keys) {
+					n = len(keys)
+				}
+				ri.limiter.wait(wait, n)
+				values, err := ri.fetch(cli, keys[:n])
+				if err != nil {
+					return err
+				}
+				keys = keys[n:]
+
+				if db != outDb {
+					if err = writer.WriteArgs([]interface{}{"SELECT", db}); err != nil {
+						return err
+					}
+					outDb = db
+				}
+				for _, v := range values {
+					if v.dump == nil {
+						err = writer.WriteArgs([]interface{}{"DEL", v.key})
+					} else {
+						var ttl int64 // 0 is no expiration
+						if v.pttl > 0 {
+							ttl = v.pttl
+						} else if v.pttl == 0 {
+							ttl = 1
+						}
+						err = writer.WriteArgs([]interface{}{"RESTORE", v.key, ttl, v.dump, "REPLACE"})
+					}
+					if err != nil {
+						return err
+					}
+				}
+				if err = writer.Flush(); err != nil {
+					return err
+				}
+				metricRumpKeys.Add(float64(len(values)), ri.inputAddr, "notify")
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package syncer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/proto"
+	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
+)
+
+func newTestRumpInput() *RumpInput {
+	ri := NewRumpInput(config.RedisConfig{Addresses: []string{"127.0.0.1:6379"}}, "")
+	ri.limiter = newRumpLimiter(0)
+	return ri
+}
+
+func TestParseKeyEvent(t *testing.T) {
+	cases := []struct {
+		reply []interface{}
+		db    int64
+		key   string
+		ok    bool
+	}{
+		{[]interface{}{"pmessage", "__keyevent@*__:*", "__keyevent@0__:set", "k"}, 0, "k", true},
+		{[]interface{}{[]byte("pmessage"), []byte("__keyevent@*__:*"), []byte("__keyevent@15__:del"), []byte("a:b")}, 15, "a:b", true},
+		{[]interface{}{"psubscribe", "__keyevent@*__:*", int64(1)}, 0, "", false},
+		{[]interface{}{"message", "__keyevent@*__:*", "__keyevent@0__:set", "k"}, 0, "", false},
+		{[]interface{}{"pmessage", "__keyspace@*__:*", "__keyspace@0__:k", "set"}, 0, "", false},
+		{[]interface{}{"pmessage", "__keyevent@*__:*", "__keyevent@x__:set", "k"}, 0, "", false},
+		{[]interface{}{"pmessage", "__keyevent@*__:*", "__keyevent@0", "k"}, 0, "", false},
+		{[]interface{}{"pmessage", "__keyevent@*__:*", "__keyevent@0__:set", nil}, 0, "", false},
+	}
+	for i, c := range cases {
+		db, key, ok := parseKeyEvent(c.reply)
+		assert.Equal(t, c.ok, ok, i)
+		assert.Equal(t, c.db, db, i)
+		assert.Equal(t, c.key, key, i)
+	}
+}
+
+func TestRumpLimiter(t *testing.T) {
+	wait := usync.NewWaitCloser(nil)
+
+	rl := newRumpLimiter(0)
+	rl.wait(wait, 1000) // unlimited
+
+	rl = newRumpLimiter(10)
+	start := time.Now()
+	rl.wait(wait, 6)
+	rl.wait(wait, 4)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	rl.wait(wait, 1) // the next window
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+
+	// a batch bigger than the limit takes a whole window
+	rl = newRumpLimiter(10)
+	start = time.Now()
+	rl.wait(wait, 20)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	wait.Close(nil)
+	start = time.Now()
+	rl.wait(wait, 1)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestRumpEvents(t *testing.T) {
+	events := newRumpEvents()
+	events.add(0, "a")
+	events.add(0, "a")
+	events.add(1, "b")
+	<-events.notify
+	select {
+	case <-events.notify:
+		t.Fatal("notifications are not merged")
+	default:
+	}
+	taken := events.take()
+	assert.Equal(t, map[int64][]string{0: {"a"}, 1: {"b"}}, taken)
+	assert.Empty(t, events.take())
+}
+
+// readCommands parses commands written by writeEvents
+func readCommands(t *testing.T, data []byte) []string {
+	r := proto.NewReader(bytes.NewReader(data), 1024)
+	cmds := []string{}
+	for {
+		reply, err := r.ReadReply()
+		if errors.Is(err, io.EOF) {
+			return cmds
+		}
+		assert.Nil(t, err)
+		cmds = append(cmds, fmt.Sprint(reply))
+	}
+}
+
+func TestRumpWriteEvents(t *testing.T) {
+	ri := newTestRumpInput()
+	fr := newFakeRedis(func(cmd string, args []interface{}) interface{} {
+		key := fmt.Sprintf("%v", args[0])
+		switch cmd {
+		case "select":
+			return "OK"
+		case "DUMP":
+			switch key {
+			case "deleted":
+				return nil
+			case "error":
+				return proto.RedisError("ERR dump")
+			}
+			return []byte("payload-" + key)
+		case "PTTL":
+			switch key {
+			case "deleted":
+				return int64(-2)
+			case "volatile":
+				return int64(500)
+			case "expiring":
+				return int64(0)
+			}
+			return int64(-1)
+		}
+		return errors.New("ERR unknown command")
+	})
+
+	events := newRumpEvents()
+	for _, key := range []string{"persistent", "volatile", "expiring", "deleted", "error"} {
+		events.add(0, key)
+	}
+	events.add(3, "other")
+
+	wait := usync.NewWaitCloser(nil)
+	buf := &bytes.Buffer{}
+	done := make(chan error, 1)
+	go func() { done <- ri.writeEvents(wait, fr, events, buf, 2) }()
+	assert.Eventually(t, func() bool {
+		dumps := 0
+		for _, cmd := range fr.executed() {
+			if cmd[0] == "PTTL" {
+				dumps++
+			}
+		}
+		return dumps == 6
+	}, time.Second, time.Millisecond)
+	wait.Close(nil)
+	assert.Nil(t, <-done)
+
+	cmds := readCommands(t, buf.Bytes())
+	assert.Equal(t, "[SELECT 0]", cmds[0])
+	assert.Equal(t, "[SELECT 3]", cmds[len(cmds)-1-1])
+	assert.Equal(t, "[RESTORE other 0 payload-other REPLACE]", cmds[len(cmds)-1])
+	db0 := cmds[1 : len(cmds)-2]
+	sort.Strings(db0)
+	assert.Equal(t, []string{
+		"[DEL deleted]",
+		"[RESTORE expiring 1 payload-expiring REPLACE]",
+		"[RESTORE persistent 0 payload-persistent REPLACE]",
+		"[RESTORE volatile 500 payload-volatile REPLACE]",
+	}, db0) // the key of error reply is skipped
+}
+
+func TestRumpWaitRescan(t *testing.T) {
+	defer func(d time.Duration) { rumpRescanInterval = d }(rumpRescanInterval)
+	rumpRescanInterval = 200 * time.Millisecond
+
+	ri := newTestRumpInput()
+	ri.scannedAt = time.Now()
+	start := time.Now()
+	ri.waitRescan(errors.New("subscription is broken"))
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	// the last scan is old enough
+	ri.scannedAt = time.Now().Add(-time.Second)
+	start = time.Now()
+	ri.waitRescan(nil)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	ri.scannedAt = time.Now()
+	ri.wait.Close(nil)
+	start = time.Now()
+	ri.waitRescan(nil)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
@@ -0,0 +1,238 @@
+package syncer
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/proto"
+)
+
+/*
+scripts and functions :
+	bodies of scripts are tracked from "script load" and "eval" of aof, and the "lua" aux field of rdb,
+	they are kept in memory, and cleared by "script flush".
+	"evalsha" of a tracked script is rewritten to "eval", so output never replies NOSCRIPT for it,
+	even if output is restarted, or the command is routed to another node of cluster.
+	scripts and functions are not routed by keys, "script load|flush" and "function load|delete|flush|restore"
+	are executed on all nodes of output cluster, after the pending commands are replied
+*/
+
+var (
+	noscriptCounter = metric.NewCounterVec(metric.CounterVecOpts{
+		Namespace: config.AppName,
+		Subsystem: "output",
+		Name:      "noscript",
+		Labels:    []string{"input"},
+	})
+)
+
+const maxScriptCacheSize = 64 * 1024 * 1024 // bytes of script bodies
+
+// ScriptCache keeps bodies of scripts seen in the input, it's owned by the syncer,
+// so scripts of the full sync are kept after the output is restarted.
+// it's bounded by the size of bodies, the least recently used scripts are evicted
+type ScriptCache struct {
+	mux     sync.Mutex
+	scripts map[string]*list.Element // sha1 -> element of cachedScript
+	lru     *list.List               // the front is the most recently used
+	size    int
+	maxSize int
+}
+
+type cachedScript struct {
+	sha  string
+	body []byte
+}
+
+func NewScriptCache() *ScriptCache {
+	return newScriptCache(maxScriptCacheSize)
+}
+
+func newScriptCache(maxSize int) *ScriptCache {
+	return &ScriptCache{scripts: make(map[string]*list.Element), lru: list.New(), maxSize: maxSize}
+}
+
+// add tracks the script and returns its sha1
+func (sc *ScriptCache) add(body []byte) string {
+	sum := sha1.Sum(body)
+	sha := hex.EncodeToString(sum[:])
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	if e, ok := sc.scripts[sha]; ok {
+		sc.lru.MoveToFront(e)
+		return sha
+	}
+	sc.scripts[sha] = sc.lru.PushFront(&cachedScript{sha: sha, body: bytes.Clone(body)})
+	sc.size += len(body)
+	for sc.size > sc.maxSize && sc.lru.Len() > 1 {
+		cs := sc.lru.Remove(sc.lru.Back()).(*cachedScript)
+		delete(sc.scripts, cs.sha)
+		sc.size -= len(cs.body)
+	}
+	return sha
+}
+
+func (sc *ScriptCache) get(sha string) ([]byte, bool) {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	e, ok := sc.scripts[strings.ToLower(sha)]
+	if !ok {
+		return nil, false
+	}
+	sc.lru.MoveToFront(e)
+	return e.Value.(*cachedScript).body, true
+}
+
+// flush removes all scripts, like script flush of input
+func (sc *ScriptCache) flush() {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	sc.scripts = make(map[string]*list.Element)
+	sc.lru.Init()
+	sc.size = 0
+}
+
+// scriptReplayer keeps scripts and functions of output in sync with input
+type scriptReplayer struct {
+	ro      *RedisOutput
+	cache   *ScriptCache
+	unknown map[string]struct{} // evalsha of untracked scripts, logged once
+}
+
+func newScriptReplayer(ro *RedisOutput, cache *ScriptCache) *scriptReplayer {
+	return &scriptReplayer{ro: ro, cache: cache, unknown: make(map[string]struct{})}
+}
+
+// replay returns the command to be sent, commands of scripts and functions are marked to be executed on all nodes
+func (sr *scriptReplayer) replay(ce cmdExecution) cmdExecution {
+	if len(ce.Args) == 0 {
+		return ce
+	}
+	arg0, _ := ce.Args[0].([]byte)
+	switch ce.Cmd {
+	case "eval", "eval_ro":
+		sr.cache.add(arg0)
+	case "evalsha", "evalsha_ro":
+		body, ok := sr.cache.get(string(arg0))
+		if !ok {
+			if _, logged := sr.unknown[string(arg0)]; !logged {
+				sr.unknown[string(arg0)] = struct{}{}
+				sr.ro.logger.Warnf("script is not tracked, evalsha is replayed as is : sha(%s)", arg0)
+			}
+			return ce
+		}
+		args := make([]interface{}, 0, len(ce.Args))
+		args = append(args, body)
+		args = append(args, ce.Args[1:]...)
+		if ce.Cmd == "evalsha" {
+			ce.Cmd = "eval"
+		} else {
+			ce.Cmd = "eval_ro"
+		}
+		ce.Args = args
+	case "script":
+		switch sub := strings.ToLower(string(arg0)); sub {
+		case "load":
+			if len(ce.Args) > 1 {
+				body, _ := ce.Args[1].([]byte)
+				sr.cache.add(body)
+			}
+			ce.allNodes = sr.ro.cfg.Redis.IsCluster()
+		case "flush":
+			sr.cache.flush()
+			ce.allNodes = sr.ro.cfg.Redis.IsCluster()
+		}
+	case "function":
+		switch sub := strings.ToLower(string(arg0)); sub {
+		case "load", "delete", "flush", "restore":
+			if sub == "load" && sr.ro.outputCfg().FunctionExists != "append" {
+				ce = functionLoadReplace(ce)
+			}
+			ce.allNodes = sr.ro.cfg.Redis.IsCluster()
+		}
+	}
+	return ce
+}
+
+// functionLoadReplace replaces the existing library, so "function load" is idempotent after resuming
+func functionLoadReplace(ce cmdExecution) cmdExecution {
+	if len(ce.Args) < 2 {
+		return ce
+	}
+	for _, arg := range ce.Args[1 : len(ce.Args)-1] {
+		if strings.EqualFold(string(arg.([]byte)), "replace") {
+			return ce
+		}
+	}
+	args := make([]interface{}, 0, len(ce.Args)+1)
+	args = append(args, ce.Args[0], []byte("REPLACE"))
+	args = append(args, ce.Args[1:]...)
+	ce.Args = args
+	return ce
+}
+
+// doOnAllNodes executes the command on all nodes of output cluster,
+// error replies are ignored like other commands, e.g. the library exists after resuming
+func (ro *RedisOutput) doOnAllNodes(conn client.Redis, ce cmdExecution) error {
+	err := client.DoOnAllNodes(conn, ce.Cmd, ce.Args...)
+	if err == nil {
+		return nil
+	}
+	if isErrorReply(err) {
+		ro.logger.Warnf("execute on all nodes : cmd(%s %s), err(%v)", ce.Cmd, ce.Args[0], err)
+		return nil
+	}
+	ro.logger.Errorf("execute on all nodes error : cmd(%s %s), err(%v)", ce.Cmd, ce.Args[0], err)
+	return err
+}
+
+// isErrorReply returns true if all errors are error replies of redis, errors of nodes are joined by DoOnAllNodes
+func isErrorReply(err error) bool {
+	if err == nil {
+		return false
+	}
+	if je, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := je.Unwrap()
+		for _, e := range errs {
+			if !isErrorReply(e) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+	var rerr common.RedisError
+	var perr proto.RedisError
+	return errors.As(err, &rerr) || errors.As(err, &perr)
+}
+
+// countNoScript counts NOSCRIPT replies or errors, they are replies of evalsha whose scripts are not tracked
+func (ro *RedisOutput) countNoScript(replies ...interface{}) {
+	n := 0
+	var walk func(replies []interface{})
+	walk = func(replies []interface{}) {
+		for _, rpl := range replies {
+			switch tt := rpl.(type) {
+			case []interface{}:
+				walk(tt)
+			case error:
+				if strings.HasPrefix(tt.Error(), "NOSCRIPT") {
+					n++
+				}
+			}
+		}
+	}
+	walk(replies)
+	if n > 0 {
+		noscriptCounter.Add(float64(n), ro.cfg.InputName)
+		ro.logger.Errorf("output replies NOSCRIPT, scripts are not seen in rdb or aof of input : count(%d)", n)
+	}
+}
@@ -0,0 +1,132 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mgtv-tech/redis-GunYu/config"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/common"
+	"github.com/mgtv-tech/redis-GunYu/pkg/redis/client/proto"
+)
+
+func TestScriptCache(t *testing.T) {
+	sc := newScriptCache(10)
+	sha1 := sc.add([]byte("return 1"))
+	assert.Equal(t, "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", sha1)
+	body, ok := sc.get(strings.ToUpper(sha1))
+	assert.True(t, ok)
+	assert.Equal(t, "return 1", string(body))
+
+	// the least recently used script is evicted
+	sha2 := sc.add([]byte("return 2"))
+	_, ok = sc.get(sha2)
+	assert.True(t, ok)
+	_, ok = sc.get(sha1)
+	assert.False(t, ok)
+	assert.Equal(t, 8, sc.size)
+
+	// a script larger than the limit is kept until another one is added
+	sha3 := sc.add([]byte("return 'large'"))
+	_, ok = sc.get(sha3)
+	assert.True(t, ok)
+	_, ok = sc.get(sha2)
+	assert.False(t, ok)
+
+	sc.flush()
+	_, ok = sc.get(sha3)
+	assert.False(t, ok)
+	assert.Equal(t, 0, sc.size)
+}
+
+func bytesCmd(cmd string, args ...string) cmdExecution {
+	ce := cmdExecution{Cmd: cmd}
+	for _, arg := range args {
+		ce.Args = append(ce.Args, []byte(arg))
+	}
+	return ce
+}
+
+func TestScriptReplayer(t *testing.T) {
+	ro := newTestOutput(&config.OutputConfig{Redis: &config.RedisConfig{Type: config.RedisTypeCluster}})
+	sr := newScriptReplayer(ro, NewScriptCache())
+	sha := sr.cache.add([]byte("return 1"))
+
+	tests := []struct {
+		ce       cmdExecution
+		cmd      []string
+		allNodes bool
+	}{
+		{bytesCmd("set", "k", "v"), []string{"set k v"}, false},
+		{bytesCmd("evalsha", sha, "0"), []string{"eval return 1 0"}, false},
+		{bytesCmd("evalsha_ro", strings.ToUpper(sha), "1", "k"), []string{"eval_ro return 1 1 k"}, false},
+		{bytesCmd("evalsha", "unknown", "0"), []string{"evalsha unknown 0"}, false},
+		{bytesCmd("eval", "return 2", "0"), []string{"eval return 2 0"}, false},
+		{bytesCmd("evalsha", "7f923f79fe76194c868d7e1d0820de36700eb649", "0"), []string{"eval return 2 0"}, false},
+		{bytesCmd("script", "load", "return 3"), []string{"script load return 3"}, true},
+		{bytesCmd("script", "exists", sha), []string{"script exists " + sha}, false},
+		{bytesCmd("function", "load", "#!lua name=lib"), []string{"function load REPLACE #!lua name=lib"}, true},
+		{bytesCmd("function", "delete", "lib"), []string{"function delete lib"}, true},
+		{bytesCmd("fcall", "fn", "0"), []string{"fcall fn 0"}, false},
+		{bytesCmd("script", "flush"), []string{"script flush"}, true},
+		{bytesCmd("evalsha", sha, "0"), []string{"evalsha " + sha + " 0"}, false},
+	}
+	for _, tt := range tests {
+		ce := sr.replay(tt.ce)
+		assert.Equal(t, tt.cmd, cmdStrings([]cmdExecution{ce}), tt.cmd)
+		assert.Equal(t, tt.allNodes, ce.allNodes, tt.cmd)
+	}
+
+	// standalone output executes them as other commands
+	ro = newTestOutput(&config.OutputConfig{Redis: &config.RedisConfig{Type: config.RedisTypeStandalone}})
+	sr = newScriptReplayer(ro, NewScriptCache())
+	assert.False(t, sr.replay(bytesCmd("script", "flush")).allNodes)
+}
+
+func TestFunctionLoadReplace(t *testing.T) {
+	ce := functionLoadReplace(bytesCmd("function", "load", "replace", "#!lua name=lib"))
+	assert.Equal(t, []string{"function load replace #!lua name=lib"}, cmdStrings([]cmdExecution{ce}))
+	ce = functionLoadReplace(bytesCmd("function", "load", "#!lua name=lib"))
+	assert.Equal(t, []string{"function load REPLACE #!lua name=lib"}, cmdStrings([]cmdExecution{ce}))
+}
+
+func TestIsErrorReply(t *testing.T) {
+	replyErr := fmt.Errorf("node(a) : %w", common.RedisError("ERR Library 'lib' already exists"))
+	connReplyErr := fmt.Errorf("node(b) : %w", proto.RedisError("ERR Library 'lib' already exists"))
+	netErr := fmt.Errorf("node(c) : %w", errors.New("connection reset by peer"))
+
+	assert.False(t, isErrorReply(nil))
+	assert.True(t, isErrorReply(replyErr))
+	assert.True(t, isErrorReply(errors.Join(replyErr, connReplyErr)))
+	assert.False(t, isErrorReply(errors.Join(replyErr, netErr)))
+	assert.False(t, isErrorReply(errors.Join(netErr, replyErr)))
+}
+
+func TestDoOnAllNodes(t *testing.T) {
+	ro := newTestOutput(&config.OutputConfig{Redis: &config.RedisConfig{Type: config.RedisTypeCluster}})
+	calls := 0
+	cli := newFakeRedis(func(cmd string, args []interface{}) interface{} {
+		calls++
+		if calls == 1 {
+			return common.RedisError("ERR Library 'lib' already exists")
+		}
+		return "OK"
+	})
+	cli.typ = config.RedisTypeCluster
+	assert.Nil(t, ro.doOnAllNodes(cli, bytesCmd("function", "load", "#!lua name=lib")))
+	assert.Equal(t, 2, len(cli.executed()))
+
+	// an error of connection on one node fails the command
+	calls = 0
+	cli.handler = func(cmd string, args []interface{}) interface{} {
+		calls++
+		if calls == 1 {
+			return common.RedisError("ERR Library 'lib' already exists")
+		}
+		return errors.New("EOF")
+	}
+	assert.NotNil(t, ro.doOnAllNodes(cli, bytesCmd("function", "load", "#!lua name=lib")))
+}
@@ -3,6 +3,7 @@ package syncer
 import (
 	"sync"
 
+	"github.com/mgtv-tech/redis-GunYu/pkg/journal"
 	usync "github.com/mgtv-tech/redis-GunYu/pkg/sync"
 )
 
@@ -17,6 +18,24 @@ const (
 	SyncStateIncrSynced  SyncState = iota
 )
 
+func (ss SyncState) String() string {
+	switch ss {
+	case SyncStateStarted:
+		return "started"
+	case SyncStateFullInit:
+		return "full_init"
+	case SyncStateFullSyncing:
+		return "full_syncing"
+	case SyncStateFullSynced:
+		return "full_synced"
+	case SyncStateIncrSyncing:
+		return "incr_syncing"
+	case SyncStateIncrSynced:
+		return "incr_synced"
+	}
+	return "unknown"
+}
+
 type SyncStateObserver interface {
 	Update(SyncState)
 }
@@ -70,6 +89,9 @@ func (sm *SyncFiniteStateMachine) Reset() {
 		sm.notifiers[i] = usync.NewWaitCloser(nil)
 	}
 	sm.state = SyncStateStarted
+	for _, ob := range sm.observers {
+		ob.Update(sm.state)
+	}
 }
 
 func (sm *SyncFiniteStateMachine) State() SyncState {
@@ -83,3 +105,23 @@ func (sm *SyncFiniteStateMachine) StateNotify(state SyncState) usync.WaitChannel
 	defer sm.locker.RUnlock()
 	return sm.notifiers[state].Done()
 }
+
+// stateJournal records changes of the sync state to the journal
+type stateJournal struct {
+	input string
+	state SyncState
+}
+
+func (sj *stateJournal) Update(state SyncState) {
+	if state == sj.state {
+		return
+	}
+	journal.Record(journal.Event{
+		Input: sj.input,
+		Actor: journal.ActorInput,
+		Type:  journal.TypeSyncState,
+		From:  sj.state.String(),
+		To:    state.String(),
+	})
+	sj.state = state
+}
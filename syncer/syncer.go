@@ -12,6 +12,7 @@ import (
 	"github.com/mgtv-tech/redis-GunYu/config"
 	pb "github.com/mgtv-tech/redis-GunYu/pkg/api/golang"
 	"github.com/mgtv-tech/redis-GunYu/pkg/cluster"
+	"github.com/mgtv-tech/redis-GunYu/pkg/journal"
 	"github.com/mgtv-tech/redis-GunYu/pkg/log"
 	"github.com/mgtv-tech/redis-GunYu/pkg/metric"
 	"github.com/mgtv-tech/redis-GunYu/pkg/redis"
@@ -71,6 +72,11 @@ type Syncer interface {
 	State() SyncerState
 	Role() SyncerRole
 	TransactionMode() bool
+	Config() SyncerConfig
+	Restart(cfg SyncerConfig)
+	Delay() *DelayControl
+	Guard() *GuardControl
+	Lag() *SyncLag
 }
 
 var (
@@ -88,13 +94,19 @@ func NewSyncer(cfg SyncerConfig) Syncer {
 		logger: log.WithLogger(config.LogModuleName(fmt.Sprintf("[syncer(%s)] ", cfg.Input.Address()))),
 	}
 	sy.channel = NewStoreChannel(StorerConf{
-		InputId: cfg.Input.Address(),
-		Dir:     cfg.Channel.Storer.DirPath,
-		MaxSize: cfg.Channel.Storer.MaxSize,
-		LogSize: cfg.Channel.Storer.LogSize,
-		flush:   cfg.Channel.Storer.Flush,
+		InputId:   cfg.Input.Address(),
+		Dir:       cfg.Channel.Storer.DirPath,
+		MaxSize:   cfg.Channel.Storer.MaxSize,
+		LogSize:   cfg.Channel.Storer.LogSize,
+		flush:     cfg.Channel.Storer.Flush,
+		verifyCrc: cfg.Channel.VerifyCrc,
 	})
 	sy.wait = usync.NewWaitCloser(nil)
+	sy.delay = NewDelayControl()
+	sy.cmdGuard = NewGuardControl()
+	sy.cmdGuard.setPauser(func() { usync.SafeGo(sy.pauseByGuard, nil) }, sy.resumeByGuard)
+	sy.lag = NewSyncLag(cfg.Input.Address())
+	sy.scripts = NewScriptCache()
 	return sy
 }
 
@@ -109,8 +121,14 @@ type syncer struct {
 	leader    *ReplicaLeader
 	slaveOf   *cluster.RoleInfo
 	state     SyncerState
+	recorded  SyncerState // the latest state in the journal
 	role      SyncerRole
 	pauseWait usync.WaitNotifier
+	restart   *SyncerConfig // pending configuration to restart
+	delay     *DelayControl
+	cmdGuard  *GuardControl
+	lag       *SyncLag
+	scripts   *ScriptCache // scripts seen in the input, evalsha is rewritten to eval
 }
 
 type SyncerState int
@@ -153,9 +171,33 @@ func (sr SyncerRole) String() string {
 }
 
 func (s *syncer) TransactionMode() bool {
+	s.guard.RLock()
+	defer s.guard.RUnlock()
 	return s.cfg.CanTransaction
 }
 
+func (s *syncer) Config() SyncerConfig {
+	s.guard.RLock()
+	defer s.guard.RUnlock()
+	return s.cfg
+}
+
+// Restart reruns the leader or follower with the new configuration, the channel is reserved
+func (s *syncer) Restart(cfg SyncerConfig) {
+	s.guard.Lock()
+	s.restart = &cfg
+	if s.state != SyncerStateRun && s.state != SyncerStateReadyRun {
+		// it's applied when syncer runs again
+		s.guard.Unlock()
+		return
+	}
+	wait := s.wait
+	s.guard.Unlock()
+
+	s.logger.Infof("restart syncer")
+	wait.Close(nil)
+}
+
 func (s *syncer) State() SyncerState {
 	return s.getState()
 }
@@ -255,10 +297,53 @@ func (s *syncer) Resume() {
 	s.guard.Lock()
 	defer s.guard.Unlock()
 	s.state = SyncerStateReadyRun
+	if s.pauseWait != nil { // it may be resumed by guard
+		close(s.pauseWait)
+		s.pauseWait = nil
+	}
+}
+
+// pauseByGuard pauses the syncer when the guard is tripped, it's resumed when the window is decided
+func (s *syncer) pauseByGuard() {
+	state := s.getState()
+	if state != SyncerStateRun && state != SyncerStateReadyRun {
+		return
+	}
+	s.logger.Warnf("syncer is paused by guard")
+	s.Pause()
+	// the window may be decided before it's paused
+	if w := s.cmdGuard.Window(); w == nil || w.Decision != GuardPending {
+		s.resumeByGuard()
+	}
+}
+
+func (s *syncer) resumeByGuard() {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if s.state != SyncerStatePause || s.pauseWait == nil {
+		return
+	}
+	s.logger.Infof("syncer is resumed by guard")
+	s.state = SyncerStateReadyRun
 	close(s.pauseWait)
 	s.pauseWait = nil
 }
 
+// Delay returns the control of delayed replay, it works if output.delay is configured
+func (s *syncer) Delay() *DelayControl {
+	return s.delay
+}
+
+// Guard returns the control of dangerous command guard, it works if output.guard is configured
+func (s *syncer) Guard() *GuardControl {
+	return s.cmdGuard
+}
+
+// Lag returns the lag of the input, it's updated by the leader
+func (s *syncer) Lag() *SyncLag {
+	return s.lag
+}
+
 func (s *syncer) DelRunId() {
 	s.guard.RLock()
 	input := s.input
@@ -279,14 +364,43 @@ func (s *syncer) updateStateMetric() {
 			syncerStateGauge.Set(0, s.cfg.Input.Address(), i.String())
 		}
 	}
+	s.recordState(state)
+}
+
+// recordState records the state to the journal if it's changed, the error of the syncer is the reason
+func (s *syncer) recordState(state SyncerState) {
+	s.guard.Lock()
+	prev := s.recorded
+	s.recorded = state
+	wait := s.wait
+	s.guard.Unlock()
+	if prev == state {
+		return
+	}
+	ev := journal.Event{
+		Input: s.cfg.Input.Address(),
+		Actor: journal.ActorSyncer,
+		Type:  journal.TypeSyncerState,
+		From:  prev.String(),
+		To:    state.String(),
+	}
+	if err := wait.Error(); err != nil && state == SyncerStateStop {
+		ev.Reason = err.Error()
+	}
+	journal.Record(ev)
 }
 
 func (s *syncer) run() error {
+	if server := s.startPsyncServer(); server != nil {
+		defer server.Stop()
+	}
+
 	for {
 		state := s.getState()
 		s.updateStateMetric()
 		switch state {
 		case SyncerStateReadyRun, SyncerStateRun:
+			s.applyRestartConfig()
 			role := s.getRole()
 			var err error
 			if role == SyncerRoleLeader {
@@ -294,6 +408,9 @@ func (s *syncer) run() error {
 			} else if role == SyncerRoleFollower {
 				err = s.runFollower()
 			}
+			if s.shouldRestart() {
+				continue
+			}
 			if err != nil {
 				//s.logger.Errorf("run error : %v", err)
 				s.guard.Lock()
@@ -324,6 +441,50 @@ func (s *syncer) run() error {
 	}
 }
 
+// startPsyncServer serves the channel to redis replicas if server.psync is configured, it returns nil if it's not started
+func (s *syncer) startPsyncServer() *PsyncServer {
+	psyncCfg := config.Get().Server.Psync
+	if psyncCfg == nil {
+		return nil
+	}
+	password, err := psyncCfg.Password.Value()
+	if err != nil {
+		s.logger.Errorf("psync server password error : %v", err)
+		return nil
+	}
+	server := NewPsyncServer(s.cfg.Input.Address(), psyncCfg.Address(s.cfg.Id), password, s.channel)
+	if err = server.Start(); err != nil {
+		s.logger.Errorf("start psync server error : addr(%s), err(%v)", psyncCfg.Address(s.cfg.Id), err)
+		return nil
+	}
+	return server
+}
+
+// applyRestartConfig applies the pending configuration before running leader or follower
+func (s *syncer) applyRestartConfig() {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if s.restart == nil {
+		return
+	}
+	s.cfg.Input = s.restart.Input
+	s.cfg.Output = s.restart.Output
+	s.cfg.CanTransaction = s.restart.CanTransaction
+	s.restart = nil
+}
+
+// shouldRestart returns true if there is a pending configuration and syncer is not paused or stopped
+func (s *syncer) shouldRestart() bool {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if s.restart == nil || (s.state != SyncerStateRun && s.state != SyncerStateReadyRun) {
+		return false
+	}
+	s.state = SyncerStateReadyRun
+	s.wait = usync.NewWaitCloser(nil)
+	return true
+}
+
 func (s *syncer) runLeader() error {
 	output, err := s.newOutput()
 	if err != nil {
@@ -331,9 +492,19 @@ func (s *syncer) runLeader() error {
 	}
 
 	s.guard.Lock()
-	input := NewRedisInput(s.cfg.Input)
+	var input Input
+	switch config.Get().Input.SyncType {
+	case config.TypeRump:
+		input = NewRumpInput(s.cfg.Input, s.cfg.Channel.Storer.DirPath)
+	case config.TypeFile:
+		input = NewFileInput(s.cfg.Input, *config.Get().Input.File)
+	default:
+		input = NewRedisInput(s.cfg.Input)
+	}
+	s.lag.reset(s.cfg.Input, s.cfg.Output)
 	input.SetOutput(output)
 	input.SetChannel(s.channel)
+	input.SetLag(s.lag)
 	leader := NewReplicaLeader(input, s.channel)
 	s.input = input
 	s.leader = leader
@@ -409,15 +580,34 @@ func (s *syncer) newOutput() (*RedisOutput, error) {
 		return nil, errors.Join(ErrRestart, err)
 	}
 
+	inputAddrs := s.cfg.Input.ShardAddresses()
+	settings := config.Get().InputSettings(inputAddrs...)
 	outputCfg := RedisOutputConfig{
 		InputName:                  s.cfg.Input.Address(),
+		Input:                      s.cfg.Input,
+		InputAddresses:             inputAddrs,
 		Redis:                      s.cfg.Output,
-		Parallel:                   config.Get().Output.ReplayRdbParallel,
-		EnableResumeFromBreakPoint: *config.Get().Output.ResumeFromBreakPoint,
+		Parallel:                   settings.Output.ReplayRdbParallel,
+		EnableResumeFromBreakPoint: *settings.Output.ResumeFromBreakPoint,
 		RunId:                      id1,
 		CanTransaction:             s.cfg.CanTransaction,
+		CheckpointRedis:            settings.Output.CheckpointRedis,
+		CheckpointStore:            settings.Output.ExternalCheckpointStore(),
+		Bidirectional:              settings.Output.Bidirectional,
+		Delay:                      s.delay,
+		Guard:                      s.cmdGuard,
+		Lag:                        s.lag,
+		Scripts:                    s.scripts,
+	}
+	if outputCfg.Bidirectional != nil {
+		outputCfg.OriginKey = originKey(s.cfg.Output, s.cfg.CanTransaction)
+		if len(outputCfg.OriginKey) == 0 {
+			err = fmt.Errorf("origin key is empty : prefix(%s), redis(%s)", originKeyPrefix, s.cfg.Output.Address())
+			s.logger.Errorf("%s", err.Error())
+			return nil, errors.Join(ErrQuit, err)
+		}
 	}
-	if *config.Get().Output.ResumeFromBreakPoint {
+	if *settings.Output.ResumeFromBreakPoint {
 		var localCheckpoint string
 		if s.cfg.CanTransaction && s.cfg.Output.IsCluster() {
 			localCheckpoint = choseKeyInSlots(config.CheckpointKey, s.cfg.Output.GetAllSlots())
@@ -430,7 +620,7 @@ func (s *syncer) newOutput() (*RedisOutput, error) {
 			return nil, errors.Join(ErrQuit, err)
 		}
 		// update checkpoint name and run id,
-		err = s.updateCheckpoint(wait, localCheckpoint, []string{id1, id2})
+		err = s.updateCheckpoint(wait, outputCfg.CheckpointStore, outputCfg.CheckpointRedis, localCheckpoint, []string{id1, id2})
 		if err != nil {
 			return nil, errors.Join(ErrRestart, err)
 		}
@@ -442,17 +632,21 @@ func (s *syncer) newOutput() (*RedisOutput, error) {
 	return output, nil
 }
 
-func (s *syncer) updateCheckpoint(wait usync.WaitCloser, localCheckpoint string, ids []string) error {
+func (s *syncer) updateCheckpoint(wait usync.WaitCloser, cpStore *config.CheckpointStoreConfig, cpRedis *config.RedisConfig,
+	localCheckpoint string, ids []string) error {
+	if cpRedis == nil {
+		cpRedis = &s.cfg.Output
+	}
 	return util.RetryLinearJitter(wait.Context(), func() error {
-		cli, err := client.NewRedis(s.cfg.Output)
+		store, err := checkpoint.NewStore(wait.Context(), cpStore, *cpRedis)
 		if err != nil {
 			return err
 		}
-		defer cli.Close()
+		defer store.Close()
 
-		err = checkpoint.UpdateCheckpoint(cli, localCheckpoint, ids)
+		err = checkpoint.UpdateCheckpoint(store, localCheckpoint, ids)
 		if err != nil {
-			s.logger.Errorf("update checkpoint : redis(%s), local(%s), ids(%v), error(%v)", s.cfg.Output.Address(), localCheckpoint, ids, err)
+			s.logger.Errorf("update checkpoint : store(%s), local(%s), ids(%v), error(%v)", store.Name(), localCheckpoint, ids, err)
 		}
 		return err
 	}, 5, time.Second*1, 0.3)